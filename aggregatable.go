@@ -0,0 +1,106 @@
+package handel
+
+import "encoding"
+
+// Aggregatable is a contribution Handel can aggregate. Signatures are one kind
+// of contributions but any homomorphic payload, e.g. commitments of a
+// distributed key generation, can be aggregated by Handel as long as the
+// aggregation of contributions can be verified against the aggregation of
+// their verifiers. Use NewAggregatableConstructor, AsSignature and
+// AsPublicKey to run Handel over Aggregatable contributions.
+type Aggregatable interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+	// Aggregate returns the aggregation of this contribution with the given
+	// one, without modifying any of them.
+	Aggregate(Aggregatable) Aggregatable
+}
+
+// AggregateVerifier verifies an aggregated contribution, as a public key
+// verifies a signature. The aggregation of the verifiers of some
+// contributions must verify the aggregation of these contributions.
+type AggregateVerifier interface {
+	// Verify returns an error if the contribution is invalid for the message
+	// with respect to this verifier.
+	Verify(msg []byte, a Aggregatable) error
+	// Aggregate returns the aggregation of this verifier with the given one,
+	// without modifying any of them.
+	Aggregate(AggregateVerifier) AggregateVerifier
+	// String returns an easy representation of the verifier.
+	String() string
+}
+
+// AggregatableConstructor creates empty contributions suitable for
+// unmarshalling and empty verifiers suitable for aggregation.
+type AggregatableConstructor interface {
+	// Aggregatable returns a fresh empty contribution
+	Aggregatable() Aggregatable
+	// Verifier returns a fresh empty verifier
+	Verifier() AggregateVerifier
+}
+
+// aggSig is a Signature aggregating an Aggregatable contribution.
+type aggSig struct {
+	Aggregatable
+}
+
+func (a *aggSig) Combine(s Signature) Signature {
+	return &aggSig{a.Aggregate(s.(*aggSig).Aggregatable)}
+}
+
+// aggPublic is a PublicKey verifying an Aggregatable contribution.
+type aggPublic struct {
+	AggregateVerifier
+}
+
+func (a *aggPublic) VerifySignature(msg []byte, s Signature) error {
+	return a.Verify(msg, s.(*aggSig).Aggregatable)
+}
+
+func (a *aggPublic) Combine(p PublicKey) PublicKey {
+	return &aggPublic{a.Aggregate(p.(*aggPublic).AggregateVerifier)}
+}
+
+// aggConstructor is a Constructor of Aggregatable contributions.
+type aggConstructor struct {
+	c AggregatableConstructor
+}
+
+// NewAggregatableConstructor returns the Constructor to give to Handel to
+// aggregate the contributions created by the given constructor. The
+// identities of the registry must then hold verifiers wrapped by AsPublicKey
+// and the contribution of the node must be wrapped by AsSignature.
+func NewAggregatableConstructor(c AggregatableConstructor) Constructor {
+	return &aggConstructor{c}
+}
+
+func (a *aggConstructor) Signature() Signature {
+	return &aggSig{a.c.Aggregatable()}
+}
+
+func (a *aggConstructor) PublicKey() PublicKey {
+	return &aggPublic{a.c.Verifier()}
+}
+
+// AsSignature returns the contribution as a Signature Handel can aggregate.
+func AsSignature(a Aggregatable) Signature {
+	return &aggSig{a}
+}
+
+// AsPublicKey returns the verifier as a PublicKey to put in the identities of
+// the registry.
+func AsPublicKey(v AggregateVerifier) PublicKey {
+	return &aggPublic{v}
+}
+
+// AggregatableOf returns the contribution aggregated in the given signature,
+// e.g. in the signature of a MultiSignature output by Handel. It returns
+// false if the signature has not been created by AsSignature or by a
+// constructor returned by NewAggregatableConstructor.
+func AggregatableOf(s Signature) (Aggregatable, bool) {
+	a, ok := s.(*aggSig)
+	if !ok {
+		return nil, false
+	}
+	return a.Aggregatable, true
+}
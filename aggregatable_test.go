@@ -0,0 +1,97 @@
+package handel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scalarSum is a contribution aggregated by summing scalars
+type scalarSum struct {
+	v int64
+}
+
+func (s *scalarSum) MarshalBinary() ([]byte, error) {
+	buff := make([]byte, 8)
+	binary.BigEndian.PutUint64(buff, uint64(s.v))
+	return buff, nil
+}
+
+func (s *scalarSum) UnmarshalBinary(buff []byte) error {
+	if len(buff) != 8 {
+		return errors.New("invalid scalar length")
+	}
+	s.v = int64(binary.BigEndian.Uint64(buff))
+	return nil
+}
+
+func (s *scalarSum) Aggregate(a Aggregatable) Aggregatable {
+	return &scalarSum{s.v + a.(*scalarSum).v}
+}
+
+// expectedSum verifies that a sum is the sum of the expected scalars
+type expectedSum struct {
+	v int64
+}
+
+func (e *expectedSum) Verify(msg []byte, a Aggregatable) error {
+	if got := a.(*scalarSum).v; got != e.v {
+		return fmt.Errorf("invalid sum %d, expected %d", got, e.v)
+	}
+	return nil
+}
+
+func (e *expectedSum) Aggregate(v AggregateVerifier) AggregateVerifier {
+	return &expectedSum{e.v + v.(*expectedSum).v}
+}
+
+func (e *expectedSum) String() string { return fmt.Sprint(e.v) }
+
+type sumConstructor struct{}
+
+func (s *sumConstructor) Aggregatable() Aggregatable  { return new(scalarSum) }
+func (s *sumConstructor) Verifier() AggregateVerifier { return new(expectedSum) }
+
+func TestAggregatableScalarSum(t *testing.T) {
+	n := 8
+	cons := NewAggregatableConstructor(new(sumConstructor))
+	ids := make([]Identity, n)
+	nets := make([]Network, n)
+	for i := 0; i < n; i++ {
+		ids[i] = NewStaticIdentity(int32(i), "", AsPublicKey(&expectedSum{int64(i * i)}))
+		nets[i] = &TestNetwork{id: int32(i), list: nets}
+	}
+	reg := NewArrayRegistry(ids)
+	conf := &Config{Contributions: n, NewTimeoutStrategy: newInfiniteTimeout}
+	handels := make([]*Handel, n)
+	for i := 0; i < n; i++ {
+		handels[i] = NewHandel(nets[i], reg, ids[i], cons, msg, AsSignature(&scalarSum{int64(i * i)}), conf)
+	}
+	for _, h := range handels {
+		go h.Start()
+	}
+	defer CloseHandels(handels)
+
+	select {
+	case ms := <-handels[0].FinalSignatures():
+		require.Equal(t, n, ms.Cardinality())
+		require.NoError(t, VerifyMultiSignature(msg, &ms, reg, cons))
+		sum, ok := AggregatableOf(ms.Signature)
+		require.True(t, ok)
+		require.Equal(t, int64(140), sum.(*scalarSum).v)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no final sum")
+	}
+
+	// a wrong contribution does not verify
+	_, ok := AggregatableOf(&fakeSig{true})
+	require.False(t, ok)
+	bs := NewWilffBitset(n)
+	bs.Set(2, true)
+	wrong := &MultiSignature{BitSet: bs, Signature: AsSignature(&scalarSum{5})}
+	require.Error(t, VerifyMultiSignature(msg, wrong, reg, cons))
+}
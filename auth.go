@@ -0,0 +1,65 @@
+package handel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// PacketAuth authenticates the packets exchanged by Handel, so a node can not
+// send packets on behalf of another one, see Config.PacketAuth.
+type PacketAuth interface {
+	// Sign returns the authenticator of the packet, sent in its Auth field.
+	Sign(p *Packet) ([]byte, error)
+	// Verify returns nil if the Auth field of the packet authenticates it as
+	// sent by the given identity, the origin of the packet.
+	Verify(p *Packet, origin Identity) error
+}
+
+// AuthPayload returns the content of the packet to authenticate: its origin,
+// level, multi-signature and individual signature. The chunking fields and
+// the authenticator itself are not part of it, as packets are authenticated
+// before being split, see Config.MaxChunkSize.
+func (p *Packet) AuthPayload() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, p.Origin)
+	b.WriteByte(p.Level)
+	binary.Write(&b, binary.BigEndian, uint32(len(p.MultiSig)))
+	b.Write(p.MultiSig)
+	b.Write(p.IndividualSig)
+	return b.Bytes()
+}
+
+// keyPacketAuth authenticates the packets with the keys of the nodes.
+type keyPacketAuth struct {
+	secret SecretKey
+	cons   Constructor
+}
+
+// NewKeyPacketAuth returns a PacketAuth signing the AuthPayload of the
+// packets with the given secret key of the node, and verifying the packets
+// received against the public key of their origin in the registry. The
+// constructor creates the signatures to unmarshal.
+func NewKeyPacketAuth(secret SecretKey, c Constructor) PacketAuth {
+	return &keyPacketAuth{secret: secret, cons: c}
+}
+
+func (k *keyPacketAuth) Sign(p *Packet) ([]byte, error) {
+	sig, err := k.secret.Sign(p.AuthPayload(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return sig.MarshalBinary()
+}
+
+func (k *keyPacketAuth) Verify(p *Packet, origin Identity) error {
+	if len(p.Auth) == 0 {
+		return errors.New("packet not authenticated")
+	}
+	sig := k.cons.Signature()
+	if err := sig.UnmarshalBinary(p.Auth); err != nil {
+		return err
+	}
+	return origin.PublicKey().VerifySignature(p.AuthPayload(), sig)
+}
@@ -0,0 +1,57 @@
+package handel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// idAuth authenticates the packets with a hash keyed by the ID of the sender
+type idAuth struct{}
+
+func (idAuth) mac(p *Packet, id int32) []byte {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, id)
+	h.Write(p.AuthPayload())
+	return h.Sum(nil)
+}
+
+func (a idAuth) Sign(p *Packet) ([]byte, error) { return a.mac(p, p.Origin), nil }
+
+func (a idAuth) Verify(p *Packet, origin Identity) error {
+	if !bytes.Equal(p.Auth, a.mac(p, origin.ID())) {
+		return errors.New("invalid mac")
+	}
+	return nil
+}
+
+func TestPacketAuthPayload(t *testing.T) {
+	p := &Packet{Origin: 3, Level: 2, MultiSig: []byte{1, 2}, IndividualSig: []byte{3}}
+	payload := p.AuthPayload()
+	// the chunking fields and the authenticator are not authenticated
+	require.Equal(t, payload, (&Packet{Origin: 3, Level: 2, MultiSig: []byte{1, 2}, IndividualSig: []byte{3}, ChunkCount: 2, Auth: []byte{4}}).AuthPayload())
+	// the multi-signature can not be extended with the individual signature
+	require.NotEqual(t, payload, (&Packet{Origin: 3, Level: 2, MultiSig: []byte{1, 2, 3}}).AuthPayload())
+	require.NotEqual(t, payload, (&Packet{Origin: 4, Level: 2, MultiSig: []byte{1, 2}, IndividualSig: []byte{3}}).AuthPayload())
+}
+
+func TestKeyPacketAuth(t *testing.T) {
+	reg := FakeRegistry(4)
+	origin, _ := reg.Identity(2)
+	auth := NewKeyPacketAuth(new(fakeSecret), new(fakeCons))
+	p := &Packet{Origin: 2, Level: 1, MultiSig: []byte{1}}
+	var err error
+	p.Auth, err = auth.Sign(p)
+	require.NoError(t, err)
+	require.NoError(t, auth.Verify(p, origin))
+
+	p.Auth = nil
+	require.Error(t, auth.Verify(p, origin))
+	p.Auth, err = (&fakeSig{false}).MarshalBinary()
+	require.NoError(t, err)
+	require.Error(t, auth.Verify(p, origin))
+}
@@ -0,0 +1,66 @@
+package handel
+
+import "time"
+
+// BandwidthEstimate is the number of packets a Handel node expects to send to
+// reach completion under ideal conditions, i.e. when no node is offline and
+// each level completes at once.
+type BandwidthEstimate struct {
+	// Levels holds the estimate of each level, in ascending level order
+	Levels []LevelEstimate
+	// Total is the total number of packets sent over all levels
+	Total int
+}
+
+// LevelEstimate is the expected number of packets sent at a given level.
+type LevelEstimate struct {
+	Level int
+	// Peers is the number of nodes at this level
+	Peers int
+	// FastPath is the number of packets sent when the level completes
+	FastPath int
+	// Periodic is the number of packets sent by the periodic updates to
+	// contact the remaining peers of the level
+	Periodic int
+	// Duration is the time needed by the periodic updates to contact all
+	// the remaining peers of the level
+	Duration time.Duration
+}
+
+// EstimateBandwidth returns the number of packets a node of the registry
+// expects to send with the given config. In ideal conditions, each peer of a
+// level is contacted once with the complete signature of the level: the first
+// ones with the fast path, the others through the periodic updates. The level
+// sizes are taken from the point of view of the first node of the registry.
+// A nil config is the default config.
+func EstimateBandwidth(r Registry, c *Config) BandwidthEstimate {
+	var est BandwidthEstimate
+	if r.Size() == 0 {
+		return est
+	}
+	conf := DefaultConfig(r.Size())
+	if c != nil {
+		conf = mergeWithDefault(c, r.Size())
+	}
+	id, _ := r.Identity(0)
+	part := conf.NewPartitioner(id.ID(), r, conf.Logger)
+	for _, lvl := range part.Levels() {
+		peers := part.Size(lvl)
+		fastPath := min(conf.FastPath, peers)
+		periodic := peers - fastPath
+		var duration time.Duration
+		if conf.UpdateCount > 0 {
+			periods := (periodic + conf.UpdateCount - 1) / conf.UpdateCount
+			duration = time.Duration(periods) * conf.UpdatePeriod
+		}
+		est.Levels = append(est.Levels, LevelEstimate{
+			Level:    lvl,
+			Peers:    peers,
+			FastPath: fastPath,
+			Periodic: periodic,
+			Duration: duration,
+		})
+		est.Total += peers
+	}
+	return est
+}
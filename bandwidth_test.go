@@ -0,0 +1,27 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateBandwidth(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	conf := &Config{FastPath: 2, UpdateCount: 3, UpdatePeriod: 10 * time.Millisecond}
+	est := EstimateBandwidth(reg, conf)
+	require.Equal(t, []LevelEstimate{
+		{Level: 1, Peers: 1, FastPath: 1, Periodic: 0, Duration: 0},
+		{Level: 2, Peers: 2, FastPath: 2, Periodic: 0, Duration: 0},
+		{Level: 3, Peers: 4, FastPath: 2, Periodic: 2, Duration: 10 * time.Millisecond},
+		{Level: 4, Peers: 8, FastPath: 2, Periodic: 6, Duration: 20 * time.Millisecond},
+	}, est.Levels)
+	// every other node is contacted once
+	require.Equal(t, n-1, est.Total)
+
+	require.Equal(t, BandwidthEstimate{}, EstimateBandwidth(FakeRegistry(0), conf))
+	// a nil config is the default one
+	require.Equal(t, EstimateBandwidth(reg, DefaultConfig(n)), EstimateBandwidth(reg, nil))
+}
@@ -3,6 +3,9 @@ package handel
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
 
 	"github.com/willf/bitset"
 )
@@ -34,12 +37,20 @@ type BitSet interface {
 	None() bool
 	// Any returns true if any bit is set, false otherwise
 	Any() bool
+	// The operations between two bitsets below require both to have the same
+	// bit length: an operation between bitsets of different lengths is an
+	// error, implementations should panic in such a case. They leave both
+	// operands untouched.
+	//
 	// Or between this bitset and another, returns a new bitset.
 	Or(b2 BitSet) BitSet
 	// And between this bitset and another, returns a new bitset.
 	And(b2 BitSet) BitSet
 	// Xor between this bitset and another, returns a new bitset.
 	Xor(b2 BitSet) BitSet
+	// AndNot returns a new bitset with the bits of this bitset which are not
+	// set in the other.
+	AndNot(b2 BitSet) BitSet
 	// IsSuperSet returns true if this is a superset of the other set
 	IsSuperSet(b2 BitSet) bool
 	// NextSet returns the next bit set from the specified index,
@@ -47,13 +58,29 @@ type BitSet interface {
 	// along with an error code (true = valid, false = no set bit found)
 	// for i,e := v.NextSet(0); e; i,e = v.NextSet(i + 1) {...}
 	NextSet(i int) (int, bool)
-	// IntersectionCardinality computes the cardinality of the differnce
+	// IntersectionCardinality returns the number of bits set in both bitsets
 	IntersectionCardinality(b2 BitSet) int
 	// Clone this BitSet
 	Clone() BitSet
 }
 
-// WilffBitSet implements a BitSet using the wilff library.
+// GrowableBitSet is an optional interface for BitSets whose storage can grow
+// bit by bit instead of being allocated at once for the whole bit length.
+// Implementations must only marshal the bits up to the highest bit set, the
+// remaining bits being implicitly unset, so signatures sent during the sparse
+// early phase of the protocol do not carry the full-length zero padding.
+type GrowableBitSet interface {
+	BitSet
+	// Append adds a bit with the given status at the end of the bitset,
+	// increasing its bit length by one.
+	Append(bool)
+	// Highest returns the index of the highest bit set, or false if no bit is
+	// set.
+	Highest() (int, bool)
+}
+
+// WilffBitSet implements a BitSet using the wilff library. It implements the
+// GrowableBitSet interface as well.
 type WilffBitSet struct {
 	b *bitset.BitSet
 	l int
@@ -67,13 +94,6 @@ func NewWilffBitset(length int) BitSet {
 	}
 }
 
-func newWilffBitset(bs *bitset.BitSet) BitSet {
-	return &WilffBitSet{
-		b: bs,
-		l: int(bs.Len()),
-	}
-}
-
 // BitLength implements the BitSet interface
 func (w *WilffBitSet) BitLength() int {
 	return int(w.l)
@@ -118,22 +138,64 @@ func (w *WilffBitSet) Combine(b2 BitSet) BitSet {
 
 // Or implements the BitSet interface
 func (w *WilffBitSet) Or(b2 BitSet) BitSet {
-	return newWilffBitset(w.b.Union(b2.(*WilffBitSet).b))
+	return &WilffBitSet{b: w.b.Union(w.operand(b2)), l: w.l}
 }
 
 // And implements the BitSet interface
 func (w *WilffBitSet) And(b2 BitSet) BitSet {
-	return newWilffBitset(w.b.Intersection(b2.(*WilffBitSet).b))
+	return &WilffBitSet{b: w.b.Intersection(w.operand(b2)), l: w.l}
 }
 
 // Xor implements the BitSet interface
 func (w *WilffBitSet) Xor(b2 BitSet) BitSet {
-	return newWilffBitset(w.b.SymmetricDifference(b2.(*WilffBitSet).b))
+	return &WilffBitSet{b: w.b.SymmetricDifference(w.operand(b2)), l: w.l}
+}
+
+// AndNot implements the BitSet interface
+func (w *WilffBitSet) AndNot(b2 BitSet) BitSet {
+	return &WilffBitSet{b: w.b.Difference(w.operand(b2)), l: w.l}
 }
 
 // Clone implements the BitSet interface
 func (w *WilffBitSet) Clone() BitSet {
-	return newWilffBitset(w.b.Clone())
+	return &WilffBitSet{b: w.b.Clone(), l: w.l}
+}
+
+// Append implements the GrowableBitSet interface
+func (w *WilffBitSet) Append(status bool) {
+	w.l++
+	if status {
+		w.b = w.b.Set(uint(w.l - 1))
+	}
+}
+
+// Highest implements the GrowableBitSet interface. It only looks at the words
+// of the bitset from the last one down to the first one holding a bit.
+func (w *WilffBitSet) Highest() (int, bool) {
+	words := w.b.Bytes()
+	for i := len(words) - 1; i >= 0; i-- {
+		if words[i] != 0 {
+			return i*64 + bits.Len64(words[i]) - 1, true
+		}
+	}
+	return 0, false
+}
+
+// operand returns the bits of the other operand of an operation, which must
+// have the same bit length. Other BitSet implementations are converted bit by
+// bit.
+func (w *WilffBitSet) operand(b2 BitSet) *bitset.BitSet {
+	if b2.BitLength() != w.l {
+		panic(fmt.Sprintf("bitset: operation between bit lengths %d and %d", w.l, b2.BitLength()))
+	}
+	if w2, ok := b2.(*WilffBitSet); ok {
+		return w2.b
+	}
+	b := bitset.New(uint(w.l))
+	for i, e := b2.NextSet(0); e; i, e = b2.NextSet(i + 1) {
+		b.Set(uint(i))
+	}
+	return b
 }
 
 func (w *WilffBitSet) inBound(idx int) bool {
@@ -142,18 +204,33 @@ func (w *WilffBitSet) inBound(idx int) bool {
 
 // IsSuperSet implements the BitSet interface
 func (w *WilffBitSet) IsSuperSet(b2 BitSet) bool {
-	return w.b.IsSuperSet(b2.(*WilffBitSet).b)
+	return w.b.IsSuperSet(w.operand(b2))
 }
 
 // MarshalBinary implements the go Marshaler interface. It encodes the size
-// first and then the bitset.
+// first and then the bitset up to its highest bit set.
 func (w *WilffBitSet) MarshalBinary() ([]byte, error) {
 	var b bytes.Buffer
 	err := binary.Write(&b, binary.BigEndian, uint16(w.l))
 	if err != nil {
 		return nil, err
 	}
-	buff, err := w.b.MarshalBinary()
+	// as a GrowableBitSet, the bits after the highest one set are not
+	// encoded, the bitset is only copied when there are some
+	trimmed := w.b
+	if highest, ok := w.Highest(); !ok {
+		trimmed = new(bitset.BitSet)
+	} else if uint(highest+1) < w.b.Len() {
+		words := make([]uint64, highest/64+1)
+		copy(words, w.b.Bytes())
+		trimmed = bitset.From(words)
+		// Shrink clears the whole last word when the length is a multiple of
+		// 64, the words have the right length already in that case
+		if (highest+1)%64 != 0 {
+			trimmed.Shrink(uint(highest))
+		}
+	}
+	buff, err := trimmed.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
@@ -162,7 +239,7 @@ func (w *WilffBitSet) MarshalBinary() ([]byte, error) {
 }
 
 // UnmarshalBinary implements the go Marshaler interface. It decodes the length
-// first and then the bitset.
+// first and then the bitset. The bits not present in the buffer are unset.
 func (w *WilffBitSet) UnmarshalBinary(buff []byte) error {
 	var b = bytes.NewBuffer(buff)
 	var length uint16
@@ -171,9 +248,16 @@ func (w *WilffBitSet) UnmarshalBinary(buff []byte) error {
 		return err
 	}
 
-	w.b = new(bitset.BitSet)
+	bs := new(bitset.BitSet)
+	if err := bs.UnmarshalBinary(b.Bytes()); err != nil {
+		return err
+	}
+	if bs.Len() > uint(length) {
+		return errors.New("bitset: encoded bits exceed the bit length")
+	}
+	w.b = bs
 	w.l = int(length)
-	return w.b.UnmarshalBinary(b.Bytes())
+	return nil
 }
 
 func (w *WilffBitSet) String() string {
@@ -182,7 +266,7 @@ func (w *WilffBitSet) String() string {
 
 // All implements the BitSet interface
 func (w *WilffBitSet) All() bool {
-	return w.b.All()
+	return w.Cardinality() == w.l
 }
 
 // None implements the BitSet interface
@@ -203,5 +287,5 @@ func (w *WilffBitSet) NextSet(i int) (int, bool) {
 
 // IntersectionCardinality implements the BitSet interface
 func (w *WilffBitSet) IntersectionCardinality(b2 BitSet) int {
-	return int(w.b.IntersectionCardinality(b2.(*WilffBitSet).b))
+	return int(w.b.IntersectionCardinality(w.operand(b2)))
 }
@@ -49,6 +49,88 @@ func testBitSets(t *testing.T, tests []bitsetTest) {
 
 }
 
+func TestBitSetWilffAndNot(t *testing.T) {
+	b1 := nb(10)
+	b1.Set(1, true)
+	b1.Set(4, true)
+	b1.Set(7, true)
+	b2 := nb(10)
+	b2.Set(4, true)
+	b2.Set(5, true)
+
+	diff := b1.AndNot(b2)
+	require.Equal(t, 10, diff.BitLength())
+	require.Equal(t, 2, diff.Cardinality())
+	require.True(t, diff.Get(1))
+	require.True(t, diff.Get(7))
+	// operands are left untouched
+	require.Equal(t, 3, b1.Cardinality())
+	require.Equal(t, 2, b2.Cardinality())
+}
+
+// otherBitSet is a BitSet implementation other than WilffBitSet
+type otherBitSet struct {
+	BitSet
+}
+
+func TestBitSetWilffOperations(t *testing.T) {
+	fromMask := func(length int, mask uint) BitSet {
+		b := nb(length)
+		for i := 0; i < length; i++ {
+			b.Set(i, mask&(1<<uint(i)) != 0)
+		}
+		return b
+	}
+	count := func(mask uint) int {
+		c := 0
+		for ; mask != 0; mask &= mask - 1 {
+			c++
+		}
+		return c
+	}
+	var ops = []struct {
+		name string
+		op   func(a, b BitSet) BitSet
+		exp  func(a, b uint) uint
+	}{
+		{"or", BitSet.Or, func(a, b uint) uint { return a | b }},
+		{"and", BitSet.And, func(a, b uint) uint { return a & b }},
+		{"xor", BitSet.Xor, func(a, b uint) uint { return a ^ b }},
+		{"andnot", BitSet.AndNot, func(a, b uint) uint { return a &^ b }},
+	}
+	for length := 0; length <= 5; length++ {
+		for ma := uint(0); ma < 1<<uint(length); ma++ {
+			for mb := uint(0); mb < 1<<uint(length); mb++ {
+				a, b := fromMask(length, ma), fromMask(length, mb)
+				for _, other := range []BitSet{b, &otherBitSet{b}} {
+					for _, op := range ops {
+						res := op.op(a, other)
+						require.Equal(t, fromMask(length, op.exp(ma, mb)), res, "%s %d %b %b", op.name, length, ma, mb)
+					}
+					require.Equal(t, count(ma&mb), a.IntersectionCardinality(other))
+					require.Equal(t, ma&mb == mb, a.IsSuperSet(other))
+				}
+				// operands are left untouched
+				require.Equal(t, fromMask(length, ma), a)
+				require.Equal(t, fromMask(length, mb), b)
+
+				c := a.Clone()
+				require.Equal(t, a, c)
+				if length > 0 {
+					c.Set(0, !c.Get(0))
+					require.Equal(t, fromMask(length, ma), a)
+				}
+			}
+		}
+	}
+
+	for _, op := range ops {
+		require.Panics(t, func() { op.op(nb(4), nb(5)) }, op.name)
+	}
+	require.Panics(t, func() { nb(4).IntersectionCardinality(nb(5)) })
+	require.Panics(t, func() { nb(4).IsSuperSet(nb(5)) })
+}
+
 func TestBitSetWilffMarshalling(t *testing.T) {
 	b := NewWilffBitset(10).(*WilffBitSet)
 	b.Set(1, true)
@@ -62,3 +144,99 @@ func TestBitSetWilffMarshalling(t *testing.T) {
 
 	require.Equal(t, b.l, b2.l)
 }
+
+// fullWord returns the indexes of all the bits of the given 64-bit word.
+func fullWord(word int) []int {
+	bits := make([]int, 64)
+	for i := range bits {
+		bits[i] = word*64 + i
+	}
+	return bits
+}
+
+func TestBitSetWilffGrowable(t *testing.T) {
+	var _ GrowableBitSet = NewWilffBitset(0).(*WilffBitSet)
+
+	type growTest struct {
+		length  int
+		setBits []int
+	}
+	var tests = []growTest{
+		{0, nil},
+		{10, nil},
+		{10, []int{0}},
+		{1000, []int{3}},
+		{1000, []int{1, 64, 65}},
+		{1000, []int{999}},
+		{64, []int{63}},
+		{128, []int{5, 127}},
+		{130, []int{63, 64}},
+		// the highest bit at the end of a word, below the bit length
+		{100, []int{63}},
+		{200, []int{5, 127}},
+		{5000, []int{1, 4095}},
+		{200, fullWord(1)},
+	}
+
+	for i, test := range tests {
+		t.Logf(" -- test %d --", i)
+		b := NewWilffBitset(test.length).(*WilffBitSet)
+		for _, idx := range test.setBits {
+			b.Set(idx, true)
+		}
+		buff, err := b.MarshalBinary()
+		require.NoError(t, err)
+
+		b2 := new(WilffBitSet)
+		require.NoError(t, b2.UnmarshalBinary(buff))
+		require.Equal(t, test.length, b2.BitLength())
+		require.Equal(t, len(test.setBits), b2.Cardinality())
+		for i := 0; i < test.length; i++ {
+			require.Equal(t, b.Get(i), b2.Get(i))
+		}
+		highest, ok := b2.Highest()
+		require.Equal(t, len(test.setBits) > 0, ok)
+		if ok {
+			require.Equal(t, test.setBits[len(test.setBits)-1], highest)
+		}
+		// operations still use the full bit length
+		require.Equal(t, test.length, b2.Or(b).BitLength())
+		require.Equal(t, b.All(), b2.All())
+	}
+
+	// sparse bitsets are encoded without the trailing zeros
+	sparse := NewWilffBitset(1000)
+	sparse.Set(3, true)
+	dense := NewWilffBitset(1000)
+	dense.Set(999, true)
+	sparseBuff, err := sparse.MarshalBinary()
+	require.NoError(t, err)
+	denseBuff, err := dense.MarshalBinary()
+	require.NoError(t, err)
+	require.True(t, len(sparseBuff) < len(denseBuff))
+	// a bitset whose last bit is set is encoded as is, and left untouched
+	words, err := dense.(*WilffBitSet).b.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, words, denseBuff[2:])
+	require.Equal(t, uint(1000), dense.(*WilffBitSet).b.Len())
+	sparseBuff, err = sparse.MarshalBinary()
+	require.NoError(t, err)
+	require.True(t, sparse.Get(3))
+	require.Equal(t, uint(1000), sparse.(*WilffBitSet).b.Len())
+
+	// growing a bitset bit by bit
+	grow := NewWilffBitset(0).(*WilffBitSet)
+	grow.Append(false)
+	grow.Append(true)
+	grow.Append(false)
+	require.Equal(t, 3, grow.BitLength())
+	require.Equal(t, 1, grow.Cardinality())
+	require.True(t, grow.Get(1))
+	buff, err := grow.MarshalBinary()
+	require.NoError(t, err)
+	grow2 := new(WilffBitSet)
+	require.NoError(t, grow2.UnmarshalBinary(buff))
+	require.Equal(t, 3, grow2.BitLength())
+	require.True(t, grow2.Get(1))
+	require.False(t, grow2.Get(2))
+}
@@ -0,0 +1,466 @@
+// Package bls allows to use Handel with the BLS signature scheme over the
+// BLS12-381 curve. It implements the relevant Handel interfaces: PublicKey,
+// SecretKey and Signature. The public keys are points in G1 and the signatures
+// points in G2, hashed to the curve as in the proof of possession ciphersuite
+// of the IETF BLS signature draft, so the signatures are the ones of Ethereum
+// 2.0. Since the signatures are aggregated over the same message, the proof of
+// possession of each public key must be checked before adding it to the
+// registry, as with any BLS multi-signature. The curve implementation comes
+// from the kilic/bls12-381 package.
+package bls
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/ConsenSys/handel"
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// Domain is the domain separation tag used to hash a message to G2.
+var Domain = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+
+// DefaultKeyCacheSize is the number of aggregate public keys kept in cache by
+// the Constructor returned by NewConstructor.
+const DefaultKeyCacheSize = 1000
+
+// Constructor implements the handel.Constructor interface. It also computes
+// the aggregate public keys of the bitsets, keeping the last ones in cache.
+type Constructor struct {
+	keys *keyCache
+}
+
+// NewConstructor returns a handel.Constructor capable of creating empty BLS
+// signature objects and empty public keys, caching DefaultKeyCacheSize
+// aggregate public keys.
+func NewConstructor() *Constructor {
+	return NewCachingConstructor(DefaultKeyCacheSize)
+}
+
+// NewCachingConstructor returns a Constructor caching up to size aggregate
+// public keys. Zero disables the cache.
+func NewCachingConstructor(size int) *Constructor {
+	return &Constructor{keys: newKeyCache(size)}
+}
+
+// Signature implements the handel.Constructor interface
+func (c *Constructor) Signature() handel.Signature {
+	return new(Signature)
+}
+
+// PublicKey implements the handel.Constructor interface
+func (c *Constructor) PublicKey() handel.PublicKey {
+	return new(PublicKey)
+}
+
+// SecretKey implements the simul/lib/Constructor interface
+func (c *Constructor) SecretKey() handel.SecretKey {
+	return new(SecretKey)
+}
+
+// KeyPair implements the simul/lib/Constructor interface
+func (c *Constructor) KeyPair(r io.Reader) (handel.SecretKey, handel.PublicKey) {
+	secret, pub, err := NewKeyPair(r)
+	if err != nil {
+		// this method is only used in simulation code anyway
+		panic(err)
+	}
+	return secret, pub
+}
+
+// AggregatePublicKey returns the aggregate public key of the identities whose
+// index is set in the bitset, e.g. to verify a multi-signature of a Handel
+// level against the identities of that level. The aggregate keys are cached
+// by a hash of the bitset and of the IDs of the identities, since the
+// verifications of the signatures of a level aggregate the same bitsets again
+// and again. It implements the handel.AggregateKeyer interface, so Handel
+// takes the aggregate keys from this cache, which is shared by all the Handel
+// instances using the Constructor. It is safe for concurrent use.
+func (c *Constructor) AggregatePublicKey(ids []handel.Identity, bs handel.BitSet) (handel.PublicKey, error) {
+	if bs.BitLength() != len(ids) {
+		return nil, errors.New("bls: bitset of another length than the identities")
+	}
+	key, err := keyCacheKey(ids, bs)
+	if err != nil {
+		return nil, err
+	}
+	if apk, ok := c.keys.get(key); ok {
+		return apk, nil
+	}
+	g := bls12381.NewG1()
+	sum := g.Zero()
+	for i, ok := bs.NextSet(0); ok && i < len(ids); i, ok = bs.NextSet(i + 1) {
+		pub, isBLS := ids[i].PublicKey().(*PublicKey)
+		if !isBLS || pub.p == nil {
+			return nil, errors.New("bls: identity without a BLS public key")
+		}
+		g.Add(sum, sum, pub.p)
+	}
+	apk := &PublicKey{g.Affine(sum)}
+	c.keys.add(key, apk)
+	return apk, nil
+}
+
+// batchScalarBits is the size of the random scalars of VerifyBatch. A batch
+// with an invalid signature passes the check with a probability of 2^-64.
+const batchScalarBits = 64
+
+// VerifyBatch implements the handel.BatchVerifier interface. It checks all
+// the signatures at once with a single multi-pairing, by verifying that
+// e(sum r_i P_i, H(m)) == e(G1, sum r_i S_i) holds for random scalars r_i. The
+// random scalars prevent invalid signatures from cancelling each other in the
+// sums, as S_1 + X and S_2 - X would. It costs two pairings and a small scalar
+// multiplication per signature instead of two pairings per signature.
+func (c *Constructor) VerifyBatch(msg []byte, keys []handel.PublicKey, sigs []handel.Signature) error {
+	if len(keys) != len(sigs) {
+		return errors.New("bls: batch with a different number of keys and signatures")
+	}
+	if len(sigs) == 0 {
+		return nil
+	}
+	g1 := bls12381.NewG1()
+	g2 := bls12381.NewG2()
+	sumP, sumS := g1.Zero(), g2.Zero()
+	p, s := g1.New(), g2.New()
+	max := new(big.Int).Lsh(big.NewInt(1), batchScalarBits)
+	for i := range sigs {
+		pub, ok := keys[i].(*PublicKey)
+		if !ok || pub.p == nil {
+			return errors.New("bls: not a BLS public key")
+		}
+		sig, ok := sigs[i].(*Signature)
+		if !ok || sig.s == nil {
+			return errors.New("bls: not a BLS signature")
+		}
+		r, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return err
+		}
+		// a zero scalar would leave the signature out of the check
+		r.Add(r, big.NewInt(1))
+		g1.Add(sumP, sumP, g1.MulScalarBig(p, pub.p, r))
+		g2.Add(sumS, sumS, g2.MulScalarBig(s, sig.s, r))
+	}
+	hm, err := g2.HashToCurve(msg, Domain)
+	if err != nil {
+		return err
+	}
+	e := bls12381.NewEngine()
+	e.AddPairInv(e.G1.One(), g2.Affine(sumS))
+	e.AddPair(g1.Affine(sumP), hm)
+	if !e.Check() {
+		return errors.New("bls: batch invalid")
+	}
+	return nil
+}
+
+// PublicKey holds the public key information = point in G1
+type PublicKey struct {
+	p *bls12381.PointG1
+}
+
+func (p *PublicKey) String() string {
+	buff, err := p.MarshalBinary()
+	if err != nil {
+		return "bls: empty public key"
+	}
+	return hex.EncodeToString(buff)
+}
+
+// VerifySignature checks the given BLS signature on the message using the
+// public key p by verifying that the equality e(P, H(m)) == e(G1, S) holds,
+// where e is the pairing operation, P the public key, S the signature and G1
+// the generator of G1.
+func (p *PublicKey) VerifySignature(msg []byte, sig handel.Signature) error {
+	s, ok := sig.(*Signature)
+	if !ok || s.s == nil {
+		return errors.New("bls: not a BLS signature")
+	}
+	if p.p == nil {
+		return errors.New("bls: empty public key")
+	}
+	hm, err := bls12381.NewG2().HashToCurve(msg, Domain)
+	if err != nil {
+		return err
+	}
+	// the engine modifies the points it is given
+	e := bls12381.NewEngine()
+	e.AddPairInv(e.G1.One(), new(bls12381.PointG2).Set(s.s))
+	e.AddPair(new(bls12381.PointG1).Set(p.p), hm)
+	if !e.Check() {
+		return errors.New("bls: signature invalid")
+	}
+	return nil
+}
+
+// Combine implements the handel.PublicKey interface
+func (p *PublicKey) Combine(pp handel.PublicKey) handel.PublicKey {
+	if p.p == nil {
+		return pp
+	}
+	p2 := pp.(*PublicKey)
+	if p2.p == nil {
+		return p
+	}
+	g := bls12381.NewG1()
+	res := g.New()
+	g.Add(res, p.p, p2.p)
+	return &PublicKey{g.Affine(res)}
+}
+
+// MarshalBinary implements the simul/lib/PublicKey interface. The public key
+// is encoded as a compressed point of 48 bytes.
+func (p *PublicKey) MarshalBinary() ([]byte, error) {
+	if p.p == nil {
+		return nil, errors.New("bls: can't marshal an empty public key")
+	}
+	return bls12381.NewG1().ToCompressed(new(bls12381.PointG1).Set(p.p)), nil
+}
+
+// UnmarshalBinary implements the simul/lib/PublicKey interface. It rejects the
+// points outside of the subgroup and the point at infinity.
+func (p *PublicKey) UnmarshalBinary(buff []byte) error {
+	g := bls12381.NewG1()
+	point, err := g.FromCompressed(buff)
+	if err != nil {
+		return err
+	}
+	if g.IsZero(point) {
+		return errors.New("bls: public key at infinity")
+	}
+	p.p = point
+	return nil
+}
+
+// SecretKey holds the secret scalar and can return the corresponding public
+// key. It can sign messages using the BLS signature scheme.
+type SecretKey struct {
+	s *big.Int
+}
+
+// NewKeyPair returns a new keypair generated from the given reader.
+func NewKeyPair(reader io.Reader) (*SecretKey, *PublicKey, error) {
+	if reader == nil {
+		reader = rand.Reader
+	}
+	g := bls12381.NewG1()
+	var s *big.Int
+	for s == nil || s.Sign() == 0 {
+		var err error
+		if s, err = rand.Int(reader, g.Q()); err != nil {
+			return nil, nil, err
+		}
+	}
+	secret := &SecretKey{s}
+	return secret, secret.PublicKey(), nil
+}
+
+// PublicKey returns the public key P = x * G1 of the secret key x, where G1
+// is the generator of G1.
+func (s *SecretKey) PublicKey() *PublicKey {
+	g := bls12381.NewG1()
+	p := g.New()
+	g.MulScalarBig(p, g.One(), s.s)
+	return &PublicKey{g.Affine(p)}
+}
+
+// Sign creates a BLS signature S = x * H(m) on a message m using the private
+// key x. The signature S is a point on curve G2.
+func (s *SecretKey) Sign(msg []byte, reader io.Reader) (handel.Signature, error) {
+	g := bls12381.NewG2()
+	hm, err := g.HashToCurve(msg, Domain)
+	if err != nil {
+		return nil, err
+	}
+	sig := g.New()
+	g.MulScalarBig(sig, hm, s.s)
+	return &Signature{g.Affine(sig)}, nil
+}
+
+// MarshalBinary implements the simul/lib/SecretKey interface. The secret key
+// is encoded as a big endian integer of 32 bytes.
+func (s *SecretKey) MarshalBinary() ([]byte, error) {
+	buff := make([]byte, secretKeySize)
+	return s.s.FillBytes(buff), nil
+}
+
+// UnmarshalBinary implements the simul/lib/SecretKey interface. It rejects the
+// zero key and the keys not lower than the order of the groups.
+func (s *SecretKey) UnmarshalBinary(buff []byte) error {
+	if len(buff) != secretKeySize {
+		return errors.New("bls: invalid secret key length")
+	}
+	secret := new(big.Int).SetBytes(buff)
+	if secret.Sign() == 0 || secret.Cmp(bls12381.NewG1().Q()) >= 0 {
+		return errors.New("bls: secret key out of range")
+	}
+	s.s = secret
+	return nil
+}
+
+const secretKeySize = 32
+
+// Signature represents a BLS signature, a point in G2
+type Signature struct {
+	s *bls12381.PointG2
+}
+
+// MarshalBinary implements the handel.Signature interface. The signature is
+// encoded as a compressed point of 96 bytes.
+func (m *Signature) MarshalBinary() ([]byte, error) {
+	if m.s == nil {
+		return nil, errors.New("bls: can't marshal an empty signature")
+	}
+	return bls12381.NewG2().ToCompressed(new(bls12381.PointG2).Set(m.s)), nil
+}
+
+// UnmarshalBinary implements the handel.Signature interface. It rejects the
+// points outside of the subgroup.
+func (m *Signature) UnmarshalBinary(b []byte) error {
+	s, err := bls12381.NewG2().FromCompressed(b)
+	if err != nil {
+		return err
+	}
+	m.s = s
+	return nil
+}
+
+// Combine implements the handel.Signature interface
+func (m *Signature) Combine(ms handel.Signature) handel.Signature {
+	if m.s == nil {
+		return ms
+	}
+	m2 := ms.(*Signature)
+	if m2.s == nil {
+		return m
+	}
+	g := bls12381.NewG2()
+	res := g.New()
+	g.Add(res, m.s, m2.s)
+	return &Signature{g.Affine(res)}
+}
+
+// Subtract implements the handel.SubtractableSignature interface
+func (m *Signature) Subtract(ms handel.Signature) handel.Signature {
+	m2 := ms.(*Signature)
+	g := bls12381.NewG2()
+	res := g.New()
+	g.Sub(res, m.s, m2.s)
+	return &Signature{g.Affine(res)}
+}
+
+func (m *Signature) String() string {
+	buff, err := m.MarshalBinary()
+	if err != nil {
+		return "bls: empty signature"
+	}
+	return hex.EncodeToString(buff)
+}
+
+// keyCache is a LRU cache of aggregate public keys. A nil keyCache is a
+// valid, always empty, cache. It is safe for concurrent use.
+type keyCache struct {
+	sync.Mutex
+	size int
+	// number of keys found in the cache
+	hits int
+	// most recently used entries first
+	ll *list.List
+	m  map[[sha256.Size]byte]*list.Element
+}
+
+type keyEntry struct {
+	key [sha256.Size]byte
+	apk *PublicKey
+}
+
+// newKeyCache returns a cache holding up to size aggregate public keys, or nil
+// if size is not positive.
+func newKeyCache(size int) *keyCache {
+	if size <= 0 {
+		return nil
+	}
+	return &keyCache{
+		size: size,
+		ll:   list.New(),
+		m:    make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// keyCacheKey returns the hash of the bitset and of the IDs of the identities
+// it indexes, so the bitsets of different levels are different keys.
+func keyCacheKey(ids []handel.Identity, bs handel.BitSet) ([sha256.Size]byte, error) {
+	var key [sha256.Size]byte
+	buff, err := bs.MarshalBinary()
+	if err != nil {
+		return key, err
+	}
+	h := sha256.New()
+	h.Write(buff)
+	var id [4]byte
+	for _, identity := range ids {
+		binary.BigEndian.PutUint32(id[:], uint32(identity.ID()))
+		h.Write(id[:])
+	}
+	copy(key[:], h.Sum(nil))
+	return key, nil
+}
+
+func (c *keyCache) get(key [sha256.Size]byte) (*PublicKey, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.m[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	c.hits++
+	return e.Value.(*keyEntry).apk, true
+}
+
+func (c *keyCache) add(key [sha256.Size]byte, apk *PublicKey) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.m[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*keyEntry).apk = apk
+		return
+	}
+	c.m[key] = c.ll.PushFront(&keyEntry{key, apk})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.m, oldest.Value.(*keyEntry).key)
+	}
+}
+
+func (c *keyCache) hitCount() int {
+	if c == nil {
+		return 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.hits
+}
+
+func (c *keyCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,413 @@
+package bls
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandel(t *testing.T) {
+	n := 16
+	config := h.DefaultConfig(n)
+	msg := []byte("Peaches and Cream")
+	secretKeys := make([]h.SecretKey, n)
+	pubKeys := make([]h.PublicKey, n)
+	cons := NewConstructor()
+	for i := 0; i < n; i++ {
+		sec, pub, err := NewKeyPair(nil)
+		require.NoError(t, err)
+		secretKeys[i] = sec
+		pubKeys[i] = pub
+	}
+	test := h.NewTest(secretKeys, pubKeys, cons, msg, config)
+	test.Start()
+	defer test.Stop()
+
+	select {
+	case <-test.WaitCompleteSuccess():
+	case <-time.After(100 * time.Second):
+		t.FailNow()
+	}
+}
+
+func TestHandelBatch(t *testing.T) {
+	n := 16
+	config := h.DefaultConfig(n)
+	config.BatchSize = 8
+	config.BatchWindow = 5 * time.Millisecond
+	msg := []byte("Peaches and Cream")
+	secretKeys := make([]h.SecretKey, n)
+	pubKeys := make([]h.PublicKey, n)
+	for i := 0; i < n; i++ {
+		sec, pub, err := NewKeyPair(nil)
+		require.NoError(t, err)
+		secretKeys[i] = sec
+		pubKeys[i] = pub
+	}
+	test := h.NewTest(secretKeys, pubKeys, NewConstructor(), msg, config)
+	test.Start()
+	defer test.Stop()
+
+	select {
+	case <-test.WaitCompleteSuccess():
+	case <-time.After(100 * time.Second):
+		t.FailNow()
+	}
+}
+
+func TestHandelAggregateKeyCache(t *testing.T) {
+	n := 16
+	config := h.DefaultConfig(n)
+	// all the aggregate keys come from the constructor
+	config.APKCacheSize = 0
+	msg := []byte("Peaches and Cream")
+	secretKeys := make([]h.SecretKey, n)
+	pubKeys := make([]h.PublicKey, n)
+	cons := NewConstructor()
+	var _ h.AggregateKeyer = cons
+	for i := 0; i < n; i++ {
+		sec, pub, err := NewKeyPair(nil)
+		require.NoError(t, err)
+		secretKeys[i] = sec
+		pubKeys[i] = pub
+	}
+	test := h.NewTest(secretKeys, pubKeys, cons, msg, config)
+	test.Start()
+	defer test.Stop()
+
+	select {
+	case <-test.WaitCompleteSuccess():
+	case <-time.After(100 * time.Second):
+		t.FailNow()
+	}
+	require.True(t, cons.keys.len() > 0)
+	require.True(t, cons.keys.hitCount() > 0)
+}
+
+// test vectors of the Ethereum 2.0 BLS tests, using the same ciphersuite
+var vectors = []struct {
+	sk  string
+	pk  string
+	msg string
+	sig string
+}{
+	{
+		sk:  "263dbd792f5b1be47ed85f8938c0f29586af0d3ac7b977f21c278fe1462040e3",
+		pk:  "a491d1b0ecd9bb917989f0e74f0dea0422eac4a873e5e2644f368dffb9a6e20fd6e10c1b77654d067c0618f6e5a7f79a",
+		msg: "0000000000000000000000000000000000000000000000000000000000000000",
+		sig: "b6ed936746e01f8ecf281f020953fbf1f01debd5657c4a383940b020b26507f6076334f91e2366c96e9ab279fb5158090352ea1c5b0c9274504f4f0e7053af24802e51e4568d164fe986834f41e55c8e850ce1f98458c0cfc9ab380b55285a55",
+	},
+	{
+		sk:  "263dbd792f5b1be47ed85f8938c0f29586af0d3ac7b977f21c278fe1462040e3",
+		pk:  "a491d1b0ecd9bb917989f0e74f0dea0422eac4a873e5e2644f368dffb9a6e20fd6e10c1b77654d067c0618f6e5a7f79a",
+		msg: "5656565656565656565656565656565656565656565656565656565656565656",
+		sig: "882730e5d03f6b42c3abc26d3372625034e1d871b65a8a6b900a56dae22da98abbe1b68f85e49fe7652a55ec3d0591c20767677e33e5cbb1207315c41a9ac03be39c2e7668edc043d6cb1d9fd93033caa8a1c5b0e84bedaeb6c64972503a43eb",
+	},
+	{
+		sk:  "263dbd792f5b1be47ed85f8938c0f29586af0d3ac7b977f21c278fe1462040e3",
+		pk:  "a491d1b0ecd9bb917989f0e74f0dea0422eac4a873e5e2644f368dffb9a6e20fd6e10c1b77654d067c0618f6e5a7f79a",
+		msg: "abababababababababababababababababababababababababababababababab",
+		sig: "91347bccf740d859038fcdcaf233eeceb2a436bcaaee9b2aa3bfb70efe29dfb2677562ccbea1c8e061fb9971b0753c240622fab78489ce96768259fc01360346da5b9f579e5da0d941e4c6ba18a0e64906082375394f337fa1af2b7127b0d121",
+	},
+	{
+		sk:  "47b8192d77bf871b62e87859d653922725724a5c031afeabc60bcef5ff665138",
+		pk:  "b301803f8b5ac4a1133581fc676dfedc60d891dd5fa99028805e5ea5b08d3491af75d0707adab3b70c6a6a580217bf81",
+		msg: "0000000000000000000000000000000000000000000000000000000000000000",
+		sig: "b23c46be3a001c63ca711f87a005c200cc550b9429d5f4eb38d74322144f1b63926da3388979e5321012fb1a0526bcd100b5ef5fe72628ce4cd5e904aeaa3279527843fae5ca9ca675f4f51ed8f83bbf7155da9ecc9663100a885d5dc6df96d9",
+	},
+	{
+		sk:  "328388aff0d4a5b7dc9205abd374e7e98f3cd9f3418edb4eafda5fb16473d216",
+		pk:  "b53d21a4cfd562c469cc81514d4ce5a6b577d8403d32a394dc265dd190b47fa9f829fdd7963afdf972e5e77854051f6f",
+		msg: "0000000000000000000000000000000000000000000000000000000000000000",
+		sig: "948a7cb99f76d616c2c564ce9bf4a519f1bea6b0a624a02276443c245854219fabb8d4ce061d255af5330b078d5380681751aa7053da2c98bae898edc218c75f07e24d8802a17cd1f6833b71e58f5eb5b94208b4d0bb3848cecb075ea21be115",
+	},
+}
+
+// aggregate of the signatures of the vectors on the zero message
+const aggregateVector = "9683b3e6701f9a4b706709577963110043af78a5b41991b998475a3d3fd62abf35ce03b33908418efc95a058494a8ae504354b9f626231f6b3f3c849dfdeaf5017c4780e2aee1850ceaf4b4d9ce70971a3d2cfcd97b7e5ecf6759f8da5f76d31"
+
+func fromHex(t *testing.T, s string) []byte {
+	buff, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return buff
+}
+
+func TestVectors(t *testing.T) {
+	for i, v := range vectors {
+		t.Run(fmt.Sprintf("vector-%d", i), func(t *testing.T) {
+			sk := new(SecretKey)
+			require.NoError(t, sk.UnmarshalBinary(fromHex(t, v.sk)))
+			require.Equal(t, v.pk, sk.PublicKey().String())
+
+			msg := fromHex(t, v.msg)
+			sig, err := sk.Sign(msg, nil)
+			require.NoError(t, err)
+			require.Equal(t, v.sig, sig.(*Signature).String())
+
+			pk := new(PublicKey)
+			require.NoError(t, pk.UnmarshalBinary(fromHex(t, v.pk)))
+			sig2 := new(Signature)
+			require.NoError(t, sig2.UnmarshalBinary(fromHex(t, v.sig)))
+			require.NoError(t, pk.VerifySignature(msg, sig2))
+			require.Error(t, pk.VerifySignature([]byte("another message"), sig2))
+		})
+	}
+}
+
+func TestAggregateVector(t *testing.T) {
+	cons := NewConstructor()
+	msg := fromHex(t, vectors[0].msg)
+	var ids []h.Identity
+	sig := cons.Signature()
+	for _, v := range vectors {
+		if v.msg != vectors[0].msg {
+			continue
+		}
+		pk := new(PublicKey)
+		require.NoError(t, pk.UnmarshalBinary(fromHex(t, v.pk)))
+		ids = append(ids, h.NewStaticIdentity(int32(len(ids)), "", pk))
+		s := new(Signature)
+		require.NoError(t, s.UnmarshalBinary(fromHex(t, v.sig)))
+		sig = sig.Combine(s)
+	}
+	require.Equal(t, aggregateVector, sig.(*Signature).String())
+
+	bs := h.NewWilffBitset(len(ids))
+	for i := range ids {
+		bs.Set(i, true)
+	}
+	apk, err := cons.AggregatePublicKey(ids, bs)
+	require.NoError(t, err)
+	require.NoError(t, apk.VerifySignature(msg, sig))
+
+	bs.Set(0, false)
+	apk, err = cons.AggregatePublicKey(ids, bs)
+	require.NoError(t, err)
+	require.Error(t, apk.VerifySignature(msg, sig))
+}
+
+func TestSign(t *testing.T) {
+	reader := rand.Reader
+	msg := []byte("Get Funky Tonight")
+
+	sk, pk, err := NewKeyPair(reader)
+	require.NoError(t, err)
+
+	sig, err := sk.Sign(msg, nil)
+	require.NoError(t, err)
+
+	buff, _ := sig.MarshalBinary()
+	require.Len(t, buff, 96)
+	buff, _ = pk.MarshalBinary()
+	require.Len(t, buff, 48)
+
+	err = pk.VerifySignature(msg, sig)
+	require.NoError(t, err)
+}
+
+func TestCombine(t *testing.T) {
+	reader := rand.Reader
+	msg := []byte("Get Funky Tonight")
+
+	sk1, pk1, err := NewKeyPair(reader)
+	require.NoError(t, err)
+
+	sk2, pk2, err := NewKeyPair(reader)
+	require.NoError(t, err)
+
+	require.NotEqual(t, pk1.String(), pk2.String())
+
+	sig1, err := sk1.Sign(msg, nil)
+	require.NoError(t, err)
+	require.NoError(t, pk1.VerifySignature(msg, sig1))
+
+	sig2, err := sk2.Sign(msg, nil)
+	require.NoError(t, err)
+	require.NoError(t, pk2.VerifySignature(msg, sig2))
+
+	sig3 := sig1.Combine(sig2)
+	pk3 := pk1.Combine(pk2)
+	require.NoError(t, pk3.VerifySignature(msg, sig3))
+
+	sub := sig3.(h.SubtractableSignature).Subtract(sig1)
+	require.NoError(t, pk2.VerifySignature(msg, sub))
+	require.Error(t, pk1.VerifySignature(msg, sub))
+
+	// the empty values of the constructor are neutral
+	cons := NewConstructor()
+	require.Equal(t, sig1, cons.Signature().Combine(sig1))
+	require.Equal(t, pk1, cons.PublicKey().Combine(pk1))
+}
+
+func TestVerifyBatch(t *testing.T) {
+	msg := []byte("Sweet Dreams")
+	keys, sigs := batch(t, msg, 4)
+	cons := NewConstructor()
+	var _ h.BatchVerifier = cons
+	require.NoError(t, cons.VerifyBatch(msg, keys, sigs))
+	require.NoError(t, cons.VerifyBatch(msg, nil, nil))
+	require.Error(t, cons.VerifyBatch([]byte("another message"), keys, sigs))
+	require.Error(t, cons.VerifyBatch(msg, keys[1:], sigs))
+
+	// one invalid signature
+	invalid := append([]h.Signature{}, sigs...)
+	invalid[2] = sigs[1]
+	require.Error(t, cons.VerifyBatch(msg, keys, invalid))
+
+	// two invalid signatures whose sum is valid
+	x := sigs[3]
+	invalid = append([]h.Signature{}, sigs...)
+	invalid[0] = sigs[0].Combine(x)
+	invalid[1] = sigs[1].(h.SubtractableSignature).Subtract(x)
+	sum := invalid[0].Combine(invalid[1])
+	require.NoError(t, keys[0].Combine(keys[1]).VerifySignature(msg, sum))
+	require.Error(t, cons.VerifyBatch(msg, keys, invalid))
+}
+
+// batch returns n public keys and their signatures on msg.
+func batch(t testing.TB, msg []byte, n int) ([]h.PublicKey, []h.Signature) {
+	keys := make([]h.PublicKey, n)
+	sigs := make([]h.Signature, n)
+	for i := range keys {
+		sk, pk, err := NewKeyPair(nil)
+		require.NoError(t, err)
+		sig, err := sk.Sign(msg, nil)
+		require.NoError(t, err)
+		keys[i], sigs[i] = pk, sig
+	}
+	return keys, sigs
+}
+
+func BenchmarkVerifyBatch(b *testing.B) {
+	msg := []byte("Sweet Dreams")
+	cons := NewConstructor()
+	for _, n := range []int{1, 4, 16, 64} {
+		keys, sigs := batch(b, msg, n)
+		b.Run(fmt.Sprintf("batch-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := cons.VerifyBatch(msg, keys, sigs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("one-by-one-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := range sigs {
+					if err := keys[j].VerifySignature(msg, sigs[j]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalling(t *testing.T) {
+	sk, pk, err := NewKeyPair(nil)
+	require.NoError(t, err)
+
+	buffSK, err := sk.MarshalBinary()
+	require.NoError(t, err)
+
+	buffPK, err := pk.MarshalBinary()
+	require.NoError(t, err)
+
+	cons := NewConstructor()
+
+	sk2 := cons.SecretKey()
+	err = sk2.(*SecretKey).UnmarshalBinary(buffSK)
+	require.NoError(t, err)
+	require.Equal(t, pk.String(), sk2.(*SecretKey).PublicKey().String())
+
+	pk2 := cons.PublicKey()
+	err = pk2.(*PublicKey).UnmarshalBinary(buffPK)
+	require.NoError(t, err)
+	require.Equal(t, pk.String(), pk2.(*PublicKey).String())
+
+	// zero and out of range secret keys
+	require.Error(t, new(SecretKey).UnmarshalBinary(make([]byte, 32)))
+	order := "73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001"
+	require.Error(t, new(SecretKey).UnmarshalBinary(fromHex(t, order)))
+	require.Error(t, new(SecretKey).UnmarshalBinary(buffSK[1:]))
+
+	// point at infinity and invalid encodings
+	infinity := make([]byte, 48)
+	infinity[0] = 0xc0
+	require.Error(t, new(PublicKey).UnmarshalBinary(infinity))
+	require.Error(t, new(PublicKey).UnmarshalBinary(buffPK[1:]))
+	require.Error(t, new(Signature).UnmarshalBinary(make([]byte, 96)))
+
+	_, err = new(Signature).MarshalBinary()
+	require.Error(t, err)
+}
+
+func TestAggregatePublicKey(t *testing.T) {
+	n := 8
+	var ids []h.Identity
+	var pubs []*PublicKey
+	for i := 0; i < n; i++ {
+		_, pub, err := NewKeyPair(nil)
+		require.NoError(t, err)
+		ids = append(ids, h.NewStaticIdentity(int32(i), "", pub))
+		pubs = append(pubs, pub)
+	}
+
+	cons := NewCachingConstructor(2)
+	bitset := func(idx ...int) h.BitSet {
+		bs := h.NewWilffBitset(n)
+		for _, i := range idx {
+			bs.Set(i, true)
+		}
+		return bs
+	}
+
+	apk, err := cons.AggregatePublicKey(ids, bitset(1, 4, 6))
+	require.NoError(t, err)
+	expected := pubs[1].Combine(pubs[4]).Combine(pubs[6])
+	require.Equal(t, expected.(*PublicKey).String(), apk.String())
+	require.Equal(t, 1, cons.keys.len())
+
+	// same bitset gives the cached key
+	apk2, err := cons.AggregatePublicKey(ids, bitset(1, 4, 6))
+	require.NoError(t, err)
+	require.True(t, apk == apk2)
+	require.Equal(t, 1, cons.keys.len())
+
+	// the same bitset over other identities is another key
+	other := append([]h.Identity{}, ids...)
+	other[0], other[1] = other[1], other[0]
+	apk3, err := cons.AggregatePublicKey(other, bitset(1, 4, 6))
+	require.NoError(t, err)
+	expected = pubs[0].Combine(pubs[4]).Combine(pubs[6])
+	require.Equal(t, expected.(*PublicKey).String(), apk3.String())
+	require.Equal(t, 2, cons.keys.len())
+
+	// the cache stays bounded, evicting the least recently used key
+	_, err = cons.AggregatePublicKey(ids, bitset(1, 4, 6))
+	require.NoError(t, err)
+	_, err = cons.AggregatePublicKey(ids, bitset(0))
+	require.NoError(t, err)
+	require.Equal(t, 2, cons.keys.len())
+	apk4, err := cons.AggregatePublicKey(ids, bitset(1, 4, 6))
+	require.NoError(t, err)
+	require.True(t, apk == apk4)
+	key, err := keyCacheKey(other, bitset(1, 4, 6))
+	require.NoError(t, err)
+	_, ok := cons.keys.get(key)
+	require.False(t, ok)
+
+	// no cache
+	cons = NewCachingConstructor(0)
+	apk5, err := cons.AggregatePublicKey(ids, bitset(1, 4, 6))
+	require.NoError(t, err)
+	require.Equal(t, apk.String(), apk5.String())
+	require.Equal(t, 0, cons.keys.len())
+
+	_, err = cons.AggregatePublicKey(ids[1:], bitset(1))
+	require.Error(t, err)
+}
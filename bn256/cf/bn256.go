@@ -200,6 +200,15 @@ func (m *SigBLS) Combine(ms handel.Signature) handel.Signature {
 	return &SigBLS{e: res}
 }
 
+// Subtract implements the handel.SubtractableSignature interface
+func (m *SigBLS) Subtract(ms handel.Signature) handel.Signature {
+	m2 := ms.(*SigBLS)
+	neg := new(bn256.G1).Neg(m2.e)
+	res := new(bn256.G1)
+	res.Add(m.e, neg)
+	return &SigBLS{e: res}
+}
+
 func (m *SigBLS) String() string {
 	return m.e.String()
 }
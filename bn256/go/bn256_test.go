@@ -78,6 +78,10 @@ func TestCombine(t *testing.T) {
 	sig3 := sig1.Combine(sig2)
 	pk3 := pk1.Combine(pk2)
 	require.NoError(t, pk3.VerifySignature(msg, sig3))
+
+	sub := sig3.(h.SubtractableSignature).Subtract(sig1)
+	require.NoError(t, pk2.VerifySignature(msg, sub))
+	require.Error(t, pk1.VerifySignature(msg, sub))
 }
 
 func TestMarshalling(t *testing.T) {
@@ -0,0 +1,172 @@
+package handel
+
+import (
+	"bytes"
+	"errors"
+	"math"
+)
+
+// chunkKey identifies the packet the chunks received belong to, by the
+// sequence number its origin gave it.
+type chunkKey struct {
+	origin int32
+	level  byte
+	seq    uint32
+}
+
+// chunkBuffer holds the chunks of a packet received so far. The chunks are
+// kept by index in a map, so the buffer only grows with the chunks actually
+// received and not with the count announced by an unauthenticated chunk.
+type chunkBuffer struct {
+	parts map[uint16][]byte
+	count uint16
+	ind   []byte
+	auth  []byte
+	// tick of the periodic update at which the last chunk has been received
+	tick int
+}
+
+// chunkBufferTicks is the number of periodic updates the chunks of an
+// incomplete packet are kept for without receiving a new chunk.
+const chunkBufferTicks = 3
+
+// chunkBuffersPerLevel is the maximum number of packets of an origin and level
+// whose chunks are buffered at the same time. The buffers are bounded even if
+// the origin sends chunks of many sequence numbers.
+const chunkBuffersPerLevel = 4
+
+// chunkPacket splits the multi-signature of the packet in chunks of at most
+// size bytes, numbered with the given sequence number. The individual
+// signature is carried by the first chunk. It returns the packet itself if the
+// multi-signature does not need to be split.
+func chunkPacket(p *Packet, size int, seq uint32) ([]*Packet, error) {
+	count := (len(p.MultiSig) + size - 1) / size
+	if count <= 1 {
+		return []*Packet{p}, nil
+	}
+	if count > math.MaxUint16 {
+		return nil, errors.New("too many chunks for the multi-signature")
+	}
+	packets := make([]*Packet, count)
+	for i := range packets {
+		end := min((i+1)*size, len(p.MultiSig))
+		packets[i] = &Packet{
+			Version:    p.Version,
+			Origin:     p.Origin,
+			Level:      p.Level,
+			MultiSig:   p.MultiSig[i*size : end],
+			ChunkIndex: uint16(i),
+			ChunkCount: uint16(count),
+			ChunkSeq:   seq,
+		}
+	}
+	packets[0].IndividualSig = p.IndividualSig
+	packets[0].Auth = p.Auth
+	return packets, nil
+}
+
+// reassemble buffers the chunk and returns the packet it belongs to once all
+// its chunks have been received. The chunks of a packet are the ones of the
+// same origin, level and sequence number, so the chunks of different packets
+// are never mixed, and may arrive in any order. A chunk of a different count
+// than the ones buffered for its packet starts it again.
+func (h *Handel) reassemble(p *Packet) (*Packet, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.done {
+		return nil, false
+	}
+	if err := h.validateChunk(p); err != nil {
+		h.log.Warn("invalid_chunk", err)
+		return nil, false
+	}
+	key := chunkKey{p.Origin, p.Level, p.ChunkSeq}
+	buf, ok := h.chunks[key]
+	if !ok {
+		h.limitChunks(key)
+	}
+	if !ok || buf.count != p.ChunkCount {
+		buf = &chunkBuffer{
+			parts: make(map[uint16][]byte),
+			count: p.ChunkCount,
+		}
+		h.chunks[key] = buf
+	}
+	buf.tick = h.tick
+	buf.parts[p.ChunkIndex] = p.MultiSig
+	if p.IndividualSig != nil {
+		buf.ind = p.IndividualSig
+	}
+	if p.Auth != nil {
+		buf.auth = p.Auth
+	}
+	if len(buf.parts) < int(buf.count) {
+		return nil, false
+	}
+	delete(h.chunks, key)
+	parts := make([][]byte, buf.count)
+	for i, part := range buf.parts {
+		parts[i] = part
+	}
+	return &Packet{
+		Version:       p.Version,
+		Origin:        p.Origin,
+		Level:         p.Level,
+		MultiSig:      bytes.Join(parts, nil),
+		IndividualSig: buf.ind,
+		Auth:          buf.auth,
+	}, true
+}
+
+// limitChunks drops the chunks of the packet of the lowest sequence number of
+// the origin and level of the key if chunkBuffersPerLevel packets are buffered
+// already. The lock must be held.
+func (h *Handel) limitChunks(key chunkKey) {
+	count := 0
+	var oldest chunkKey
+	for k := range h.chunks {
+		if k.origin != key.origin || k.level != key.level {
+			continue
+		}
+		if count == 0 || k.seq < oldest.seq {
+			oldest = k
+		}
+		count++
+	}
+	if count >= chunkBuffersPerLevel {
+		delete(h.chunks, oldest)
+	}
+}
+
+// evictChunks drops the chunks of the packets that received no new chunk
+// during chunkBufferTicks periodic updates. The lock must be held.
+func (h *Handel) evictChunks() {
+	for key, buf := range h.chunks {
+		if h.tick-buf.tick >= chunkBufferTicks {
+			delete(h.chunks, key)
+		}
+	}
+}
+
+// validateChunk verifies the chunk can be buffered: its origin must be a node
+// we can receive from and its level must exist, so the number of buffers is
+// bounded.
+func (h *Handel) validateChunk(p *Packet) error {
+	if err := p.checkVersion(); err != nil {
+		return err
+	}
+	if p.ChunkIndex >= p.ChunkCount {
+		return errors.New("chunk index out of range")
+	}
+	if h.members != nil {
+		if _, isMember := h.members[p.Origin]; !isMember {
+			return errors.New("origin not a member")
+		}
+	} else if p.Origin < 0 || p.Origin >= int32(h.reg.Size()) {
+		return errors.New("packet's origin out of range")
+	}
+	if _, exists := h.levels[int(p.Level)]; !exists || p.Level == 0 {
+		return errors.New("invalid packet's level")
+	}
+	return nil
+}
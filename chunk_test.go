@@ -0,0 +1,209 @@
+package handel
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkPacket(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+
+	buff, err := fullSig(4).MarshalBinary()
+	require.NoError(t, err)
+	ind, err := new(fakeSig).MarshalBinary()
+	require.NoError(t, err)
+	p := &Packet{Origin: 12, Level: 4, MultiSig: buff, IndividualSig: ind, Auth: []byte{1}}
+	size := (len(buff) + 2) / 3
+	chunks, err := chunkPacket(p, size, 1)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	for i, c := range chunks {
+		require.Equal(t, uint16(i), c.ChunkIndex)
+		require.Equal(t, uint16(3), c.ChunkCount)
+		require.Equal(t, uint32(1), c.ChunkSeq)
+		require.True(t, len(c.MultiSig) <= size)
+	}
+	single, err := chunkPacket(p, len(buff), 1)
+	require.NoError(t, err)
+	require.Equal(t, []*Packet{p}, single)
+
+	// chunks out of order, with a duplicate
+	_, complete := h.reassemble(chunks[2])
+	require.False(t, complete)
+	_, complete = h.reassemble(chunks[2])
+	require.False(t, complete)
+	_, complete = h.reassemble(chunks[0])
+	require.False(t, complete)
+	full, complete := h.reassemble(chunks[1])
+	require.True(t, complete)
+	require.Equal(t, p, full)
+	require.Empty(t, h.chunks)
+
+	// the chunks of the next packet are not mixed with the ones of the
+	// previous packet, even if they arrive before its first chunk
+	next := *p
+	next.MultiSig = append([]byte{}, buff...)
+	next.MultiSig[len(buff)-1]++
+	nextChunks, err := chunkPacket(&next, size, 2)
+	require.NoError(t, err)
+	h.reassemble(chunks[0])
+	h.reassemble(chunks[1])
+	_, complete = h.reassemble(nextChunks[2])
+	require.False(t, complete)
+	_, complete = h.reassemble(nextChunks[1])
+	require.False(t, complete)
+	full, complete = h.reassemble(nextChunks[0])
+	require.True(t, complete)
+	require.Equal(t, &next, full)
+	full, complete = h.reassemble(chunks[2])
+	require.True(t, complete)
+	require.Equal(t, p, full)
+
+	// the chunks of an incomplete packet are dropped after a few periodic
+	// updates
+	h.reassemble(chunks[0])
+	require.Len(t, h.chunks, 1)
+	h.Lock()
+	for i := 0; i < chunkBufferTicks; i++ {
+		h.tick++
+		h.evictChunks()
+	}
+	h.Unlock()
+	require.Empty(t, h.chunks)
+
+	// invalid chunks are not buffered
+	invalid := *chunks[0]
+	invalid.ChunkIndex = 3
+	_, complete = h.reassemble(&invalid)
+	require.False(t, complete)
+	invalid = *chunks[0]
+	invalid.Origin = int32(n)
+	_, complete = h.reassemble(&invalid)
+	require.False(t, complete)
+	require.Empty(t, h.chunks)
+
+	// a chunk announcing the maximum count only buffers what it carries
+	huge := *chunks[1]
+	huge.ChunkCount = math.MaxUint16
+	_, complete = h.reassemble(&huge)
+	require.False(t, complete)
+	buf := h.chunks[chunkKey{p.Origin, p.Level, 1}]
+	require.Len(t, buf.parts, 1)
+	require.Equal(t, uint16(math.MaxUint16), buf.count)
+	delete(h.chunks, chunkKey{p.Origin, p.Level, 1})
+
+	// the packets of an origin and level buffered at the same time are
+	// bounded
+	for seq := uint32(1); seq <= chunkBuffersPerLevel+1; seq++ {
+		c := *chunks[0]
+		c.ChunkSeq = seq
+		h.reassemble(&c)
+	}
+	require.Len(t, h.chunks, chunkBuffersPerLevel)
+	_, kept := h.chunks[chunkKey{p.Origin, p.Level, 1}]
+	require.False(t, kept)
+}
+
+func TestHandelMaxChunkSize(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	nets := newOrderedNetworks(n)
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.NewTimeoutStrategy = newInfiniteTimeout
+	config.MaxChunkSize = 2
+	config.PacketAuth = idAuth{}
+	handels := make([]*Handel, n)
+	for i := range handels {
+		id, _ := reg.Identity(i)
+		handels[i] = NewHandel(nets[i], reg, id, new(fakeCons), msg, &fakeSig{true}, config)
+	}
+	defer CloseHandels(handels)
+	for _, net := range nets {
+		go net.run()
+		defer net.close()
+	}
+	for _, h := range handels {
+		go h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality())
+		case <-time.After(10 * time.Second):
+			t.Fatalf("instance %d did not complete", i)
+		}
+	}
+}
+
+// orderedNetwork delivers the packets sent to a node in the order they are
+// sent, from a single routine, so the chunks of a packet are received one
+// after the other and not evicted while other routines are scheduled.
+type orderedNetwork struct {
+	sync.Mutex
+	cond    *sync.Cond
+	list    []*orderedNetwork
+	lis     Listener
+	pending []*Packet
+	closed  bool
+}
+
+func newOrderedNetworks(n int) []*orderedNetwork {
+	nets := make([]*orderedNetwork, n)
+	for i := range nets {
+		nets[i] = &orderedNetwork{list: nets}
+		nets[i].cond = sync.NewCond(nets[i])
+	}
+	return nets
+}
+
+func (o *orderedNetwork) RegisterListener(l Listener) {
+	o.Lock()
+	defer o.Unlock()
+	o.lis = l
+}
+
+func (o *orderedNetwork) Send(ids []Identity, p *Packet) {
+	for _, id := range ids {
+		dst := o.list[id.ID()]
+		dst.Lock()
+		dst.pending = append(dst.pending, p)
+		dst.cond.Signal()
+		dst.Unlock()
+	}
+}
+
+// run dispatches the packets received until close is called.
+func (o *orderedNetwork) run() {
+	for {
+		o.Lock()
+		for len(o.pending) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+		if o.closed {
+			o.Unlock()
+			return
+		}
+		p := o.pending[0]
+		o.pending = o.pending[1:]
+		lis := o.lis
+		o.Unlock()
+		lis.NewPacket(p)
+	}
+}
+
+func (o *orderedNetwork) close() {
+	o.Lock()
+	defer o.Unlock()
+	o.closed = true
+	o.cond.Broadcast()
+}
@@ -22,21 +22,39 @@ type Config struct {
 	// about its state to other Handel nodes.
 	UpdatePeriod time.Duration
 
+	// UpdateJitter varies the interval between two periodic updates by a
+	// random fraction of UpdatePeriod of at most UpdateJitter, drawn
+	// uniformly for each interval, so the nodes started together do not send
+	// their updates at the same time. The mean interval stays UpdatePeriod.
+	// It must be in [0, 1); zero disables the jitter. It has no effect with a
+	// Scheduler.
+	UpdateJitter float64
+
 	// UpdateCount indicates the number of nodes contacted during each update at
 	// a given level.
 	UpdateCount int
 
+	// Window decides the number of nodes contacted during each update at a
+	// given level instead of UpdateCount, e.g. NewExponentialWindow. Nil
+	// means UpdateCount nodes are contacted.
+	Window WindowPolicy
+
 	// FastPath indicates how many peers should we contact when a level gets
 	// completed.
 	FastPath int
 
 	// NewBitSet returns an empty bitset. This function is used to parse
-	// incoming packets containing bitsets.
+	// incoming packets containing bitsets. NewRoaringBitset saves memory
+	// and bandwidth with large registries, whose bitsets are mostly sparse.
+	// All the nodes must use the same implementation, as their encodings
+	// differ.
 	NewBitSet func(bitlength int) BitSet
 
 	// NewPartitioner returns the Partitioner to use for this Handel round. If
 	// nil, it returns the RandomBinPartitioner. The id is the ID Handel is
-	// responsible for and reg is the global registry of participants.
+	// responsible for and reg is the global registry of participants. See
+	// NewShufflePartitioner for a partitioner ordering the peers of the levels
+	// from a shared seed.
 	NewPartitioner func(id int32, reg Registry, Logger Logger) Partitioner
 
 	// NewEvaluatorStrategy returns the signature evaluator to use during the
@@ -63,6 +81,401 @@ type Config struct {
 	// Set to zero by default: no sleep time. When activated the sleep replaces the verification.
 	// This sleep time is approximate and depends on golang and the os. The actual delay can be longer.
 	UnsafeSleepTimeOnSigVerify int
+
+	// OnTick is called at the end of each periodic update with the tick
+	// number (starting at 1) and, for each level, the IDs of the nodes that
+	// have been contacted during that tick. It is called while Handel's lock is
+	// held, so it must not call back into Handel.
+	OnTick func(tick int, sends map[int][]int32)
+
+	// StallTimeout is the duration after which Handel considers itself stalled
+	// if it did not verify any new signature and did not reach the threshold
+	// yet. When stalled, Handel re-submits for verification the signatures
+	// dropped unverified, if any are kept (see RetryBufferSize). Zero
+	// disables the stall detection.
+	StallTimeout time.Duration
+
+	// RetryBufferSize is the maximum number of signatures dropped unverified by
+	// the evaluator that are kept to be verified when Handel stalls. Zero
+	// means no signature is kept.
+	RetryBufferSize int
+
+	// VerifyPacing is the minimum interval between the start of two
+	// consecutive signature verifications, whatever the processing. A batch
+	// verification counts as one, see BatchSize. It bounds the CPU used by
+	// Handel for verifying signatures, at the cost of latency. Zero means no
+	// pacing.
+	VerifyPacing time.Duration
+
+	// OnPacketAfterDone is called with each packet received after Handel has
+	// been stopped, instead of silently dropping it. It can be used to log,
+	// count or buffer late packets for a subsequent round. It is called while
+	// Handel's lock is held, so it must not call back into Handel.
+	OnPacketAfterDone func(*Packet)
+
+	// OnInvalidPacket is called with the error and the packet, as received,
+	// of each packet rejected as invalid, e.g. to count or inspect the
+	// rejects of a misconfigured cluster. The error wraps
+	// ErrLevelOutOfRange, ErrOriginOutOfRange, ErrOriginNotContributing or
+	// ErrUnmarshal for the corresponding rejects, see errors.Is. It is called without holding
+	// Handel's lock, possibly concurrently.
+	OnInvalidPacket func(err error, p *Packet)
+
+	// MinImprovementToResend is the minimum number of new contributions the
+	// signature of a level must gain before Handel sends it again to all the
+	// peers of that level. A complete signature is always sent. Zero or one
+	// means any improvement triggers a re-dissemination.
+	MinImprovementToResend int
+
+	// OnActorPanic is called with the recovered value whenever one of the
+	// actors processing a verified signature panics. Handel logs the panic and
+	// keeps running in any case. It is called while Handel's lock is held, so
+	// it must not call back into Handel.
+	OnActorPanic func(r interface{})
+
+	// ProgressStep is the number of contributions between two calls to
+	// OnProgress. Zero disables the progress notifications.
+	ProgressStep int
+
+	// OnProgress is called with the cardinality of the full signature and the
+	// size of the registry each time the full signature reaches a multiple of
+	// ProgressStep it had not reached yet. It is called once if several
+	// multiples are reached at once. It is called while Handel's lock is held,
+	// so it must not call back into Handel.
+	OnProgress func(card, total int)
+
+	// SendConcurrency is the maximum number of levels whose update is sent
+	// concurrently during a periodic update. The sends are then done outside of
+	// Handel's lock, so the Network must be safe for concurrent use. Zero
+	// means the updates are sent one after the other.
+	SendConcurrency int
+
+	// MaxOutstandingSends is the maximum number of sends in flight at any time,
+	// across the periodic updates and the fast path sends, so a slow Network
+	// does not pile up sending goroutines. A send waits for a slot to be
+	// freed, or is dropped with Config.DropBlockedSends. Zero means no limit
+	// besides SendConcurrency.
+	MaxOutstandingSends int
+
+	// DropBlockedSends drops the sends exceeding Config.MaxOutstandingSends
+	// instead of waiting for a send in flight to complete. The dropped peers
+	// are still considered contacted.
+	DropBlockedSends bool
+
+	// MaxDuration is the maximum duration of a Handel round. Once exceeded,
+	// Handel outputs its current best full signature on the FinalSignatures
+	// channel, even if it does not reach the threshold, and stops. Use
+	// Handel.MaxDurationReached to know if the output has been forced. Zero
+	// means no maximum duration.
+	MaxDuration time.Duration
+
+	// APKCacheSize is the number of aggregate public keys kept in cache by the
+	// processing, so verifying again a multi-signature with the same bitset
+	// at the same level does not recompute its aggregate public key. Zero
+	// disables the cache.
+	APKCacheSize int
+
+	// MemberFilter restricts the aggregation to the identities of the registry
+	// it accepts, e.g. the members of a shard. The levels and the threshold
+	// are computed over the members only and packets from non-members are
+	// dropped. All the members must use the same filter. If nil, all the
+	// identities of the registry are members.
+	MemberFilter func(Identity) bool
+
+	// MaxCandidatesPerLevel is the maximum number of candidate
+	// multi-signatures a SignatureStore may keep per level. A store exceeding
+	// it must evict the candidate with the lowest cardinality. The default
+	// store keeps only the best signature per level, so it is always within
+	// the limit, see MergeStore. Zero means no limit.
+	MaxCandidatesPerLevel int
+
+	// MergeStore makes the store keep, besides the best signature of each
+	// level, up to MaxCandidatesPerLevel signatures of the level none of the
+	// others contains. The best signature is then the largest union of
+	// disjoint candidates, which can be larger than any signature received
+	// when the signatures of a level overlap, e.g. on a lossy network. The
+	// signatures overlapping the best one are then verified as well.
+	MergeStore bool
+
+	// ThresholdDetector decides whether a full multi-signature reaches the
+	// threshold and can be output. If nil, a full multi-signature reaches the
+	// threshold when it has at least Contributions contributions. A stateful
+	// detector must not be shared between Handel instances.
+	ThresholdDetector ThresholdDetector
+
+	// FanoutByLevelSize makes the periodic updates share TickSendBudget
+	// between the active levels proportionally to their number of nodes,
+	// instead of contacting UpdateCount nodes at each level.
+	FanoutByLevelSize bool
+
+	// TickSendBudget is the total number of nodes contacted during a periodic
+	// update when FanoutByLevelSize is set. Each active level contacts at least
+	// one node, so the budget may be exceeded when it is lower than the number
+	// of active levels.
+	TickSendBudget int
+
+	// LastMileGap is the number of contributions a level may miss to be in
+	// its last mile: the periodic updates then contact at least
+	// LastMileFanout peers of the level instead of UpdateCount, to get the
+	// last contributions quickly. Zero disables it.
+	LastMileGap int
+
+	// LastMileFanout is the number of peers contacted during a periodic update
+	// at a level in its last mile, see LastMileGap.
+	LastMileFanout int
+
+	// ShuffleCandidates shuffles the peers of each level with a seed derived
+	// from the ID of the node and the level, instead of using Rand. The order
+	// is then reproducible while differing between nodes, so they do not all
+	// contact the same peers first. It takes precedence over
+	// DisableShuffling.
+	ShuffleCandidates bool
+
+	// SelfPropagationRetries is the maximum number of times Handel sends again
+	// its signatures once it contacted all the peers of its started levels, as
+	// long as it did not observe that its own contribution propagated. A retry
+	// starts the next level not started yet, whose peers have never been
+	// contacted, or once all the levels are started, sends again to the peers
+	// of the levels already contacted. A peer only stops sending its
+	// individual signature at a level once it has received all the
+	// contributions of the level, ours included, so a packet without
+	// individual signature whose multi-signature is valid means our
+	// contribution propagated. The packets received at a level never hold our
+	// own contribution, so it can not be observed directly. Zero disables the
+	// retransmissions.
+	SelfPropagationRetries int
+
+	// DecodeWorkers is the maximum number of incoming packets decoded
+	// concurrently. Packets are always decoded outside of Handel's lock; with
+	// DecodeWorkers set, NewPacket returns before the packet is decoded, so
+	// packets received by a single goroutine are decoded in parallel. NewPacket
+	// blocks while all the workers are busy. Zero means each packet is decoded
+	// by the goroutine calling NewPacket.
+	DecodeWorkers int
+
+	// RejectNonImproving drops, as they are received and before verifying
+	// them, the multi-signatures whose contributions are all in the best
+	// signature already stored at their level, whatever the processing. The
+	// individual signatures are kept to build better signatures. The dropped
+	// signatures are counted in Stats.SigSkipped. DefaultConfig enables it.
+	RejectNonImproving bool
+
+	// AcceptLevelWindow restricts the packets Handel accepts to the ones for
+	// a level at most AcceptLevelWindow levels away from its current level,
+	// i.e. the highest level it started. Packets out of the window are dropped
+	// before being verified and counted in Stats.MsgOutOfWindow. Zero means
+	// packets are accepted whatever their level.
+	AcceptLevelWindow int
+
+	// ReorderVerified makes Handel process the signatures verified at the same
+	// time in ascending level order, instead of the order of their
+	// verification, so the lower levels progress first. The number of
+	// signatures reordered together is bounded.
+	ReorderVerified bool
+
+	// OriginMustContribute rejects the packets whose multi-signature does not
+	// include the contribution of their origin, e.g. packets relayed by a node
+	// on behalf of others, for applications requiring the direct contribution
+	// of the nodes they receive from. Honest Handel nodes always include their
+	// own contribution. The rejects wrap ErrOriginNotContributing.
+	OriginMustContribute bool
+
+	// SelfFirst makes the store combine the signatures in ascending level
+	// order, so the node's own contribution, stored at level 0, is always the
+	// first one of the aggregation, for applications needing a deterministic
+	// combination order. By default the order is arbitrary.
+	SelfFirst bool
+
+	// Scheduler, if set, drives the periodic updates and the verification of
+	// the signatures of Handel, instead of routines of its own, so many Handel
+	// instances can share a bounded number of routines. The scheduler must be
+	// started separately. It has no effect with a custom signature processing.
+	Scheduler *SharedScheduler
+
+	// MaxUselessSends is the number of consecutive sends to a peer of a level
+	// without receiving any verified signature from it after which the peer
+	// is skipped, e.g. because it is offline. The peer is contacted again
+	// after UselessSendsCooldown periodic updates. Zero means peers are never
+	// skipped.
+	MaxUselessSends int
+
+	// UselessSendsCooldown is the number of periodic updates during which a
+	// peer is skipped after MaxUselessSends. Default is
+	// DefaultUselessSendsCooldown.
+	UselessSendsCooldown int
+
+	// MaxUpdateBackoff is the maximum number of UpdatePeriod between two
+	// periodic updates of a level whose signature does not improve: the
+	// interval starts at one period and doubles after each periodic update
+	// sending the same signature as the previous one, up to MaxUpdateBackoff
+	// periods. It is reset once the signature of the level improves. Zero or
+	// one disables the backoff. Independently of it, the levels completed
+	// whose full signature has been sent to all their peers are never sent
+	// again.
+	MaxUpdateBackoff int
+
+	// DryRun makes Handel log the destinations and the size of the
+	// signatures of each packet instead of sending it. Everything else
+	// happens as if the packets were sent, e.g. to trace what a node would do
+	// given the packets it receives.
+	DryRun bool
+
+	// MaxChunkSize is the maximum size of the marshalled multi-signature of a
+	// packet. Larger multi-signatures, e.g. of signature schemes whose
+	// aggregate grows with the number of contributions, are split in several
+	// packets reassembled by the receiver, which does not need to set it. A
+	// packet whose chunks are mixed with the ones of another packet of the
+	// same node and level is dropped as invalid. Zero means the
+	// multi-signatures are never split.
+	MaxChunkSize int
+
+	// OffsetStartPosition makes each node contact first the peer of each
+	// level at the position given by its own ID, instead of the first one of
+	// the list, so the nodes sharing the same list of peers, e.g. with
+	// DisableShuffling, do not all contact the same peer first.
+	OffsetStartPosition bool
+
+	// VerifySelfSig verifies our own signature against our own public key
+	// when creating Handel, which then fails if it is invalid, see
+	// NewHandelErr. It is disabled by default, to save its cost on startup
+	// for the callers trusting their own signer.
+	VerifySelfSig bool
+
+	// PriorityProcessing verifies the pending signatures by descending level
+	// and, at a given level, by descending cardinality, instead of in the
+	// order given by NewEvaluatorStrategy. The options of the evaluator
+	// processing, e.g. RetryBufferSize, are then ignored.
+	PriorityProcessing bool
+
+	// BatchSize is the maximum number of signatures of a level verified at
+	// once when the Constructor is a BatchVerifier. Setting it replaces the
+	// evaluator processing, as PriorityProcessing does, by one verifying the
+	// pending signatures in batches. Zero disables the batches.
+	BatchSize int
+
+	// BatchWindow is the time the batch processing waits after the first
+	// pending signature for more to join the batch, see BatchSize.
+	BatchWindow time.Duration
+
+	// DedupCacheSize is the number of packets recently accepted that Handel
+	// remembers, so a packet identical to one accepted within the last
+	// UpdatePeriod is dropped before being parsed, and counted in
+	// Stats.MsgDuplicate. Zero means DefaultDedupCacheFactor times the number
+	// of nodes, a negative size disables the cache.
+	DedupCacheSize int
+
+	// StorePath is the path of the file where Handel persists the best
+	// signature of each level as they improve, so a node restarting after a
+	// crash during the same round recovers them instead of starting over. The
+	// file of another message is overwritten. Recover reads the signatures of
+	// the file. Empty means the signatures are only kept in memory.
+	StorePath string
+
+	// PacketAuth authenticates the packets sent, and drops the packets
+	// received whose authenticator is not valid for their origin before
+	// parsing them, see NewKeyPacketAuth. Nil means the packets are not
+	// authenticated and their Origin is trusted.
+	PacketAuth PacketAuth
+
+	// PeerScorer orders the peers of each level by descending score at the
+	// beginning of each pass over the level, i.e. each time Handel has a
+	// better signature to send to the whole level, so the peers most likely
+	// to help are contacted first. Each peer is still contacted once per pass.
+	// If nil, the peers are contacted in the order of the level.
+	PeerScorer PeerScorer
+}
+
+// PeerScorer scores the peers of a level, see Config.PeerScorer. It is called
+// while Handel's lock is held.
+type PeerScorer interface {
+	Score(id Identity, level int) float64
+}
+
+// ThresholdDetector decides whether a full multi-signature is good enough to
+// be output by Handel. It is called while Handel's lock is held each time the
+// full multi-signature may have improved.
+type ThresholdDetector interface {
+	Reached(ms *MultiSignature) bool
+}
+
+// sustainedThreshold is a ThresholdDetector only reporting the threshold as
+// reached after it has been reached by a given number of consecutive
+// multi-signatures.
+type sustainedThreshold struct {
+	threshold   int
+	consecutive int
+	count       int
+}
+
+// NewSustainedThreshold returns a ThresholdDetector reporting the threshold as
+// reached once the given number of consecutive multi-signatures have at least
+// threshold contributions. It guards against transient threshold crossings.
+func NewSustainedThreshold(threshold, consecutive int) ThresholdDetector {
+	return &sustainedThreshold{threshold: threshold, consecutive: consecutive}
+}
+
+func (s *sustainedThreshold) Reached(ms *MultiSignature) bool {
+	if ms.Cardinality() < s.threshold {
+		s.count = 0
+		return false
+	}
+	s.count++
+	return s.count >= s.consecutive
+}
+
+// WindowPolicy decides how many peers of a level are contacted during a
+// periodic update. It is called while Handel's lock is held.
+type WindowPolicy interface {
+	// NextCount returns the number of peers to contact at the level, given
+	// the number of peers already contacted with our current signature for
+	// this level and the time elapsed since the level started.
+	NextCount(level int, sent int, elapsed time.Duration) int
+}
+
+// fixedWindow is a WindowPolicy contacting the same number of peers at each
+// update.
+type fixedWindow struct {
+	count int
+}
+
+// NewFixedWindow returns a WindowPolicy contacting count peers at each
+// update, as Config.UpdateCount does.
+func NewFixedWindow(count int) WindowPolicy {
+	return &fixedWindow{count}
+}
+
+func (f *fixedWindow) NextCount(int, int, time.Duration) int {
+	return f.count
+}
+
+// maxWindowDoublings bounds the growth of an exponential window, far above
+// the size of any level.
+const maxWindowDoublings = 20
+
+// exponentialWindow is a WindowPolicy doubling the number of peers contacted
+// each period.
+type exponentialWindow struct {
+	initial int
+	period  time.Duration
+}
+
+// NewExponentialWindow returns a WindowPolicy contacting initial peers at the
+// start of a level, and twice as many each time the given period elapses, so
+// a level does not contact many peers before knowing whether it needs to.
+// The count is naturally bounded by the number of peers of the level.
+func NewExponentialWindow(initial int, period time.Duration) WindowPolicy {
+	return &exponentialWindow{initial, period}
+}
+
+func (e *exponentialWindow) NextCount(level int, sent int, elapsed time.Duration) int {
+	doublings := 0
+	if e.period > 0 {
+		doublings = int(elapsed / e.period)
+	}
+	if doublings > maxWindowDoublings {
+		doublings = maxWindowDoublings
+	}
+	return e.initial << uint(doublings)
 }
 
 // DefaultConfig returns a default configuration for Handel.
@@ -79,6 +492,8 @@ func DefaultConfig(numberOfNodes int) *Config {
 		NewTimeoutStrategy:   DefaultTimeoutStrategy,
 		Logger:               DefaultLogger,
 		Rand:                 rand.Reader,
+		DedupCacheSize:       DefaultDedupCacheFactor * numberOfNodes,
+		RejectNonImproving:   true,
 	}
 }
 
@@ -89,6 +504,14 @@ const DefaultContributionsPerc = 51
 // DefaultCandidateCount is the default candidate count used by Handel.
 const DefaultCandidateCount = 10
 
+// DefaultUselessSendsCooldown is the default number of periodic updates
+// during which a peer is skipped after Config.MaxUselessSends.
+const DefaultUselessSendsCooldown = 100
+
+// DefaultDedupCacheFactor is the default number of packets per node kept by
+// the cache of the packets recently accepted, see Config.DedupCacheSize.
+const DefaultDedupCacheFactor = 4
+
 // DefaultUpdatePeriod is the default update period used by Handel.
 const DefaultUpdatePeriod = 10 * time.Millisecond
 
@@ -140,6 +563,12 @@ func mergeWithDefault(c *Config, size int) *Config {
 	if c.UpdateCount == 0 {
 		c2.UpdateCount = DefaultUpdateCount
 	}
+	if c.DedupCacheSize == 0 {
+		c2.DedupCacheSize = DefaultDedupCacheFactor * size
+	}
+	if c.UselessSendsCooldown == 0 {
+		c2.UselessSendsCooldown = DefaultUselessSendsCooldown
+	}
 	if c.NewBitSet == nil {
 		c2.NewBitSet = DefaultBitSet
 	}
@@ -33,7 +33,9 @@ type SecretKey interface {
 
 // Constructor creates empty signatures of the required type suitable for
 // unmarshalling and empty public keys of the required type suitable for
-// aggregation. See package bn256 for an example.
+// aggregation. See package bn256 for an example. Its methods can be called
+// concurrently, e.g. with Config.DecodeWorkers, and must return a new object
+// at each call. See SignaturePool to reuse the signatures.
 type Constructor interface {
 	// Signature returns a fresh empty signature suitable for unmarshaling
 	Signature() Signature
@@ -53,6 +55,39 @@ type Signature interface {
 	Combine(Signature) Signature
 }
 
+// SubtractableSignature is an optional interface of the signatures whose
+// aggregation can be undone, as BLS signatures. For such signatures, Handel
+// verifies a multi-signature which is a superset of one it already verified
+// by verifying only the contributions it adds, saving the aggregation of the
+// public keys of the contributions already verified.
+type SubtractableSignature interface {
+	Signature
+	// Subtract returns the signature which, combined with the given one,
+	// gives this signature.
+	Subtract(Signature) Signature
+}
+
+// BatchVerifier is an optional interface of the Constructors whose signature
+// scheme can verify several signatures over the same message at once faster
+// than one by one, as BLS with a single multi-pairing check, see package bls.
+// Handel uses it when Config.BatchSize is set.
+type BatchVerifier interface {
+	// VerifyBatch returns nil if each signature is valid for the message under
+	// the public key at the same index, an error otherwise.
+	VerifyBatch(msg []byte, keys []PublicKey, sigs []Signature) error
+}
+
+// AggregateKeyer is an optional interface of the Constructors computing the
+// aggregate public key of a bitset themselves, e.g. to keep them in cache
+// across the levels and the Handel instances sharing the Constructor. Handel
+// uses it instead of combining the public keys one by one when the key is not
+// in the cache of Config.APKCacheSize.
+type AggregateKeyer interface {
+	// AggregatePublicKey returns the aggregate public key of the identities
+	// whose index is set in the bitset.
+	AggregatePublicKey(ids []Identity, bs BitSet) (PublicKey, error)
+}
+
 // MultiSignature represents an aggregated signature alongside with its bitset.
 // The signature is the aggregation of all individual signatures from the nodes
 // whose index is set in the bitset.
@@ -109,6 +144,67 @@ func (m *MultiSignature) Unmarshal(b []byte, s Signature, nbs func(b int) BitSet
 	return nil
 }
 
+// MarshalCompact returns the canonical and minimal encoding of the
+// multisignature, suitable for storage or on-chain verification rather than
+// for dissemination between Handel nodes. The encoding is the bit length of the
+// bitset as an uvarint, followed by the bits packed in bytes (least significant
+// bit first, unused bits set to zero), followed by the signature.
+func (m *MultiSignature) MarshalCompact() ([]byte, error) {
+	sig, err := m.Signature.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	length := m.BitSet.BitLength()
+	var lenBuff [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuff[:], uint64(length))
+	bits := make([]byte, (length+7)/8)
+	for i, ok := m.BitSet.NextSet(0); ok && i < length; i, ok = m.BitSet.NextSet(i + 1) {
+		bits[i/8] |= 1 << uint(i%8)
+	}
+
+	var b bytes.Buffer
+	b.Write(lenBuff[:n])
+	b.Write(bits)
+	b.Write(sig)
+	return b.Bytes(), nil
+}
+
+// UnmarshalCompact reads a multisignature encoded with MarshalCompact from the
+// given slice, using the *empty* signature and bitset interface given.
+func (m *MultiSignature) UnmarshalCompact(b []byte, s Signature, nbs func(b int) BitSet) error {
+	var buff = bytes.NewBuffer(b)
+	length, err := binary.ReadUvarint(buff)
+	if err != nil {
+		return err
+	}
+	// the length must be encoded in as few bytes as possible, so a compact
+	// multi-signature has a single encoding
+	var minimal [binary.MaxVarintLen64]byte
+	if binary.PutUvarint(minimal[:], length) != len(b)-buff.Len() {
+		return errors.New("non canonical compact bit length")
+	}
+	if length > uint64(buff.Len())*8 {
+		return errors.New("compact bitset smaller than its bit length")
+	}
+	bits := buff.Next(int(length+7) / 8)
+	bs := nbs(int(length))
+	for i := 0; i < int(length); i++ {
+		if bits[i/8]&(1<<uint(i%8)) != 0 {
+			bs.Set(i, true)
+		}
+	}
+	if length%8 != 0 && bits[len(bits)-1]>>(length%8) != 0 {
+		return errors.New("non canonical compact bitset")
+	}
+	if err := s.UnmarshalBinary(buff.Bytes()); err != nil {
+		return err
+	}
+
+	m.BitSet = bs
+	m.Signature = s
+	return nil
+}
+
 func (m *MultiSignature) String() string {
 	return fmt.Sprintf("{bitset %d/%d}",
 		m.BitSet.Cardinality(), m.BitSet.BitLength())
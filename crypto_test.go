@@ -22,3 +22,60 @@ func TestMultiSignatureMarshalling(t *testing.T) {
 	require.NoError(t, err)
 
 }
+
+func TestMultiSignatureCompact(t *testing.T) {
+	var tests = []struct {
+		length  int
+		setBits []int
+	}{
+		{0, nil},
+		{1, []int{0}},
+		{8, []int{0, 7}},
+		{10, []int{1, 9}},
+		{2000, []int{0, 5, 1024, 1999}},
+	}
+
+	for i, test := range tests {
+		t.Logf(" -- test %d --", i)
+		bs := NewWilffBitset(test.length)
+		for _, idx := range test.setBits {
+			bs.Set(idx, true)
+		}
+		ms := &MultiSignature{BitSet: bs, Signature: &fakeSig{true}}
+		compact, err := ms.MarshalCompact()
+		require.NoError(t, err)
+
+		ms2 := new(MultiSignature)
+		require.NoError(t, ms2.UnmarshalCompact(compact, new(fakeSig), NewWilffBitset))
+		require.Equal(t, test.length, ms2.BitLength())
+		require.Equal(t, len(test.setBits), ms2.Cardinality())
+		for _, idx := range test.setBits {
+			require.True(t, ms2.Get(idx))
+		}
+		require.Equal(t, ms.Signature, ms2.Signature)
+
+		// the encoding is canonical
+		compact2, err := ms2.MarshalCompact()
+		require.NoError(t, err)
+		require.Equal(t, compact, compact2)
+
+		// and smaller than the wire format
+		wire, err := ms.MarshalBinary()
+		require.NoError(t, err)
+		require.True(t, len(compact) < len(wire), "compact %d >= wire %d", len(compact), len(wire))
+	}
+
+	// a bitset with extra bits set is not canonical
+	ms := &MultiSignature{BitSet: NewWilffBitset(3), Signature: &fakeSig{true}}
+	compact, err := ms.MarshalCompact()
+	require.NoError(t, err)
+	compact[1] |= 0x80
+	require.Error(t, new(MultiSignature).UnmarshalCompact(compact, new(fakeSig), NewWilffBitset))
+	// a bit length encoded on more bytes than needed is not canonical
+	compact[1] &^= 0x80
+	require.NoError(t, new(MultiSignature).UnmarshalCompact(compact, new(fakeSig), NewWilffBitset))
+	padded := append([]byte{compact[0] | 0x80, 0}, compact[1:]...)
+	require.Error(t, new(MultiSignature).UnmarshalCompact(padded, new(fakeSig), NewWilffBitset))
+	// truncated buffer
+	require.Error(t, new(MultiSignature).UnmarshalCompact([]byte{0x10}, new(fakeSig), NewWilffBitset))
+}
@@ -0,0 +1,86 @@
+package handel
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"time"
+)
+
+// packetKey identifies the content of a packet, see Packet.AuthPayload.
+type packetKey [sha256.Size]byte
+
+// packetCache is a LRU cache of the packets recently accepted, so the
+// byte-identical packets received again within the window are dropped before
+// being parsed. An improved signature has different bytes, so it is never
+// dropped. A nil packetCache is a valid, always empty, cache. It is not
+// thread-safe.
+type packetCache struct {
+	size   int
+	window time.Duration
+	// most recently accepted entries first
+	ll *list.List
+	m  map[packetKey]*list.Element
+}
+
+type packetEntry struct {
+	key packetKey
+	at  time.Time
+}
+
+// newPacketCache returns a cache holding up to size packets, or nil if size is
+// not positive.
+func newPacketCache(size int, window time.Duration) *packetCache {
+	if size <= 0 {
+		return nil
+	}
+	return &packetCache{
+		size:   size,
+		window: window,
+		ll:     list.New(),
+		m:      make(map[packetKey]*list.Element),
+	}
+}
+
+// key returns the key of the packet, or false if the cache is disabled so the
+// packet does not need to be hashed.
+func (c *packetCache) key(p *Packet) (packetKey, bool) {
+	if c == nil {
+		return packetKey{}, false
+	}
+	return sha256.Sum256(p.AuthPayload()), true
+}
+
+// seen returns true if a packet of the given key has been accepted less than
+// the window ago.
+func (c *packetCache) seen(key packetKey, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+	e, ok := c.m[key]
+	return ok && now.Sub(e.Value.(*packetEntry).at) <= c.window
+}
+
+// add records the packet of the given key as accepted at the given time.
+func (c *packetCache) add(key packetKey, now time.Time) {
+	if c == nil {
+		return
+	}
+	if e, ok := c.m[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*packetEntry).at = now
+		return
+	}
+	c.m[key] = c.ll.PushFront(&packetEntry{key, now})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.m, oldest.Value.(*packetEntry).key)
+	}
+}
+
+func (c *packetCache) setWindow(window time.Duration) {
+	if c == nil {
+		return
+	}
+	c.window = window
+}
@@ -0,0 +1,45 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketCache(t *testing.T) {
+	var disabled *packetCache
+	_, ok := disabled.key(&Packet{})
+	require.False(t, ok)
+	require.Nil(t, newPacketCache(0, time.Second))
+
+	c := newPacketCache(2, time.Second)
+	p1 := &Packet{Origin: 1, Level: 2, MultiSig: []byte{1}}
+	p2 := &Packet{Origin: 1, Level: 2, MultiSig: []byte{1, 2}}
+	p3 := &Packet{Origin: 2, Level: 2, MultiSig: []byte{1}}
+	k1, ok := c.key(p1)
+	require.True(t, ok)
+	k2, _ := c.key(p2)
+	k3, _ := c.key(p3)
+	// a copy of the packet has the same key
+	copied, _ := c.key(&Packet{Origin: 1, Level: 2, MultiSig: []byte{1}})
+	require.Equal(t, k1, copied)
+
+	now := time.Now()
+	require.False(t, c.seen(k1, now))
+	c.add(k1, now)
+	require.True(t, c.seen(k1, now.Add(time.Second)))
+	require.False(t, c.seen(k1, now.Add(2*time.Second)))
+	require.False(t, c.seen(k2, now))
+
+	// the least recently accepted packet is evicted
+	c.add(k2, now)
+	c.add(k1, now)
+	c.add(k3, now)
+	require.True(t, c.seen(k1, now))
+	require.False(t, c.seen(k2, now))
+	require.True(t, c.seen(k3, now))
+
+	c.setWindow(0)
+	require.False(t, c.seen(k1, now.Add(time.Millisecond)))
+}
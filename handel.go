@@ -2,11 +2,17 @@ package handel
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	mathRand "math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,15 +44,39 @@ type Handel struct {
 	actors []actor
 	// best final signature,i.e. at the last level, seen so far
 	best *MultiSignature
+	// reason why the last full signature checked has not been output, empty
+	// if it has been
+	declineReason string
 	// channel to exposes multi-signatures to the user
 	out chan MultiSignature
+	// channel to expose the contributors added to the full signature, only
+	// created when requested by the user
+	diffs chan []int32
+	// full signature at the time of the last diff
+	diffPrev *MultiSignature
+	// last multiple of Config.ProgressStep notified to Config.OnProgress
+	progressNotified int
+	// functions called when a level completes, see RegisterLevelCallback
+	levelCallbacks []func(level int, cardinality int, t time.Time)
+	// channel receiving the reason of an abort, closed when handel stops
+	aborted chan error
+	// closed when handel stops
+	stopped chan bool
+	// chunks received of the packets split with Config.MaxChunkSize
+	chunks map[chunkKey]*chunkBuffer
+	// sequence number of the last packet split with Config.MaxChunkSize
+	chunkSeq uint32
 	// indicating whether handel is finished or not
 	done bool
+	// indicating whether handel has been stopped by Config.MaxDuration
+	maxDurationReached bool
 	// constant threshold of contributions required in a ms to be considered
 	// valid
 	threshold int
 	// ticker for the periodic update
 	ticker *time.Ticker
+	// source of the variations of the update period, see Config.UpdateJitter
+	jitter *mathRand.Rand
 	// all the levels
 	levels map[int]*level
 	// ids of the level in order as returned by the partitioner
@@ -59,6 +89,66 @@ type Handel struct {
 	log Logger
 	// minimal stats about Handel
 	stats HStats
+	// drops the received multi-signatures not improving the store, nil
+	// without Config.RejectNonImproving
+	improvement *improvementFilter
+	// counters of the cost of the protocol, see Metrics. A pointer, so its
+	// counters are 64-bit aligned for the atomic operations.
+	metrics *metrics
+	// packets recently accepted, see Config.DedupCacheSize
+	dedup *packetCache
+	// number of periodic updates done so far
+	tick int
+	// period of the periodic updates, Config.UpdatePeriod until changed by
+	// SetUpdatePeriod. It is not written to the config, which the caller may
+	// share between several instances.
+	updatePeriod time.Duration
+	// IDs contacted per level during the current periodic update, only
+	// collected when Config.OnTick is set
+	tickSends map[int][]int32
+	// last time a new signature has been verified, used to detect stalls
+	lastProgress time.Time
+	// indicating whether handel is muted, i.e. it does not send anything
+	muted bool
+	// maps the global ID of the members to their ID in the registry, only
+	// used when Config.MemberFilter is set
+	members map[int32]int32
+	// registry given to Handel, marking the origins of the verified signatures
+	// as seen if it is a LivenessRegistry, nil otherwise
+	liveness LivenessRegistry
+	// sends postponed until the end of the current periodic update, only
+	// used when Config.SendConcurrency is set
+	pendingSends  []pendingSend
+	postponeSends bool
+	// indicating whether our own contribution is known to have propagated,
+	// and the number of retransmissions done so far while it is not, see
+	// Config.SelfPropagationRetries
+	selfPropagated bool
+	selfRetries    int
+	// slots of the workers decoding the incoming packets, only used when
+	// Config.DecodeWorkers is set
+	decodeSem chan bool
+	// slots of the sends in flight, only used when Config.MaxOutstandingSends
+	// is set
+	sendSem chan bool
+	// last improvements of the cardinality of the full signature, oldest
+	// first, used to estimate the time to reach the threshold
+	progress []progressSample
+}
+
+// progressSample is the cardinality of the full signature at a given time.
+type progressSample struct {
+	time time.Time
+	card int
+}
+
+// progressSamples is the maximum number of samples kept to compute the ETA.
+const progressSamples = 16
+
+// pendingSend is a packet to send to some nodes, outside of the lock.
+type pendingSend struct {
+	ids []Identity
+	p   *Packet
 }
 
 // NewHandel returns a Handle interface that uses the given network and
@@ -66,20 +156,58 @@ type Handel struct {
 // constructor defines over which curves / signature scheme Handel runs. The
 // message is the message to "multi-sign" by Handel.  The first config in the
 // slice is taken if not nil. Otherwise, the default config generated by
-// DefaultConfig() is used.
+// DefaultConfig() is used. It panics if the arguments are invalid, see
+// NewHandelErr.
 func NewHandel(n Network, r Registry, id Identity, c Constructor,
 	msg []byte, s Signature, conf ...*Config) *Handel {
+	h, err := NewHandelErr(n, r, id, c, msg, s, conf...)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// NewHandelErr is similar to NewHandel but returns an error instead of
+// panicking if Handel can't run with the given arguments, for example with an
+// empty registry or, with Config.VerifySelfSig, an invalid own signature.
+func NewHandelErr(n Network, r Registry, id Identity, c Constructor,
+	msg []byte, s Signature, conf ...*Config) (*Handel, error) {
+	if r.Size() == 0 {
+		return nil, errors.New("handel: empty registry")
+	}
 
 	var config *Config
+	var members map[int32]int32
+	liveness, _ := r.(LivenessRegistry)
 	if len(conf) > 0 && conf[0] != nil {
+		if conf[0].MemberFilter != nil {
+			if !conf[0].MemberFilter(id) {
+				return nil, errors.New("handel: own identity is not a member")
+			}
+			r, members = newMemberRegistry(r, conf[0].MemberFilter)
+			id, _ = r.Identity(int(members[id.ID()]))
+		}
 		config = mergeWithDefault(conf[0], r.Size())
 	} else {
 		config = DefaultConfig(r.Size())
 	}
+	if config.UpdateJitter < 0 || config.UpdateJitter >= 1 {
+		return nil, fmt.Errorf("handel: update jitter %v out of [0, 1)", config.UpdateJitter)
+	}
+	if config.VerifySelfSig {
+		if err := id.PublicKey().VerifySignature(msg, s); err != nil {
+			return nil, fmt.Errorf("handel: invalid own signature: %s", err)
+		}
+	}
 	log := config.Logger.With("id", id.ID())
 	part := config.NewPartitioner(id.ID(), r, log)
-	firstBs := config.NewBitSet(1)
-	firstBs.Set(0, true)
+	// position of our own contribution at level 0, as given by the partitioner
+	myIndex, err := part.IndexAtLevel(id.ID(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("handel: no index for own identity at level 0: %s", err)
+	}
+	firstBs := config.NewBitSet(part.Size(0))
+	firstBs.Set(myIndex, true)
 	mySig := &MultiSignature{BitSet: firstBs, Signature: s}
 
 	h := &Handel{
@@ -92,18 +220,55 @@ func NewHandel(n Network, r Registry, id Identity, c Constructor,
 		msg:         msg,
 		sig:         s,
 		out:         make(chan MultiSignature, 10000),
-		ticker:      time.NewTicker(config.UpdatePeriod),
+		aborted:     make(chan error, 1),
+		stopped:     make(chan bool),
+		chunks:      make(map[chunkKey]*chunkBuffer),
+		dedup:       newPacketCache(config.DedupCacheSize, config.UpdatePeriod),
+		metrics:     new(metrics),
 		log:         log,
-		levels:      createLevels(config, part),
+		levels:      createLevels(config, id.ID(), part),
 		ids:         part.Levels(),
+		members:     members,
+		liveness:    liveness,
+	}
+	h.updatePeriod = config.UpdatePeriod
+	if config.UpdateJitter > 0 {
+		var seed int64
+		if err := binary.Read(config.Rand, binary.BigEndian, &seed); err != nil {
+			return nil, err
+		}
+		h.jitter = mathRand.New(mathRand.NewSource(seed))
+	}
+	h.ticker = time.NewTicker(h.nextUpdatePeriod())
+	if config.DecodeWorkers > 0 {
+		h.decodeSem = make(chan bool, config.DecodeWorkers)
+	}
+	if config.MaxOutstandingSends > 0 {
+		h.sendSem = make(chan bool, config.MaxOutstandingSends)
 	}
 	h.actors = []actor{
 		actorFunc(h.checkCompletedLevel),
 		actorFunc(h.checkFinalSignature),
+		actorFunc(h.checkContributorDiff),
+		actorFunc(h.checkProgress),
 	}
 
 	h.threshold = h.c.Contributions
-	h.store = newStore(part, h.c.NewBitSet, c)
+	st := newStore(part, h.c.NewBitSet, c)
+	h.store = st
+	if config.MergeStore {
+		ms := newMergeStore(part, h.c.NewBitSet, c, config.MaxCandidatesPerLevel)
+		st, h.store = ms.store, ms
+	}
+	st.selfFirst = h.c.SelfFirst
+	if config.StorePath != "" {
+		if h.store, err = newWALStore(h.store, config.StorePath, msg, c, h.c.NewBitSet, h.log); err != nil {
+			return nil, fmt.Errorf("handel: can't open the store file: %s", err)
+		}
+	}
+	if config.RejectNonImproving {
+		h.improvement = &improvementFilter{store: h.store}
+	}
 
 	// We need to add our own sig at level 0
 	ind := &incomingSig{
@@ -111,44 +276,209 @@ func NewHandel(n Network, r Registry, id Identity, c Constructor,
 		level:       0,
 		ms:          mySig,
 		isInd:       true,
-		mappedIndex: 0,
+		mappedIndex: myIndex,
 	}
 	h.store.Store(ind) // Our own sig is at level 0.
 	evaluator := h.c.NewEvaluatorStrategy(h.store, h)
-	h.proc = newEvaluatorProcessing(part, c, msg, config.UnsafeSleepTimeOnSigVerify, evaluator, h.log)
+	switch {
+	case config.BatchSize > 0:
+		h.proc = newBatchProcessing(h.store, part, c, msg, config.BatchSize, config.BatchWindow, config.VerifyPacing)
+	case config.PriorityProcessing:
+		h.proc = newPriorityProcessing(h.store, part, c, msg, config.VerifyPacing)
+	default:
+		h.proc = newEvaluatorProcessing(part, c, msg, config, evaluator, h.log)
+	}
 	h.net.RegisterListener(h)
 	h.timeout = h.c.NewTimeoutStrategy(h, h.ids)
-	return h
+	return h, nil
 }
 
 // NewPacket implements the Listener interface for the network.  It parses the
 // packet and forwards the multisignature (if correct) and the individual
-// signature (if correct) to the processing loop.
+// signature (if correct) to the processing loop. With Config.DecodeWorkers,
+// the packet is parsed by one of the decode workers. The chunks of a packet
+// split with Config.MaxChunkSize are buffered until they are all received.
 func (h *Handel) NewPacket(p *Packet) {
-	h.Lock()
-	defer h.Unlock()
+	atomic.AddInt64(&h.metrics.packetsReceived, 1)
+	if p.ChunkCount > 1 {
+		var complete bool
+		if p, complete = h.reassemble(p); !complete {
+			return
+		}
+	}
+	if h.decodeSem == nil {
+		h.newPacket(p)
+		return
+	}
+	h.decodeSem <- true
+	go func() {
+		defer func() { <-h.decodeSem }()
+		h.newPacket(p)
+	}()
+}
 
-	if h.done {
+// newPacket parses the packet outside of the lock, so packets can be decoded
+// concurrently, and takes the lock to forward its signatures to the
+// processing.
+func (h *Handel) newPacket(p *Packet) {
+	key, dedup := h.dedup.key(p)
+	accepted, err := h.acceptPacket(p, key, dedup)
+	if err != nil {
+		h.invalidPacket(p, err)
 		return
 	}
-	if err := h.validatePacket(p); err != nil {
-		h.log.Warn("invalid_packet", err)
+	if accepted == nil {
 		return
 	}
-	ms, ind, err := h.parseSignatures(p)
+	if err := h.authenticate(p, accepted.Origin); err != nil {
+		h.invalidPacket(p, fmt.Errorf("auth: %s", err))
+		return
+	}
+	// the levels and the partitioner are never modified, so the parsing is
+	// safe without the lock
+	ms, ind, err := h.parseSignatures(accepted)
 	if err != nil {
-		h.log.Warn("invalid_packet - multisig", err)
+		h.invalidPacket(p, err)
+		return
+	}
+	p = accepted
+
+	h.Lock()
+	defer h.Unlock()
+	if h.done {
+		h.release(ms, ind)
 		return
-	} else if !h.getLevel(p.Level).rcvCompleted {
+	}
+	if dedup {
+		h.dedup.add(key, time.Now())
+	}
+	if ind == nil {
+		// the peer completed the level containing our contribution, which is
+		// only trusted once its signature is verified
+		ms.selfAck = true
+	}
+	if !h.getLevel(p.Level).rcvCompleted {
 		// sends it to processing
 		h.log.Debug("rcvd_from", p.Origin, "rcvd_level", p.Level)
-		h.proc.Add(ms)
+		h.addImproving(ms)
 		if ind != nil {
 			// can happen since we don't always send individual signature if this
 			// is a complete level
-			h.proc.Add(ind)
+			h.addImproving(ind)
+		}
+	} else {
+		h.release(ms, ind)
+	}
+}
+
+// invalidPacket logs the error of the given invalid packet, as received, and
+// passes them to Config.OnInvalidPacket. It must be called without holding
+// the lock.
+func (h *Handel) invalidPacket(p *Packet, err error) {
+	h.log.Warn("invalid_packet", err)
+	if h.c.OnInvalidPacket != nil {
+		h.c.OnInvalidPacket(err, p)
+	}
+}
+
+// addImproving forwards the signature to the processing unless it is a
+// multi-signature adding no contribution to the best signature stored at its
+// level, in which case its verification would be wasted, see
+// Config.RejectNonImproving.
+func (h *Handel) addImproving(s *incomingSig) {
+	if h.improvement != nil && !h.improvement.Accept(s) {
+		atomic.AddInt64(&h.metrics.sigDropped, 1)
+		h.log.Debug("skipped_from", s.origin, "skipped_level", s.level)
+		h.release(s)
+		return
+	}
+	h.proc.Add(s)
+}
+
+// authenticate verifies the packet as received, i.e. with the origin of the
+// sender in the global registry, against the identity of its local origin,
+// if Config.PacketAuth is set.
+func (h *Handel) authenticate(p *Packet, origin int32) error {
+	if h.c.PacketAuth == nil {
+		return nil
+	}
+	id, ok := h.reg.Identity(int(origin))
+	if !ok {
+		return errors.New("unknown origin")
+	}
+	return h.c.PacketAuth.Verify(p, id)
+}
+
+// release gives back the signatures of the given incoming signatures, which
+// are discarded, to the constructor if it is a SignaturePool.
+func (h *Handel) release(sigs ...*incomingSig) {
+	for _, s := range sigs {
+		if s != nil {
+			release(h.cons, s.ms.Signature)
+		}
+	}
+}
+
+// acceptPacket returns the packet to parse if Handel is running, if the
+// packet's origin and level are valid and, if dedup is true, if no packet of
+// the given key has been accepted within the last update period. The origin
+// of the returned packet is mapped to the ID of the member if
+// Config.MemberFilter is set. It returns an error if the packet is invalid,
+// and neither a packet nor an error if it is dropped otherwise.
+func (h *Handel) acceptPacket(p *Packet, key packetKey, dedup bool) (*Packet, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.done {
+		if h.c.OnPacketAfterDone != nil {
+			h.c.OnPacketAfterDone(p)
+		}
+		return nil, nil
+	}
+	if h.members != nil {
+		local, isMember := h.members[p.Origin]
+		if !isMember {
+			h.stats.msgRcvCt++
+			return nil, fmt.Errorf("%w: origin %d not a member", ErrOriginOutOfRange, p.Origin)
 		}
+		// the packet may be dispatched to other listeners
+		lp := *p
+		lp.Origin = local
+		p = &lp
 	}
+	if err := h.validatePacket(p); err != nil {
+		return nil, err
+	}
+	if !h.inLevelWindow(int(p.Level)) {
+		h.stats.msgOutOfWindowCt++
+		h.log.Debug("out_of_window", p.Level, "origin", p.Origin)
+		return nil, nil
+	}
+	if dedup && h.dedup.seen(key, time.Now()) {
+		h.stats.msgDuplicateCt++
+		atomic.AddInt64(&h.metrics.packetsDuplicate, 1)
+		return nil, nil
+	}
+	return p, nil
+}
+
+// inLevelWindow returns true if the level is within Config.AcceptLevelWindow
+// of our current level, i.e. the highest level started.
+func (h *Handel) inLevelWindow(level int) bool {
+	if h.c.AcceptLevelWindow <= 0 {
+		return true
+	}
+	var current int
+	for _, id := range h.ids {
+		if h.levels[id].started() {
+			current = id
+		}
+	}
+	diff := level - current
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= h.c.AcceptLevelWindow
 }
 
 // Start the Handel protocol by sending signatures to peers in the first level,
@@ -157,40 +487,451 @@ func (h *Handel) Start() {
 	h.Lock()
 	defer h.Unlock()
 	h.startTime = time.Now()
-	go h.proc.Start()
-	go h.rangeOnVerified()
+	h.lastProgress = h.startTime
 	go h.timeout.Start()
-	go h.periodicLoop()
+	if sp, ok := h.proc.(scheduledProcessing); ok && h.c.Scheduler != nil {
+		h.ticker.Stop()
+		h.c.Scheduler.register(h, sp)
+	} else {
+		go h.proc.Start()
+		go h.rangeOnVerified(h.proc.Verified())
+		go h.periodicLoop()
+	}
+	// our own contribution may be enough, e.g. with a single node
+	h.checkFinalSignature(nil)
+}
+
+// StartContext starts Handel as Start does, and stops it as Stop does when
+// the context is done. It does not wait for the context: Handel can still be
+// stopped before, in which case the context is not watched anymore.
+func (h *Handel) StartContext(ctx context.Context) {
+	h.Start()
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.Stop()
+		case <-h.stopped:
+		}
+	}()
+}
+
+// SetProcessing replaces the processing verifying the incoming signatures,
+// e.g. to switch from a fifo processing to an evaluator based one once the
+// packets rate grows. The current processing is stopped, the signatures it
+// has already verified are stored and passed to the actors, and the ones it
+// has not verified yet are added to the new processing, if the current
+// processing can hand them over. The new processing is started if Handel is.
+// It returns an error if Handel is stopped or driven by a SharedScheduler.
+func (h *Handel) SetProcessing(p signatureProcessing) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.done {
+		return errors.New("handel: processing set on a stopped handel")
+	}
+	if h.c.Scheduler != nil {
+		return errors.New("handel: processing set with a shared scheduler")
+	}
+	old := h.proc
+	h.proc = p
+	old.Stop()
+	// the signatures verified in the meantime are handled by the routine
+	// ranging over the old processing output
+	h.drainVerified(old.Verified())
+	if pp, ok := old.(pendingProcessing); ok {
+		for _, sp := range pp.pending() {
+			p.Add(sp)
+		}
+	}
+	if !h.startTime.IsZero() {
+		go p.Start()
+		go h.rangeOnVerified(p.Verified())
+	}
+	return nil
+}
+
+// drainVerified handles the verified signatures available on the channel,
+// without waiting. The lock must be held.
+func (h *Handel) drainVerified(verified chan incomingSig) {
+	for {
+		select {
+		case v, ok := <-verified:
+			if !ok {
+				return
+			}
+			h.store.Store(&v)
+			h.unsafeOnVerified(&v)
+		default:
+			return
+		}
+	}
 }
 
 // periodicLoop simply calls the periodic update each period of time.
 func (h *Handel) periodicLoop() {
 	for range h.ticker.C {
 		h.periodicUpdate()
+		h.jitterTicker()
+	}
+}
+
+// jitterTicker sets the time until the next periodic update with
+// Config.UpdateJitter, so the periodic updates of the nodes started together
+// do not stay synchronized.
+func (h *Handel) jitterTicker() {
+	h.Lock()
+	defer h.Unlock()
+	if h.jitter == nil || h.done || h.updatePeriod <= 0 {
+		return
 	}
+	h.ticker.Reset(h.nextUpdatePeriod())
+}
+
+// nextUpdatePeriod returns the period of the periodic updates, varied by a
+// random fraction of at most Config.UpdateJitter.
+func (h *Handel) nextUpdatePeriod() time.Duration {
+	d := h.updatePeriod
+	if h.jitter == nil {
+		return d
+	}
+	jittered := time.Duration(float64(d) * (1 + h.c.UpdateJitter*(2*h.jitter.Float64()-1)))
+	if jittered <= 0 {
+		return d
+	}
+	return jittered
 }
 
 // Stop the Handel protocol and all sub routines
 func (h *Handel) Stop() {
 	h.Lock()
 	defer h.Unlock()
+	h.unsafeStop()
+}
+
+// unsafeStop stops Handel if not already done. The lock must be held.
+func (h *Handel) unsafeStop() {
+	if h.done {
+		return
+	}
 	h.ticker.Stop()
 	h.timeout.Stop()
 	h.proc.Stop()
+	if c, ok := h.store.(io.Closer); ok {
+		c.Close()
+	}
 	h.done = true
 	close(h.out)
+	close(h.aborted)
+	close(h.stopped)
+	if h.diffs != nil {
+		close(h.diffs)
+	}
+}
+
+// Abort stops Handel because the round has become moot, e.g. the block being
+// signed got orphaned. No final signature is output anymore: FinalSignatures
+// is closed as with Stop and the reason is sent on the Aborted channel. It has
+// no effect if Handel is already stopped.
+func (h *Handel) Abort(reason error) {
+	h.Lock()
+	defer h.Unlock()
+	if h.done {
+		return
+	}
+	h.log.Info("abort", reason)
+	h.aborted <- reason
+	h.unsafeStop()
+}
+
+// Aborted returns the channel receiving the reason given to Abort. The channel
+// is closed when Handel stops, so consumers receive a nil error if Handel has
+// been stopped without being aborted.
+func (h *Handel) Aborted() <-chan error {
+	return h.aborted
+}
+
+// SetUpdatePeriod changes the period of the periodic updates while Handel is
+// running. A period inferior or equal to zero disables the periodic updates
+// until a positive period is set again: Config.MaxDuration and
+// Config.StallTimeout are then still checked every DefaultUpdatePeriod. With
+// Config.Scheduler, the period is the one of the scheduler and only disabling
+// the updates has an effect. Only this instance is affected, the config it
+// was created with is left unchanged.
+func (h *Handel) SetUpdatePeriod(d time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	if h.done {
+		return
+	}
+	h.updatePeriod = d
+	h.dedup.setWindow(d)
+	if d <= 0 {
+		if h.c.MaxDuration > 0 || h.c.StallTimeout > 0 {
+			h.ticker.Reset(DefaultUpdatePeriod)
+		} else {
+			h.ticker.Stop()
+		}
+		return
+	}
+	h.ticker.Reset(h.nextUpdatePeriod())
+}
+
+// Mute stops Handel from sending any packet while it keeps verifying and
+// storing the incoming signatures. A muted node acts as a pure sink, useful
+// for observer nodes.
+func (h *Handel) Mute() {
+	h.Lock()
+	defer h.Unlock()
+	h.muted = true
+}
+
+// Unmute makes Handel send packets again after a call to Mute.
+func (h *Handel) Unmute() {
+	h.Lock()
+	defer h.Unlock()
+	h.muted = false
 }
 
 // periodicUpdate sends the best multi-signature (potentially ind. sig.) for
 // each started level.
 func (h *Handel) periodicUpdate() {
 	h.Lock()
-	defer h.Unlock()
+	if h.checkMaxDuration() {
+		h.Unlock()
+		return
+	}
+	// with the periodic updates disabled, only the stalls are checked
+	if h.updatePeriod <= 0 {
+		h.checkStall()
+		h.Unlock()
+		return
+	}
+	h.postponeSends = h.c.SendConcurrency > 0
+	h.tick++
+	if h.c.OnTick != nil {
+		h.tickSends = make(map[int][]int32)
+	}
+	h.evictChunks()
+	h.retransmitSelf()
+	counts := h.updateCounts()
+	for id, lvl := range h.levels {
+		if lvl.active() && lvl.due(h.tick) {
+			h.sendUpdate(lvl, counts[id])
+		}
+	}
+	if h.c.OnTick != nil {
+		h.c.OnTick(h.tick, h.tickSends)
+		h.tickSends = nil
+	}
+	h.checkStall()
+	sends := h.pendingSends
+	h.pendingSends = nil
+	h.postponeSends = false
+	h.Unlock()
+	h.sendConcurrently(sends)
+}
+
+// retransmitSelf sends our contribution to new peers as long as it is not
+// known to have propagated and Config.SelfPropagationRetries is not
+// exhausted. Once the started levels have contacted all their peers, the
+// lowest level not started yet is started, its peers never having been
+// contacted. Once all the levels are started, the levels whose peers have all
+// been contacted are made active again: the peers being selected on a rolling
+// basis, the level starts over from the peers contacted first.
+func (h *Handel) retransmitSelf() {
+	if h.selfPropagated || h.selfRetries >= h.c.SelfPropagationRetries {
+		return
+	}
+	var retried, pending bool
+	var next *level
 	for _, lvl := range h.levels {
 		if lvl.active() {
-			h.sendUpdate(lvl, h.c.UpdateCount)
+			pending = true
+		}
+		if lvl.id > 0 && !lvl.started() && !lvl.belowStart && (next == nil || lvl.id < next.id) {
+			next = lvl
+		}
+	}
+	if pending {
+		return
+	}
+	if next != nil {
+		next.setStarted()
+		retried = true
+	} else {
+		for _, lvl := range h.levels {
+			if lvl.started() && !lvl.finished() {
+				lvl.sendPeersCt = 0
+				retried = true
+			}
+		}
+	}
+	if retried {
+		h.selfRetries++
+		h.log.Debug("self_retransmit", h.selfRetries)
+	}
+}
+
+// updateCounts returns the number of peers to contact for each active level
+// during a periodic update. By default, it is Config.UpdateCount for all
+// levels, or the count given by Config.Window.
+// With Config.FanoutByLevelSize, Config.TickSendBudget is shared between the
+// active levels proportionally to their size, each level contacting at least
+// one peer. The levels in their last mile, see Config.LastMileGap, contact at
+// least Config.LastMileFanout peers.
+func (h *Handel) updateCounts() map[int]int {
+	counts := make(map[int]int, len(h.levels))
+	var total int
+	for id, lvl := range h.levels {
+		if lvl.active() {
+			counts[id] = h.windowCount(id, lvl)
+			total += len(lvl.nodes)
 		}
 	}
+	if h.c.FanoutByLevelSize && h.c.TickSendBudget > 0 {
+		for id := range counts {
+			count := h.c.TickSendBudget * len(h.levels[id].nodes) / total
+			if count < 1 {
+				count = 1
+			}
+			counts[id] = count
+		}
+	}
+	if h.c.LastMileGap > 0 {
+		for id := range counts {
+			if h.lastMile(id) && counts[id] < h.c.LastMileFanout {
+				counts[id] = h.c.LastMileFanout
+			}
+		}
+	}
+	return counts
+}
+
+// windowCount returns the number of peers to contact at the level during a
+// periodic update, as given by Config.Window if set. The time elapsed since
+// the level started is counted from its first periodic update.
+func (h *Handel) windowCount(id int, lvl *level) int {
+	if h.c.Window == nil {
+		return h.c.UpdateCount
+	}
+	if lvl.startedAt.IsZero() {
+		lvl.startedAt = time.Now()
+	}
+	return h.c.Window.NextCount(id, lvl.sendPeersCt, time.Since(lvl.startedAt))
+}
+
+// lastMile returns true if the best signature of the level misses at most
+// Config.LastMileGap contributions to be complete.
+func (h *Handel) lastMile(level int) bool {
+	lvl := h.levels[level]
+	if lvl.rcvCompleted {
+		return false
+	}
+	var card int
+	if ms, ok := h.store.Best(byte(level)); ok {
+		card = ms.Cardinality()
+	}
+	return len(lvl.nodes)-card <= h.c.LastMileGap
+}
+
+// sendConcurrently sends all the given packets, with at most
+// Config.SendConcurrency sends in flight, and returns once all are done. The
+// lock must NOT be held.
+func (h *Handel) sendConcurrently(sends []pendingSend) {
+	if len(sends) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan bool, h.c.SendConcurrency)
+	for _, s := range sends {
+		sem <- true
+		if !h.acquireSend() {
+			<-sem
+			h.log.Debug("send_dropped", s.p.Level)
+			continue
+		}
+		wg.Add(1)
+		go func(s pendingSend) {
+			defer wg.Done()
+			h.net.Send(s.ids, s.p)
+			h.releaseSend()
+			<-sem
+		}(s)
+	}
+	wg.Wait()
+}
+
+// acquireSend takes a slot of Config.MaxOutstandingSends, waiting for one to
+// be freed unless Config.DropBlockedSends is set. It returns false if the
+// send must be dropped.
+func (h *Handel) acquireSend() bool {
+	if h.sendSem == nil {
+		return true
+	}
+	if !h.c.DropBlockedSends {
+		h.sendSem <- true
+		return true
+	}
+	select {
+	case h.sendSem <- true:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSend frees the slot taken by acquireSend.
+func (h *Handel) releaseSend() {
+	if h.sendSem != nil {
+		<-h.sendSem
+	}
+}
+
+// checkStall re-submits the signatures dropped unverified by the processing
+// if Handel did not make any progress during the last StallTimeout and did not
+// reach the threshold yet.
+func (h *Handel) checkStall() {
+	if h.c.StallTimeout <= 0 || h.done || h.best != nil {
+		return
+	}
+	if time.Since(h.lastProgress) < h.c.StallTimeout {
+		return
+	}
+	// reset so we give some time to the re-submitted signatures
+	h.lastProgress = time.Now()
+	r, ok := h.proc.(retrier)
+	if !ok {
+		return
+	}
+	if n := r.Retry(); n > 0 {
+		h.log.Info("stall_retry", n)
+	}
+}
+
+// checkMaxDuration outputs the current full signature and stops Handel if
+// the round lasts for more than Config.MaxDuration. It returns true if Handel
+// is stopped. The lock must be held.
+func (h *Handel) checkMaxDuration() bool {
+	if h.c.MaxDuration <= 0 || h.done {
+		return h.done
+	}
+	if time.Since(h.startTime) < h.c.MaxDuration {
+		return false
+	}
+	sig := h.store.FullSignature()
+	h.log.Info("max_duration", fmt.Sprintf("%d/%d/%d", sig.Cardinality(), h.threshold, h.reg.Size()))
+	h.maxDurationReached = true
+	h.out <- *sig
+	h.unsafeStop()
+	return true
+}
+
+// MaxDurationReached returns true if Handel has been stopped because the round
+// lasted more than Config.MaxDuration. In that case, the last signature
+// output may be below the threshold.
+func (h *Handel) MaxDurationReached() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.maxDurationReached
 }
 
 // StartLevel starts the given level if not started already. This in effects
@@ -202,6 +943,85 @@ func (h *Handel) StartLevel(level int) {
 	h.unsafeStartLevel(lvl)
 }
 
+// StartAt starts Handel as Start does, but resuming the aggregation at the
+// given level from the initial multi-signature, e.g. one aggregated in a
+// previous round. The bitset of the initial signature is over the whole
+// registry, and must only hold contributions of the levels below the given
+// one, including our own. The levels below are never sent to; the ones the
+// initial signature covers entirely are completed. It returns an error,
+// without starting Handel, if the initial signature is invalid.
+func (h *Handel) StartAt(level int, initial *MultiSignature) error {
+	h.Lock()
+	if err := h.unsafeSetInitial(level, initial); err != nil {
+		h.Unlock()
+		return err
+	}
+	h.Unlock()
+	h.Start()
+	h.StartLevel(level)
+	return nil
+}
+
+// unsafeSetInitial checks the initial signature of StartAt, stores it and
+// skips the levels below the given one.
+func (h *Handel) unsafeSetInitial(level int, initial *MultiSignature) error {
+	if _, exists := h.levels[level]; !exists || level < 1 {
+		return fmt.Errorf("handel: invalid start level %d", level)
+	}
+	if initial.BitLength() != h.reg.Size() {
+		return fmt.Errorf("handel: initial bitset of length %d for %d identities", initial.BitLength(), h.reg.Size())
+	}
+	if !initial.Get(int(h.id.ID())) {
+		return errors.New("handel: initial signature without our contribution")
+	}
+	ps, ok := h.store.(prefixStore)
+	if !ok {
+		return errors.New("handel: signature store not supporting an initial signature")
+	}
+	// the contributions outside the levels below
+	rest := initial.BitSet.Clone()
+	covered := make(map[int]bool)
+	aggregateKey := h.cons.PublicKey()
+	// the levels the partitioner skips as empty do not exist
+	for _, l := range append([]int{0}, h.ids...) {
+		if l >= level {
+			continue
+		}
+		ids, err := h.Partitioner.IdentitiesAt(l)
+		if err != nil {
+			return err
+		}
+		set := 0
+		for _, id := range ids {
+			if initial.Get(int(id.ID())) {
+				aggregateKey = aggregateKey.Combine(id.PublicKey())
+				set++
+			}
+		}
+		covered[l] = set == len(ids)
+		for _, id := range ids {
+			rest.Set(int(id.ID()), false)
+		}
+	}
+	if rest.Any() {
+		return fmt.Errorf("handel: initial signature with contributions at or above level %d", level)
+	}
+	if err := aggregateKey.VerifySignature(h.msg, initial.Signature); err != nil {
+		return fmt.Errorf("handel: invalid initial signature: %s", err)
+	}
+	ps.setPrefix(byte(level), &MultiSignature{BitSet: initial.BitSet.Clone(), Signature: initial.Signature})
+	for _, l := range h.ids {
+		if l >= level {
+			continue
+		}
+		lvl := h.levels[l]
+		lvl.sendStarted = false
+		lvl.belowStart = true
+		lvl.rcvCompleted = covered[l]
+	}
+	return nil
+}
+
 // unsafeStartLevel is the "unlocked" version of StartLevel.
 func (h *Handel) unsafeStartLevel(lvl *level) {
 	if lvl.started() {
@@ -214,8 +1034,11 @@ func (h *Handel) unsafeStartLevel(lvl *level) {
 // Send our best signature set for this level, to 'count' nodes. The level MUST
 // be active before calling this method.
 func (h *Handel) sendUpdate(l *level, count int) {
+	if h.muted || l.id == 0 || l.belowStart || l.finished() {
+		return
+	}
 	ms := h.store.Combined(byte(l.id) - 1)
-	newNodes, _ := l.selectNextPeers(count)
+	newNodes, _ := l.selectNextPeers(count, h.tick)
 	var sig Signature
 	if !l.rcvCompleted {
 		// send our individual signature only we still did not finish the level
@@ -226,27 +1049,205 @@ func (h *Handel) sendUpdate(l *level, count int) {
 
 // FinalSignatures returns the channel over which final multi-signatures
 // are sent over. These multi-signatures contain at least a threshold of
-// contributions, as defined in the config.
+// contributions, as defined in the config, except the last one output when
+// Config.MaxDuration is reached, which holds the contributions aggregated so
+// far and may be below the threshold, see MaxDurationReached.
 func (h *Handel) FinalSignatures() chan MultiSignature {
 	return h.out
 }
 
-// rangeOnVerified processed each verified signature from the processing
-// routine. For each, it:
-//  1) adds it to the store of verified signature
+// OriginStatus returns, for each identity of the registry, whether its
+// contribution is included in the verified signatures stored so far. It is
+// derived from the full signature, i.e. the union of the best signature of
+// each level mapped back to the registry by the partitioner.
+func (h *Handel) OriginStatus() map[int32]bool {
+	h.Lock()
+	defer h.Unlock()
+	full := h.store.FullSignature()
+	status := make(map[int32]bool, h.reg.Size())
+	for i := 0; i < h.reg.Size(); i++ {
+		id, ok := h.reg.Identity(i)
+		if !ok {
+			continue
+		}
+		status[globalID(id)] = full.Get(i)
+	}
+	return status
+}
+
+// CombinedAt returns the multi-signature combining all the signatures Handel
+// has stored at the given level and below, i.e. the signature Handel would send
+// to the peers of the next level. It returns false if the level is out of
+// bounds or if no signature can be combined.
+func (h *Handel) CombinedAt(level int) (*MultiSignature, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if level < 0 || level > h.Partitioner.MaxLevel() {
+		return nil, false
+	}
+	ms := h.store.Combined(byte(level))
+	return ms, ms != nil
+}
+
+// LevelBitSets returns a copy of the bitset of the best signature stored at
+// each level, including our own contribution at level 0, e.g. for a second
+// aggregation layer merging the results of several Handel instances. Levels
+// without any signature are omitted. The bitsets can be modified freely.
+func (h *Handel) LevelBitSets() map[int]BitSet {
+	h.Lock()
+	defer h.Unlock()
+	bitsets := make(map[int]BitSet)
+	for l := 0; l <= h.Partitioner.MaxLevel(); l++ {
+		if ms, ok := h.store.Best(byte(l)); ok {
+			bitsets[l] = ms.BitSet.Clone()
+		}
+	}
+	return bitsets
+}
+
+// Best returns a copy of the current full multi-signature, i.e. the
+// combination of the best signatures of all levels, whether or not it reaches
+// the threshold, and its cardinality. It returns nil and zero while the full
+// multi-signature only holds our own contribution. It can be called at any
+// time, e.g. to monitor the progress of Handel.
+func (h *Handel) Best() (*MultiSignature, int) {
+	h.Lock()
+	defer h.Unlock()
+	full := h.store.FullSignature()
+	card := full.Cardinality()
+	if card <= 1 {
+		return nil, 0
+	}
+	return &MultiSignature{BitSet: full.BitSet.Clone(), Signature: full.Signature}, card
+}
+
+// DumpBest returns the current best full multi-signature, even if it does not
+// reach the threshold, and logs a human-readable summary of its contributors
+// and of the state of each level. It is meant for debugging a running node and
+// can be called at any time.
+func (h *Handel) DumpBest() *MultiSignature {
+	h.Lock()
+	defer h.Unlock()
+	full := h.store.FullSignature()
+	var contributors []string
+	for i, ok := full.NextSet(0); ok; i, ok = full.NextSet(i + 1) {
+		if id, exists := h.reg.Identity(i); exists {
+			contributors = append(contributors, strconv.Itoa(int(globalID(id))))
+		}
+	}
+	var levels []string
+	for _, id := range h.ids {
+		lvl := h.levels[id]
+		var card int
+		if ms, ok := h.store.Best(byte(id)); ok {
+			card = ms.Cardinality()
+		}
+		levels = append(levels, fmt.Sprintf("%d:%d/%d(started=%t,completed=%t)", id, card, len(lvl.nodes), lvl.started(), lvl.rcvCompleted))
+	}
+	h.log.Info("dump_best", fmt.Sprintf("%d/%d/%d", full.Cardinality(), h.threshold, h.reg.Size()),
+		"contributors", strings.Join(contributors, ","),
+		"levels", strings.Join(levels, " "))
+	return full
+}
+
+// rangeOnVerified processed each verified signature from the given output of
+// the processing routine. For each, it:
+//  1) adds it to the store of verified signature and marks its origin as
+//     seen if the registry is a LivenessRegistry
 //  2) pass it down to all registered actors. Each handler is called in
 //     a thread safe manner, global lock is held during the call to actors.
-func (h *Handel) rangeOnVerified() {
-	for v := range h.proc.Verified() {
-		h.store.Store(&v)
-		h.Lock()
-		for _, actor := range h.actors {
-			actor.OnVerifiedSignature(&v)
+//     A panicking actor does not stop the processing, see callActor.
+//
+// With Config.ReorderVerified, the signatures already verified are taken
+// together, up to reorderBufferSize, and processed in ascending level order.
+func (h *Handel) rangeOnVerified(verified chan incomingSig) {
+	for v := range verified {
+		h.handleVerified(verified, v)
+	}
+}
+
+// handleVerified processes the verified signature read from the channel, and
+// the ones already available with Config.ReorderVerified.
+func (h *Handel) handleVerified(verified chan incomingSig, v incomingSig) {
+	batch := []incomingSig{v}
+	if h.c.ReorderVerified {
+		batch = bufferVerified(verified, batch)
+	}
+	for i := range batch {
+		h.onVerified(&batch[i])
+	}
+}
+
+// reorderBufferSize is the maximum number of verified signatures reordered
+// together with Config.ReorderVerified.
+const reorderBufferSize = 64
+
+// bufferVerified appends to the batch the signatures already available on the
+// channel, without waiting, up to reorderBufferSize, and returns the batch
+// sorted by ascending level.
+func bufferVerified(verified chan incomingSig, batch []incomingSig) []incomingSig {
+drain:
+	for len(batch) < reorderBufferSize {
+		select {
+		case v, ok := <-verified:
+			if !ok {
+				break drain
+			}
+			batch = append(batch, v)
+		default:
+			break drain
 		}
-		h.Unlock()
+	}
+	sort.SliceStable(batch, func(i, j int) bool {
+		return batch[i].level < batch[j].level
+	})
+	return batch
+}
+
+// onVerified stores the verified signature and passes it to the actors.
+func (h *Handel) onVerified(v *incomingSig) {
+	atomic.AddInt64(&h.metrics.sigVerified, 1)
+	h.store.Store(v)
+	h.Lock()
+	defer h.Unlock()
+	h.unsafeOnVerified(v)
+}
+
+// unsafeOnVerified passes the verified signature, already stored, to the
+// actors. The lock must be held.
+func (h *Handel) unsafeOnVerified(v *incomingSig) {
+	h.lastProgress = time.Now()
+	if lvl, ok := h.levels[int(v.level)]; ok {
+		lvl.responded(v.origin)
+	}
+	if v.selfAck {
+		h.selfPropagated = true
+	}
+	if h.liveness != nil {
+		if id, ok := h.reg.Identity(int(v.origin)); ok {
+			h.liveness.MarkSeen(globalID(id))
+		}
+	}
+	for _, actor := range h.actors {
+		h.callActor(actor, v)
 	}
 }
 
+// callActor passes the signature to the actor, recovering from any panic so
+// the global lock is not left held forever. The panic is logged and reported
+// to Config.OnActorPanic if set.
+func (h *Handel) callActor(a actor, s *incomingSig) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.log.Error("actor_panic", r)
+			if h.c.OnActorPanic != nil {
+				h.c.OnActorPanic(r)
+			}
+		}
+	}()
+	a.OnVerifiedSignature(s)
+}
+
 // actor is an interface that takes a new verified signature and acts on it
 // according to its own rule. It can be checking if it passes to a next level,
 // checking if the protocol is finished, checking if a signature completes
@@ -265,66 +1266,248 @@ func (a actorFunc) OnVerifiedSignature(s *incomingSig) {
 	a(s)
 }
 
+// ContributorDiffs returns a channel emitting, each time the full signature
+// improves, the IDs of the contributors added since the previous improvement.
+// The first diff emitted contains all contributors known so far, including
+// ourself. If the channel is full, the contributors are reported with the next
+// diff emitted instead. The channel is closed when Handel stops.
+func (h *Handel) ContributorDiffs() <-chan []int32 {
+	h.Lock()
+	defer h.Unlock()
+	if h.diffs == nil {
+		h.diffs = make(chan []int32, 1000)
+	}
+	return h.diffs
+}
+
+// checkContributorDiff emits the new contributors of the full signature if
+// the user asked for them.
+func (h *Handel) checkContributorDiff(s *incomingSig) {
+	if h.diffs == nil || h.done {
+		return
+	}
+	full := h.store.FullSignature()
+	added := full.BitSet
+	if h.diffPrev != nil {
+		added = full.AndNot(h.diffPrev.BitSet)
+	}
+	if added.None() {
+		return
+	}
+	var diff []int32
+	for i, ok := added.NextSet(0); ok; i, ok = added.NextSet(i + 1) {
+		if id, exists := h.reg.Identity(i); exists {
+			diff = append(diff, globalID(id))
+		}
+	}
+	select {
+	case h.diffs <- diff:
+		// only advanced once sent, so the next diff carries the contributors
+		// not reported
+		h.diffPrev = full
+	default:
+		h.log.Warn("contributor_diff", "channel full")
+	}
+}
+
+// checkProgress notifies Config.OnProgress when the full signature crosses a
+// multiple of Config.ProgressStep.
+func (h *Handel) checkProgress(s *incomingSig) {
+	if h.c.ProgressStep <= 0 || h.c.OnProgress == nil {
+		return
+	}
+	h.notifyProgress(h.store.FullSignature().Cardinality())
+}
+
+// notifyProgress calls Config.OnProgress if the cardinality reaches a multiple
+// of Config.ProgressStep above the last one notified. Crossing several
+// multiples at once calls it only once.
+func (h *Handel) notifyProgress(card int) {
+	reached := card / h.c.ProgressStep * h.c.ProgressStep
+	if reached <= h.progressNotified {
+		return
+	}
+	h.progressNotified = reached
+	h.c.OnProgress(card, h.reg.Size())
+}
+
+// Reasons why checkFinalSignature does not output the full signature, see
+// LastDeclineReason.
+const (
+	declineBelowThreshold = "below threshold"
+	declineDetector       = "threshold detector not reached"
+	declineNoImprovement  = "not an improvement"
+	declineDone           = "protocol done"
+)
+
+// thresholdDecline returns an empty string if the full signature reaches the
+// threshold, as decided by Config.ThresholdDetector if set, or the reason why
+// it does not otherwise.
+func (h *Handel) thresholdDecline(sig *MultiSignature) string {
+	if h.c.ThresholdDetector != nil {
+		if !h.c.ThresholdDetector.Reached(sig) {
+			return declineDetector
+		}
+		return ""
+	}
+	if sig.Cardinality() < h.threshold {
+		return declineBelowThreshold
+	}
+	return ""
+}
+
 // checkFinalSignature checks if a new better final signature (ig. a signature
 // at the last level) has been generated. If so, it sends it to the output
-// channel.
+// channel. Otherwise, it records the reason, see LastDeclineReason.
 func (h *Handel) checkFinalSignature(s *incomingSig) {
 	sig := h.store.FullSignature()
+	h.recordProgress(time.Now(), sig.Cardinality())
 
-	if sig.BitSet.Cardinality() < h.threshold {
+	if reason := h.thresholdDecline(sig); reason != "" {
+		h.declineReason = reason
 		return
 	}
-	newBest := func(ms *MultiSignature) {
-		if h.done {
-			return
-		}
-		h.best = ms
-		h.log.Info("new_sig", fmt.Sprintf("%d/%d/%d", ms.Cardinality(), h.threshold, h.reg.Size()))
-		h.out <- *h.best
+	if h.done {
+		h.declineReason = declineDone
+		return
+	}
+	if h.best != nil && sig.Cardinality() <= h.best.Cardinality() {
+		h.declineReason = declineNoImprovement
+		return
 	}
+	h.declineReason = ""
+	h.best = sig
+	h.log.Info("new_sig", fmt.Sprintf("%d/%d/%d", sig.Cardinality(), h.threshold, h.reg.Size()))
+	h.out <- *h.best
+}
 
-	if h.best == nil {
-		newBest(sig)
+// LastDeclineReason returns why the last full signature checked by Handel has
+// not been output as a final signature: it is below the threshold, it is not
+// reached according to Config.ThresholdDetector, it does not improve the last
+// final signature, or Handel is done. It returns an empty string if the last
+// full signature checked has been output, or if none has been checked yet.
+func (h *Handel) LastDeclineReason() string {
+	h.Lock()
+	defer h.Unlock()
+	return h.declineReason
+}
+
+// recordProgress keeps a sample of the cardinality of the full signature if it
+// improved.
+func (h *Handel) recordProgress(now time.Time, card int) {
+	if l := len(h.progress); l > 0 && h.progress[l-1].card >= card {
 		return
 	}
+	if len(h.progress) >= progressSamples {
+		h.progress = h.progress[1:]
+	}
+	h.progress = append(h.progress, progressSample{now, card})
+}
+
+// ETA returns the estimated time left before the full signature reaches the
+// threshold, extrapolated linearly from its last improvements. It returns
+// false if there are not enough improvements to extrapolate from, or if
+// Handel has not improved for longer than the period the improvements span.
+func (h *Handel) ETA() (time.Duration, bool) {
+	h.Lock()
+	defer h.Unlock()
+	return h.eta(time.Now())
+}
 
-	newCard := sig.Cardinality()
-	local := h.best.Cardinality()
-	if newCard > local {
-		newBest(sig)
+func (h *Handel) eta(now time.Time) (time.Duration, bool) {
+	if len(h.progress) == 0 {
+		return 0, false
 	}
+	first := h.progress[0]
+	last := h.progress[len(h.progress)-1]
+	if last.card >= h.threshold {
+		return 0, true
+	}
+	span := last.time.Sub(first.time)
+	if len(h.progress) < 2 || span <= 0 || now.Sub(last.time) > span {
+		return 0, false
+	}
+	perContribution := span / time.Duration(last.card-first.card)
+	eta := last.time.Add(perContribution * time.Duration(h.threshold-last.card)).Sub(now)
+	if eta < 0 {
+		eta = 0
+	}
+	return eta, true
 }
 
+// checkInvariants makes Handel panic when an internal invariant is broken,
+// instead of only logging it. It is set by the tests.
+var checkInvariants = false
+
 // checkCompletedLevels checks if higher levels may be completed by the given
 // signature. For each of those, it sends the update to the corresponding peers
 // in a fast path fashion.
 func (h *Handel) checkCompletedLevel(s *incomingSig) {
 	// The receiving phase: have we completed this level?
 	lvl := h.getLevel(s.level)
-	if lvl.rcvCompleted {
-		return
-	}
-
 	sp, _ := h.store.Best(s.level)
 	if sp == nil {
 		panic("we should have received the best signature, we got nil!")
 	}
+	if lvl.rcvCompleted {
+		// completion is monotonic: the store never replaces the complete
+		// signature of a level by a smaller one. The levels skipped by StartAt
+		// are completed by the initial signature instead.
+		if !lvl.belowStart && sp.Cardinality() != len(lvl.nodes) {
+			regressed := fmt.Sprintf("%d/%d", sp.Cardinality(), len(lvl.nodes))
+			h.log.Error("completed_level_regressed", s.level, "best", regressed)
+			if checkInvariants {
+				panic(fmt.Sprintf("completed level %d regressed to %s", s.level, regressed))
+			}
+		}
+		return
+	}
 	if sp.Cardinality() == len(lvl.nodes) {
 		h.log.Debug("level_complete", s.level)
 		lvl.rcvCompleted = true
+		// the callbacks are called last so a panicking one does not prevent
+		// the updates
+		defer h.notifyLevelCompleted(lvl.id, sp.Cardinality())
 	}
 
 	// The sending phase: for all upper levels we may have completed the level.
-	// We try to update all levels upwards & send an update if it's the case
-	for id, lvl := range h.levels {
+	// We try to update all levels upwards & send an update if it's the case.
+	// The completed levels are gathered first so the updates are always sent
+	// in ascending level order, whatever the level of the signature.
+	var completed []*level
+	for _, id := range h.ids {
 		if id < int(s.level+1) {
 			continue
 		}
+		lvl := h.levels[id]
 		ms := h.store.Combined(byte(id) - 1)
 		if ms != nil && lvl.updateSigToSend(ms) {
-			h.sendUpdate(lvl, h.c.FastPath)
+			completed = append(completed, lvl)
 		}
 	}
+	for _, lvl := range completed {
+		h.sendUpdate(lvl, h.c.FastPath)
+	}
+}
+
+// RegisterLevelCallback registers a function called each time a level
+// completes, i.e. once Handel has received all the contributions of the
+// level, with the level, the cardinality of its signature and the time of
+// completion. It is called once per level, while Handel's lock is held, so it
+// must not call back into Handel.
+func (h *Handel) RegisterLevelCallback(fn func(level int, cardinality int, t time.Time)) {
+	h.Lock()
+	defer h.Unlock()
+	h.levelCallbacks = append(h.levelCallbacks, fn)
+}
+
+// notifyLevelCompleted calls the functions registered with
+// RegisterLevelCallback.
+func (h *Handel) notifyLevelCompleted(level, cardinality int) {
+	now := time.Now()
+	for _, fn := range h.levelCallbacks {
+		fn(level, cardinality, now)
+	}
 }
 
 // getLevel returns the level corresponding to this ID.
@@ -339,8 +1522,11 @@ func (h *Handel) getLevel(levelID byte) *level {
 }
 
 // sendTo creates a Handel packet to send to the given identities containing the
-// given multisignature. The individual signature may be empty.
+// given multisignature. The individual signature may be empty. Our own
+// identity is dropped from the identities, as it can only be there because of
+// a misconfigured partitioner.
 func (h *Handel) sendTo(lvl int, ids []Identity, ms *MultiSignature, ind Signature) {
+	ids = h.withoutSelf(lvl, ids)
 	h.stats.msgSentCt += len(ids)
 
 	buff, err := ms.MarshalBinary()
@@ -350,7 +1536,8 @@ func (h *Handel) sendTo(lvl int, ids []Identity, ms *MultiSignature, ind Signatu
 	}
 
 	p := &Packet{
-		Origin:   h.id.ID(),
+		Version:  PacketVersion,
+		Origin:   globalID(h.id),
 		Level:    byte(lvl),
 		MultiSig: buff,
 	}
@@ -362,25 +1549,112 @@ func (h *Handel) sendTo(lvl int, ids []Identity, ms *MultiSignature, ind Signatu
 		}
 		p.IndividualSig = indBuff
 	}
+	if h.c.PacketAuth != nil {
+		if p.Auth, err = h.c.PacketAuth.Sign(p); err != nil {
+			h.log.Error("packet_auth", err)
+			return
+		}
+	}
+	packets := []*Packet{p}
+	if h.c.MaxChunkSize > 0 {
+		h.chunkSeq++
+		if packets, err = chunkPacket(p, h.c.MaxChunkSize, h.chunkSeq); err != nil {
+			h.log.Error("chunk", err)
+			return
+		}
+	}
+
+	if h.tickSends != nil {
+		for _, id := range ids {
+			h.tickSends[lvl] = append(h.tickSends[lvl], globalID(id))
+		}
+	}
 
 	h.log.Debug("sent_level", p.Level, "sent_nodes", fmt.Sprintf("%s", ids))
-	h.net.Send(ids, p)
+	if h.members != nil {
+		ids = globalIdentities(ids)
+	}
+	if h.c.DryRun {
+		h.log.Info("dry_run", p.Level, "dest", fmt.Sprintf("%s", ids), "size", len(p.MultiSig)+len(p.IndividualSig))
+		return
+	}
+	for _, p := range packets {
+		h.metrics.sent(p, len(ids))
+		if h.postponeSends {
+			h.pendingSends = append(h.pendingSends, pendingSend{ids, p})
+			continue
+		}
+		if !h.acquireSend() {
+			h.log.Debug("send_dropped", p.Level)
+			continue
+		}
+		h.net.Send(ids, p)
+		h.releaseSend()
+	}
 }
 
+// withoutSelf returns the identities without our own identity, logging a
+// warning if it was present.
+func (h *Handel) withoutSelf(lvl int, ids []Identity) []Identity {
+	self := h.id.ID()
+	for _, id := range ids {
+		if id.ID() != self {
+			continue
+		}
+		h.log.Warn("self_in_level", lvl)
+		res := make([]Identity, 0, len(ids)-1)
+		for _, id := range ids {
+			if id.ID() != self {
+				res = append(res, id)
+			}
+		}
+		return res
+	}
+	return ids
+}
+
+// ErrLevelOutOfRange is the error of the packets whose level is not one of the
+// levels of the node, e.g. because it is above the highest level or because
+// the level is empty. The errors returned for such packets wrap it.
+var ErrLevelOutOfRange = errors.New("handel: packet's level out of range")
+
+// ErrOriginOutOfRange is the error of the packets whose origin is not in the
+// registry or, with Config.MemberFilter, not a member, or whose origin is not
+// one of the nodes of their level. The errors returned for such packets wrap
+// it.
+var ErrOriginOutOfRange = errors.New("handel: packet's origin out of range")
+
+// ErrOriginNotContributing is the error of the packets rejected with
+// Config.OriginMustContribute because their multi-signature does not include
+// the contribution of their origin. The errors returned for such packets wrap
+// it.
+var ErrOriginNotContributing = errors.New("handel: packet's origin not contributing")
+
+// ErrUnmarshal is the error of the packets whose signatures can not be
+// unmarshalled, or whose bitset does not match their level. The errors
+// returned for such packets wrap it.
+var ErrUnmarshal = errors.New("handel: invalid packet's signatures")
+
 // validatePacket verifies the validity of the origin and level fields of the
 // packet and returns an error if any. This method does NOT verify the validity
-// of the signature(s) inside the packet.
+// of the signature(s) inside the packet. The levels are derived
+// deterministically from the registry by the partitioner, which omits the
+// structurally empty levels, so a packet for an empty level is rejected.
 func (h *Handel) validatePacket(p *Packet) error {
 	h.stats.msgRcvCt++
 
+	if err := p.checkVersion(); err != nil {
+		return err
+	}
 	if p.Origin < 0 || p.Origin >= int32(h.reg.Size()) {
-		return errors.New("packet's origin out of range")
+		return fmt.Errorf("%w: origin %d", ErrOriginOutOfRange, p.Origin)
 	}
 
+	// the level 0 only holds our own contribution
 	_, exists := h.levels[int(p.Level)]
 
-	if !exists {
-		return fmt.Errorf("invalid packet's level %d", p.Level)
+	if !exists || p.Level == 0 {
+		return fmt.Errorf("%w: level %d", ErrLevelOutOfRange, p.Level)
 	}
 	return nil
 }
@@ -388,22 +1662,44 @@ func (h *Handel) validatePacket(p *Packet) error {
 // parseMultisignature returns the multisignature (and the individual signature
 // if present) unmarshalled if correct, or an error otherwise.
 func (h *Handel) parseSignatures(p *Packet) (ms *incomingSig, ind *incomingSig, err error) {
+	var sig, individual Signature
+	defer func() {
+		// the signatures of an invalid packet can be reused
+		if err != nil {
+			release(h.cons, sig)
+			release(h.cons, individual)
+		}
+	}()
 	m := new(MultiSignature)
-	err = m.Unmarshal(p.MultiSig, h.cons.Signature(), h.c.NewBitSet)
+	sig = h.cons.Signature()
+	err = m.Unmarshal(p.MultiSig, sig, h.c.NewBitSet)
 	if err != nil {
+		err = fmt.Errorf("%w: multisig: %s", ErrUnmarshal, err)
 		return
 	}
 
 	// level is already check before
 	lvl, _ := h.levels[int(p.Level)]
 	if m.BitLength() != len(lvl.nodes) {
-		err = errors.New("invalid bitset's size for given level")
+		err = fmt.Errorf("%w: invalid bitset's size %d for level %d", ErrUnmarshal, m.BitLength(), p.Level)
 		return
 	}
 	if m.None() {
-		err = errors.New("no signature in the bitset")
+		err = fmt.Errorf("%w: no signature in the bitset", ErrUnmarshal)
 		return
 	}
+	if h.c.OriginMustContribute {
+		var originIndex int
+		originIndex, err = h.Partitioner.IndexAtLevel(p.Origin, int(p.Level))
+		if err != nil {
+			err = fmt.Errorf("%w: %s", ErrOriginOutOfRange, err)
+			return
+		}
+		if !m.Get(originIndex) {
+			err = fmt.Errorf("%w: origin %d at level %d", ErrOriginNotContributing, p.Origin, p.Level)
+			return
+		}
+	}
 	ms = &incomingSig{
 		origin: p.Origin,
 		level:  p.Level,
@@ -413,14 +1709,16 @@ func (h *Handel) parseSignatures(p *Packet) (ms *incomingSig, ind *incomingSig,
 	if p.IndividualSig == nil {
 		return
 	}
-	individual := h.cons.Signature()
+	individual = h.cons.Signature()
 	if err = individual.UnmarshalBinary(p.IndividualSig); err != nil {
+		err = fmt.Errorf("%w: individual signature: %s", ErrUnmarshal, err)
 		return
 	}
 	bs := h.c.NewBitSet(len(lvl.nodes))
 	var levelIndex int
 	levelIndex, err = h.Partitioner.IndexAtLevel(p.Origin, int(p.Level))
 	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrOriginOutOfRange, err)
 		return
 	}
 	bs.Set(levelIndex, true)
@@ -449,10 +1747,18 @@ type level struct {
 
 	// True if we can start to send messages for this level.
 	sendStarted bool
+	// time of the first periodic update of the level, only set when
+	// Config.Window is
+	startedAt time.Time
 
-	// True is this level is completed for the reception, i.e. we have all the sigs
+	// True is this level is completed for the reception, i.e. we have all the sigs.
+	// It is never reset once set.
 	rcvCompleted bool
 
+	// True if the level is below the one given to StartAt: it is never
+	// started.
+	belowStart bool
+
 	// This field reference our current position in our list of peers. Each time
 	// Handel sends an update, it takes the peer at this position and increases
 	// it.
@@ -472,52 +1778,122 @@ type level struct {
 	// Size of the current sig we're sending. This allows to check if we have a
 	//  better signature.
 	sendSigSize int
+
+	// Minimum increase of the size of the sig to send before resetting the
+	// count of peers contacted.
+	sendMinImprovement int
+
+	// Config.MaxUselessSends and Config.UselessSendsCooldown
+	maxUselessSends int
+	uselessCooldown int
+	// number of sends to each peer since it last sent us a verified
+	// signature
+	uselessSends map[int32]int
+	// tick until which each peer is skipped
+	skippedUntil map[int32]int
+
+	// Config.PeerScorer
+	scorer PeerScorer
+
+	// Config.MaxUpdateBackoff
+	maxBackoff int
+	// number of periodic updates between two periodic sends of the level
+	backoff int
+	// tick of the next periodic send of the level
+	nextUpdate int
+	// size of the signature sent by the last periodic send
+	backoffSigSize int
 }
 
 // newLevel returns a fresh new level at the given id (number) for these given
-// nodes to contact.
-func newLevel(id int, nodes []Identity, sendExpectedFullSize int) *level {
-	if id <= 0 {
+// nodes to contact. The level 0 only holds our own contribution: it is
+// completed from the beginning and never started, since there is no one to
+// send it to.
+func newLevel(id int, nodes []Identity, sendExpectedFullSize, sendMinImprovement int) *level {
+	if id < 0 {
 		panic("bad value for level id")
 	}
 	l := &level{
 		id:                   id,
 		nodes:                nodes,
 		sendStarted:          false,
-		rcvCompleted:         false,
+		rcvCompleted:         id == 0,
 		sendPos:              0,
 		sendPeersCt:          0,
 		sendExpectedFullSize: sendExpectedFullSize,
 		sendSigSize:          0,
+		sendMinImprovement:   sendMinImprovement,
 	}
 	return l
 }
 
 // createLevels generate a map of all the levels for this registry. It currently
-// shuffles the peers to contact for each level.
-func createLevels(c *Config, partitioner Partitioner) map[int]*level {
+// shuffles the peers to contact for each level. With Config.ShuffleCandidates,
+// the shuffle is seeded by the given ID of our node and the level.
+//
+// If the partitioner orders the peers of the levels, e.g. the
+// ShufflePartitioner, its order is kept.
+//
+// The initial state of a level derives from our own contribution, the only
+// signature we have at the beginning: the signature we send at a level
+// combines the contributions of the lower levels, so a level is started from
+// the beginning if our own contribution is all it expects, i.e. for the first
+// non-empty level. Only the level 0, made of our own node, is completed at
+// the beginning since the other levels never include our own contribution.
+//
+// Whatever the number of nodes, the levels are the non-empty levels of the
+// partitioner: they are disjoint and, with our own node, cover the whole
+// registry, the highest level holding all the remaining nodes. The
+// signatures of a level therefore never have more contributions than its
+// nodes.
+func createLevels(c *Config, id int32, partitioner Partitioner) map[int]*level {
 	lvls := make(map[int]*level)
-	var firstActive bool
-	sendExpectedFullSize := 1
+	// our own contribution is the only one of level 0
+	own := partitioner.Size(0)
+	self, _ := partitioner.IdentitiesAt(0)
+	lvls[0] = newLevel(0, self, 0, c.MinImprovementToResend)
+	sendExpectedFullSize := own
 	for _, level := range partitioner.Levels() {
 		nodes2, _ := partitioner.IdentitiesAt(level)
 		nodes := nodes2
-		if !c.DisableShuffling {
+		if co, ok := partitioner.(candidateOrderer); ok {
+			nodes, _ = co.Candidates(level)
+		} else if c.ShuffleCandidates {
+			nodes = make([]Identity, len(nodes2))
+			copy(nodes, nodes2)
+			shuffle(nodes, levelSeed(id, level))
+		} else if !c.DisableShuffling {
 			nodes = make([]Identity, len(nodes2))
 			copy(nodes, nodes2)
 			shuffle(nodes, c.Rand)
 		}
-		lvls[level] = newLevel(level, nodes, sendExpectedFullSize)
-		sendExpectedFullSize += len(nodes)
-		if !firstActive {
+		lvls[level] = newLevel(level, nodes, sendExpectedFullSize, c.MinImprovementToResend)
+		lvls[level].maxUselessSends = c.MaxUselessSends
+		if c.OffsetStartPosition && len(nodes) > 0 {
+			lvls[level].sendPos = int(id) % len(nodes)
+		}
+		lvls[level].uselessCooldown = c.UselessSendsCooldown
+		lvls[level].scorer = c.PeerScorer
+		lvls[level].maxBackoff = c.MaxUpdateBackoff
+		if sendExpectedFullSize == own {
+			lvls[level].sendSigSize = own
 			lvls[level].setStarted()
-			firstActive = true
 		}
+		sendExpectedFullSize += len(nodes)
 	}
 
 	return lvls
 }
 
+// levelSeed returns the source of the deterministic shuffle of the peers of
+// the given level for the given node.
+func levelSeed(id int32, level int) io.Reader {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, id)
+	binary.Write(&b, binary.BigEndian, int32(level))
+	return &b
+}
+
 // a level is active on two necessary conditions:
 // 1. It must have been started, i.e. its waiting time has elapsed (see
 // timeout.go)
@@ -527,6 +1903,34 @@ func (l *level) active() bool {
 	return l.started() && l.sendPeersCt < len(l.nodes)
 }
 
+// finished returns true once the level is completed and its full signature
+// has been sent to all its peers: it has nothing left to receive nor to send.
+func (l *level) finished() bool {
+	return l.rcvCompleted && l.sendSigSize == l.sendExpectedFullSize &&
+		l.sendPeersCt >= len(l.nodes)
+}
+
+// due returns true if the level is sent during the periodic update of the
+// given tick. With Config.MaxUpdateBackoff, the number of periodic updates
+// between two sends doubles each time the signature to send did not improve
+// since the previous one, and is reset once it improves.
+func (l *level) due(tick int) bool {
+	if l.maxBackoff <= 1 {
+		return true
+	}
+	if tick < l.nextUpdate {
+		return false
+	}
+	if l.backoff == 0 || l.sendSigSize > l.backoffSigSize {
+		l.backoff = 1
+	} else {
+		l.backoff = min(2*l.backoff, l.maxBackoff)
+	}
+	l.backoffSigSize = l.sendSigSize
+	l.nextUpdate = tick + l.backoff
+	return true
+}
+
 // started returns true after the waiting time of a level has elapsed. See
 // timeout.go for more information.
 func (l *level) started() bool {
@@ -534,41 +1938,111 @@ func (l *level) started() bool {
 }
 
 // setStarted is called by timeout strategy to indicate a level must start. See
-// timeout.go.
+// timeout.go. The level 0 is never started, as it has no peer to send to, nor
+// the levels skipped by Handel.StartAt.
 func (l *level) setStarted() {
+	if l.id == 0 || l.belowStart {
+		return
+	}
 	l.sendStarted = true
 }
 
 // Select the peers Handel should contact next at this level. Peers are selected
-// on a rolling basis.
-func (l *level) selectNextPeers(count int) ([]Identity, bool) {
+// on a rolling basis, ordered by score at the beginning of each pass with
+// Config.PeerScorer. The peers skipped at the given tick, see
+// Config.MaxUselessSends, are passed over and counted as contacted.
+func (l *level) selectNextPeers(count, tick int) ([]Identity, bool) {
+	if l.sendPeersCt == 0 && l.scorer != nil {
+		l.sortByScore()
+	}
 	size := min(count, len(l.nodes))
-	res := make([]Identity, size)
+	res := make([]Identity, 0, size)
 
-	for i := 0; i < size; i++ {
-		res[i] = l.nodes[l.sendPos]
+	for i := 0; i < len(l.nodes) && len(res) < size; i++ {
+		id := l.nodes[l.sendPos]
 		l.sendPos++
 		if l.sendPos >= len(l.nodes) {
 			l.sendPos = 0
 		}
+		l.sendPeersCt++
+		if !l.skipped(id.ID(), tick) {
+			res = append(res, id)
+		}
 	}
-
-	l.sendPeersCt += size
 	return res, true
 }
 
+// sortByScore orders the peers by descending score and restarts from the
+// first one. The peers of equal score keep their rolling order, starting from
+// the current position, so a constant score keeps the round robin.
+func (l *level) sortByScore() {
+	scores := make(map[int32]float64, len(l.nodes))
+	for _, id := range l.nodes {
+		scores[id.ID()] = l.scorer.Score(id, l.id)
+	}
+	// the nodes may be shared with the partitioner
+	nodes := make([]Identity, 0, len(l.nodes))
+	nodes = append(nodes, l.nodes[l.sendPos:]...)
+	nodes = append(nodes, l.nodes[:l.sendPos]...)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return scores[nodes[i].ID()] > scores[nodes[j].ID()]
+	})
+	l.nodes = nodes
+	l.sendPos = 0
+}
+
+// skipped returns true if the peer must not be contacted at the given tick
+// because it did not send us anything after Config.MaxUselessSends sends.
+// Otherwise it counts the send to the peer.
+func (l *level) skipped(id int32, tick int) bool {
+	if l.maxUselessSends <= 0 {
+		return false
+	}
+	if l.uselessSends == nil {
+		l.uselessSends = make(map[int32]int)
+		l.skippedUntil = make(map[int32]int)
+	}
+	if until, ok := l.skippedUntil[id]; ok {
+		if tick < until {
+			return true
+		}
+		delete(l.skippedUntil, id)
+	}
+	if l.uselessSends[id] >= l.maxUselessSends {
+		delete(l.uselessSends, id)
+		l.skippedUntil[id] = tick + l.uselessCooldown
+		return true
+	}
+	l.uselessSends[id]++
+	return false
+}
+
+// responded records that the peer sent us a verified signature, so the sends
+// to it have not been useless.
+func (l *level) responded(id int32) {
+	delete(l.uselessSends, id)
+	delete(l.skippedUntil, id)
+}
+
 // Updates the size of the signature stored at this level if the given sig has a
 // larger cardinality. If it is the case, it resets the counter of the numbers
 // of peers Handel has contacted, in order to eventually propagate the better
-// signature to the whole level.
+// signature to the whole level. An improvement smaller than the minimum
+// improvement of the level is ignored, unless the signature is complete.
 // If the level is now complete, it returns true; if not it returns false.
 func (l *level) updateSigToSend(sig *MultiSignature) bool {
 	if l.sendSigSize >= sig.Cardinality() {
 		return false
 	}
+	if sig.Cardinality() != l.sendExpectedFullSize &&
+		sig.Cardinality()-l.sendSigSize < l.sendMinImprovement {
+		return false
+	}
 
 	l.sendSigSize = sig.Cardinality()
 	l.sendPeersCt = 0
+	// the improved signature is sent at the next periodic update
+	l.nextUpdate = 0
 
 	if l.sendSigSize == l.sendExpectedFullSize {
 		// If we have all the signatures to send
@@ -593,6 +2067,94 @@ func (l *level) String() string {
 
 // HStats contain minimal stats about handel
 type HStats struct {
-	msgSentCt int
-	msgRcvCt  int
+	msgSentCt        int
+	msgRcvCt         int
+	msgOutOfWindowCt int
+	msgDuplicateCt   int
+}
+
+// Stats is a snapshot of the state of a Handel node, see Handel.Stats.
+type Stats struct {
+	// MsgSent is the number of packets sent so far
+	MsgSent int
+	// MsgRcv is the number of packets received so far
+	MsgRcv int
+	// MsgOutOfWindow is the number of packets dropped because their level is
+	// out of Config.AcceptLevelWindow
+	MsgOutOfWindow int
+	// MsgDuplicate is the number of packets dropped because they are
+	// identical to a packet received within the last update period, see
+	// Config.DedupCacheSize. MsgDuplicate over MsgRcv is the hit rate of the
+	// cache.
+	MsgDuplicate int
+	// SigSkipped is the number of received multi-signatures dropped before
+	// their verification because they add no contribution to the best
+	// signature of their level, see Config.RejectNonImproving
+	SigSkipped int
+	// BestCardinality is the number of contributions of the full
+	// multi-signature
+	BestCardinality int
+	// Levels holds the state of each level, in ascending order
+	Levels []LevelStats
+}
+
+// LevelStats is a snapshot of the state of a level.
+type LevelStats struct {
+	Level int
+	// Cardinality is the number of contributions of the best signature
+	// received at this level
+	Cardinality int
+	// Started is true when Handel sends its signature to this level
+	Started bool
+	// Completed is true when all the signatures of the level are received
+	Completed bool
+	// Sent is the number of peers contacted with the current signature
+	Sent int
+}
+
+// VerificationStats returns the number of signatures verified so far, the
+// total time spent verifying them and the longest verification, to tell
+// whether the crypto is the bottleneck. The durations only cover the
+// verification by the Constructor's keys, not the queueing nor the
+// Config.VerifyPacing, and are those of the current processing, see
+// SetProcessing. It returns zeros if the processing does not measure them. It
+// is safe to call at any time.
+func (h *Handel) VerificationStats() (count int, total time.Duration, max time.Duration) {
+	h.Lock()
+	t, ok := h.proc.(verificationTimer)
+	h.Unlock()
+	if !ok {
+		return 0, 0, 0
+	}
+	return t.VerificationStats()
+}
+
+// Stats returns a snapshot of the state of Handel.
+func (h *Handel) Stats() Stats {
+	h.Lock()
+	defer h.Unlock()
+	s := Stats{
+		MsgSent:         h.stats.msgSentCt,
+		MsgRcv:          h.stats.msgRcvCt,
+		MsgOutOfWindow:  h.stats.msgOutOfWindowCt,
+		MsgDuplicate:    h.stats.msgDuplicateCt,
+		BestCardinality: h.store.FullSignature().Cardinality(),
+	}
+	if h.improvement != nil {
+		s.SigSkipped = h.improvement.dropped
+	}
+	for _, id := range h.ids {
+		lvl := h.levels[id]
+		ls := LevelStats{
+			Level:     id,
+			Started:   lvl.started(),
+			Completed: lvl.rcvCompleted,
+			Sent:      lvl.sendPeersCt,
+		}
+		if ms, ok := h.store.Best(byte(id)); ok {
+			ls.Cardinality = ms.Cardinality()
+		}
+		s.Levels = append(s.Levels, ls)
+	}
+	return s
 }
@@ -2,17 +2,28 @@ package handel
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/require"
 )
 
 var msg = []byte("Sun is Shining...")
 
+func TestMain(m *testing.M) {
+	checkInvariants = true
+	os.Exit(m.Run())
+}
+
 type handelTest struct {
 	n        int
 	offlines []int32
@@ -251,204 +262,2908 @@ func TestHandelCheckCompletedLevel(t *testing.T) {
 	}
 }
 
-func TestHandelCheckFinalSignature(t *testing.T) {
-	n := 16
+func TestHandelSkipEmptyLevel(t *testing.T) {
+	n := 6
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(5)
+	net := new(levelNetwork)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	// the level 2 of node 5 would be made of the nodes 6 and 7
+	require.Equal(t, []int{1, 3}, h.ids)
+	_, exists := h.levels[2]
+	require.False(t, exists)
+	require.True(t, h.levels[1].started())
+	require.False(t, h.levels[3].started())
 
-	type checkFinalTest struct {
-		// one slice represents sigs to store before calling the checkVerified
-		// you can put multiple slices to call checkverified multiple times
-		sigs [][]*incomingSig
-		// input to the handler
-		input *incomingSig
-		// expected output on the output channel
-		out []*MultiSignature
+	// completing the level 1 updates the level 3 directly
+	sig := &incomingSig{origin: 4, level: 1, ms: fullSig(1)}
+	h.store.Store(sig)
+	h.Lock()
+	h.checkCompletedLevel(sig)
+	h.Unlock()
+	require.True(t, h.levels[1].rcvCompleted)
+	require.True(t, h.levels[3].started())
+	net.Lock()
+	defer net.Unlock()
+	require.Equal(t, []byte{3}, net.levels)
+	require.ElementsMatch(t, []int32{0, 1, 2, 3}, net.ids)
+}
+
+func TestHandelCompletionMonotonic(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	net := new(levelNetwork)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, UpdateCount: n}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	lvl := h.levels[2]
+	partial := func() *incomingSig {
+		sig := finalIncomingSig(2, 2)
+		sig.ms.BitSet.Set(1, false)
+		return sig
 	}
 
-	// test(3) set a non-complete signature followed by a complete signature
-	pairs1 := incomingSigs(0, 1, 2, 3, 4)
-	pairs2 := incomingSigs(3, 4)
-	// index 8 (2^4-1) + 6 = 14 set to false
-	pairs1[4].ms.BitSet.Set(6, false)
-	final4 := finalIncomingSig(4, n)
-	// missing one contribution
-	final4b := finalIncomingSig(4, n)
-	final4b.ms.BitSet.Set(14, false)
+	h.store.Store(fullIncomingSig(1))
+	// partial, complete, then partial signatures again at level 2
+	for i, sig := range []*incomingSig{partial(), fullIncomingSig(2), partial(), partial()} {
+		h.store.Store(sig)
+		require.NotPanics(t, func() { h.checkCompletedLevel(sig) })
+		require.Equal(t, i > 0, lvl.rcvCompleted)
+	}
+	// the level 3 got the complete signature of the levels below and does not
+	// resend smaller ones
+	lvl3 := h.levels[3]
+	require.Equal(t, lvl3.sendExpectedFullSize, lvl3.sendSigSize)
+	require.False(t, lvl3.updateSigToSend(h.store.Combined(1)))
+	require.Equal(t, lvl3.sendExpectedFullSize, lvl3.sendSigSize)
 
-	// test(4) set a under-threshold signature followed by a good one
-	pairs3 := incomingSigs(0, 1, 2, 3, 4)
-	// 4-1 -> because that's how you compute the size of a level
-	// -1 -> to just spread out holes to other levels and leave this one still
-	// having one contribution
-	for i := 0; i < pow2(4-1)-1; i++ {
-		pairs3[4].ms.BitSet.Set(i, false)
+	// a regression of the store is detected
+	h.store = newStore(h.Partitioner, h.c.NewBitSet, h.cons)
+	sig := partial()
+	h.store.Store(sig)
+	require.Panics(t, func() { h.checkCompletedLevel(sig) })
+}
+
+func TestHandelOnTick(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+
+	type tickSends struct {
+		tick  int
+		sends map[int][]int32
+	}
+	ticks := make(chan tickSends, 2)
+	h := handels[1]
+	h.c.OnTick = func(tick int, sends map[int][]int32) {
+		ticks <- tickSends{tick, sends}
 	}
-	pairs3[3].ms.BitSet.Set(1, false)
-	pairs3[3].ms.BitSet.Set(2, false)
 
-	//fmt.Println("pairs3[4] bitset = ", pairs3[4].ms.BitSet.String())
-	//fmt.Println("pairs3[3] bitset = ", pairs3[3].ms.BitSet.String())
+	// only level 1 is started and node 0 is the only node at level 1 from
+	// node 1's point of view
+	h.periodicUpdate()
+	ts := <-ticks
+	require.Equal(t, 1, ts.tick)
+	require.Equal(t, map[int][]int32{1: {0}}, ts.sends)
 
-	toMatrix := func(pairs ...[]*incomingSig) [][]*incomingSig {
-		return append(make([][]*incomingSig, 0), pairs...)
+	// all peers of level 1 have been contacted, nothing is sent anymore
+	h.periodicUpdate()
+	ts = <-ticks
+	require.Equal(t, 2, ts.tick)
+	require.Empty(t, ts.sends)
+}
+
+func TestHandelSetUpdatePeriod(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+	ticks := make(chan time.Time, 100)
+	h.c.OnTick = func(int, map[int][]int32) {
+		ticks <- time.Now()
 	}
-	var tests = []checkFinalTest{
-		// too lower level signatures
-		{toMatrix(incomingSigs(0, 1, 2)), nil, []*MultiSignature{nil}},
-		// everything's perfect
-		{toMatrix(incomingSigs(0, 1, 2, 3, 4)), nil, []*MultiSignature{finalIncomingSig(4, n).ms}},
-		// gives two consecutives better
-		{toMatrix(pairs1, pairs2), nil, []*MultiSignature{final4b.ms, final4.ms}},
-		// one underthreshold and fully signed
-		{toMatrix(pairs3, pairs2), nil, []*MultiSignature{nil, final4.ms}},
+	h.Start()
+
+	// wait for ticks at the default period
+	<-ticks
+	<-ticks
+
+	period := 50 * time.Millisecond
+	h.SetUpdatePeriod(period)
+	// drain the ticks happened before the change
+	for len(ticks) > 0 {
+		<-ticks
+	}
+	last := <-ticks
+	for i := 0; i < 3; i++ {
+		next := <-ticks
+		require.True(t, next.Sub(last) > period/2)
+		last = next
 	}
 
-	waitOut := func(h *Handel) *MultiSignature {
-		select {
-		case ms := <-h.FinalSignatures():
-			return &ms
-		case <-time.After(20 * time.Millisecond):
-			return nil
+	// disabling the periodic updates
+	h.SetUpdatePeriod(0)
+	for len(ticks) > 0 {
+		<-ticks
+	}
+	select {
+	case <-ticks:
+		t.Fatal("periodic update while disabled")
+	case <-time.After(2 * period):
+	}
+
+	h.SetUpdatePeriod(DefaultUpdatePeriod)
+	select {
+	case <-ticks:
+	case <-time.After(time.Second):
+		t.Fatal("periodic update not enabled again")
+	}
+
+	// the maximum duration is still enforced with the updates disabled
+	h2 := handels[2]
+	h2.c.MaxDuration = 100 * time.Millisecond
+	h2.Start()
+	h2.SetUpdatePeriod(0)
+	select {
+	case <-h2.FinalSignatures():
+		require.True(t, h2.MaxDurationReached())
+	case <-time.After(time.Second):
+		t.Fatal("maximum duration not enforced with the updates disabled")
+	}
+
+	// the instances sharing a config keep their own period
+	reg := FakeRegistry(n)
+	conf := DefaultConfig(n)
+	id3, _ := reg.Identity(3)
+	id4, _ := reg.Identity(4)
+	h3 := NewHandel(new(levelNetwork), reg, id3, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h3.Stop()
+	h4 := NewHandel(new(levelNetwork), reg, id4, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h4.Stop()
+	h3.SetUpdatePeriod(time.Hour)
+	require.Equal(t, DefaultUpdatePeriod, conf.UpdatePeriod)
+	h4.Lock()
+	require.Equal(t, DefaultUpdatePeriod, h4.updatePeriod)
+	h4.Unlock()
+}
+
+func TestHandelUpdateJitter(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n).(*arrayRegistry)
+	period := 10 * time.Millisecond
+	jitter := 0.5
+	nets := make([]Network, n)
+	for i := range nets {
+		nets[i] = &TestNetwork{int32(i), nets, nil}
+	}
+	conf := &Config{UpdatePeriod: period, UpdateJitter: jitter}
+	h, err := NewHandelErr(nets[1], reg, reg.ids[1], new(fakeCons), msg, &fakeSig{true}, conf)
+	require.NoError(t, err)
+	defer h.Stop()
+
+	// the intervals vary within the jitter and their mean stays the period
+	cycles := 10000
+	var sum, min, max time.Duration
+	for i := 0; i < cycles; i++ {
+		d := h.nextUpdatePeriod()
+		if i == 0 || d < min {
+			min = d
 		}
+		if d > max {
+			max = d
+		}
+		sum += d
 	}
+	mean := sum / time.Duration(cycles)
+	require.InDelta(t, float64(period), float64(mean), 0.01*float64(period))
+	require.True(t, min >= time.Duration(float64(period)*(1-jitter)))
+	require.True(t, max <= time.Duration(float64(period)*(1+jitter)))
+	require.True(t, max-min > period*9/10)
 
-	for i, test := range tests {
-		t.Logf(" -- test %d --", i)
-		_, handels := FakeSetup(n)
-		h := handels[1]
-		store := h.store
+	// the running ticker uses the jittered intervals
+	ticks := make(chan time.Time, 100)
+	h.c.OnTick = func(int, map[int][]int32) {
+		ticks <- time.Now()
+	}
+	h.Start()
+	last := <-ticks
+	var intervals []time.Duration
+	for i := 0; i < 20; i++ {
+		next := <-ticks
+		intervals = append(intervals, next.Sub(last))
+		last = next
+	}
+	varied := false
+	for _, d := range intervals[1:] {
+		if d-intervals[0] > period/10 || intervals[0]-d > period/10 {
+			varied = true
+		}
+	}
+	require.True(t, varied)
 
-		for i, toInsert := range test.sigs {
-			// insert slice
-			for _, sig := range toInsert {
-				store.Store(sig)
-			}
-			h.checkFinalSignature(test.input)
+	for _, invalid := range []float64{-0.1, 1} {
+		conf := &Config{UpdateJitter: invalid}
+		_, err := NewHandelErr(nets[1], reg, reg.ids[1], new(fakeCons), msg, &fakeSig{true}, conf)
+		require.Error(t, err)
+	}
+}
 
-			// lookup expected result at that point
-			expected := test.out[i]
-			output := waitOut(h)
-			require.Equal(t, expected, output)
+func TestHandelMaxCandidatesPerLevel(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n).(*arrayRegistry)
+	conf := &Config{MergeStore: true, MaxCandidatesPerLevel: 2}
+	h := NewHandel(&TestNetwork{1, nil, nil}, reg, reg.ids[1], new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	sigOf := func(indexes ...int) *MultiSignature {
+		bs := NewWilffBitset(8)
+		for _, i := range indexes {
+			bs.Set(i, true)
 		}
+		return newSig(bs)
+	}
+	// the candidate with the lowest cardinality is evicted
+	for _, ms := range []*MultiSignature{sigOf(0, 1, 2), sigOf(2, 3, 4, 5), sigOf(5, 6)} {
+		h.store.Store(&incomingSig{level: 4, ms: ms})
+	}
+	cands := h.store.(*mergeStore).cands[4]
+	require.Len(t, cands.sigs, 2)
+	for _, s := range cands.sigs {
+		require.True(t, s.Cardinality() > 2)
 	}
 }
 
-func TestHandelParsePacket(t *testing.T) {
+func TestHandelFanoutByLevelSize(t *testing.T) {
 	n := 16
-	registry := FakeRegistry(n)
-	//ids := registry.(*arrayRegistry).ids // TODO: The test runs ok even if we comment this lines
-	c := DefaultConfig(n)
-	c.DisableShuffling = true
-	h := &Handel{
-		c:           c,
-		reg:         registry,
-		cons:        new(fakeCons),
-		msg:         msg,
-		Partitioner: NewBinPartitioner(1, registry, DefaultLogger),
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	var tests = []struct {
+		budget int
+		counts map[int]int
+	}{
+		// levels have 1, 2, 4 and 8 nodes
+		{15, map[int]int{1: 1, 2: 2, 3: 4, 4: 8}},
+		{8, map[int]int{1: 1, 2: 1, 3: 2, 4: 4}},
+		{0, map[int]int{1: 1, 2: 1, 3: 1, 4: 1}},
 	}
-	h.levels = createLevels(h.c, h.Partitioner)
-	type packetTest struct {
-		*Packet
-		Error bool
+	for _, test := range tests {
+		sends := make(chan map[int][]int32, 1)
+		conf := &Config{
+			NewTimeoutStrategy: newInfiniteTimeout,
+			FanoutByLevelSize:  true,
+			TickSendBudget:     test.budget,
+			OnTick: func(tick int, s map[int][]int32) {
+				sends <- s
+			},
+		}
+		h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		for _, lvl := range h.levels {
+			lvl.setStarted()
+		}
+		h.periodicUpdate()
+		counts := make(map[int]int)
+		for lvl, ids := range <-sends {
+			counts[lvl] = len(ids)
+		}
+		require.Equal(t, test.counts, counts)
 	}
-	correctSig := newSig(fullBitset(2))
-	buffMs, _ := correctSig.MarshalBinary()
-	incorrectSig := newSig(fullBitset(5))
-	invalidMsBuff, _ := incorrectSig.MarshalBinary()
-	packets := []*packetTest{
-		{
-			&Packet{
-				Origin:   65000,
-				Level:    0,
-				MultiSig: fakeConstSig,
-			}, true,
-		},
-		{
-			&Packet{
-				Origin:   3,
-				Level:    254,
-				MultiSig: fakeConstSig,
-			}, true,
-		},
-		{
-			&Packet{
-				Origin:   3,
-				Level:    1,
-				MultiSig: []byte{0x01},
-			}, true,
-		},
-		{
-			&Packet{
-				Origin:   3,
-				Level:    2,
-				MultiSig: buffMs,
-			}, false,
-		},
-		{
-			&Packet{
-				Origin:   3,
-				Level:    2,
-				MultiSig: invalidMsBuff,
-			}, true,
+}
+
+func TestHandelLastMile(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	sends := make(chan map[int][]int32, 1)
+	conf := &Config{
+		NewTimeoutStrategy: newInfiniteTimeout,
+		LastMileGap:        1,
+		LastMileFanout:     4,
+		OnTick: func(tick int, s map[int][]int32) {
+			sends <- s
 		},
 	}
-	for i, test := range packets {
-		t.Logf(" -- test %d --", i)
-		err := h.validatePacket(test.Packet)
-		_, _, err2 := h.parseSignatures(test.Packet)
-		if test.Error {
-			var isErr1 = err != nil
-			var isErr2 = err2 != nil
-			require.True(t, isErr1 || isErr2)
-		} else {
-			require.NoError(t, err)
-		}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	for _, lvl := range h.levels {
+		lvl.setStarted()
+	}
+	// level 4 has 8 nodes and misses one contribution, level 3 misses two
+	bs := NewWilffBitset(8)
+	for i := 0; i < 7; i++ {
+		bs.Set(i, true)
 	}
+	h.store.Store(&incomingSig{origin: 8, level: 4, ms: newSig(bs)})
+	bs = NewWilffBitset(4)
+	bs.Set(0, true)
+	bs.Set(1, true)
+	h.store.Store(&incomingSig{origin: 4, level: 3, ms: newSig(bs)})
+
+	h.periodicUpdate()
+	counts := make(map[int]int)
+	for lvl, ids := range <-sends {
+		counts[lvl] = len(ids)
+	}
+	// the empty level 1 is in its last mile as well, but has a single peer
+	require.Equal(t, map[int]int{1: 1, 2: 1, 3: 1, 4: 4}, counts)
+
+	// the nearly full level is done contacting its peers in two ticks
+	h.periodicUpdate()
+	<-sends
+	require.False(t, h.levels[4].active())
 }
 
-func TestHandelCreateLevel(t *testing.T) {
+func TestHandelWindowPolicy(t *testing.T) {
+	fixed := NewFixedWindow(3)
+	require.Equal(t, 3, fixed.NextCount(1, 0, 0))
+	require.Equal(t, 3, fixed.NextCount(4, 5, time.Hour))
+	exp := NewExponentialWindow(1, 10*time.Millisecond)
+	require.Equal(t, 1, exp.NextCount(1, 0, 0))
+	require.Equal(t, 1, exp.NextCount(1, 0, 9*time.Millisecond))
+	require.Equal(t, 2, exp.NextCount(1, 1, 10*time.Millisecond))
+	require.Equal(t, 8, exp.NextCount(1, 3, 35*time.Millisecond))
+	require.Equal(t, 1<<maxWindowDoublings, exp.NextCount(1, 0, time.Hour))
+
 	n := 16
-	registry := FakeRegistry(n)
-	part := NewBinPartitioner(1, registry, DefaultLogger)
-	c := DefaultConfig(n)
-	c.DisableShuffling = true
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{
+		NewTimeoutStrategy: newInfiniteTimeout,
+		Window:             NewExponentialWindow(1, time.Minute),
+	}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	for _, lvl := range h.levels {
+		lvl.setStarted()
+	}
+	// level 4 started three periods ago
+	h.levels[4].startedAt = time.Now().Add(-3 * time.Minute)
+	require.Equal(t, map[int]int{1: 1, 2: 1, 3: 1, 4: 8}, h.updateCounts())
+}
 
-	mapping1 := createLevels(c, part)
-	mapping2 := createLevels(c, part)
-	require.Equal(t, mapping1, mapping2)
+// evaluator0 drops all signatures without verifying them
+type evaluator0 struct{}
 
-	seed := make([]byte, 512)
-	_, err := rand.Reader.Read(seed)
+func (e *evaluator0) Evaluate(sp *incomingSig) int { return 0 }
+
+func TestHandelStallRetry(t *testing.T) {
+	n := 2
+	reg := FakeRegistry(n).(*arrayRegistry)
+	nets := make([]Network, n)
+	for i := range nets {
+		nets[i] = &TestNetwork{int32(i), nets, nil}
+	}
+	conf := &Config{
+		NewEvaluatorStrategy: func(SignatureStore, *Handel) SigEvaluator {
+			return new(evaluator0)
+		},
+		NewTimeoutStrategy: newInfiniteTimeout,
+		StallTimeout:       100 * time.Millisecond,
+		RetryBufferSize:    10,
+	}
+	h := NewHandel(nets[0], reg, reg.ids[0], new(fakeCons), msg, &fakeSig{true}, conf)
+	h.Start()
+	defer h.Stop()
+
+	// the signature of node 1 completes the full signature but is dropped by
+	// the evaluator
+	buff, err := fullSig(1).MarshalBinary()
 	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 1, Level: 1, MultiSig: buff})
+	select {
+	case <-h.FinalSignatures():
+		t.Fatal("dropped signature should not have been verified yet")
+	case <-time.After(20 * time.Millisecond):
+	}
 
-	c.DisableShuffling = false
-	var r bytes.Buffer
-	r.Write(seed)
-	c.Rand = &r
-	mapping3 := createLevels(c, part)
-	require.NotEqual(t, mapping3, mapping2)
+	// the stall makes Handel verify the dropped signature
+	select {
+	case ms := <-h.FinalSignatures():
+		require.Equal(t, n, ms.Cardinality())
+	case <-time.After(time.Second):
+		t.Fatal("dropped signature not verified after stall")
+	}
+}
 
-	var r2 bytes.Buffer
-	r2.Write(seed)
-	c.Rand = &r2
-	mapping4 := createLevels(c, part)
-	require.Equal(t, mapping3, mapping4)
+func TestHandelOriginStatus(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
 
-	c = DefaultConfig(n)
-	mapping5 := createLevels(c, part)
-	require.NotEqual(t, mapping5, mapping4)
-	require.NotEqual(t, mapping5, mapping1)
+	// only our own contribution at first
+	status := h.OriginStatus()
+	require.Len(t, status, n)
+	for id, contributed := range status {
+		require.Equal(t, id == 1, contributed)
+	}
+
+	// node 0 is level 1 and nodes 2,3 are level 2 from node 1's point of view
+	h.store.Store(fullIncomingSig(1))
+	h.store.Store(fullIncomingSig(2))
+	status = h.OriginStatus()
+	for id, contributed := range status {
+		require.Equal(t, id < 4, contributed)
+	}
 }
 
-type infiniteTimeout struct {
+// selfIndexPartitioner places our own contribution at position 1 of a level 0
+// of size 2.
+type selfIndexPartitioner struct {
+	Partitioner
 }
 
-// newInfiniteTimeout creates an InfiniteTimeout. Needs this signature
-func newInfiniteTimeout(h *Handel, lvls []int) TimeoutStrategy {
-	return &infiniteTimeout{}
+func (p *selfIndexPartitioner) Size(level int) int {
+	if level == 0 {
+		return 2
+	}
+	return p.Partitioner.Size(level)
 }
 
-// Start implements the interface
-func (l *infiniteTimeout) Start() {}
+func (p *selfIndexPartitioner) IndexAtLevel(globalID int32, level int) (int, error) {
+	if level == 0 {
+		return 1, nil
+	}
+	return p.Partitioner.IndexAtLevel(globalID, level)
+}
 
-// Stop implements the interface
-func (l *infiniteTimeout) Stop() {}
+func TestHandelSelfIndex(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{
+		NewPartitioner: func(id int32, reg Registry, logger Logger) Partitioner {
+			return &selfIndexPartitioner{NewBinPartitioner(id, reg, logger)}
+		},
+	}
+	h := NewHandel(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	ms, ok := h.store.Best(0)
+	require.True(t, ok)
+	require.Equal(t, 2, ms.BitLength())
+	require.False(t, ms.Get(0))
+	require.True(t, ms.Get(1))
+}
+
+func TestHandelMaxDuration(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+	// nobody else is running so the threshold is unreachable
+	h.c.MaxDuration = 100 * time.Millisecond
+	start := time.Now()
+	h.Start()
+
+	select {
+	case ms := <-h.FinalSignatures():
+		require.True(t, time.Since(start) >= h.c.MaxDuration)
+		require.True(t, ms.Cardinality() < h.threshold)
+		require.True(t, ms.Get(1))
+	case <-time.After(time.Second):
+		t.Fatal("no signature output at max duration")
+	}
+	require.True(t, h.MaxDurationReached())
+	_, open := <-h.FinalSignatures()
+	require.False(t, open)
+}
+
+func TestHandelLivenessRegistry(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+	reg := NewLivenessRegistry(h.reg)
+	h.reg, h.liveness = reg, reg
+	h.Start()
+
+	require.True(t, reg.LastSeen(0).IsZero())
+	buff, err := fullSig(1).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 0, Level: 1, MultiSig: buff})
+
+	deadline := time.After(time.Second)
+	for reg.LastSeen(0).IsZero() {
+		select {
+		case <-deadline:
+			t.Fatal("origin of verified signature not marked as seen")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	require.True(t, reg.LastSeen(2).IsZero())
+}
+
+func TestHandelRegistrySize(t *testing.T) {
+	require.Equal(t, 0, log2(0))
+	require.Equal(t, 0, log2(1))
+	require.Equal(t, 1, log2(2))
+	require.Equal(t, 2, log2(3))
+
+	// empty registry
+	reg := FakeRegistry(0)
+	id := &fakeIdentity{0, &fakePublic{true}}
+	_, err := NewHandelErr(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{true})
+	require.Error(t, err)
+	require.Panics(t, func() {
+		NewHandel(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{true})
+	})
+
+	// a single node outputs its own signature
+	reg = FakeRegistry(1)
+	id2, _ := reg.Identity(0)
+	h, err := NewHandelErr(&TestNetwork{}, reg, id2, new(fakeCons), msg, &fakeSig{true})
+	require.NoError(t, err)
+	require.Empty(t, h.ids)
+	require.Len(t, h.levels, 1)
+	h.Start()
+	defer h.Stop()
+	select {
+	case ms := <-h.FinalSignatures():
+		require.Equal(t, 1, ms.Cardinality())
+		require.True(t, ms.Get(0))
+	case <-time.After(time.Second):
+		t.Fatal("no signature output with a single node")
+	}
+}
+
+// stubProcessing is a processing whose verified and pending signatures are
+// set by the test.
+type stubProcessing struct {
+	out     chan incomingSig
+	todos   []*incomingSig
+	stopped bool
+}
+
+func (s *stubProcessing) Start()                     {}
+func (s *stubProcessing) Stop()                      { s.stopped = true }
+func (s *stubProcessing) Add(sp *incomingSig)        { s.todos = append(s.todos, sp) }
+func (s *stubProcessing) Verified() chan incomingSig { return s.out }
+func (s *stubProcessing) pending() []*incomingSig {
+	todos := s.todos
+	s.todos = nil
+	return todos
+}
+
+func TestHandelSetProcessing(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	h := NewHandel(new(slowNetwork), reg, id, new(fakeCons), msg, &fakeSig{true})
+	// one signature verified but not handled yet, one not verified yet
+	stub := &stubProcessing{out: make(chan incomingSig, 1)}
+	stub.out <- *fullIncomingSig(1)
+	stub.todos = []*incomingSig{fullIncomingSig(2)}
+	h.proc = stub
+
+	evaluator := newEvaluatorProcessing(h.Partitioner, new(fakeCons), msg, new(Config), h.store, DefaultLogger)
+	require.NoError(t, h.SetProcessing(evaluator))
+	require.True(t, stub.stopped)
+	_, ok := h.store.Best(1)
+	require.True(t, ok)
+	require.True(t, h.levels[1].rcvCompleted)
+	h.Start()
+	defer h.Stop()
+	for i := 0; ; i++ {
+		if _, ok := h.store.Best(2); ok {
+			break
+		}
+		require.True(t, i < 100, "pending signature lost")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// switching away from a fifo processing keeps its queued signatures
+	h2 := NewHandel(new(slowNetwork), reg, id, new(fakeCons), msg, &fakeSig{true})
+	defer h2.Stop()
+	fifo := newFifoProcessing(h2.store, h2.Partitioner, new(fakeCons), msg)
+	h2.proc = fifo
+	for lvl := 1; lvl <= h2.Partitioner.MaxLevel(); lvl++ {
+		fifo.Add(fullIncomingSig(lvl))
+	}
+	evaluator = newEvaluatorProcessing(h2.Partitioner, new(fakeCons), msg, new(Config), h2.store, DefaultLogger)
+	require.NoError(t, h2.SetProcessing(evaluator))
+	h2.Start()
+	for lvl := 1; lvl <= h2.Partitioner.MaxLevel(); lvl++ {
+		for i := 0; ; i++ {
+			if _, ok := h2.store.Best(byte(lvl)); ok {
+				break
+			}
+			require.True(t, i < 100, "queued signature at level %d lost", lvl)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// switching a running instance
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		h.Start()
+	}
+	for _, h := range handels[:n/2] {
+		fifo := newFifoProcessing(h.store, h.Partitioner, new(fakeCons), msg)
+		require.NoError(t, h.SetProcessing(fifo))
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.True(t, ms.Cardinality() >= h.threshold)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("instance %d did not complete", i)
+		}
+	}
+
+	h.Stop()
+	require.Error(t, h.SetProcessing(stub))
+}
+
+func TestHandelProgress(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	type progress struct{ card, total int }
+	var notified []progress
+	conf := &Config{
+		ProgressStep: 5,
+		OnProgress: func(card, total int) {
+			notified = append(notified, progress{card, total})
+		},
+	}
+	h := NewHandel(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	for _, card := range []int{1, 4, 7, 7, 10, 13, 16} {
+		h.notifyProgress(card)
+	}
+	require.Equal(t, []progress{{7, n}, {10, n}, {16, n}}, notified)
+
+	// the actor notifies the cardinality of the full signature
+	notified = nil
+	h.progressNotified = 0
+	h.store.Store(fullIncomingSig(3))
+	h.checkProgress(fullIncomingSig(3))
+	require.Equal(t, []progress{{5, n}}, notified)
+}
+
+func TestHandelVerifySelfSig(t *testing.T) {
+	reg := FakeRegistry(4)
+	id, _ := reg.Identity(1)
+	// our own signature is trusted by default
+	_, err := NewHandelErr(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{false})
+	require.NoError(t, err)
+
+	conf := &Config{VerifySelfSig: true}
+	_, err = NewHandelErr(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{false}, conf)
+	require.Error(t, err)
+	require.Panics(t, func() {
+		NewHandel(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{false}, conf)
+	})
+	_, err = NewHandelErr(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	require.NoError(t, err)
+}
+
+func TestHandelMemberFilter(t *testing.T) {
+	n := 16
+	reg := NewLivenessRegistry(FakeRegistry(n))
+	nets := make([]Network, n)
+	for i := range nets {
+		nets[i] = &TestNetwork{int32(i), nets, nil}
+	}
+	// the shard is made of the even identities
+	conf := &Config{
+		MemberFilter: func(id Identity) bool {
+			return id.ID()%2 == 0
+		},
+	}
+	var handels []*Handel
+	for i := 0; i < n; i++ {
+		id, _ := reg.Identity(i)
+		h, err := NewHandelErr(nets[i], reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		if i%2 == 1 {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, n/2, h.reg.Size())
+		require.Equal(t, PercentageToContributions(DefaultContributionsPerc, n/2), h.threshold)
+		handels = append(handels, h)
+	}
+	defer CloseHandels(handels)
+
+	// a packet from a non-member is dropped
+	buff, err := fullSig(1).MarshalBinary()
+	require.NoError(t, err)
+	handels[0].NewPacket(&Packet{Origin: 1, Level: 1, MultiSig: buff})
+	require.Empty(t, handels[0].proc.(*evaluatorProcessing).todos)
+
+	// the destinations are reported with their global ID
+	sent := make(chan int32, 1000)
+	handels[0].c.OnTick = func(tick int, sends map[int][]int32) {
+		for _, ids := range sends {
+			for _, id := range ids {
+				select {
+				case sent <- id:
+				default:
+				}
+			}
+		}
+	}
+	for _, h := range handels {
+		go h.Start()
+	}
+	for _, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n/2, ms.BitLength())
+			require.True(t, ms.Cardinality() >= h.threshold)
+		case <-time.After(2 * time.Second):
+			t.Fatal("shard did not aggregate")
+		}
+	}
+	status := handels[0].OriginStatus()
+	require.Len(t, status, n/2)
+	_, ok := status[2]
+	require.True(t, ok)
+	_, ok = status[1]
+	require.False(t, ok)
+
+	for len(sent) > 0 {
+		require.Zero(t, <-sent%2)
+	}
+	// the origins are marked as seen with their global ID
+	for i := int32(1); i < int32(n); i += 2 {
+		require.True(t, reg.LastSeen(i).IsZero())
+	}
+	require.False(t, reg.LastSeen(int32(n-2)).IsZero())
+}
+
+func TestHandelContributorDiffs(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	h := handels[1]
+	diffs := h.ContributorDiffs()
+	h.Start()
+
+	next := func() []int32 {
+		select {
+		case d := <-diffs:
+			return d
+		case <-time.After(time.Second):
+			t.Fatal("no contributor diff")
+		}
+		return nil
+	}
+
+	buff, err := fullSig(1).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 0, Level: 1, MultiSig: buff})
+	require.Equal(t, []int32{0, 1}, next())
+
+	buff, err = fullSig(2).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 2, Level: 2, MultiSig: buff})
+	require.Equal(t, []int32{2, 3}, next())
+
+	CloseHandels(handels)
+	_, open := <-diffs
+	require.False(t, open)
+
+	// the contributors dropped with a full channel are in the next diff
+	_, handels = FakeSetup(n)
+	defer CloseHandels(handels)
+	h = handels[1]
+	h.diffs = make(chan []int32, 1)
+	h.store.Store(fullIncomingSig(1))
+	h.checkContributorDiff(nil)
+	h.store.Store(fullIncomingSig(2))
+	h.checkContributorDiff(nil)
+	require.Equal(t, []int32{0, 1}, <-h.diffs)
+	h.checkContributorDiff(nil)
+	require.Equal(t, []int32{2, 3}, <-h.diffs)
+}
+
+func TestHandelStats(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+	h.store.Store(fullIncomingSig(1))
+	h.checkCompletedLevel(fullIncomingSig(1))
+
+	s := h.Stats()
+	require.Equal(t, 2, s.BestCardinality)
+	require.Len(t, s.Levels, 3)
+	require.Equal(t, LevelStats{Level: 1, Cardinality: 1, Started: true, Completed: true}, s.Levels[0])
+	// level 2 gets the fast path update
+	require.Equal(t, 2, s.Levels[1].Level)
+	require.True(t, s.Levels[1].Started)
+	require.Equal(t, 2, s.Levels[1].Sent)
+	require.Equal(t, 2, s.MsgSent)
+}
+
+// slowPublic is a public key taking a known time to verify a signature
+type slowPublic struct {
+	*fakePublic
+	latency time.Duration
+}
+
+func (p *slowPublic) VerifySignature(msg []byte, s Signature) error {
+	time.Sleep(p.latency)
+	return p.fakePublic.VerifySignature(msg, s)
+}
+
+func (p *slowPublic) Combine(PublicKey) PublicKey { return p }
+
+type slowCons struct {
+	*fakeCons
+	pub *slowPublic
+}
+
+func (c *slowCons) PublicKey() PublicKey { return c.pub }
+
+func TestHandelVerificationStats(t *testing.T) {
+	n := 4
+	latency := 5 * time.Millisecond
+	pub := &slowPublic{&fakePublic{true}, latency}
+	cons := &slowCons{new(fakeCons), pub}
+	ids := make([]Identity, n)
+	for i := 0; i < n; i++ {
+		ids[i] = NewStaticIdentity(int32(i), "", pub)
+	}
+	reg := NewArrayRegistry(ids)
+	for _, conf := range []*Config{
+		{},
+		{PriorityProcessing: true},
+		{BatchSize: 4},
+	} {
+		conf.Contributions = n
+		conf.NewTimeoutStrategy = newInfiniteTimeout
+		nets := make([]Network, n)
+		for i := range nets {
+			nets[i] = &TestNetwork{id: int32(i), list: nets}
+		}
+		handels := make([]*Handel, n)
+		for i := 0; i < n; i++ {
+			handels[i] = NewHandel(nets[i], reg, ids[i], cons, msg, &fakeSig{true}, conf)
+		}
+		h := handels[0]
+		count, total, max := h.VerificationStats()
+		require.Equal(t, 0, count)
+		require.Equal(t, time.Duration(0), total+max)
+
+		for _, h := range handels {
+			go h.Start()
+		}
+		select {
+		case <-h.FinalSignatures():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no final signature")
+		}
+		CloseHandels(handels)
+		count, total, max = h.VerificationStats()
+		require.True(t, count > 0)
+		require.True(t, max >= latency)
+		require.True(t, max <= total)
+		require.True(t, total >= time.Duration(count)*latency)
+	}
+}
+
+// countSig and countPublic count how many times each contribution has been
+// aggregated, so a signature only verifies if no contribution is aggregated
+// twice in the signature or in the public key. countSig is subtractable.
+type countSig map[int32]int
+
+func (c countSig) MarshalBinary() ([]byte, error) { return nil, nil }
+func (c countSig) UnmarshalBinary([]byte) error   { return nil }
+func (c countSig) Combine(s Signature) Signature {
+	res := make(countSig)
+	for k, v := range c {
+		res[k] += v
+	}
+	for k, v := range s.(countSig) {
+		res[k] += v
+	}
+	return res
+}
+
+func (c countSig) Subtract(s Signature) Signature {
+	res := make(countSig)
+	for k, v := range c {
+		res[k] += v
+	}
+	for k, v := range s.(countSig) {
+		if res[k] -= v; res[k] == 0 {
+			delete(res, k)
+		}
+	}
+	return res
+}
+
+type countPublic struct {
+	countSig
+}
+
+func (c *countPublic) VerifySignature(msg []byte, s Signature) error {
+	if !reflect.DeepEqual(c.countSig, s.(countSig)) {
+		return errors.New("invalid count")
+	}
+	return nil
+}
+func (c *countPublic) Combine(p PublicKey) PublicKey {
+	return &countPublic{c.countSig.Combine(p.(*countPublic).countSig).(countSig)}
+}
+func (c *countPublic) String() string { return fmt.Sprint(c.countSig) }
+
+type countCons struct{}
+
+func (c *countCons) Signature() Signature { return make(countSig) }
+func (c *countCons) PublicKey() PublicKey { return &countPublic{make(countSig)} }
+
+type countSecret struct {
+	id int32
+}
+
+func (c *countSecret) Sign(msg []byte, r io.Reader) (Signature, error) {
+	return countSig{c.id: 1}, nil
+}
+
+func TestHandelTestSigner(t *testing.T) {
+	n := 8
+	ids := make([]Identity, n)
+	keys := make([]SecretKey, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", &countPublic{countSig{int32(i): 1}})
+		keys[i] = &countSecret{int32(i)}
+	}
+	reg := NewArrayRegistry(ids)
+	signer := NewTestSigner(reg, keys, new(countCons), msg)
+
+	ms, err := signer.Sign(0, 3, 7)
+	require.NoError(t, err)
+	require.Equal(t, n, ms.BitLength())
+	require.Equal(t, 3, ms.Cardinality())
+	require.NoError(t, VerifyMultiSignature(msg, ms, reg, new(countCons)))
+
+	// signature of the level 2 of node 1, i.e. of nodes 2 and 3
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	min, max, err := part.(*binomialPartitioner).rangeLevel(2)
+	require.NoError(t, err)
+	ms, err = signer.SignRange(min, max, 1)
+	require.NoError(t, err)
+	inc := &incomingSig{level: 2, ms: ms}
+	require.NoError(t, verifySignature(inc, msg, part, new(countCons), nil))
+
+	_, err = signer.Sign()
+	require.Error(t, err)
+	_, err = signer.Sign(n)
+	require.Error(t, err)
+	_, err = signer.Sign(1, 1)
+	require.Error(t, err)
+	_, err = signer.SignRange(4, n+1, 0)
+	require.Error(t, err)
+}
+
+func TestHandelOwnContributionOnce(t *testing.T) {
+	n := 8
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", &countPublic{countSig{int32(i): 1}})
+	}
+	reg := NewArrayRegistry(ids)
+	h := NewHandel(new(levelNetwork), reg, ids[1], new(countCons), msg, countSig{1: 1})
+
+	// our own identity is in none of the levels peers send signatures for, so
+	// a packet can't include our own contribution
+	for _, lvl := range h.ids {
+		_, err := h.Partitioner.IndexAtLevel(1, lvl)
+		require.Error(t, err)
+	}
+	buff, err := fullSig(1).MarshalBinary()
+	require.NoError(t, err)
+	_, _, err = h.parseSignatures(&Packet{Origin: 1, Level: 1, MultiSig: buff, IndividualSig: buff})
+	require.Error(t, err)
+
+	// complete signatures of all levels, as aggregated by the peers
+	for _, lvl := range h.ids {
+		peers, err := h.Partitioner.IdentitiesAt(lvl)
+		require.NoError(t, err)
+		sig := make(countSig)
+		for _, p := range peers {
+			sig[p.ID()] = 1
+		}
+		inc := &incomingSig{level: byte(lvl), ms: &MultiSignature{BitSet: finalBitset(len(peers)), Signature: sig}}
+		require.NoError(t, verifySignature(inc, msg, h.Partitioner, h.cons, nil))
+		h.store.Store(inc)
+	}
+
+	// each contribution is aggregated once in the full signature and its key
+	full := h.store.FullSignature()
+	require.Equal(t, n, full.Cardinality())
+	apk := h.cons.PublicKey()
+	for i := 0; i < n; i++ {
+		apk = apk.Combine(ids[i].PublicKey())
+	}
+	require.NoError(t, apk.VerifySignature(msg, full.Signature))
+}
+
+func TestHandelDumpBest(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	var buff bytes.Buffer
+	conf := &Config{
+		NewTimeoutStrategy: newInfiniteTimeout,
+		Logger:             NewKitLoggerFrom(log.NewLogfmtLogger(&buff)),
+	}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	h.store.Store(fullIncomingSig(1))
+	sig := fullIncomingSig(2)
+	sig.ms.BitSet.Set(1, false)
+	h.store.Store(sig)
+	h.checkCompletedLevel(fullIncomingSig(1))
+
+	best := h.DumpBest()
+	require.Equal(t, 3, best.Cardinality())
+	require.True(t, best.Get(2))
+	require.False(t, best.Get(3))
+	out := buff.String()
+	require.Contains(t, out, "dump_best=3/5/8")
+	require.Contains(t, out, "contributors=0,1,2")
+	require.Contains(t, out, "1:1/1(started=true,completed=true)")
+	require.Contains(t, out, "2:1/2(started=true,completed=false)")
+	require.Contains(t, out, "3:0/4(started=false,completed=false)")
+}
+
+func TestHandelLevelBitSets(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	sig := fullIncomingSig(2)
+	sig.ms.BitSet.Set(1, false)
+	h.store.Store(sig)
+
+	bitsets := h.LevelBitSets()
+	require.Len(t, bitsets, 2)
+	require.Equal(t, 1, bitsets[0].Cardinality())
+	require.Equal(t, 1, bitsets[2].Cardinality())
+	require.Equal(t, 2, bitsets[2].BitLength())
+
+	// the bitsets are copies
+	bitsets[2].Set(1, true)
+	best, ok := h.store.Best(2)
+	require.True(t, ok)
+	require.False(t, best.Get(1))
+}
+
+func TestHandelAbort(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	h.Start()
+	reason := errors.New("block orphaned")
+	h.Abort(reason)
+	h.Abort(errors.New("aborted twice"))
+	select {
+	case err := <-h.Aborted():
+		require.Equal(t, reason, err)
+	case <-time.After(time.Second):
+		t.Fatal("no abort signal")
+	}
+	_, ok := <-h.FinalSignatures()
+	require.False(t, ok)
+	_, ok = <-h.Aborted()
+	require.False(t, ok)
+
+	// a stopped Handel is not aborted
+	h = NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	h.Start()
+	h.Stop()
+	h.Abort(reason)
+	require.NoError(t, <-h.Aborted())
+}
+
+func TestHandelLevelCallback(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true})
+	type completion struct{ level, card int }
+	var completed []completion
+	h.RegisterLevelCallback(func(level, card int, at time.Time) {
+		require.False(t, at.IsZero())
+		completed = append(completed, completion{level, card})
+	})
+	// an incomplete level
+	bs := NewWilffBitset(8)
+	bs.Set(0, true)
+	h.onVerified(&incomingSig{origin: 8, level: 4, ms: newSig(bs)})
+	require.Empty(t, completed)
+
+	h.onVerified(fullIncomingSig(3))
+	h.onVerified(fullIncomingSig(4))
+	// a level is only notified once
+	h.onVerified(fullIncomingSig(3))
+	require.Equal(t, []completion{{3, 4}, {4, 8}}, completed)
+}
+
+func TestHandelBest(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true})
+	ms, card := h.Best()
+	require.Nil(t, ms)
+	require.Equal(t, 0, card)
+
+	h.store.Store(fullIncomingSig(2))
+	ms, card = h.Best()
+	require.Equal(t, 3, card)
+	require.Equal(t, n, ms.BitLength())
+	for _, i := range []int{1, 2, 3} {
+		require.True(t, ms.Get(i))
+	}
+	// the copy does not alias the store
+	ms.Set(7, true)
+	_, card = h.Best()
+	require.Equal(t, 3, card)
+}
+
+func TestHandelStartContext(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	ctx, cancel := context.WithCancel(context.Background())
+	h.StartContext(ctx)
+	cancel()
+	select {
+	case _, ok := <-h.FinalSignatures():
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("not stopped by the context")
+	}
+	// stopping again does not close the channels twice
+	h.Stop()
+
+	// a Handel stopped before its context is done
+	h = NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	ctx, cancel = context.WithCancel(context.Background())
+	h.StartContext(ctx)
+	h.Stop()
+	cancel()
+	_, ok := <-h.FinalSignatures()
+	require.False(t, ok)
+}
+
+func TestHandelDryRun(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	var buff bytes.Buffer
+	net := new(levelNetwork)
+	conf := &Config{
+		NewTimeoutStrategy: newInfiniteTimeout,
+		Logger:             NewKitLoggerFrom(log.NewLogfmtLogger(&buff)),
+		DryRun:             true,
+	}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	h.periodicUpdate()
+	h.store.Store(fullIncomingSig(1))
+	h.Lock()
+	h.checkCompletedLevel(fullIncomingSig(1))
+	h.Unlock()
+
+	require.Empty(t, net.levels)
+	require.True(t, h.levels[1].rcvCompleted)
+	require.True(t, h.levels[2].started())
+	require.Equal(t, 1+2, h.stats.msgSentCt)
+	require.Contains(t, buff.String(), "dry_run=1 dest=[fake-0-true] size=")
+	require.Contains(t, buff.String(), "dry_run=2")
+}
+
+func TestHandelAcceptLevelWindow(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, AcceptLevelWindow: 1}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	accepted := func(level byte) bool {
+		peers, err := h.Partitioner.IdentitiesAt(int(level))
+		require.NoError(t, err)
+		p, err := h.acceptPacket(&Packet{Origin: peers[0].ID(), Level: level}, packetKey{}, false)
+		require.NoError(t, err)
+		return p != nil
+	}
+
+	// only the level 1 is started
+	require.True(t, accepted(1))
+	require.True(t, accepted(2))
+	require.False(t, accepted(3))
+	require.False(t, accepted(4))
+
+	h.StartLevel(3)
+	require.False(t, accepted(1))
+	require.True(t, accepted(2))
+	require.True(t, accepted(3))
+	require.True(t, accepted(4))
+
+	s := h.Stats()
+	require.Equal(t, 3, s.MsgOutOfWindow)
+	require.Equal(t, 8, s.MsgRcv)
+}
+
+func TestHandelSkipNoImprovement(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	h := NewHandel(new(slowNetwork), reg, id, new(fakeCons), msg, &fakeSig{true})
+	defer h.Stop()
+	stub := new(stubProcessing)
+	h.proc = stub
+
+	peers, err := h.Partitioner.IdentitiesAt(3)
+	require.NoError(t, err)
+	require.Len(t, peers, 4)
+	packet := func(origin int32, indexes ...int) *Packet {
+		bs := NewWilffBitset(len(peers))
+		for _, i := range indexes {
+			bs.Set(i, true)
+		}
+		buff, err := newSig(bs).MarshalBinary()
+		require.NoError(t, err)
+		return &Packet{Origin: origin, Level: 3, MultiSig: buff}
+	}
+	best := NewWilffBitset(len(peers))
+	best.Set(0, true)
+	best.Set(1, true)
+	h.store.Store(&incomingSig{origin: peers[0].ID(), level: 3, ms: newSig(best)})
+
+	// a duplicate of the best signature, and a subset of it, are never verified
+	h.NewPacket(packet(peers[0].ID(), 0, 1))
+	h.NewPacket(packet(peers[1].ID(), 1))
+	require.Empty(t, stub.todos)
+	require.Equal(t, 2, h.Stats().SigSkipped)
+
+	// individual signatures are kept to build better signatures
+	withInd := packet(peers[1].ID(), 1)
+	withInd.IndividualSig, err = (&fakeSig{true}).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(withInd)
+	require.Len(t, stub.todos, 1)
+	require.True(t, stub.todos[0].Individual())
+	require.Equal(t, 3, h.Stats().SigSkipped)
+
+	// a signature with a new contribution is verified
+	h.NewPacket(packet(peers[2].ID(), 0, 2))
+	require.Len(t, stub.todos, 2)
+	require.Equal(t, 3, h.Stats().SigSkipped)
+}
+
+func TestHandelRejectNonImproving(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := DefaultConfig(n)
+	require.True(t, conf.RejectNonImproving)
+	conf.RejectNonImproving = false
+	h := NewHandel(new(slowNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	stub := new(stubProcessing)
+	h.proc = stub
+
+	peers, err := h.Partitioner.IdentitiesAt(3)
+	require.NoError(t, err)
+	best := NewWilffBitset(len(peers))
+	best.Set(0, true)
+	best.Set(1, true)
+	h.store.Store(&incomingSig{origin: peers[0].ID(), level: 3, ms: newSig(best)})
+
+	// without the option, a signature not improving the store is verified
+	buff, err := newSig(best).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: peers[1].ID(), Level: 3, MultiSig: buff})
+	require.Len(t, stub.todos, 1)
+	require.Equal(t, 0, h.Stats().SigSkipped)
+}
+
+func TestHandelPacketAuth(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	nets := make([]Network, n)
+	for i := range nets {
+		nets[i] = &TestNetwork{int32(i), nets, nil}
+	}
+	conf := &Config{PacketAuth: idAuth{}}
+	handels := make([]*Handel, n)
+	for i := range handels {
+		id, _ := reg.Identity(i)
+		handels[i] = NewHandel(nets[i], reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		go h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.True(t, ms.Cardinality() >= h.threshold)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("instance %d did not complete", i)
+		}
+	}
+
+	id, _ := reg.Identity(1)
+	h := NewHandel(new(slowNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	stub := new(stubProcessing)
+	h.proc = stub
+	peers, err := h.Partitioner.IdentitiesAt(3)
+	require.NoError(t, err)
+	bs := NewWilffBitset(len(peers))
+	bs.Set(0, true)
+	buff, err := newSig(bs).MarshalBinary()
+	require.NoError(t, err)
+	signed := func(origin int32) *Packet {
+		p := &Packet{Origin: origin, Level: 3, MultiSig: buff}
+		p.Auth, err = idAuth{}.Sign(p)
+		require.NoError(t, err)
+		return p
+	}
+
+	// a packet claiming another origin than its signer is dropped
+	spoofed := signed(peers[0].ID())
+	spoofed.Origin = peers[1].ID()
+	h.NewPacket(spoofed)
+	h.NewPacket(&Packet{Origin: peers[0].ID(), Level: 3, MultiSig: buff})
+	require.Empty(t, stub.todos)
+	h.NewPacket(signed(peers[0].ID()))
+	require.Len(t, stub.todos, 1)
+}
+
+func TestHandelDedup(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{UpdatePeriod: time.Hour}
+	h := NewHandel(new(slowNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	require.Equal(t, DefaultDedupCacheFactor*n, h.c.DedupCacheSize)
+	stub := new(stubProcessing)
+	h.proc = stub
+
+	peers, err := h.Partitioner.IdentitiesAt(3)
+	require.NoError(t, err)
+	packet := func(indexes ...int) *Packet {
+		bs := NewWilffBitset(len(peers))
+		for _, i := range indexes {
+			bs.Set(i, true)
+		}
+		buff, err := newSig(bs).MarshalBinary()
+		require.NoError(t, err)
+		return &Packet{Origin: peers[0].ID(), Level: 3, MultiSig: buff}
+	}
+
+	h.NewPacket(packet(0))
+	h.NewPacket(packet(0))
+	require.Len(t, stub.todos, 1)
+	// an improved signature from the same origin and level is not a duplicate
+	h.NewPacket(packet(0, 1))
+	require.Len(t, stub.todos, 2)
+	s := h.Stats()
+	require.Equal(t, 1, s.MsgDuplicate)
+	require.Equal(t, 3, s.MsgRcv)
+
+	// the packets are only deduplicated within the update period
+	h.SetUpdatePeriod(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	h.NewPacket(packet(0))
+	require.Len(t, stub.todos, 3)
+
+	// the cache can be disabled
+	conf.DedupCacheSize = -1
+	h2 := NewHandel(new(slowNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h2.Stop()
+	stub = new(stubProcessing)
+	h2.proc = stub
+	h2.NewPacket(packet(0))
+	h2.NewPacket(packet(0))
+	require.Len(t, stub.todos, 2)
+}
+
+// verifiedProcessing outputs the verified signatures it is given
+type verifiedProcessing struct {
+	out chan incomingSig
+}
+
+func (v *verifiedProcessing) Start()                     {}
+func (v *verifiedProcessing) Stop()                      {}
+func (v *verifiedProcessing) Add(*incomingSig)           {}
+func (v *verifiedProcessing) Verified() chan incomingSig { return v.out }
+
+func TestHandelReorderVerified(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	for _, reorder := range []bool{false, true} {
+		conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, ReorderVerified: reorder}
+		h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		var levels []byte
+		h.actors = append(h.actors, actorFunc(func(s *incomingSig) {
+			levels = append(levels, s.level)
+		}))
+		// signatures verified out of level order
+		proc := &verifiedProcessing{make(chan incomingSig, 4)}
+		for _, lvl := range []int{3, 1, 4, 2} {
+			proc.out <- *fullIncomingSig(lvl)
+		}
+		close(proc.out)
+		h.proc = proc
+		h.rangeOnVerified(proc.out)
+		if reorder {
+			require.Equal(t, []byte{1, 2, 3, 4}, levels)
+		} else {
+			require.Equal(t, []byte{3, 1, 4, 2}, levels)
+		}
+		require.Equal(t, n, h.store.FullSignature().Cardinality())
+		h.Stop()
+	}
+
+	// the reordering is bounded
+	verified := make(chan incomingSig, reorderBufferSize+1)
+	for i := 0; i <= reorderBufferSize; i++ {
+		verified <- *fullIncomingSig(1)
+	}
+	batch := bufferVerified(verified, []incomingSig{*fullIncomingSig(2)})
+	require.Len(t, batch, reorderBufferSize)
+	require.Equal(t, byte(2), batch[len(batch)-1].level)
+	require.Len(t, verified, 2)
+}
+
+func TestHandelETA(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, Contributions: 12}
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	_, ok := h.ETA()
+	require.False(t, ok)
+
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	h.recordProgress(at(0), 1)
+	_, ok = h.eta(at(0))
+	require.False(t, ok)
+
+	// one contribution every 10ms
+	h.recordProgress(at(10), 2)
+	h.recordProgress(at(20), 3)
+	h.recordProgress(at(25), 3)
+	h.recordProgress(at(30), 4)
+	require.Len(t, h.progress, 4)
+	eta, ok := h.eta(at(30))
+	require.True(t, ok)
+	require.Equal(t, 80*time.Millisecond, eta)
+	eta, ok = h.eta(at(50))
+	require.True(t, ok)
+	require.Equal(t, 60*time.Millisecond, eta)
+
+	// no improvement for longer than the history: stalled
+	_, ok = h.eta(at(61))
+	require.False(t, ok)
+
+	// the samples are bounded, the oldest ones are dropped
+	for i := 5; i < 5+progressSamples; i++ {
+		h.recordProgress(at(100+i), i)
+	}
+	require.Len(t, h.progress, progressSamples)
+	require.Equal(t, 5, h.progress[0].card)
+
+	// threshold reached
+	h.recordProgress(at(200), 12)
+	eta, ok = h.eta(at(300))
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), eta)
+}
+
+func TestHandelCombinedAt(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+
+	_, ok := h.CombinedAt(-1)
+	require.False(t, ok)
+	_, ok = h.CombinedAt(h.Partitioner.MaxLevel() + 1)
+	require.False(t, ok)
+
+	// only our own signature is stored
+	ms, ok := h.CombinedAt(0)
+	require.True(t, ok)
+	require.Equal(t, 1, ms.Cardinality())
+
+	// signature of node 0 at level 1
+	h.store.Store(fullIncomingSig(1))
+	ms, ok = h.CombinedAt(1)
+	require.True(t, ok)
+	require.Equal(t, 2, ms.Cardinality())
+	require.True(t, ms.Get(0))
+	require.True(t, ms.Get(1))
+}
+
+func TestHandelPacketAfterDone(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	h := handels[1]
+	late := make(chan *Packet, 1)
+	h.c.OnPacketAfterDone = func(p *Packet) {
+		late <- p
+	}
+	h.Stop()
+
+	buff, err := fullSig(1).MarshalBinary()
+	require.NoError(t, err)
+	p := &Packet{Origin: 0, Level: 1, MultiSig: buff}
+	h.NewPacket(p)
+	select {
+	case p2 := <-late:
+		require.Equal(t, p, p2)
+	default:
+		t.Fatal("late packet not given to the hook")
+	}
+}
+
+func TestHandelMute(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+	h.Mute()
+	h.Start()
+
+	buff, err := fullSig(1).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 0, Level: 1, MultiSig: buff})
+
+	// the full signature grows from the received packet
+	deadline := time.After(time.Second)
+	for h.store.FullSignature().Cardinality() != 2 {
+		select {
+		case <-deadline:
+			t.Fatal("received signature not stored")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	sent := func() int {
+		h.Lock()
+		defer h.Unlock()
+		return h.stats.msgSentCt
+	}
+	// wait for a few periodic updates
+	time.Sleep(5 * DefaultUpdatePeriod)
+	require.Equal(t, 0, sent())
+
+	// peers of started levels are contacted again once unmuted
+	h.Unmute()
+	time.Sleep(5 * DefaultUpdatePeriod)
+	require.True(t, sent() > 0)
+}
+
+func TestHandelActorPanic(t *testing.T) {
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	panics := make(chan interface{}, 1)
+	for _, h := range handels {
+		h.c.OnActorPanic = func(r interface{}) {
+			select {
+			case panics <- r:
+			default:
+			}
+		}
+		h.actors = append([]actor{actorFunc(func(*incomingSig) {
+			panic("faulty handler")
+		})}, h.actors...)
+	}
+	for _, h := range handels {
+		go h.Start()
+	}
+
+	// the protocol must still complete despite the faulty handler
+	for _, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.True(t, ms.Cardinality() >= h.c.Contributions)
+		case <-time.After(2 * time.Second):
+			t.Fatal("handel did not survive a panicking actor")
+		}
+	}
+	require.Equal(t, "faulty handler", <-panics)
+}
+
+// slowNetwork simulates a synchronous transport taking some time to send
+// each packet. It records the maximum number of concurrent sends.
+type slowNetwork struct {
+	sync.Mutex
+	delay    time.Duration
+	sent     int
+	inFlight int
+	maxIn    int
+	// if set, each send is signaled on entered and lasts until block is closed
+	// instead of the delay
+	entered chan bool
+	block   chan bool
+}
+
+func (s *slowNetwork) RegisterListener(Listener) {}
+
+func (s *slowNetwork) Send(ids []Identity, p *Packet) {
+	s.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxIn {
+		s.maxIn = s.inFlight
+	}
+	entered, block := s.entered, s.block
+	s.Unlock()
+	if block != nil {
+		entered <- true
+		<-block
+	} else {
+		time.Sleep(s.delay)
+	}
+	s.Lock()
+	s.inFlight--
+	s.sent++
+	s.Unlock()
+}
+
+// newSlowHandel returns a Handel with all levels started, sending through a
+// slow network.
+func newSlowHandel(n int, net *slowNetwork, concurrency int) *Handel {
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{
+		NewTimeoutStrategy: newInfiniteTimeout,
+		SendConcurrency:    concurrency,
+	}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	for _, lvl := range h.levels {
+		lvl.setStarted()
+	}
+	return h
+}
+
+func TestHandelSendConcurrency(t *testing.T) {
+	net := &slowNetwork{delay: 20 * time.Millisecond}
+	h := newSlowHandel(16, net, 2)
+	defer h.Stop()
+	h.periodicUpdate()
+	// one send per level, at most two at the same time
+	require.Equal(t, len(h.ids), net.sent)
+	require.Equal(t, 2, net.maxIn)
+	require.Equal(t, len(h.ids), h.stats.msgSentCt)
+	require.Empty(t, h.pendingSends)
+
+	// the lock is not held during the sends
+	net.Lock()
+	net.entered = make(chan bool, len(h.ids))
+	net.block = make(chan bool)
+	net.Unlock()
+	for _, lvl := range h.levels {
+		lvl.sendPeersCt = 0
+	}
+	done := make(chan bool)
+	go func() {
+		h.periodicUpdate()
+		close(done)
+	}()
+	<-net.entered
+	locked := make(chan bool)
+	go func() {
+		h.Lock()
+		h.Unlock()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock held while sending")
+	}
+	close(net.block)
+	<-done
+}
+
+func TestHandelMaxOutstandingSends(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	var sends []pendingSend
+	for i := 0; i < 4; i++ {
+		sends = append(sends, pendingSend{[]Identity{id}, &Packet{Level: byte(i)}})
+	}
+	newHandel := func(net Network, drop bool) *Handel {
+		conf := &Config{
+			NewTimeoutStrategy:  newInfiniteTimeout,
+			SendConcurrency:     4,
+			MaxOutstandingSends: 2,
+			DropBlockedSends:    drop,
+		}
+		return NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	// concurrent updates block once the ceiling is reached
+	net := &slowNetwork{delay: 20 * time.Millisecond}
+	h := newHandel(net, false)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.sendConcurrently(sends)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 12, net.sent)
+	require.Equal(t, 2, net.maxIn)
+
+	// or drop the sends exceeding it
+	net = &slowNetwork{delay: 100 * time.Millisecond}
+	h = newHandel(net, true)
+	h.sendConcurrently(sends)
+	require.Equal(t, 2, net.sent)
+	require.Equal(t, 2, net.maxIn)
+
+	// the sequential updates and the fast path sends take a slot too
+	for _, drop := range []bool{false, true} {
+		net = &slowNetwork{}
+		conf := &Config{
+			NewTimeoutStrategy:  newInfiniteTimeout,
+			MaxOutstandingSends: 1,
+			DropBlockedSends:    drop,
+		}
+		h = NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		for _, lvl := range h.levels {
+			lvl.setStarted()
+		}
+		// a send in flight
+		h.sendSem <- true
+		done := make(chan bool)
+		go func() {
+			h.periodicUpdate()
+			h.Lock()
+			h.sendUpdate(h.levels[1], h.c.FastPath)
+			h.Unlock()
+			close(done)
+		}()
+		if drop {
+			<-done
+			require.Equal(t, 0, net.sent)
+			continue
+		}
+		select {
+		case <-done:
+			t.Fatal("send not waiting for a slot")
+		case <-time.After(50 * time.Millisecond):
+		}
+		<-h.sendSem
+		<-done
+		require.Equal(t, len(h.ids)+1, net.sent)
+		require.Equal(t, 1, net.maxIn)
+	}
+}
+
+func BenchmarkHandelSendConcurrency(b *testing.B) {
+	for _, concurrency := range []int{0, 2, 4} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			net := &slowNetwork{delay: time.Millisecond}
+			h := newSlowHandel(16, net, concurrency)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, lvl := range h.levels {
+					lvl.sendPeersCt = 0
+				}
+				h.periodicUpdate()
+			}
+		})
+	}
+}
+
+func TestHandelDecodeWorkers(t *testing.T) {
+	n := 33
+	config := DefaultConfig(n)
+	config.NewTimeoutStrategy = newInfiniteTimeout
+	config.DecodeWorkers = 4
+	secrets := make([]SecretKey, n)
+	pubs := make([]PublicKey, n)
+	for i := 0; i < n; i++ {
+		secrets[i] = new(fakeSecret)
+		pubs[i] = &fakePublic{true}
+	}
+	test := NewTest(secrets, pubs, new(fakeCons), msg, config)
+	test.Start()
+	defer test.Stop()
+	select {
+	case <-test.WaitCompleteSuccess():
+	case <-time.After(30 * time.Second):
+		t.FailNow()
+	}
+}
+
+func BenchmarkHandelDecodeWorkers(b *testing.B) {
+	n := 1000
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	for _, workers := range []int{0, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			conf := &Config{
+				NewTimeoutStrategy: newInfiniteTimeout,
+				UpdatePeriod:       time.Hour,
+				DecodeWorkers:      workers,
+			}
+			h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+			defer h.Stop()
+			// packets of the largest level, decoded but never verified
+			lvl := h.ids[len(h.ids)-1]
+			nodes := h.levels[lvl].nodes
+			buff, err := newSig(finalBitset(len(nodes))).MarshalBinary()
+			require.NoError(b, err)
+			b.ResetTimer()
+			// the packets are received by a single goroutine, as the network
+			// implementations do
+			for i := 0; i < b.N; i++ {
+				h.NewPacket(&Packet{Origin: nodes[i%len(nodes)].ID(), Level: byte(lvl), MultiSig: buff})
+			}
+			// waits for all the workers to be done
+			for i := 0; i < workers; i++ {
+				h.decodeSem <- true
+			}
+		})
+	}
+}
+
+// levelNetwork records the level of each packet sent and the IDs of the
+// nodes it is sent to.
+type levelNetwork struct {
+	sync.Mutex
+	levels []byte
+	ids    []int32
+}
+
+func (l *levelNetwork) RegisterListener(Listener) {}
+
+func (l *levelNetwork) Send(ids []Identity, p *Packet) {
+	l.Lock()
+	defer l.Unlock()
+	l.levels = append(l.levels, p.Level)
+	for _, id := range ids {
+		l.ids = append(l.ids, id.ID())
+	}
+}
+
+func TestHandelStartAt(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	newHandel := func(net Network) *Handel {
+		conf := &Config{UpdatePeriod: 10 * time.Millisecond, UpdateCount: n}
+		return NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	// the levels 0 to 2 are the nodes 0 to 3
+	initial := newSig(NewWilffBitset(n))
+	for i := 0; i < 4; i++ {
+		initial.Set(i, true)
+	}
+
+	net := new(levelNetwork)
+	h := newHandel(net)
+	defer h.Stop()
+	require.NoError(t, h.StartAt(3, initial))
+	time.Sleep(50 * time.Millisecond)
+	h.Lock()
+	require.True(t, h.levels[1].rcvCompleted)
+	require.True(t, h.levels[2].rcvCompleted)
+	require.True(t, h.levels[3].started())
+	h.Unlock()
+	net.Lock()
+	require.NotEmpty(t, net.levels)
+	for _, lvl := range net.levels {
+		require.NotEqual(t, byte(1), lvl)
+		require.NotEqual(t, byte(2), lvl)
+	}
+	// the nodes of the level 3 are sent the initial signature
+	require.Contains(t, net.ids, int32(4))
+	net.Unlock()
+
+	invalid := func(level int, ms *MultiSignature) {
+		h := newHandel(new(levelNetwork))
+		defer h.Stop()
+		require.Error(t, h.StartAt(level, ms))
+	}
+	invalid(0, initial)
+	invalid(5, initial)
+	invalid(2, initial)
+	invalid(3, newSig(NewWilffBitset(n-1)))
+	// without our contribution
+	bs := initial.BitSet.Clone()
+	bs.Set(1, false)
+	invalid(3, newSig(bs))
+	// with an invalid signature
+	invalid(3, &MultiSignature{BitSet: initial.BitSet, Signature: &fakeSig{false}})
+
+	// with 6 nodes, the node 5 has no level 2
+	reg = FakeRegistry(6)
+	id, _ = reg.Identity(5)
+	net = new(levelNetwork)
+	h = newHandel(net)
+	defer h.Stop()
+	initial = newSig(NewWilffBitset(6))
+	initial.Set(4, true)
+	initial.Set(5, true)
+	require.NoError(t, h.StartAt(3, initial))
+	time.Sleep(50 * time.Millisecond)
+	h.Lock()
+	require.True(t, h.levels[1].rcvCompleted)
+	require.True(t, h.levels[3].started())
+	h.Unlock()
+	net.Lock()
+	require.Contains(t, net.ids, int32(0))
+	net.Unlock()
+}
+
+func TestHandelSkipSelf(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	net := new(levelNetwork)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, UpdateCount: n}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	// a misconfigured level containing ourself
+	lvl := h.levels[3]
+	lvl.nodes = append(lvl.nodes, id)
+	lvl.setStarted()
+	h.Lock()
+	h.sendUpdate(lvl, len(lvl.nodes))
+	h.Unlock()
+	require.ElementsMatch(t, []int32{4, 5, 6, 7}, net.ids)
+	require.Equal(t, 4, h.stats.msgSentCt)
+}
+
+func TestHandelSelfPropagationRetries(t *testing.T) {
+	n := 4
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	net := new(levelNetwork)
+	conf := &Config{
+		NewTimeoutStrategy:     newInfiniteTimeout,
+		UpdatePeriod:           time.Hour,
+		UpdateCount:            n,
+		SelfPropagationRetries: 2,
+	}
+	newHandel := func() *Handel {
+		h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		for _, lvl := range h.levels {
+			lvl.setStarted()
+		}
+		return h
+	}
+	sent := func() int {
+		net.Lock()
+		defer net.Unlock()
+		n := len(net.levels)
+		net.levels = nil
+		return n
+	}
+
+	// all the peers are down: both levels are sent again twice
+	h := newHandel()
+	for i := 0; i < 3; i++ {
+		h.periodicUpdate()
+		require.Equal(t, 2, sent())
+	}
+	h.periodicUpdate()
+	require.Equal(t, 0, sent())
+	h.Stop()
+
+	// a peer comes back after a retransmission and tells us it completed the
+	// level containing our contribution, only trusted once its signature is
+	// verified
+	h = newHandel()
+	defer h.Stop()
+	h.Start()
+	for i := 0; i < 2; i++ {
+		h.periodicUpdate()
+		require.Equal(t, 2, sent())
+	}
+	invalid, err := (&MultiSignature{BitSet: finalBitset(2), Signature: &fakeSig{false}}).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 3, Level: 2, MultiSig: invalid})
+	buff, err := fullSig(2).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 2, Level: 2, MultiSig: buff})
+	deadline := time.After(time.Second)
+	for {
+		h.Lock()
+		propagated := h.selfPropagated
+		h.Unlock()
+		if propagated {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("verified packet without individual signature not seen")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	h.periodicUpdate()
+	require.Equal(t, 0, sent())
+
+	// an invalid packet does not stop the retransmissions
+	h2 := newHandel()
+	defer h2.Stop()
+	h2.Start()
+	h2.NewPacket(&Packet{Origin: 3, Level: 2, MultiSig: invalid})
+	for i := 0; i < 2; i++ {
+		h2.periodicUpdate()
+		require.Equal(t, 2, sent())
+	}
+}
+
+func TestHandelSelfPropagationNewPeers(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	net := new(levelNetwork)
+	conf := &Config{
+		NewTimeoutStrategy:     newInfiniteTimeout,
+		UpdatePeriod:           time.Hour,
+		UpdateCount:            n,
+		SelfPropagationRetries: 3,
+	}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	sent := func() []byte {
+		net.Lock()
+		defer net.Unlock()
+		lvls := net.levels
+		net.levels = nil
+		return lvls
+	}
+	// the peer of the level 1 is down: the retransmissions start the next
+	// levels, whose peers have not been contacted yet, before sending again
+	// to the same peers
+	h.periodicUpdate()
+	require.Equal(t, []byte{1}, sent())
+	h.periodicUpdate()
+	require.Equal(t, []byte{2}, sent())
+	h.periodicUpdate()
+	require.Equal(t, []byte{3}, sent())
+	h.periodicUpdate()
+	require.ElementsMatch(t, []byte{1, 2, 3}, sent())
+	h.periodicUpdate()
+	require.Empty(t, sent())
+}
+
+func TestHandelCheckCompletedLevelOrder(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	net := new(levelNetwork)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout}
+	for i := 0; i < 10; i++ {
+		net.levels = nil
+		h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		// the complete signatures of the lower levels are verified in reverse
+		// order, the last one completes all the upper levels at once
+		verified := make(chan incomingSig, 3)
+		for _, level := range []int{3, 2, 1} {
+			verified <- *fullIncomingSig(level)
+		}
+		close(verified)
+		h.rangeOnVerified(verified)
+		require.Equal(t, []byte{2, 3, 4}, net.levels)
+		h.Stop()
+	}
+}
+
+func TestHandelCompletedLevelRegression(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, &Config{NewTimeoutStrategy: newInfiniteTimeout})
+	defer h.Stop()
+	// a level marked completed whose best signature is not complete
+	partial := fullIncomingSig(3)
+	partial.ms.BitSet.Set(0, false)
+	h.store.Store(partial)
+	h.getLevel(3).rcvCompleted = true
+	require.Panics(t, func() { h.checkCompletedLevel(partial) })
+
+	// outside the tests, the regression is only logged
+	checkInvariants = false
+	defer func() { checkInvariants = true }()
+	require.NotPanics(t, func() { h.checkCompletedLevel(partial) })
+	require.True(t, h.getLevel(3).rcvCompleted)
+}
+
+func TestHandelSustainedThreshold(t *testing.T) {
+	bs := NewWilffBitset(8)
+	ms := newSig(bs)
+	d := NewSustainedThreshold(2, 2)
+	require.False(t, d.Reached(ms))
+	bs.Set(0, true)
+	bs.Set(1, true)
+	require.False(t, d.Reached(ms))
+	// flapping below the threshold resets the detector
+	bs.Set(1, false)
+	require.False(t, d.Reached(ms))
+	bs.Set(1, true)
+	require.False(t, d.Reached(ms))
+	require.True(t, d.Reached(ms))
+	require.True(t, d.Reached(ms))
+
+	// the detector is used in place of the cardinality check
+	n := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+	h.c.ThresholdDetector = NewSustainedThreshold(2, 2)
+	h.store.Store(fullIncomingSig(1))
+	h.checkFinalSignature(nil)
+	require.Nil(t, h.best)
+	h.checkFinalSignature(nil)
+	require.NotNil(t, h.best)
+	require.Equal(t, 2, h.best.Cardinality())
+}
+
+func TestHandelCheckFinalSignature(t *testing.T) {
+	n := 16
+
+	type checkFinalTest struct {
+		// one slice represents sigs to store before calling the checkVerified
+		// you can put multiple slices to call checkverified multiple times
+		sigs [][]*incomingSig
+		// input to the handler
+		input *incomingSig
+		// expected output on the output channel
+		out []*MultiSignature
+	}
+
+	// test(3) set a non-complete signature followed by a complete signature
+	pairs1 := incomingSigs(0, 1, 2, 3, 4)
+	pairs2 := incomingSigs(3, 4)
+	// index 8 (2^4-1) + 6 = 14 set to false
+	pairs1[4].ms.BitSet.Set(6, false)
+	final4 := finalIncomingSig(4, n)
+	// missing one contribution
+	final4b := finalIncomingSig(4, n)
+	final4b.ms.BitSet.Set(14, false)
+
+	// test(4) set a under-threshold signature followed by a good one
+	pairs3 := incomingSigs(0, 1, 2, 3, 4)
+	// 4-1 -> because that's how you compute the size of a level
+	// -1 -> to just spread out holes to other levels and leave this one still
+	// having one contribution
+	for i := 0; i < pow2(4-1)-1; i++ {
+		pairs3[4].ms.BitSet.Set(i, false)
+	}
+	pairs3[3].ms.BitSet.Set(1, false)
+	pairs3[3].ms.BitSet.Set(2, false)
+
+	//fmt.Println("pairs3[4] bitset = ", pairs3[4].ms.BitSet.String())
+	//fmt.Println("pairs3[3] bitset = ", pairs3[3].ms.BitSet.String())
+
+	toMatrix := func(pairs ...[]*incomingSig) [][]*incomingSig {
+		return append(make([][]*incomingSig, 0), pairs...)
+	}
+	var tests = []checkFinalTest{
+		// too lower level signatures
+		{toMatrix(incomingSigs(0, 1, 2)), nil, []*MultiSignature{nil}},
+		// everything's perfect
+		{toMatrix(incomingSigs(0, 1, 2, 3, 4)), nil, []*MultiSignature{finalIncomingSig(4, n).ms}},
+		// gives two consecutives better
+		{toMatrix(pairs1, pairs2), nil, []*MultiSignature{final4b.ms, final4.ms}},
+		// one underthreshold and fully signed
+		{toMatrix(pairs3, pairs2), nil, []*MultiSignature{nil, final4.ms}},
+	}
+
+	waitOut := func(h *Handel) *MultiSignature {
+		select {
+		case ms := <-h.FinalSignatures():
+			return &ms
+		case <-time.After(20 * time.Millisecond):
+			return nil
+		}
+	}
+
+	for i, test := range tests {
+		t.Logf(" -- test %d --", i)
+		_, handels := FakeSetup(n)
+		h := handels[1]
+		store := h.store
+
+		for i, toInsert := range test.sigs {
+			// insert slice
+			for _, sig := range toInsert {
+				store.Store(sig)
+			}
+			h.checkFinalSignature(test.input)
+
+			// lookup expected result at that point
+			expected := test.out[i]
+			output := waitOut(h)
+			require.Equal(t, expected, output)
+		}
+	}
+}
+
+// neverReached is a ThresholdDetector never reporting the threshold reached
+type neverReached struct{}
+
+func (n *neverReached) Reached(*MultiSignature) bool { return false }
+
+func TestHandelLastDeclineReason(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	newHandel := func(conf *Config) *Handel {
+		conf.NewTimeoutStrategy = newInfiniteTimeout
+		return NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	check := func(h *Handel) string {
+		h.Lock()
+		h.checkFinalSignature(nil)
+		h.Unlock()
+		return h.LastDeclineReason()
+	}
+
+	h := newHandel(&Config{Contributions: 2})
+	require.Equal(t, "", h.LastDeclineReason())
+	require.Equal(t, declineBelowThreshold, check(h))
+	h.store.Store(fullIncomingSig(1))
+	require.Equal(t, "", check(h))
+	require.Equal(t, declineNoImprovement, check(h))
+	h.Stop()
+	h.store.Store(fullIncomingSig(2))
+	require.Equal(t, declineDone, check(h))
+
+	h = newHandel(&Config{Contributions: 1, ThresholdDetector: new(neverReached)})
+	defer h.Stop()
+	require.Equal(t, declineDetector, check(h))
+}
+
+func TestHandelEmptyLevel(t *testing.T) {
+	n := 6
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(4)
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true})
+	defer h.Stop()
+	// the level 2 of node 4 would be made of the nodes 6 and 7
+	require.Equal(t, []int{1, 3}, h.ids)
+	require.Error(t, h.validatePacket(&Packet{Origin: 5, Level: 2}))
+	require.NoError(t, h.validatePacket(&Packet{Origin: 5, Level: 1}))
+	require.NoError(t, h.validatePacket(&Packet{Origin: 0, Level: 3}))
+
+	// a complete packet for the empty level does not reach the processing
+	buff, err := fullSig(2).MarshalBinary()
+	require.NoError(t, err)
+	h.NewPacket(&Packet{Origin: 5, Level: 2, MultiSig: buff})
+	require.Equal(t, 0, len(h.proc.(*evaluatorProcessing).todos))
+}
+
+func TestHandelOriginMustContribute(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	for _, must := range []bool{false, true} {
+		conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, OriginMustContribute: must}
+		h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		// the level 2 of node 1 is made of the nodes 2 and 3
+		packet := func(bits ...int) *Packet {
+			bs := NewWilffBitset(2)
+			for _, b := range bits {
+				bs.Set(b, true)
+			}
+			buff, err := newSig(bs).MarshalBinary()
+			require.NoError(t, err)
+			return &Packet{Origin: 2, Level: 2, MultiSig: buff}
+		}
+		_, _, err := h.parseSignatures(packet(0, 1))
+		require.NoError(t, err)
+		_, _, err = h.parseSignatures(packet(0))
+		require.NoError(t, err)
+		// relayed signature of node 3 only
+		_, _, err = h.parseSignatures(packet(1))
+		if must {
+			require.Error(t, err)
+		} else {
+			require.NoError(t, err)
+		}
+		h.Stop()
+	}
+}
+
+func TestHandelParsePacket(t *testing.T) {
+	n := 16
+	registry := FakeRegistry(n)
+	//ids := registry.(*arrayRegistry).ids // TODO: The test runs ok even if we comment this lines
+	c := DefaultConfig(n)
+	c.DisableShuffling = true
+	h := &Handel{
+		c:           c,
+		reg:         registry,
+		cons:        new(fakeCons),
+		msg:         msg,
+		Partitioner: NewBinPartitioner(1, registry, DefaultLogger),
+	}
+	h.levels = createLevels(h.c, 1, h.Partitioner)
+	type packetTest struct {
+		*Packet
+		Error bool
+	}
+	correctSig := newSig(fullBitset(2))
+	buffMs, _ := correctSig.MarshalBinary()
+	incorrectSig := newSig(fullBitset(5))
+	invalidMsBuff, _ := incorrectSig.MarshalBinary()
+	packets := []*packetTest{
+		{
+			&Packet{
+				Origin:   65000,
+				Level:    0,
+				MultiSig: fakeConstSig,
+			}, true,
+		},
+		{
+			&Packet{
+				Origin:   3,
+				Level:    254,
+				MultiSig: fakeConstSig,
+			}, true,
+		},
+		{
+			&Packet{
+				Origin:   3,
+				Level:    1,
+				MultiSig: []byte{0x01},
+			}, true,
+		},
+		{
+			&Packet{
+				Origin:   3,
+				Level:    2,
+				MultiSig: buffMs,
+			}, false,
+		},
+		{
+			&Packet{
+				Origin:   3,
+				Level:    2,
+				MultiSig: invalidMsBuff,
+			}, true,
+		},
+	}
+	for i, test := range packets {
+		t.Logf(" -- test %d --", i)
+		err := h.validatePacket(test.Packet)
+		_, _, err2 := h.parseSignatures(test.Packet)
+		if test.Error {
+			var isErr1 = err != nil
+			var isErr2 = err2 != nil
+			require.True(t, isErr1 || isErr2)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestHandelCreateLevelsAnySize(t *testing.T) {
+	step := 1
+	if testing.Short() {
+		step = 7
+	}
+	for n := 2; n <= 2000; n += step {
+		reg := FakeRegistry(n)
+		c := DefaultConfig(n)
+		c.DisableShuffling = true
+		for _, id := range []int32{0, int32(n / 3), int32(n / 2), int32(n - 1)} {
+			part := NewBinPartitioner(id, reg, DefaultLogger)
+			lvls := createLevels(c, id, part)
+			require.Len(t, lvls, len(part.Levels())+1)
+			require.Equal(t, []Identity{reg.(*arrayRegistry).ids[id]}, lvls[0].nodes)
+			seen := make(map[int32]bool)
+			seen[id] = true
+			expected := 1
+			for _, level := range part.Levels() {
+				lvl := lvls[level]
+				require.NotEmpty(t, lvl.nodes, "n=%d id=%d level=%d", n, id, level)
+				require.Equal(t, part.Size(level), len(lvl.nodes))
+				// the signature sent at a level holds the lower levels
+				require.Equal(t, expected, lvl.sendExpectedFullSize)
+				expected += len(lvl.nodes)
+				for _, node := range lvl.nodes {
+					require.False(t, seen[node.ID()], "n=%d id=%d: %d twice", n, id, node.ID())
+					seen[node.ID()] = true
+					idx, err := part.IndexAtLevel(node.ID(), level)
+					require.NoError(t, err)
+					require.True(t, idx >= 0 && idx < len(lvl.nodes))
+				}
+			}
+			// the last level completes the registry
+			require.Equal(t, n, expected, "n=%d id=%d", n, id)
+			require.Len(t, seen, n)
+		}
+	}
+}
+
+func TestHandelInvalidPacketErrors(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	var lock sync.Mutex
+	var rejects []error
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, UpdatePeriod: time.Hour}
+	var h *Handel
+	conf.OnInvalidPacket = func(err error, p *Packet) {
+		// called without the lock
+		h.MaxDurationReached()
+		lock.Lock()
+		defer lock.Unlock()
+		rejects = append(rejects, err)
+	}
+	h = NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	valid, _ := newSig(fullBitset(2)).MarshalBinary()
+	invalidSize, _ := newSig(fullBitset(5)).MarshalBinary()
+	empty, _ := newSig(NewWilffBitset(2)).MarshalBinary()
+	level1, _ := newSig(fullBitset(1)).MarshalBinary()
+	ind, _ := (&fakeSig{true}).MarshalBinary()
+	// the multi-signature of level 2 without the contribution of node 2
+	idx, err := h.Partitioner.IndexAtLevel(3, 2)
+	require.NoError(t, err)
+	bs := NewWilffBitset(2)
+	bs.Set(idx, true)
+	without2, _ := newSig(bs).MarshalBinary()
+
+	for i, test := range []struct {
+		p              *Packet
+		mustContribute bool
+		err            error
+	}{
+		{&Packet{Origin: 3, Level: 0, MultiSig: valid}, false, ErrLevelOutOfRange},
+		{&Packet{Origin: 3, Level: 5, MultiSig: valid}, false, ErrLevelOutOfRange},
+		{&Packet{Origin: int32(n), Level: 2, MultiSig: valid}, false, ErrOriginOutOfRange},
+		{&Packet{Origin: -1, Level: 2, MultiSig: valid}, false, ErrOriginOutOfRange},
+		{&Packet{Origin: 3, Level: 2, MultiSig: []byte{0x01}}, false, ErrUnmarshal},
+		{&Packet{Origin: 3, Level: 2, MultiSig: invalidSize}, false, ErrUnmarshal},
+		{&Packet{Origin: 3, Level: 2, MultiSig: valid, IndividualSig: []byte{}}, false, ErrUnmarshal},
+		{&Packet{Origin: 3, Level: 2, MultiSig: empty}, false, ErrUnmarshal},
+		// the origin is not a node of the level of the packet
+		{&Packet{Origin: 3, Level: 1, MultiSig: level1, IndividualSig: ind}, false, ErrOriginOutOfRange},
+		{&Packet{Origin: 3, Level: 1, MultiSig: level1}, true, ErrOriginOutOfRange},
+		{&Packet{Origin: 2, Level: 2, MultiSig: without2}, true, ErrOriginNotContributing},
+	} {
+		lock.Lock()
+		rejects = nil
+		lock.Unlock()
+		h.c.OriginMustContribute = test.mustContribute
+		h.NewPacket(test.p)
+		lock.Lock()
+		require.Len(t, rejects, 1, "test %d", i)
+		require.True(t, errors.Is(rejects[0], test.err), "test %d: %s", i, rejects[0])
+		lock.Unlock()
+	}
+
+	// a valid packet is not rejected
+	lock.Lock()
+	rejects = nil
+	lock.Unlock()
+	h.NewPacket(&Packet{Origin: 3, Level: 2, MultiSig: valid})
+	lock.Lock()
+	require.Empty(t, rejects)
+	lock.Unlock()
+}
+
+func TestHandelCreateLevel(t *testing.T) {
+	n := 16
+	registry := FakeRegistry(n)
+	part := NewBinPartitioner(1, registry, DefaultLogger)
+	c := DefaultConfig(n)
+	c.DisableShuffling = true
+
+	mapping1 := createLevels(c, 1, part)
+	mapping2 := createLevels(c, 1, part)
+	require.Equal(t, mapping1, mapping2)
+
+	seed := make([]byte, 512)
+	_, err := rand.Reader.Read(seed)
+	require.NoError(t, err)
+
+	c.DisableShuffling = false
+	var r bytes.Buffer
+	r.Write(seed)
+	c.Rand = &r
+	mapping3 := createLevels(c, 1, part)
+	require.NotEqual(t, mapping3, mapping2)
+
+	var r2 bytes.Buffer
+	r2.Write(seed)
+	c.Rand = &r2
+	mapping4 := createLevels(c, 1, part)
+	require.Equal(t, mapping3, mapping4)
+
+	c = DefaultConfig(n)
+	mapping5 := createLevels(c, 1, part)
+	require.NotEqual(t, mapping5, mapping4)
+	require.NotEqual(t, mapping5, mapping1)
+}
+
+func TestHandelCreateLevelInitialState(t *testing.T) {
+	type levelTest struct {
+		n       int
+		id      int32
+		started []int
+	}
+	var tests = []levelTest{
+		// alone, there is no level to start
+		{1, 0, nil},
+		// our peer at level 1 expects our own contribution only
+		{16, 1, []int{1}},
+		// sole member of our half: levels 1 and 2 are empty
+		{5, 4, []int{3}},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d --", i)
+		part := NewBinPartitioner(test.id, FakeRegistry(test.n), DefaultLogger)
+		lvls := createLevels(DefaultConfig(test.n), test.id, part)
+		require.Len(t, lvls, len(part.Levels())+1)
+		// the level 0 holds our own contribution only
+		self := lvls[0]
+		require.Len(t, self.nodes, 1)
+		require.Equal(t, test.id, self.nodes[0].ID())
+		require.True(t, self.rcvCompleted)
+		require.False(t, self.started())
+		require.False(t, self.active())
+		var started []int
+		for _, id := range part.Levels() {
+			lvl := lvls[id]
+			require.False(t, lvl.rcvCompleted)
+			if lvl.started() {
+				started = append(started, id)
+			}
+		}
+		require.Equal(t, test.started, started)
+	}
+}
+
+func TestHandelLevelZero(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	net := new(levelNetwork)
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+
+	self := h.getLevel(0)
+	require.True(t, self.rcvCompleted)
+	h.StartLevel(0)
+	require.False(t, self.started())
+	h.sendUpdate(self, n)
+	require.Empty(t, net.levels)
+	require.Error(t, h.validatePacket(&Packet{Origin: 0, Level: 0}))
+
+	// the level 1 behaves as before
+	lvl := h.getLevel(1)
+	require.True(t, lvl.started())
+	require.False(t, lvl.rcvCompleted)
+	h.periodicUpdate()
+	require.Equal(t, []byte{1}, net.levels)
+}
+
+func TestHandelMinImprovementToResend(t *testing.T) {
+	n := 16
+	registry := FakeRegistry(n)
+	part := NewBinPartitioner(1, registry, DefaultLogger)
+	c := DefaultConfig(n)
+	c.MinImprovementToResend = 3
+	lvl := createLevels(c, 1, part)[4]
+	require.Equal(t, 8, lvl.sendExpectedFullSize)
+
+	sigOf := func(ids ...int) *MultiSignature {
+		bs := NewWilffBitset(8)
+		for _, id := range ids {
+			bs.Set(id, true)
+		}
+		return &MultiSignature{BitSet: bs, Signature: &fakeSig{true}}
+	}
+
+	// first signature to send: not enough improvement from scratch
+	require.False(t, lvl.updateSigToSend(sigOf(0, 1)))
+	require.Equal(t, 0, lvl.sendSigSize)
+	require.False(t, lvl.updateSigToSend(sigOf(0, 1, 2)))
+	require.Equal(t, 3, lvl.sendSigSize)
+
+	// all peers have been contacted with the current signature
+	lvl.setStarted()
+	lvl.selectNextPeers(len(lvl.nodes), 0)
+	require.False(t, lvl.active())
+
+	// +1 does not trigger a resend
+	require.False(t, lvl.updateSigToSend(sigOf(0, 1, 2, 3)))
+	require.Equal(t, 3, lvl.sendSigSize)
+	require.False(t, lvl.active())
+
+	// +3 does
+	require.False(t, lvl.updateSigToSend(sigOf(0, 1, 2, 3, 4, 5)))
+	require.Equal(t, 6, lvl.sendSigSize)
+	require.True(t, lvl.active())
+
+	// a complete signature is always sent
+	lvl.selectNextPeers(len(lvl.nodes), 0)
+	require.True(t, lvl.updateSigToSend(sigOf(0, 1, 2, 3, 4, 5, 6, 7)))
+	require.True(t, lvl.active())
+}
+
+func TestHandelOffsetStartPosition(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	c := DefaultConfig(n)
+	c.DisableShuffling = true
+	first := func(id int32) int32 {
+		lvls := createLevels(c, id, NewBinPartitioner(id, reg, DefaultLogger))
+		peers, _ := lvls[3].selectNextPeers(1, 0)
+		return peers[0].ID()
+	}
+	// nodes 0 to 3 share the peers 4 to 7 at level 3
+	require.Equal(t, first(0), first(1))
+	c.OffsetStartPosition = true
+	starts := make(map[int32]bool)
+	for id := int32(0); id < 4; id++ {
+		starts[first(id)] = true
+	}
+	require.Len(t, starts, 4)
+}
+
+// mapScorer scores the peers by ID, the missing ones scoring zero
+type mapScorer map[int32]float64
+
+func (m mapScorer) Score(id Identity, level int) float64 { return m[id.ID()] }
+
+func TestHandelPeerScorer(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	c := DefaultConfig(n)
+	c.DisableShuffling = true
+	scores := mapScorer{5: 1, 7: 2}
+	c.PeerScorer = scores
+	lvl := createLevels(c, 1, NewBinPartitioner(1, reg, DefaultLogger))[3]
+	order := func(count int) []int32 {
+		var ids []int32
+		for i := 0; i < count; i++ {
+			peers, _ := lvl.selectNextPeers(1, 0)
+			for _, p := range peers {
+				ids = append(ids, p.ID())
+			}
+		}
+		return ids
+	}
+
+	// the peers of equal score are contacted in their rolling order
+	require.Equal(t, []int32{7, 5, 4, 6}, order(4))
+	// the order is only changed at the beginning of a pass
+	scores[4] = 3
+	require.Equal(t, []int32{7, 5}, order(2))
+	lvl.updateSigToSend(fullSig(3))
+	require.Equal(t, []int32{4, 7, 5, 6}, order(4))
+
+	// a constant score keeps the round robin
+	c.PeerScorer = mapScorer{}
+	lvl = createLevels(c, 1, NewBinPartitioner(1, reg, DefaultLogger))[3]
+	lvl.sendPos = 2
+	require.Equal(t, []int32{6, 7, 4, 5}, order(4))
+}
+
+func TestHandelFinishedLevel(t *testing.T) {
+	n := 4
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	net := new(levelNetwork)
+	conf := &Config{
+		NewTimeoutStrategy:     newInfiniteTimeout,
+		UpdatePeriod:           time.Hour,
+		SelfPropagationRetries: 10,
+	}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	h.Lock()
+	for _, s := range []*incomingSig{fullIncomingSig(1), fullIncomingSig(2)} {
+		h.store.Store(s)
+		h.checkCompletedLevel(s)
+	}
+	h.Unlock()
+	// the peers of the level 1 are contacted once
+	for i := 0; i < 3; i++ {
+		h.periodicUpdate()
+	}
+	net.Lock()
+	sent := len(net.levels)
+	net.Unlock()
+	require.NotZero(t, sent)
+	h.Lock()
+	for _, lvl := range h.levels {
+		require.True(t, lvl.id == 0 || lvl.finished())
+	}
+	h.Unlock()
+
+	// completed and finished, the levels are not sent again, even to
+	// retransmit our own contribution
+	for i := 0; i < 10; i++ {
+		h.periodicUpdate()
+	}
+	net.Lock()
+	require.Equal(t, sent, len(net.levels))
+	net.Unlock()
+}
+
+func TestHandelUpdateBackoff(t *testing.T) {
+	reg := FakeRegistry(8)
+	c := DefaultConfig(8)
+	c.MaxUpdateBackoff = 4
+	lvl := createLevels(c, 1, NewBinPartitioner(1, reg, DefaultLogger))[2]
+	lvl.setStarted()
+	due := func(from, to int) []int {
+		var ticks []int
+		for tick := from; tick <= to; tick++ {
+			if lvl.due(tick) {
+				ticks = append(ticks, tick)
+			}
+		}
+		return ticks
+	}
+	// the interval doubles up to the maximum
+	require.Equal(t, []int{1, 2, 4, 8, 12, 16, 20}, due(1, 20))
+	// and is reset once the signature improves
+	require.True(t, lvl.updateSigToSend(fullSig(2)))
+	require.Equal(t, []int{21, 22, 24}, due(21, 25))
+
+	// without backoff, the level is always sent
+	c.MaxUpdateBackoff = 0
+	lvl = createLevels(c, 1, NewBinPartitioner(1, reg, DefaultLogger))[2]
+	require.Equal(t, []int{1, 2, 3, 4, 5}, due(1, 5))
+}
+
+func TestHandelMaxUselessSends(t *testing.T) {
+	reg := FakeRegistry(4)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	c := DefaultConfig(4)
+	c.DisableShuffling = true
+	c.MaxUselessSends = 2
+	c.UselessSendsCooldown = 5
+	lvl := createLevels(c, 1, part)[2]
+	lvl.setStarted()
+	pick := func(tick int) []int32 {
+		lvl.sendPeersCt = 0
+		peers, _ := lvl.selectNextPeers(1, tick)
+		var ids []int32
+		for _, p := range peers {
+			ids = append(ids, p.ID())
+		}
+		return ids
+	}
+
+	// 3 keeps responding, 2 never does
+	require.Equal(t, []int32{2}, pick(0))
+	require.Equal(t, []int32{3}, pick(0))
+	lvl.responded(3)
+	require.Equal(t, []int32{2}, pick(1))
+	require.Equal(t, []int32{3}, pick(1))
+	lvl.responded(3)
+	// 2 is skipped after two useless sends, and counted as contacted
+	lvl.sendPeersCt = 0
+	peers, _ := lvl.selectNextPeers(2, 2)
+	require.Len(t, peers, 1)
+	require.Equal(t, int32(3), peers[0].ID())
+	require.False(t, lvl.active())
+	lvl.responded(3)
+	require.Equal(t, []int32{3}, pick(6))
+	lvl.responded(3)
+	// 2 is contacted again after the cooldown
+	require.Equal(t, []int32{2}, pick(7))
+	require.Equal(t, []int32{3}, pick(7))
+	lvl.responded(3)
+
+	// a response resets the count of useless sends
+	require.Equal(t, []int32{2}, pick(7))
+	lvl.responded(2)
+	require.Equal(t, []int32{3}, pick(8))
+	lvl.responded(3)
+	require.Equal(t, []int32{2}, pick(8))
+}
+
+func TestHandelShuffleCandidates(t *testing.T) {
+	n := 16
+	registry := FakeRegistry(n)
+	c := DefaultConfig(n)
+	c.ShuffleCandidates = true
+	createFor := func(id int32) map[int]*level {
+		return createLevels(c, id, NewBinPartitioner(id, registry, DefaultLogger))
+	}
+
+	// deterministic for a given node and level
+	require.Equal(t, createFor(1), createFor(1))
+	// nodes 0 and 1 share the same peers at the last level, in a different
+	// order
+	lvl0 := createFor(0)[4]
+	lvl1 := createFor(1)[4]
+	require.ElementsMatch(t, lvl0.nodes, lvl1.nodes)
+	require.NotEqual(t, lvl0.nodes, lvl1.nodes)
+	// different levels use different seeds
+	require.NotEqual(t, levelSeed(1, 3), levelSeed(1, 4))
+}
+
+type infiniteTimeout struct {
+}
+
+// newInfiniteTimeout creates an InfiniteTimeout. Needs this signature
+func newInfiniteTimeout(h *Handel, lvls []int) TimeoutStrategy {
+	return &infiniteTimeout{}
+}
+
+// Start implements the interface
+func (l *infiniteTimeout) Start() {}
+
+// Stop implements the interface
+func (l *infiniteTimeout) Stop() {}
+
+func TestHandelMetrics(t *testing.T) {
+	n := 32
+	reg := FakeRegistry(n).(*arrayRegistry)
+	for _, policy := range []struct {
+		name   string
+		window WindowPolicy
+	}{
+		{"fixed", nil},
+		{"exponential", NewExponentialWindow(1, 20*time.Millisecond)},
+	} {
+		nets := make([]Network, n)
+		for i := range nets {
+			nets[i] = &TestNetwork{int32(i), nets, nil}
+		}
+		conf := &Config{Contributions: n, Window: policy.window}
+		handels := make([]*Handel, n)
+		for i := range handels {
+			handels[i] = NewHandel(nets[i], reg, reg.ids[i], new(fakeCons), msg, &fakeSig{true}, conf)
+		}
+		for _, h := range handels {
+			h.Start()
+		}
+		for _, h := range handels {
+			// polled concurrently
+			require.True(t, h.Metrics().SigVerified >= 0)
+			select {
+			case <-h.FinalSignatures():
+			case <-time.After(5 * time.Second):
+				t.Fatal("no final signature")
+			}
+		}
+		CloseHandels(handels)
+
+		var total Metrics
+		for _, h := range handels {
+			m := h.Metrics()
+			require.True(t, m.PacketsSent > 0)
+			require.True(t, m.SigVerified > 0)
+			require.True(t, m.BytesSent >= m.PacketsSent)
+			total.PacketsSent += m.PacketsSent
+			total.PacketsReceived += m.PacketsReceived
+			total.BytesSent += m.BytesSent
+			total.SigDropped += m.SigDropped
+		}
+		// a packet is counted as sent before being received
+		require.True(t, total.PacketsReceived <= total.PacketsSent)
+		t.Logf("%s window: %d packets and %d bytes sent per node, %d signatures dropped",
+			policy.name, total.PacketsSent/int64(n), total.BytesSent/int64(n), total.SigDropped)
+	}
+}
@@ -0,0 +1,122 @@
+// Package handelprom exports the state of a Handel node as Prometheus metrics.
+// It lives in its own package so the core of Handel does not depend on
+// Prometheus.
+package handelprom
+
+import (
+	"strconv"
+
+	"github.com/ConsenSys/handel"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	levelCardinality = prometheus.NewDesc(
+		"handel_level_cardinality",
+		"Number of contributions of the best signature received at a level.",
+		[]string{"level"}, nil)
+	levelCompleted = prometheus.NewDesc(
+		"handel_level_completed",
+		"1 if all the signatures of a level have been received, 0 otherwise.",
+		[]string{"level"}, nil)
+	levelSent = prometheus.NewDesc(
+		"handel_level_sent",
+		"Number of peers of a level contacted with the current signature.",
+		[]string{"level"}, nil)
+	bestCardinality = prometheus.NewDesc(
+		"handel_best_cardinality",
+		"Number of contributions of the full multi-signature.",
+		nil, nil)
+	packetsSent = prometheus.NewDesc(
+		"handel_packets_sent_total",
+		"Number of packets sent.",
+		nil, nil)
+	packetsReceived = prometheus.NewDesc(
+		"handel_packets_received_total",
+		"Number of packets received.",
+		nil, nil)
+	packetsOutOfWindow = prometheus.NewDesc(
+		"handel_packets_out_of_window_total",
+		"Number of packets dropped because their level is out of the accepted window.",
+		nil, nil)
+	packetsDuplicate = prometheus.NewDesc(
+		"handel_packets_duplicate_total",
+		"Number of packets dropped because they are identical to a packet received within the last update period.",
+		nil, nil)
+	signaturesSkipped = prometheus.NewDesc(
+		"handel_signatures_skipped_total",
+		"Number of signatures dropped before verification because they do not improve the best signature of their level.",
+		nil, nil)
+	signaturesVerified = prometheus.NewDesc(
+		"handel_signatures_verified_total",
+		"Number of signatures verified.",
+		nil, nil)
+	bytesSent = prometheus.NewDesc(
+		"handel_bytes_sent_total",
+		"Number of bytes of the signatures and authenticators of the packets sent.",
+		nil, nil)
+	currentLevel = prometheus.NewDesc(
+		"handel_current_level",
+		"Highest level Handel sends its signature to.",
+		nil, nil)
+)
+
+// collector samples the Stats and the Metrics of a Handel node at each
+// scrape.
+type collector struct {
+	h *handel.Handel
+}
+
+// Register registers with reg the metrics of the given Handel node. The
+// metrics are sampled from Handel.Stats and Handel.Metrics each time they are
+// collected. A handel_best_cardinality not increasing while
+// handel_current_level stays below the highest level reveals a stalled
+// aggregation.
+func Register(h *handel.Handel, reg prometheus.Registerer) error {
+	return reg.Register(&collector{h})
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- levelCardinality
+	ch <- levelCompleted
+	ch <- levelSent
+	ch <- bestCardinality
+	ch <- packetsSent
+	ch <- packetsReceived
+	ch <- packetsOutOfWindow
+	ch <- packetsDuplicate
+	ch <- signaturesSkipped
+	ch <- signaturesVerified
+	ch <- bytesSent
+	ch <- currentLevel
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.h.Stats()
+	m := c.h.Metrics()
+	var current int
+	for _, l := range s.Levels {
+		if l.Started {
+			current = l.Level
+		}
+		lvl := strconv.Itoa(l.Level)
+		var completed float64
+		if l.Completed {
+			completed = 1
+		}
+		ch <- prometheus.MustNewConstMetric(levelCardinality, prometheus.GaugeValue, float64(l.Cardinality), lvl)
+		ch <- prometheus.MustNewConstMetric(levelCompleted, prometheus.GaugeValue, completed, lvl)
+		ch <- prometheus.MustNewConstMetric(levelSent, prometheus.GaugeValue, float64(l.Sent), lvl)
+	}
+	ch <- prometheus.MustNewConstMetric(bestCardinality, prometheus.GaugeValue, float64(s.BestCardinality))
+	ch <- prometheus.MustNewConstMetric(packetsSent, prometheus.CounterValue, float64(s.MsgSent))
+	ch <- prometheus.MustNewConstMetric(packetsReceived, prometheus.CounterValue, float64(s.MsgRcv))
+	ch <- prometheus.MustNewConstMetric(packetsOutOfWindow, prometheus.CounterValue, float64(s.MsgOutOfWindow))
+	ch <- prometheus.MustNewConstMetric(packetsDuplicate, prometheus.CounterValue, float64(s.MsgDuplicate))
+	ch <- prometheus.MustNewConstMetric(signaturesSkipped, prometheus.CounterValue, float64(s.SigSkipped))
+	ch <- prometheus.MustNewConstMetric(signaturesVerified, prometheus.CounterValue, float64(m.SigVerified))
+	ch <- prometheus.MustNewConstMetric(bytesSent, prometheus.CounterValue, float64(m.BytesSent))
+	ch <- prometheus.MustNewConstMetric(currentLevel, prometheus.GaugeValue, float64(current))
+}
@@ -0,0 +1,73 @@
+package handelprom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSig struct{}
+
+func (f *fakeSig) MarshalBinary() ([]byte, error)            { return []byte{1}, nil }
+func (f *fakeSig) UnmarshalBinary([]byte) error              { return nil }
+func (f *fakeSig) Combine(handel.Signature) handel.Signature { return f }
+
+type fakePublic struct{}
+
+func (f *fakePublic) VerifySignature([]byte, handel.Signature) error { return errors.New("unused") }
+func (f *fakePublic) Combine(handel.PublicKey) handel.PublicKey      { return f }
+func (f *fakePublic) String() string                                 { return "fake" }
+
+type fakeCons struct{}
+
+func (f *fakeCons) Signature() handel.Signature { return new(fakeSig) }
+func (f *fakeCons) PublicKey() handel.PublicKey { return new(fakePublic) }
+
+type nopNetwork struct{}
+
+func (n *nopNetwork) RegisterListener(handel.Listener)       {}
+func (n *nopNetwork) Send([]handel.Identity, *handel.Packet) {}
+
+func TestRegister(t *testing.T) {
+	n := 8
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(int32(i), "", new(fakePublic))
+	}
+	reg := handel.NewArrayRegistry(ids)
+	h := handel.NewHandel(new(nopNetwork), reg, ids[1], new(fakeCons), []byte("hello"), new(fakeSig))
+
+	promReg := prometheus.NewRegistry()
+	require.NoError(t, Register(h, promReg))
+	families, err := promReg.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string][]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			if m.GetGauge() != nil {
+				values[f.GetName()] = append(values[f.GetName()], m.GetGauge().GetValue())
+			} else {
+				values[f.GetName()] = append(values[f.GetName()], m.GetCounter().GetValue())
+			}
+		}
+	}
+	// one metric per level
+	require.Len(t, values["handel_level_cardinality"], 3)
+	require.Len(t, values["handel_level_completed"], 3)
+	require.Len(t, values["handel_level_sent"], 3)
+	// only our own contribution so far
+	require.Equal(t, []float64{1}, values["handel_best_cardinality"])
+	require.Equal(t, []float64{0}, values["handel_packets_sent_total"])
+	require.Equal(t, []float64{0}, values["handel_packets_received_total"])
+	require.Equal(t, []float64{0}, values["handel_packets_out_of_window_total"])
+	require.Equal(t, []float64{0}, values["handel_packets_duplicate_total"])
+	require.Equal(t, []float64{0}, values["handel_signatures_skipped_total"])
+	require.Equal(t, []float64{0}, values["handel_signatures_verified_total"])
+	require.Equal(t, []float64{0}, values["handel_bytes_sent_total"])
+	// the first level starts right away
+	require.Equal(t, []float64{1}, values["handel_current_level"])
+}
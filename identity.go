@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	mathRand "math/rand"
+	"sort"
+	"sync"
+	"time"
 )
 
 // Identity holds the public information of a Handel node
@@ -65,6 +68,19 @@ func (s *fixedIdentity) String() string {
 	return fmt.Sprintf("{id: %d - %s}", s.id, s.addr)
 }
 
+// CanonicalOrder returns a copy of the identities sorted in the order the
+// partitioners expect them in a Registry, i.e. by ascending ID, the identity
+// at index i having the ID i when the IDs are continuous. All the nodes must
+// use the same order for their levels to be consistent.
+func CanonicalOrder(ids []Identity) []Identity {
+	sorted := make([]Identity, len(ids))
+	copy(sorted, ids)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ID() < sorted[j].ID()
+	})
+	return sorted
+}
+
 // arrayRegistry is a Registry that uses a fixed size array as backend
 type arrayRegistry struct {
 	ids []Identity
@@ -110,6 +126,93 @@ func (a *arrayRegistry) String() string {
 	return s
 }
 
+// memberIdentity is an Identity of a member of a sub-committee, with an ID
+// relative to the sub-committee.
+type memberIdentity struct {
+	Identity
+	id int32
+}
+
+func (m *memberIdentity) ID() int32 {
+	return m.id
+}
+
+// newMemberRegistry returns the registry of the identities accepted by the
+// filter, with continuous IDs, and the mapping from the original IDs of the
+// members to their new IDs.
+func newMemberRegistry(r Registry, filter func(Identity) bool) (Registry, map[int32]int32) {
+	var ids []Identity
+	members := make(map[int32]int32)
+	for i := 0; i < r.Size(); i++ {
+		id, ok := r.Identity(i)
+		if !ok || !filter(id) {
+			continue
+		}
+		members[id.ID()] = int32(len(ids))
+		ids = append(ids, &memberIdentity{id, int32(len(ids))})
+	}
+	return NewArrayRegistry(ids), members
+}
+
+// globalID returns the ID of the identity in the original registry.
+func globalID(id Identity) int32 {
+	if m, ok := id.(*memberIdentity); ok {
+		return m.Identity.ID()
+	}
+	return id.ID()
+}
+
+// globalIdentities returns the identities as given by the original registry.
+func globalIdentities(ids []Identity) []Identity {
+	res := make([]Identity, len(ids))
+	for i, id := range ids {
+		if m, ok := id.(*memberIdentity); ok {
+			id = m.Identity
+		}
+		res[i] = id
+	}
+	return res
+}
+
+// LivenessRegistry is a Registry that keeps track of the last time each
+// identity has been seen contributing. Handel marks the origin of each
+// verified signature as seen when given a LivenessRegistry.
+type LivenessRegistry interface {
+	Registry
+	// MarkSeen records that the identity with the given ID has been seen now.
+	MarkSeen(id int32)
+	// LastSeen returns the last time the identity with the given ID has been
+	// seen, or the zero time if it never has been.
+	LastSeen(id int32) time.Time
+}
+
+// livenessRegistry decorates a Registry with the liveness information.
+type livenessRegistry struct {
+	Registry
+	sync.Mutex
+	seen map[int32]time.Time
+}
+
+// NewLivenessRegistry returns a LivenessRegistry wrapping the given registry.
+func NewLivenessRegistry(inner Registry) LivenessRegistry {
+	return &livenessRegistry{
+		Registry: inner,
+		seen:     make(map[int32]time.Time),
+	}
+}
+
+func (l *livenessRegistry) MarkSeen(id int32) {
+	l.Lock()
+	defer l.Unlock()
+	l.seen[id] = time.Now()
+}
+
+func (l *livenessRegistry) LastSeen(id int32) time.Time {
+	l.Lock()
+	defer l.Unlock()
+	return l.seen[id]
+}
+
 // shuffles the given array using the given source of randomness. The shuffle is
 // NOT a cryptographic shuffle, it uses the math package (i.e. most probably
 // fisher-yates method).
@@ -1,7 +1,9 @@
 package handel
 
 import (
+	mathRand "math/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -54,3 +56,58 @@ func testRegistryTests(t *testing.T, tests []registryTest) {
 		}
 	}
 }
+
+func TestRegistryLiveness(t *testing.T) {
+	n := 4
+	inner := FakeRegistry(n)
+	reg := NewLivenessRegistry(inner)
+	require.Equal(t, n, reg.Size())
+	id, ok := reg.Identity(2)
+	require.True(t, ok)
+	require.Equal(t, int32(2), id.ID())
+
+	require.True(t, reg.LastSeen(2).IsZero())
+	before := time.Now()
+	reg.MarkSeen(2)
+	require.False(t, reg.LastSeen(2).Before(before))
+	require.True(t, reg.LastSeen(1).IsZero())
+}
+
+func TestCanonicalOrder(t *testing.T) {
+	n := 13
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", nil)
+	}
+	// two nodes getting the identities in different orders
+	ids1 := make([]Identity, n)
+	ids2 := make([]Identity, n)
+	for i, j := range mathRand.Perm(n) {
+		ids1[i] = ids[j]
+	}
+	for i := range ids {
+		ids2[i] = ids[n-1-i]
+	}
+	canon1 := CanonicalOrder(ids1)
+	canon2 := CanonicalOrder(ids2)
+	require.Equal(t, ids, canon1)
+	require.Equal(t, ids, canon2)
+	// the inputs are not modified
+	require.Equal(t, ids[n-1], ids2[0])
+
+	for id := int32(0); id < int32(n); id++ {
+		part1 := NewBinPartitioner(id, NewArrayRegistry(canon1), DefaultLogger)
+		part2 := NewBinPartitioner(id, NewArrayRegistry(canon2), DefaultLogger)
+		require.Equal(t, part1.Levels(), part2.Levels())
+		for _, lvl := range part1.Levels() {
+			ids1, err := part1.IdentitiesAt(lvl)
+			require.NoError(t, err)
+			ids2, err := part2.IdentitiesAt(lvl)
+			require.NoError(t, err)
+			require.Equal(t, ids1, ids2)
+			for _, i := range ids1 {
+				require.NotEqual(t, id, i.ID())
+			}
+		}
+	}
+}
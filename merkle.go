@@ -0,0 +1,152 @@
+package handel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ContributorProof proves that an identity contributed to a multi-signature,
+// given the root returned by MultiSignature.ContributorRoot, without the
+// bitset of the multi-signature. The leaves of the Merkle tree are the IDs of
+// the contributors in ascending order; a node without sibling is promoted
+// as is to the level above.
+type ContributorProof struct {
+	// ID of the contributor
+	ID int32
+	// Index of the contributor among the sorted contributors
+	Index int
+	// Count is the number of contributors
+	Count int
+	// Path holds the hashes of the siblings from the leaf up to the root,
+	// skipping the levels where the node has no sibling
+	Path [][]byte
+}
+
+// ContributorRoot returns the root of the Merkle tree over the sorted IDs of
+// the contributors of the full multi-signature, whose bitset is indexed like
+// the registry. It returns an error if the bitset is not as long as the
+// registry or if there is no contributor.
+func (m *MultiSignature) ContributorRoot(reg Registry) ([]byte, error) {
+	tree, _, err := m.contributorTree(reg)
+	if err != nil {
+		return nil, err
+	}
+	return tree[len(tree)-1][0], nil
+}
+
+// ContributorProof returns the proof that the given identity contributed to
+// the full multi-signature, to verify with VerifyContributorProof against
+// the root returned by ContributorRoot. It returns an error if the identity
+// did not contribute.
+func (m *MultiSignature) ContributorProof(reg Registry, id int32) (*ContributorProof, error) {
+	tree, ids, err := m.contributorTree(reg)
+	if err != nil {
+		return nil, err
+	}
+	index := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	if index == len(ids) || ids[index] != id {
+		return nil, fmt.Errorf("handel: %d is not a contributor", id)
+	}
+	proof := &ContributorProof{ID: id, Index: index, Count: len(ids)}
+	pos := index
+	for _, nodes := range tree[:len(tree)-1] {
+		if sibling := pos ^ 1; sibling < len(nodes) {
+			proof.Path = append(proof.Path, nodes[sibling])
+		}
+		pos /= 2
+	}
+	return proof, nil
+}
+
+// VerifyContributorProof returns nil if the proof shows that its identity is
+// a contributor of the multi-signature whose contributors have the given root.
+func VerifyContributorProof(root []byte, p *ContributorProof) error {
+	if p.Index < 0 || p.Index >= p.Count {
+		return errors.New("handel: contributor index out of bounds")
+	}
+	hash := leafHash(p.ID)
+	path := p.Path
+	for pos, count := p.Index, p.Count; count > 1; pos, count = pos/2, (count+1)/2 {
+		sibling := pos ^ 1
+		if sibling >= count {
+			// promoted node
+			continue
+		}
+		if len(path) == 0 {
+			return errors.New("handel: contributor proof too short")
+		}
+		if pos < sibling {
+			hash = nodeHash(hash, path[0])
+		} else {
+			hash = nodeHash(path[0], hash)
+		}
+		path = path[1:]
+	}
+	if len(path) != 0 {
+		return errors.New("handel: contributor proof too long")
+	}
+	if !bytes.Equal(hash, root) {
+		return errors.New("handel: invalid contributor proof")
+	}
+	return nil
+}
+
+// contributorTree returns the levels of the Merkle tree over the sorted IDs of
+// the contributors, leaves first, and the sorted IDs.
+func (m *MultiSignature) contributorTree(reg Registry) ([][][]byte, []int32, error) {
+	if m.BitSet.BitLength() != reg.Size() {
+		return nil, nil, errors.New("handel: contributors of a signature not indexed like the registry")
+	}
+	var ids []int32
+	for i, ok := m.BitSet.NextSet(0); ok; i, ok = m.BitSet.NextSet(i + 1) {
+		id, exists := reg.Identity(i)
+		if !exists {
+			return nil, nil, fmt.Errorf("handel: no identity at %d", i)
+		}
+		ids = append(ids, id.ID())
+	}
+	if len(ids) == 0 {
+		return nil, nil, errors.New("handel: no contributor")
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	nodes := make([][]byte, len(ids))
+	for i, id := range ids {
+		nodes[i] = leafHash(id)
+	}
+	tree := [][][]byte{nodes}
+	for len(nodes) > 1 {
+		var up [][]byte
+		for i := 0; i < len(nodes); i += 2 {
+			if i+1 == len(nodes) {
+				up = append(up, nodes[i])
+				continue
+			}
+			up = append(up, nodeHash(nodes[i], nodes[i+1]))
+		}
+		tree = append(tree, up)
+		nodes = up
+	}
+	return tree, ids, nil
+}
+
+// leafHash and nodeHash are domain separated so a node can not be passed off
+// as a leaf.
+func leafHash(id int32) []byte {
+	var buff [5]byte
+	binary.BigEndian.PutUint32(buff[1:], uint32(id))
+	h := sha256.Sum256(buff[:])
+	return h[:]
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
@@ -0,0 +1,60 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContributorProof(t *testing.T) {
+	n := 13
+	reg := FakeRegistry(n)
+	sigOf := func(indexes ...int) *MultiSignature {
+		bs := NewWilffBitset(n)
+		for _, i := range indexes {
+			bs.Set(i, true)
+		}
+		return &MultiSignature{BitSet: bs, Signature: &fakeSig{true}}
+	}
+
+	for _, contributors := range [][]int{
+		{4},
+		{0, 1},
+		{0, 2, 3, 5, 8, 9, 12},
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	} {
+		ms := sigOf(contributors...)
+		root, err := ms.ContributorRoot(reg)
+		require.NoError(t, err)
+		for _, i := range contributors {
+			proof, err := ms.ContributorProof(reg, int32(i))
+			require.NoError(t, err)
+			require.Equal(t, len(contributors), proof.Count)
+			require.NoError(t, VerifyContributorProof(root, proof))
+
+			// the proof is bound to its identity and root
+			forged := *proof
+			forged.ID = int32((i + 1) % n)
+			require.Error(t, VerifyContributorProof(root, &forged))
+			if !ms.Get(12) {
+				other, err := sigOf(append(contributors, 12)...).ContributorRoot(reg)
+				require.NoError(t, err)
+				require.Error(t, VerifyContributorProof(other, proof))
+			}
+		}
+	}
+
+	ms := sigOf(0, 2, 3)
+	_, err := ms.ContributorProof(reg, 1)
+	require.Error(t, err)
+	proof, err := ms.ContributorProof(reg, 2)
+	require.NoError(t, err)
+	root, _ := ms.ContributorRoot(reg)
+	proof.Path = proof.Path[:1]
+	require.Error(t, VerifyContributorProof(root, proof))
+
+	_, err = sigOf().ContributorRoot(reg)
+	require.Error(t, err)
+	_, err = sigOf(1).ContributorRoot(FakeRegistry(n + 1))
+	require.Error(t, err)
+}
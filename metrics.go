@@ -0,0 +1,64 @@
+package handel
+
+import "sync/atomic"
+
+// Metrics is a snapshot of the counters of the cost of the protocol for a
+// node, see Handel.Metrics. A packet sent to several peers is counted once
+// per peer, as is each chunk of a packet split with Config.MaxChunkSize.
+type Metrics struct {
+	// PacketsSent is the number of packets handed to the network
+	PacketsSent int64
+	// PacketsReceived is the number of packets received from the network,
+	// valid or not
+	PacketsReceived int64
+	// PacketsDuplicate is the number of packets dropped as identical to a
+	// packet recently received, see Config.DedupCacheSize
+	PacketsDuplicate int64
+	// SigVerified is the number of signatures verified
+	SigVerified int64
+	// SigDropped is the number of multi-signatures dropped before their
+	// verification as adding no contribution to the best signature of their
+	// level
+	SigDropped int64
+	// BytesSent is the number of bytes of the signatures and authenticators
+	// of the packets sent, without the other fields of the packets nor the
+	// overhead of the network encoding
+	BytesSent int64
+}
+
+// metrics holds the counters of Metrics. They are updated atomically, so they
+// are read without taking Handel's lock.
+type metrics struct {
+	packetsSent      int64
+	packetsReceived  int64
+	packetsDuplicate int64
+	sigVerified      int64
+	sigDropped       int64
+	bytesSent        int64
+}
+
+// sent counts the packet sent to the given number of peers.
+func (m *metrics) sent(p *Packet, peers int) {
+	atomic.AddInt64(&m.packetsSent, int64(peers))
+	size := len(p.MultiSig) + len(p.IndividualSig) + len(p.Auth)
+	atomic.AddInt64(&m.bytesSent, int64(peers*size))
+}
+
+func (m *metrics) snapshot() Metrics {
+	return Metrics{
+		PacketsSent:      atomic.LoadInt64(&m.packetsSent),
+		PacketsReceived:  atomic.LoadInt64(&m.packetsReceived),
+		PacketsDuplicate: atomic.LoadInt64(&m.packetsDuplicate),
+		SigVerified:      atomic.LoadInt64(&m.sigVerified),
+		SigDropped:       atomic.LoadInt64(&m.sigDropped),
+		BytesSent:        atomic.LoadInt64(&m.bytesSent),
+	}
+}
+
+// Metrics returns a snapshot of the counters of the cost of the protocol. It
+// does not take Handel's lock, so it can be polled frequently, at any time.
+// Unlike Stats, the counters are not updated together, so a snapshot taken
+// while Handel runs may count a packet as sent before counting its bytes.
+func (h *Handel) Metrics() Metrics {
+	return h.metrics.snapshot()
+}
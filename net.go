@@ -1,5 +1,12 @@
 package handel
 
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
 // Network is the interface that must be given to Handel to communicate with
 // other Handel instances. A Network implementation does not need to provide any
 // transport layer guarantees (such as delivery or in-order).
@@ -28,10 +35,14 @@ func (l ListenFunc) NewPacket(p *Packet) {
 }
 
 // Packet is the general packet that Handel sends out and expects to receive
-// from the Network. Handel do not provide any authentication nor
-// confidentiality on Packets, it is up to the application layer to add these
-// features if relevant.
+// from the Network. Handel do not provide any confidentiality on Packets, and
+// only authenticates them with Config.PacketAuth, it is up to the application
+// layer to add these features if relevant.
 type Packet struct {
+	// Version is the version of the encoding of the packet and its
+	// signatures, see PacketVersion. Zero is read as the version 1, the
+	// version of the packets of the nodes predating the versioning.
+	Version byte
 	// Origin is the ID of the sender of this packet.
 	Origin int32
 	// Level indicates for which level this packet is for in the Handel tree.
@@ -41,4 +52,104 @@ type Packet struct {
 	MultiSig []byte
 	// IndividualSig holds the individual signature of the Origin node
 	IndividualSig []byte
+	// ChunkIndex is the index of this packet among the chunks of a packet
+	// whose multi-signature has been split, see Config.MaxChunkSize.
+	ChunkIndex uint16
+	// ChunkCount is the number of chunks of the packet. Zero or one means the
+	// packet is not split.
+	ChunkCount uint16
+	// ChunkSeq numbers the packets split by their Origin, so the chunks of
+	// different packets are not mixed when reassembled. It is only set on
+	// the chunks.
+	ChunkSeq uint32
+	// Auth authenticates the packet as sent by its Origin, see
+	// Config.PacketAuth. It is carried by the first chunk of a split packet.
+	Auth []byte
+	// Session identifies the message the packet aggregates the signatures
+	// of, when the Handel instances are run by a SessionManager. It is empty
+	// otherwise.
+	Session []byte
+}
+
+// PacketVersion is the version of the packets sent by this code. A packet of
+// another version is rejected, since its signatures may not be decoded
+// correctly.
+const PacketVersion = 1
+
+// checkVersion returns an error if the packet is of another version than
+// PacketVersion.
+func (p *Packet) checkVersion() error {
+	if p.Version != 0 && p.Version != PacketVersion {
+		return fmt.Errorf("unsupported packet version %d, running version %d", p.Version, PacketVersion)
+	}
+	return nil
+}
+
+// MarshalBinary implements the go Marshaler interface. It encodes the version
+// first, so a node can reject the packets of a version it does not know
+// before decoding the rest. The gob encoding of the network package does not
+// use it, to keep the gob encoding of the nodes predating the version.
+func (p *Packet) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte(p.Version)
+	binary.Write(&b, binary.BigEndian, p.Origin)
+	b.WriteByte(p.Level)
+	binary.Write(&b, binary.BigEndian, p.ChunkIndex)
+	binary.Write(&b, binary.BigEndian, p.ChunkCount)
+	binary.Write(&b, binary.BigEndian, p.ChunkSeq)
+	for _, field := range [][]byte{p.MultiSig, p.IndividualSig, p.Auth, p.Session} {
+		var length [binary.MaxVarintLen64]byte
+		b.Write(length[:binary.PutUvarint(length[:], uint64(len(field)))])
+		b.Write(field)
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary implements the go BinaryUnmarshaler interface. It returns an
+// error if the packet is of another version than PacketVersion.
+func (p *Packet) UnmarshalBinary(buff []byte) error {
+	if len(buff) == 0 {
+		return errors.New("empty packet")
+	}
+	var np Packet
+	np.Version = buff[0]
+	if err := np.checkVersion(); err != nil {
+		return err
+	}
+	r := bytes.NewReader(buff[1:])
+	if err := binary.Read(r, binary.BigEndian, &np.Origin); err != nil {
+		return err
+	}
+	var err error
+	if np.Level, err = r.ReadByte(); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &np.ChunkIndex); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &np.ChunkCount); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &np.ChunkSeq); err != nil {
+		return err
+	}
+	for _, field := range []*[]byte{&np.MultiSig, &np.IndividualSig, &np.Auth, &np.Session} {
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if length > uint64(r.Len()) {
+			return errors.New("packet field longer than the packet")
+		}
+		if length == 0 {
+			continue
+		}
+		*field = make([]byte, length)
+		r.Read(*field)
+	}
+	if r.Len() != 0 {
+		return errors.New("trailing bytes after the packet")
+	}
+	*p = np
+	return nil
 }
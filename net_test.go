@@ -2,22 +2,51 @@ package handel
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestPacketMarshalling(t *testing.T) {
-	/*p1 := &Packet{*/
-	//Level:    15,
-	//Origin:   10,
-	//MultiSig: []byte("what am I signing?"),
-	//}
+	p1 := &Packet{
+		Version:       PacketVersion,
+		Level:         15,
+		Origin:        10,
+		MultiSig:      []byte("what am I signing?"),
+		IndividualSig: []byte{1, 2},
+		ChunkIndex:    1,
+		ChunkCount:    3,
+		ChunkSeq:      7,
+		Session:       []byte{3},
+	}
+	buff, err := p1.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, byte(PacketVersion), buff[0])
+	p2 := new(Packet)
+	require.NoError(t, p2.UnmarshalBinary(buff))
+	require.Equal(t, p1, p2)
+	require.Error(t, p2.UnmarshalBinary(buff[:len(buff)-1]))
+	require.Error(t, p2.UnmarshalBinary(append(buff, 0)))
 
-	//buff, err := p1.MarshalBinary()
-	//require.NoError(t, err)
+	// a packet without version is read as a v1 packet
+	p1.Version = 0
+	buff, err = p1.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, p2.UnmarshalBinary(buff))
+	require.Equal(t, p1, p2)
 
-	/*p2 := new(Packet)*/
-	//require.NoError(t, p2.UnmarshalBinary(buff))
+	// a v2 packet is rejected
+	buff[0] = 2
+	require.Error(t, p2.UnmarshalBinary(buff))
 
-	//require.Equal(t, p1.Level, p2.Level)
-	//require.Equal(t, p1.Origin, p2.Origin)
-	/*require.Equal(t, p1.MultiSig, p2.MultiSig)*/
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	h := NewHandel(new(levelNetwork), reg, id, new(fakeCons), msg, &fakeSig{true})
+	defer h.Stop()
+	p := &Packet{Origin: 0, Level: 1, MultiSig: fakeConstSig}
+	require.NoError(t, h.validatePacket(p))
+	p.Version = 1
+	require.NoError(t, h.validatePacket(p))
+	p.Version = 2
+	require.Error(t, h.validatePacket(p))
 }
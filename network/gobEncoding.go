@@ -10,6 +10,12 @@ import (
 type gobEncoding struct {
 }
 
+// gobPacket has the fields of a Packet but not its MarshalBinary and
+// UnmarshalBinary methods, so gob encodes it as a struct, as it did before the
+// packets were versioned. A node predating Packet.Version can still decode it,
+// and its packets are decoded with a zero version.
+type gobPacket h.Packet
+
 // NewGOBEncoding crates instance of Encoding interface backed by gob
 func NewGOBEncoding() Encoding {
 	return &gobEncoding{}
@@ -18,15 +24,15 @@ func NewGOBEncoding() Encoding {
 // Encode implements the Encoding interface
 func (g gobEncoding) Encode(packet *h.Packet, w io.Writer) error {
 	enc := gob.NewEncoder(w)
-	err := enc.Encode(packet)
+	err := enc.Encode((*gobPacket)(packet))
 	return err
 }
 
 // Decode implements the Encoding interface
 func (g gobEncoding) Decode(r io.Reader) (*h.Packet, error) {
-	var packet h.Packet
+	var packet gobPacket
 	//Decode gob encoded packet
 	dec := gob.NewDecoder(r)
 	err := dec.Decode(&packet)
-	return &packet, err
+	return (*h.Packet)(&packet), err
 }
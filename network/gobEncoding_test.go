@@ -0,0 +1,37 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+// oldPacket is a Packet as sent by the nodes predating Packet.Version
+type oldPacket struct {
+	Origin        int32
+	Level         byte
+	MultiSig      []byte
+	IndividualSig []byte
+}
+
+func TestGOBEncodingUnversioned(t *testing.T) {
+	enc := NewGOBEncoding()
+	old := &oldPacket{Origin: 3, Level: 2, MultiSig: []byte{1, 2}, IndividualSig: []byte{3}}
+
+	// a packet of an old node is decoded with a zero version
+	var b bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&b).Encode(old))
+	p, err := enc.Decode(&b)
+	require.NoError(t, err)
+	require.Equal(t, &handel.Packet{Origin: 3, Level: 2, MultiSig: []byte{1, 2}, IndividualSig: []byte{3}}, p)
+
+	// an old node decodes the packets sent
+	p.Version = handel.PacketVersion
+	require.NoError(t, enc.Encode(p, &b))
+	decoded := new(oldPacket)
+	require.NoError(t, gob.NewDecoder(&b).Decode(decoded))
+	require.Equal(t, old, decoded)
+}
@@ -0,0 +1,171 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	h "github.com/ConsenSys/handel"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field numbers of the Packet message of handel.proto
+const (
+	fieldOrigin protowire.Number = iota + 1
+	fieldLevel
+	fieldMultiSig
+	fieldIndividualSig
+	fieldVersion
+	fieldChunkIndex
+	fieldChunkCount
+	fieldAuth
+	fieldSession
+	fieldChunkSeq
+)
+
+// codec is the gRPC codec of the packets, encoding them as the Packet message
+// of handel.proto. It is named "proto" like the default codec of gRPC, so it
+// interoperates with the code generated from handel.proto.
+type codec struct{}
+
+// Name implements the encoding.Codec interface of gRPC
+func (codec) Name() string {
+	return "proto"
+}
+
+// Marshal implements the encoding.Codec interface of gRPC
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	p, ok := v.(*h.Packet)
+	if !ok {
+		return nil, fmt.Errorf("grpc: can not marshal %T", v)
+	}
+	return marshalPacket(p), nil
+}
+
+// Unmarshal implements the encoding.Codec interface of gRPC
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*h.Packet)
+	if !ok {
+		return fmt.Errorf("grpc: can not unmarshal into %T", v)
+	}
+	return unmarshalPacket(data, p)
+}
+
+// marshalPacket returns the Packet message of the packet. As in proto3, the
+// fields of zero value are omitted.
+func marshalPacket(p *h.Packet) []byte {
+	var b []byte
+	varint := func(num protowire.Number, v uint64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, v)
+		}
+	}
+	bytes := func(num protowire.Number, v []byte) {
+		if len(v) != 0 {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, v)
+		}
+	}
+	// an int32 is sign extended
+	varint(fieldOrigin, uint64(int64(p.Origin)))
+	varint(fieldLevel, uint64(p.Level))
+	bytes(fieldMultiSig, p.MultiSig)
+	bytes(fieldIndividualSig, p.IndividualSig)
+	varint(fieldVersion, uint64(p.Version))
+	varint(fieldChunkIndex, uint64(p.ChunkIndex))
+	varint(fieldChunkCount, uint64(p.ChunkCount))
+	bytes(fieldAuth, p.Auth)
+	bytes(fieldSession, p.Session)
+	varint(fieldChunkSeq, uint64(p.ChunkSeq))
+	return b
+}
+
+// unmarshalPacket reads the Packet message into the packet. The unknown
+// fields are skipped, and the values too large for their field of the packet
+// are rejected.
+func unmarshalPacket(b []byte, p *h.Packet) error {
+	*p = h.Packet{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == fieldOrigin && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if int64(v) < math.MinInt32 || int64(v) > math.MaxInt32 {
+				return errors.New("grpc: packet's origin out of range")
+			}
+			p.Origin = int32(v)
+			b = b[n:]
+		case typ == protowire.VarintType && (num >= fieldLevel && num <= fieldChunkCount || num == fieldChunkSeq):
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if err := setUint(p, num, v); err != nil {
+				return err
+			}
+			b = b[n:]
+		case typ == protowire.BytesType && (num == fieldMultiSig || num == fieldIndividualSig || num == fieldAuth || num == fieldSession):
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			v = append([]byte{}, v...)
+			switch num {
+			case fieldMultiSig:
+				p.MultiSig = v
+			case fieldIndividualSig:
+				p.IndividualSig = v
+			case fieldAuth:
+				p.Auth = v
+			case fieldSession:
+				p.Session = v
+			}
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// setUint sets the unsigned field of the packet of the given number.
+func setUint(p *h.Packet, num protowire.Number, v uint64) error {
+	switch num {
+	case fieldLevel, fieldVersion:
+		if v > math.MaxUint8 {
+			return fmt.Errorf("grpc: packet's field %d out of range", num)
+		}
+		if num == fieldLevel {
+			p.Level = byte(v)
+		} else {
+			p.Version = byte(v)
+		}
+	case fieldChunkIndex, fieldChunkCount:
+		if v > math.MaxUint16 {
+			return fmt.Errorf("grpc: packet's field %d out of range", num)
+		}
+		if num == fieldChunkIndex {
+			p.ChunkIndex = uint16(v)
+		} else {
+			p.ChunkCount = uint16(v)
+		}
+	case fieldChunkSeq:
+		if v > math.MaxUint32 {
+			return fmt.Errorf("grpc: packet's field %d out of range", num)
+		}
+		p.ChunkSeq = uint32(v)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package grpc
+
+import (
+	"testing"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestCodec(t *testing.T) {
+	c := codec{}
+	for _, p := range []*h.Packet{
+		{},
+		{Origin: 3, Level: 2, MultiSig: []byte{1, 2, 3}},
+		{
+			Version:       1,
+			Origin:        -1,
+			Level:         255,
+			MultiSig:      []byte{1},
+			IndividualSig: []byte{2},
+			ChunkIndex:    2,
+			ChunkCount:    65535,
+			ChunkSeq:      1 << 31,
+			Auth:          []byte{3},
+			Session:       []byte{4},
+		},
+	} {
+		buff, err := c.Marshal(p)
+		require.NoError(t, err)
+		p2 := new(h.Packet)
+		require.NoError(t, c.Unmarshal(buff, p2))
+		require.Equal(t, p, p2)
+	}
+
+	// the Packet message of handel.proto
+	buff, err := c.Marshal(&h.Packet{Origin: 1, Level: 2, MultiSig: []byte{0xff}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x08, 1, 0x10, 2, 0x1a, 1, 0xff}, buff)
+
+	// unknown fields are skipped
+	unknown := protowire.AppendTag(nil, 42, protowire.BytesType)
+	unknown = protowire.AppendBytes(unknown, []byte{1, 2})
+	p := new(h.Packet)
+	require.NoError(t, c.Unmarshal(append(unknown, buff...), p))
+	require.Equal(t, byte(2), p.Level)
+
+	// values out of the range of their field
+	level := protowire.AppendTag(nil, fieldLevel, protowire.VarintType)
+	level = protowire.AppendVarint(level, 256)
+	require.Error(t, c.Unmarshal(level, p))
+	origin := protowire.AppendTag(nil, fieldOrigin, protowire.VarintType)
+	origin = protowire.AppendVarint(origin, 1<<32)
+	require.Error(t, c.Unmarshal(origin, p))
+	// truncated
+	require.Error(t, c.Unmarshal(buff[:len(buff)-1], p))
+
+	_, err = c.Marshal("packet")
+	require.Error(t, err)
+}
@@ -0,0 +1,201 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// queueSize is the number of packets waiting to be sent to a peer, above which
+// the packets sent to it are dropped
+const queueSize = 100
+
+// bounds of the backoff between two connections to a peer
+var (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// exchangeDesc is the Exchange stream of the Handel service of handel.proto
+var exchangeDesc = grpc.StreamDesc{
+	StreamName:    "Exchange",
+	Handler:       exchangeHandler,
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// serviceDesc is the Handel service of handel.proto
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "handel.Handel",
+	HandlerType: (*interface{})(nil),
+	Streams:     []grpc.StreamDesc{exchangeDesc},
+	Metadata:    "handel.proto",
+}
+
+const exchangeMethod = "/handel.Handel/Exchange"
+
+func exchangeHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(*Network).receive(stream)
+}
+
+// Network is a handel.Network implementation using gRPC streams as its
+// transport layer. Each node runs a server receiving the packets of its peers
+// on a stream per peer, and dials a peer the first time it sends it a packet.
+// The packets to a peer are sent in order on its stream; a peer whose stream
+// fails is dialed again with an exponential backoff, the packets sent in the
+// meantime being dropped once its queue is full.
+type Network struct {
+	sync.RWMutex
+	server    *grpc.Server
+	listeners []h.Listener
+	peers     map[string]*peer
+	done      chan bool
+	quit      bool
+}
+
+// NewNetwork returns a gRPC Network whose server listens on the given
+// address.
+func NewNetwork(listen string) (*Network, error) {
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	n := &Network{
+		server: grpc.NewServer(grpc.ForceServerCodec(codec{})),
+		peers:  make(map[string]*peer),
+		done:   make(chan bool),
+	}
+	n.server.RegisterService(&serviceDesc, n)
+	go n.server.Serve(l)
+	return n, nil
+}
+
+// RegisterListener implements the handel.Network interface
+func (n *Network) RegisterListener(listener h.Listener) {
+	n.Lock()
+	defer n.Unlock()
+	n.listeners = append(n.listeners, listener)
+}
+
+// Send implements the handel.Network interface. It never blocks: the packets
+// are queued to be sent to each peer by a routine of its own.
+func (n *Network) Send(ids []h.Identity, packet *h.Packet) {
+	n.Lock()
+	defer n.Unlock()
+	if n.quit {
+		return
+	}
+	for _, id := range ids {
+		p, exists := n.peers[id.Address()]
+		if !exists {
+			p = &peer{addr: id.Address(), out: make(chan *h.Packet, queueSize)}
+			n.peers[id.Address()] = p
+			go p.run(n.done)
+		}
+		select {
+		case p.out <- packet:
+		default:
+		}
+	}
+}
+
+// Stop closes the server and the streams to the peers
+func (n *Network) Stop() {
+	n.Lock()
+	if n.quit {
+		n.Unlock()
+		return
+	}
+	n.quit = true
+	close(n.done)
+	n.Unlock()
+	n.server.Stop()
+}
+
+// receive dispatches the packets of the stream of a peer to the listeners
+// until the stream is closed.
+func (n *Network) receive(stream grpc.ServerStream) error {
+	for {
+		packet := new(h.Packet)
+		if err := stream.RecvMsg(packet); err != nil {
+			// io.EOF when the peer closes the stream
+			return nil
+		}
+		n.dispatch(packet)
+	}
+}
+
+func (n *Network) dispatch(p *h.Packet) {
+	n.RLock()
+	defer n.RUnlock()
+	for _, l := range n.listeners {
+		l.NewPacket(p)
+	}
+}
+
+// peer sends the packets queued for a node on a stream to it.
+type peer struct {
+	addr string
+	out  chan *h.Packet
+}
+
+// run connects to the peer and sends it the queued packets until done is
+// closed, connecting again with an exponential backoff each time the
+// connection or the stream fails. The backoff is reset once a stream carries
+// a packet.
+func (p *peer) run(done chan bool) {
+	backoff := minBackoff
+	for {
+		stop, sent := p.stream(done)
+		if stop {
+			return
+		}
+		if sent {
+			backoff = minBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-done:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// stream opens a stream to the peer and sends it the queued packets. It
+// returns true once done is closed, and false if the stream fails, with
+// whether a packet has been sent on the stream.
+func (p *peer) stream(done chan bool) (stop, sent bool) {
+	conn, err := grpc.Dial(p.addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})))
+	if err != nil {
+		return false, false
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &exchangeDesc, exchangeMethod)
+	if err != nil {
+		return false, false
+	}
+	for {
+		select {
+		case packet := <-p.out:
+			if err := stream.SendMsg(packet); err != nil {
+				return false, sent
+			}
+			sent = true
+		case <-done:
+			stream.CloseSend()
+			return true, sent
+		}
+	}
+}
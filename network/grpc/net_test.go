@@ -0,0 +1,61 @@
+package grpc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSig struct{}
+
+func (f *fakeSig) MarshalBinary() ([]byte, error)  { return []byte{1}, nil }
+func (f *fakeSig) UnmarshalBinary([]byte) error    { return nil }
+func (f *fakeSig) Combine(h.Signature) h.Signature { return f }
+func (f *fakeSig) String() string                  { return "fake" }
+
+type fakePublic struct{}
+
+func (f *fakePublic) VerifySignature([]byte, h.Signature) error { return nil }
+func (f *fakePublic) Combine(h.PublicKey) h.PublicKey           { return f }
+func (f *fakePublic) String() string                            { return "fake" }
+
+type fakeCons struct{}
+
+func (f *fakeCons) Signature() h.Signature { return new(fakeSig) }
+func (f *fakeCons) PublicKey() h.PublicKey { return new(fakePublic) }
+
+func TestGRPCNetwork(t *testing.T) {
+	n := 3
+	ids := make([]h.Identity, n)
+	nets := make([]*Network, n)
+	for i := range ids {
+		addr := fmt.Sprintf("127.0.0.1:%d", 6000+i)
+		ids[i] = h.NewStaticIdentity(int32(i), addr, new(fakePublic))
+		net, err := NewNetwork(addr)
+		require.NoError(t, err)
+		defer net.Stop()
+		nets[i] = net
+	}
+	reg := h.NewArrayRegistry(ids)
+	conf := h.DefaultConfig(n)
+	conf.Contributions = n
+	handels := make([]*h.Handel, n)
+	for i := range handels {
+		handels[i] = h.NewHandel(nets[i], reg, ids[i], new(fakeCons), []byte("message"), new(fakeSig), conf)
+	}
+	for _, hd := range handels {
+		hd.Start()
+		defer hd.Stop()
+	}
+	for _, hd := range handels {
+		select {
+		case ms := <-hd.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality())
+		case <-time.After(5 * time.Second):
+			t.Fatal("no final signature")
+		}
+	}
+}
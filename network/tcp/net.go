@@ -1,6 +1,8 @@
 package tcp
 
 import (
+	"bufio"
+	"errors"
 	"net"
 	"sync"
 	"time"
@@ -12,27 +14,69 @@ import (
 // value given to SetDeadLine on all connections - TTL equivalent
 var timeout = 1 * time.Minute
 
-// Network implements the handel.Network interface using TCP connections
+// DefaultBufferSize is the default number of packets buffered for a peer
+// while it is not connected.
+const DefaultBufferSize = 100
+
+// DefaultMaxBackoff is the default maximum time between two connection
+// attempts to a peer.
+const DefaultMaxBackoff = 5 * time.Second
+
+// minBackoff is the time between the first two connection attempts to a peer
+var minBackoff = 50 * time.Millisecond
+
+// Network implements the handel.Network interface using TCP connections. The
+// packets are sent to each peer in order by a routine of its own, which
+// connects to the peer and reconnects with an exponential backoff when the
+// connection breaks. The packets sent in the meantime are buffered; once the
+// buffer is full, the oldest packets are dropped.
 type Network struct {
 	sync.Mutex
-	addr     string
-	l        net.Listener
-	conns    map[string]net.Conn
-	enc      network.Encoding
-	listener h.Listener
+	addr       string
+	l          net.Listener
+	conns      map[string]net.Conn
+	peers      map[string]*peer
+	enc        network.Encoding
+	listener   h.Listener
+	bufferSize int
+	maxBackoff time.Duration
+	done       chan bool
+	quit       bool
+	sent       int
+	dropped    int
 }
 
-// NewNetwork returns a TCP Network that listens to the given address.
+// NewNetwork returns a TCP Network that listens to the given address, with
+// DefaultBufferSize and DefaultMaxBackoff.
 func NewNetwork(listen string, enc network.Encoding) (*Network, error) {
+	return NewNetworkBuffered(listen, enc, DefaultBufferSize, DefaultMaxBackoff)
+}
+
+// NewNetworkBuffered returns a TCP Network that listens to the given address,
+// buffering at most bufferSize packets per peer while it is not connected,
+// and waiting at most maxBackoff between two connection attempts to a peer.
+// Since all packets go through the buffer of their peer, bufferSize must be
+// at least one.
+func NewNetworkBuffered(listen string, enc network.Encoding, bufferSize int, maxBackoff time.Duration) (*Network, error) {
+	if bufferSize < 1 {
+		return nil, errors.New("tcp: buffer size must be at least one")
+	}
 	listener, err := net.Listen("tcp", listen)
 	if err != nil {
 		return nil, err
 	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
 	n := &Network{
-		addr:  listen,
-		l:     listener,
-		enc:   enc,
-		conns: make(map[string]net.Conn),
+		addr:       listen,
+		l:          listener,
+		enc:        enc,
+		conns:      make(map[string]net.Conn),
+		peers:      make(map[string]*peer),
+		bufferSize: bufferSize,
+		maxBackoff: maxBackoff,
+		done:       make(chan bool),
 	}
 	go n.handleIncoming()
 	return n, nil
@@ -50,11 +94,13 @@ func (n *Network) handleIncoming() {
 }
 
 func (n *Network) handleConn(c net.Conn) {
+	defer n.unregisterConn(c)
+	// a single reader for all the packets of the connection, so the bytes
+	// read ahead by a decoder are not lost
+	reader := bufio.NewReader(c)
 	for {
 		c.SetDeadline(time.Now().Add(timeout))
-		//reader := bufio.NewReader(c)
-		//packet, err := n.enc.Decode(reader)
-		packet, err := n.enc.Decode(c)
+		packet, err := n.enc.Decode(reader)
 		if err != nil {
 			return
 		}
@@ -74,43 +120,44 @@ func (n *Network) unregisterConn(c net.Conn) {
 	delete(n.conns, c.RemoteAddr().String())
 }
 
-// Send implements the handel.Network interface
+// Send implements the handel.Network interface. It never blocks: the packets
+// are buffered for the peers.
 func (n *Network) Send(ids []h.Identity, packet *h.Packet) {
 	n.Lock()
 	defer n.Unlock()
-	var err error
+	if n.quit {
+		return
+	}
 	for _, id := range ids {
 		addr := id.Address()
-		conn, exists := n.conns[addr]
+		p, exists := n.peers[addr]
 		if !exists {
-			conn, err = n.connectTo(addr)
+			p = &peer{n: n, addr: addr, ready: make(chan bool, 1)}
+			n.peers[addr] = p
+			go p.run()
 		}
-		//byteWriter := bufio.NewWriter(conn)
-		if err = n.enc.Encode(packet, conn); err != nil {
-			go n.unregisterConn(conn)
-			continue
+		if p.push(packet, n.bufferSize) {
+			n.dropped++
 		}
 	}
 }
 
-func (n *Network) connectTo(addr string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	n.conns[addr] = conn
-	go n.handleConn(conn)
-	return conn, nil
-}
-
-// Stop the listener
+// Stop the listener and the connections
 func (n *Network) Stop() {
 	n.Lock()
 	defer n.Unlock()
+	if n.quit {
+		return
+	}
+	n.quit = true
+	close(n.done)
 	n.l.Close()
 	for _, c := range n.conns {
 		c.Close()
 	}
+	for _, p := range n.peers {
+		p.close()
+	}
 }
 
 // RegisterListener implements the h.Network interface
@@ -125,3 +172,149 @@ func (n *Network) dispatch(p *h.Packet) {
 	defer n.Unlock()
 	n.listener.NewPacket(p)
 }
+
+// Values implements the monitor.CounterMeasure interface, with the number of
+// packets sent and of packets dropped because the buffer of their peer was
+// full.
+func (n *Network) Values() map[string]float64 {
+	n.Lock()
+	defer n.Unlock()
+	toSend := map[string]float64{
+		"sent":    float64(n.sent),
+		"dropped": float64(n.dropped),
+	}
+	counter, ok := n.enc.(*network.CounterEncoding)
+	if ok {
+		for k, v := range counter.Values() {
+			toSend[k] = v
+		}
+	}
+	return toSend
+}
+
+// peer sends the packets buffered for a node on a connection to it.
+type peer struct {
+	sync.Mutex
+	n    *Network
+	addr string
+	// packets to send, oldest first
+	buff []*h.Packet
+	// signals packets are buffered
+	ready chan bool
+	conn  net.Conn
+}
+
+// push buffers the packet, dropping the oldest one if the buffer is full. It
+// returns true if a packet has been dropped.
+func (p *peer) push(packet *h.Packet, size int) bool {
+	p.Lock()
+	defer p.Unlock()
+	var dropped bool
+	if len(p.buff) >= size {
+		p.buff = p.buff[1:]
+		dropped = true
+	}
+	p.buff = append(p.buff, packet)
+	select {
+	case p.ready <- true:
+	default:
+	}
+	return dropped
+}
+
+// next returns the oldest packet buffered, if any, without removing it.
+func (p *peer) next() (*h.Packet, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.buff) == 0 {
+		return nil, false
+	}
+	return p.buff[0], true
+}
+
+// pop removes the given packet from the buffer if it is still the oldest one.
+func (p *peer) pop(packet *h.Packet) {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.buff) > 0 && p.buff[0] == packet {
+		p.buff = p.buff[1:]
+	}
+}
+
+// run sends the buffered packets until the network is stopped, connecting to
+// the peer when needed. A packet is removed from the buffer once written, so
+// the packets buffered while the peer is not connected are sent once it is.
+func (p *peer) run() {
+	backoff := minBackoff
+	for {
+		packet, ok := p.next()
+		if !ok {
+			select {
+			case <-p.ready:
+				continue
+			case <-p.n.done:
+				return
+			}
+		}
+		conn, err := p.connect()
+		if err != nil {
+			select {
+			case <-time.After(backoff):
+			case <-p.n.done:
+				return
+			}
+			if backoff *= 2; backoff > p.n.maxBackoff {
+				backoff = p.n.maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if err := p.n.enc.Encode(packet, conn); err != nil {
+			p.disconnect(conn)
+			continue
+		}
+		p.pop(packet)
+		p.n.Lock()
+		p.n.sent++
+		p.n.Unlock()
+	}
+}
+
+// connect returns the connection to the peer, dialing it if needed.
+func (p *peer) connect() (net.Conn, error) {
+	p.Lock()
+	conn := p.conn
+	p.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	conn, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.conn = conn
+	return conn, nil
+}
+
+// disconnect closes the given connection, if it is still the one to the
+// peer.
+func (p *peer) disconnect(conn net.Conn) {
+	conn.Close()
+	p.Lock()
+	defer p.Unlock()
+	if p.conn == conn {
+		p.conn = nil
+	}
+}
+
+// close closes the connection to the peer
+func (p *peer) close() {
+	p.Lock()
+	defer p.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
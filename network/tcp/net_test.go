@@ -34,3 +34,90 @@ func TestTCPNetwork(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// receiver returns a listener sending the origin of the packets received on
+// the returned channel
+func receiver() (handel.Listener, chan int32) {
+	origins := make(chan int32, 100)
+	return handel.ListenFunc(func(p *handel.Packet) {
+		origins <- p.Origin
+	}), origins
+}
+
+func TestTCPNetworkReconnect(t *testing.T) {
+	addr1 := "127.0.0.1:5002"
+	addr2 := "127.0.0.1:5003"
+	n1, err := NewNetworkBuffered(addr1, network.NewGOBEncoding(), 10, 100*time.Millisecond)
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork(addr2, network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+	l, origins := receiver()
+	n2.RegisterListener(l)
+	id2 := handel.NewStaticIdentity(2, addr2, nil)
+
+	receive := func(origin int32) {
+		select {
+		case o := <-origins:
+			require.Equal(t, origin, o)
+		case <-time.After(time.Second):
+			t.Fatalf("packet %d not received", origin)
+		}
+	}
+	for i := int32(0); i < 5; i++ {
+		n1.Send([]handel.Identity{id2}, &handel.Packet{Origin: i})
+	}
+	for i := int32(0); i < 5; i++ {
+		receive(i)
+	}
+
+	// the connection breaks: the packets are sent on a new one
+	n1.Lock()
+	p := n1.peers[addr2]
+	n1.Unlock()
+	p.Lock()
+	p.conn.Close()
+	p.Unlock()
+	for i := int32(5); i < 10; i++ {
+		n1.Send([]handel.Identity{id2}, &handel.Packet{Origin: i})
+	}
+	for i := int32(5); i < 10; i++ {
+		receive(i)
+	}
+	require.Equal(t, 10.0, n1.Values()["sent"])
+}
+
+func TestTCPNetworkBuffer(t *testing.T) {
+	addr1 := "127.0.0.1:5004"
+	addr2 := "127.0.0.1:5005"
+	n1, err := NewNetworkBuffered(addr1, network.NewGOBEncoding(), 3, 100*time.Millisecond)
+	require.NoError(t, err)
+	defer n1.Stop()
+	id2 := handel.NewStaticIdentity(2, addr2, nil)
+
+	// the packets always go through the buffer
+	_, err = NewNetworkBuffered("127.0.0.1:5006", network.NewGOBEncoding(), 0, 0)
+	require.Error(t, err)
+
+	// the peer is down: the oldest packets are dropped
+	for i := int32(0); i < 5; i++ {
+		n1.Send([]handel.Identity{id2}, &handel.Packet{Origin: i})
+	}
+	require.Equal(t, 2.0, n1.Values()["dropped"])
+
+	// the buffer is flushed once the peer is up
+	n2, err := NewNetwork(addr2, network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+	l, origins := receiver()
+	n2.RegisterListener(l)
+	for i := int32(2); i < 5; i++ {
+		select {
+		case o := <-origins:
+			require.Equal(t, i, o)
+		case <-time.After(time.Second):
+			t.Fatalf("packet %d not received", i)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package udpfrag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMTU is the maximum size of the datagrams sent by a Network created
+// with NewUDPNetwork, chosen to avoid IP fragmentation on usual links.
+const DefaultMTU = 1400
+
+// DefaultFragmentTimeout is the time a Network created with NewUDPNetwork
+// waits for the missing fragments of a packet before dropping it.
+const DefaultFragmentTimeout = 2 * time.Second
+
+// headerVersion is the version of the header of the datagrams. Datagrams of
+// another version are dropped.
+const headerVersion = 1
+
+// headerSize is the size of the header of each datagram: the version, the ID
+// of the sender in the registry, the sequence number of the encoded packet for
+// its sender, the index of the fragment and the number of fragments of the
+// packet.
+const headerSize = 1 + 4 + 4 + 2 + 2
+
+// maxPacketSize is the maximum size of an encoded packet. It bounds the number
+// of fragments a receiver accepts for a packet, so a forged header can not
+// make it allocate more.
+const maxPacketSize = 1 << 20
+
+// maxPendingPerSender is the maximum number of incomplete packets buffered for
+// a sender. The oldest one is dropped to make room for a new one.
+const maxPendingPerSender = 16
+
+// maxPendingBytes is the maximum number of bytes buffered for all the
+// incomplete packets. The oldest packets are dropped to make room for a new
+// fragment.
+const maxPendingBytes = 16 * maxPacketSize
+
+// fragmentKey identifies the packet a fragment belongs to. The sequence
+// numbers are only unique per sender.
+type fragmentKey struct {
+	sender int32
+	seq    uint32
+}
+
+// fragments holds the fragments of a packet received so far.
+type fragments struct {
+	parts    [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+// fragment splits the encoded packet of the given sender in datagrams of at
+// most mtu bytes, each starting with the header.
+func fragment(sender int32, seq uint32, encoded []byte, mtu int) ([][]byte, error) {
+	size := mtu - headerSize
+	if size <= 0 {
+		return nil, errors.New("udpfrag: mtu too small for the fragment header")
+	}
+	if len(encoded) > maxPacketSize {
+		return nil, errors.New("udpfrag: packet too large")
+	}
+	count := (len(encoded) + size - 1) / size
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xffff {
+		return nil, errors.New("udpfrag: packet too large")
+	}
+	datagrams := make([][]byte, count)
+	for i := range datagrams {
+		end := min((i+1)*size, len(encoded))
+		d := make([]byte, headerSize, headerSize+end-i*size)
+		d[0] = headerVersion
+		binary.BigEndian.PutUint32(d[1:], uint32(sender))
+		binary.BigEndian.PutUint32(d[5:], seq)
+		binary.BigEndian.PutUint16(d[9:], uint16(i))
+		binary.BigEndian.PutUint16(d[11:], uint16(count))
+		datagrams[i] = append(d, encoded[i*size:end]...)
+	}
+	return datagrams, nil
+}
+
+// reassembler buffers the fragments received until all the fragments of a
+// packet are received. Fragments may arrive in any order; the fragments of a
+// packet still incomplete after the timeout are dropped.
+//
+// The pending packets are keyed on the sender ID carried in the header rather
+// than on the source address, since the source port of a sender is not stable
+// across NAT or restarts. The ID is not authenticated, so the buffered packets
+// are also bounded globally.
+type reassembler struct {
+	sync.Mutex
+	timeout time.Duration
+	// number of nodes in the registry, sender IDs are in [0, senders)
+	senders int
+	// maximum number of fragments of a packet of maxPacketSize bytes
+	maxCount int
+	// maximum number of bytes buffered for the incomplete packets
+	maxBytes int
+	bytes    int
+	pending  map[fragmentKey]*fragments
+}
+
+// newReassembler returns a reassembler of the fragments of at most mtu bytes
+// sent by fragment from senders of a registry of the given size.
+func newReassembler(timeout time.Duration, mtu, senders int) *reassembler {
+	size := mtu - headerSize
+	return &reassembler{
+		timeout:  timeout,
+		senders:  senders,
+		maxCount: min((maxPacketSize+size-1)/size, 0xffff),
+		maxBytes: maxPendingBytes,
+		pending:  make(map[fragmentKey]*fragments),
+	}
+}
+
+// add buffers the datagram received at the given time and returns the encoded
+// packet it completes, if any.
+func (r *reassembler) add(datagram []byte, now time.Time) ([]byte, bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.expire(now)
+	if len(datagram) < headerSize {
+		return nil, false, errors.New("udpfrag: datagram shorter than its header")
+	}
+	if datagram[0] != headerVersion {
+		return nil, false, errors.New("udpfrag: unknown header version")
+	}
+	sender := int32(binary.BigEndian.Uint32(datagram[1:]))
+	seq := binary.BigEndian.Uint32(datagram[5:])
+	index := int(binary.BigEndian.Uint16(datagram[9:]))
+	count := int(binary.BigEndian.Uint16(datagram[11:]))
+	if sender < 0 || int(sender) >= r.senders {
+		return nil, false, errors.New("udpfrag: sender not in the registry")
+	}
+	if index >= count {
+		return nil, false, errors.New("udpfrag: fragment index out of bounds")
+	}
+	if count > r.maxCount {
+		return nil, false, errors.New("udpfrag: too many fragments for a packet")
+	}
+	payload := datagram[headerSize:]
+	if count == 1 {
+		return payload, true, nil
+	}
+
+	key := fragmentKey{sender, seq}
+	f, exists := r.pending[key]
+	if !exists {
+		r.limit(sender)
+		f = &fragments{parts: make([][]byte, count), first: now}
+		r.pending[key] = f
+	}
+	if len(f.parts) != count {
+		return nil, false, errors.New("udpfrag: fragment count mismatch")
+	}
+	if f.parts[index] != nil {
+		// duplicate
+		return nil, false, nil
+	}
+	for r.bytes+len(payload) > r.maxBytes {
+		if !r.dropOldest(key) {
+			r.remove(key)
+			return nil, false, errors.New("udpfrag: too many pending bytes")
+		}
+	}
+	// the datagram buffer is reused by the caller
+	f.parts[index] = append([]byte{}, payload...)
+	f.received++
+	f.size += len(payload)
+	r.bytes += len(payload)
+	if f.received < count {
+		return nil, false, nil
+	}
+	r.remove(key)
+	return bytes.Join(f.parts, nil), true, nil
+}
+
+// limit drops the oldest incomplete packet of the sender if
+// maxPendingPerSender packets of the sender are buffered already.
+func (r *reassembler) limit(sender int32) {
+	count := 0
+	var oldest fragmentKey
+	var first time.Time
+	for key, f := range r.pending {
+		if key.sender != sender {
+			continue
+		}
+		if count == 0 || f.first.Before(first) {
+			oldest, first = key, f.first
+		}
+		count++
+	}
+	if count >= maxPendingPerSender {
+		r.remove(oldest)
+	}
+}
+
+// dropOldest drops the oldest incomplete packet other than the given one. It
+// returns false if there is none.
+func (r *reassembler) dropOldest(except fragmentKey) bool {
+	found := false
+	var oldest fragmentKey
+	var first time.Time
+	for key, f := range r.pending {
+		if key == except {
+			continue
+		}
+		if !found || f.first.Before(first) {
+			oldest, first, found = key, f.first, true
+		}
+	}
+	if found {
+		r.remove(oldest)
+	}
+	return found
+}
+
+// expire drops the packets whose first fragment was received more than the
+// timeout ago.
+func (r *reassembler) expire(now time.Time) {
+	for key, f := range r.pending {
+		if now.Sub(f.first) > r.timeout {
+			r.remove(key)
+		}
+	}
+}
+
+// remove drops the packet and the bytes buffered for it.
+func (r *reassembler) remove(key fragmentKey) {
+	if f, exists := r.pending[key]; exists {
+		r.bytes -= f.size
+		delete(r.pending, key)
+	}
+}
+
+// len returns the number of incomplete packets buffered.
+func (r *reassembler) len() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.pending)
+}
@@ -0,0 +1,148 @@
+package udpfrag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// forged returns a datagram of one byte of payload with the given header.
+func forged(sender int32, seq uint32, index, count uint16) []byte {
+	d := make([]byte, headerSize+1)
+	d[0] = headerVersion
+	binary.BigEndian.PutUint32(d[1:], uint32(sender))
+	binary.BigEndian.PutUint32(d[5:], seq)
+	binary.BigEndian.PutUint16(d[9:], index)
+	binary.BigEndian.PutUint16(d[11:], count)
+	return d
+}
+
+func TestFragmentReassemble(t *testing.T) {
+	encoded := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7}, 100)
+	datagrams, err := fragment(1, 1, encoded, 113)
+	require.NoError(t, err)
+	require.Len(t, datagrams, 7)
+	for _, d := range datagrams {
+		require.True(t, len(d) <= 113)
+	}
+	// the same sequence number from another sender is another packet
+	other, err := fragment(2, 1, encoded, 113)
+	require.NoError(t, err)
+
+	// out of order and duplicated fragments
+	now := time.Now()
+	r := newReassembler(time.Second, 113, 3)
+	for i := len(datagrams) - 1; i > 0; i-- {
+		_, complete, err := r.add(datagrams[i], now)
+		require.NoError(t, err)
+		require.False(t, complete)
+	}
+	_, complete, err := r.add(datagrams[1], now)
+	require.NoError(t, err)
+	require.False(t, complete)
+	_, complete, err = r.add(other[0], now)
+	require.NoError(t, err)
+	require.False(t, complete)
+	got, complete, err := r.add(datagrams[0], now)
+	require.NoError(t, err)
+	require.True(t, complete)
+	require.Equal(t, encoded, got)
+	require.Equal(t, len(other[0])-headerSize, r.bytes)
+
+	// the incomplete packet of the other sender expires
+	require.Len(t, r.pending, 1)
+	_, complete, err = r.add(other[1], now.Add(2*time.Second))
+	require.NoError(t, err)
+	require.False(t, complete)
+	require.Len(t, r.pending, 1)
+	require.Equal(t, 1, r.pending[fragmentKey{2, 1}].received)
+	require.Equal(t, len(other[1])-headerSize, r.bytes)
+
+	// a packet fitting in a single datagram
+	single, err := fragment(1, 2, []byte{0x01}, 113)
+	require.NoError(t, err)
+	require.Len(t, single, 1)
+	got, complete, err = r.add(single[0], now)
+	require.NoError(t, err)
+	require.True(t, complete)
+	require.Equal(t, []byte{0x01}, got)
+
+	_, _, err = r.add([]byte{0x01}, now)
+	require.Error(t, err)
+	_, err = fragment(1, 3, encoded, headerSize)
+	require.Error(t, err)
+	_, err = fragment(1, 3, make([]byte, maxPacketSize+1), DefaultMTU)
+	require.Error(t, err)
+}
+
+func TestFragmentReassembleBounds(t *testing.T) {
+	now := time.Now()
+	r := newReassembler(time.Second, 113, 3)
+
+	// another version of the header or a sender out of the registry
+	d := forged(1, 1, 0, 1)
+	d[0] = headerVersion + 1
+	_, _, err := r.add(d, now)
+	require.Error(t, err)
+	_, _, err = r.add(forged(3, 1, 0, 1), now)
+	require.Error(t, err)
+	_, _, err = r.add(forged(-1, 1, 0, 1), now)
+	require.Error(t, err)
+
+	// a forged count larger than the one of a packet of maxPacketSize bytes
+	require.True(t, r.maxCount < 0xffff)
+	_, _, err = r.add(forged(0, 1, 0, 0xffff), now)
+	require.Error(t, err)
+	_, _, err = r.add(forged(0, 1, 0, uint16(r.maxCount+1)), now)
+	require.Error(t, err)
+	require.Empty(t, r.pending)
+	_, _, err = r.add(forged(0, 1, 0, uint16(r.maxCount)), now)
+	require.NoError(t, err)
+	require.Len(t, r.pending, 1)
+
+	// the incomplete packets of a sender are bounded, the oldest is dropped
+	for seq := uint32(2); seq <= maxPendingPerSender+1; seq++ {
+		_, _, err = r.add(forged(0, seq, 0, 2), now.Add(time.Duration(seq)))
+		require.NoError(t, err)
+	}
+	require.Len(t, r.pending, maxPendingPerSender)
+	_, kept := r.pending[fragmentKey{0, 1}]
+	require.False(t, kept)
+	require.Equal(t, maxPendingPerSender, r.bytes)
+	// other senders are not affected
+	_, _, err = r.add(forged(1, 1, 0, 2), now)
+	require.NoError(t, err)
+	require.Len(t, r.pending, maxPendingPerSender+1)
+
+	// the pending bytes of all the senders are bounded, the oldest packets
+	// are dropped
+	r = newReassembler(time.Second, 113, 3)
+	r.maxBytes = 3
+	for sender := int32(0); sender < 3; sender++ {
+		_, _, err = r.add(forged(sender, 1, 0, 2), now.Add(time.Duration(sender)))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, r.bytes)
+	_, _, err = r.add(forged(0, 2, 0, 2), now.Add(3))
+	require.NoError(t, err)
+	require.Len(t, r.pending, 3)
+	require.Equal(t, 3, r.bytes)
+	_, kept = r.pending[fragmentKey{0, 1}]
+	require.False(t, kept)
+	// a packet larger than the cap on its own is dropped
+	_, _, err = r.add(forged(1, 1, 1, 2), now.Add(4))
+	require.NoError(t, err)
+	_, _, err = r.add(forged(1, 2, 0, 4), now.Add(5))
+	require.NoError(t, err)
+	_, _, err = r.add(forged(1, 2, 1, 4), now.Add(5))
+	require.NoError(t, err)
+	_, _, err = r.add(forged(1, 2, 2, 4), now.Add(5))
+	require.NoError(t, err)
+	_, _, err = r.add(forged(1, 2, 3, 4), now.Add(5))
+	require.Error(t, err)
+	require.Empty(t, r.pending)
+	require.Equal(t, 0, r.bytes)
+}
@@ -0,0 +1,274 @@
+// Package udpfrag is a handel.Network implementation using UDP as its
+// transport layer, splitting the packets larger than the MTU in several
+// datagrams. Its datagrams are not compatible with the ones of the udp
+// package.
+package udpfrag
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+)
+
+// maxDatagramSize is the size of the buffer datagrams are read into
+const maxDatagramSize = 65535
+
+// Network is a handel.Network implementation using UDP as its transport layer
+// listens on 0.0.0.0. Packets whose encoding is larger than the MTU are sent
+// in several datagrams, reassembled by the receiving Network.
+type Network struct {
+	sync.RWMutex
+	udpSock   *net.UDPConn
+	listeners []h.Listener
+	quit      bool
+	enc       network.Encoding
+	id        int32
+	mtu       int
+	nextSeq   uint32
+	reasm     *reassembler
+	newPacket chan *h.Packet
+	process   chan *h.Packet
+	ready     chan bool
+	done      chan bool
+	sent      int
+	rcvd      int
+}
+
+// NewUDPNetwork creates a Network listening on the port of listenAddr,
+// sending datagrams of at most DefaultMTU bytes. The identity of listenAddr
+// in the registry identifies the fragments sent by this Network.
+func NewUDPNetwork(listenAddr string, reg h.Registry) (h.Network, error) {
+	return NewNetwork(listenAddr, reg, network.NewGOBEncoding(), DefaultMTU, DefaultFragmentTimeout)
+}
+
+// NewNetwork creates a Network listening on the port of listenAddr, sending
+// datagrams of at most mtu bytes. A packet whose fragments are not all
+// received within the timeout is dropped.
+func NewNetwork(listenAddr string, reg h.Registry, enc network.Encoding, mtu int, timeout time.Duration) (*Network, error) {
+	if mtu <= headerSize {
+		return nil, fmt.Errorf("udpfrag: mtu of %d bytes too small", mtu)
+	}
+	id, err := findID(listenAddr, reg)
+	if err != nil {
+		return nil, err
+	}
+	_, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	newAddr := net.JoinHostPort("0.0.0.0", port)
+
+	// we have to bind to 0.0.0.0 (needed for AWS)
+	udpAddr, err := net.ResolveUDPAddr("udp4", newAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	udpSock, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	udpNet := &Network{
+		udpSock:   udpSock,
+		enc:       enc,
+		id:        id,
+		mtu:       mtu,
+		reasm:     newReassembler(timeout, mtu, reg.Size()),
+		newPacket: make(chan *h.Packet, 20000),
+		process:   make(chan *h.Packet, 100),
+		ready:     make(chan bool, 1),
+		done:      make(chan bool, 1),
+	}
+	go udpNet.handler()
+	go udpNet.loop()
+	go udpNet.dispatchLoop()
+	return udpNet, nil
+}
+
+// findID returns the ID of the identity of the registry whose address is addr.
+func findID(addr string, reg h.Registry) (int32, error) {
+	for i := 0; i < reg.Size(); i++ {
+		id, ok := reg.Identity(i)
+		if ok && id.Address() == addr {
+			return id.ID(), nil
+		}
+	}
+	return 0, fmt.Errorf("udpfrag: no identity with address %s in the registry", addr)
+}
+
+// Stop closes
+func (udpNet *Network) Stop() {
+	udpNet.Lock()
+	defer udpNet.Unlock()
+	if udpNet.quit {
+		return
+	}
+	udpNet.udpSock.Close()
+	udpNet.quit = true
+	close(udpNet.done)
+}
+
+// RegisterListener registers listener for processing incoming packets
+func (udpNet *Network) RegisterListener(listener h.Listener) {
+	udpNet.Lock()
+	defer udpNet.Unlock()
+	udpNet.listeners = append(udpNet.listeners, listener)
+}
+
+// Send sends a packet to supplied identities
+func (udpNet *Network) Send(identities []h.Identity, packet *h.Packet) {
+	udpNet.Lock()
+	udpNet.sent += len(identities)
+	udpNet.Unlock()
+
+	var encoded bytes.Buffer
+	if err := udpNet.enc.Encode(packet, &encoded); err != nil {
+		log.Println(err)
+		return
+	}
+	seq := atomic.AddUint32(&udpNet.nextSeq, 1)
+	datagrams, err := fragment(udpNet.id, seq, encoded.Bytes(), udpNet.mtu)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, id := range identities {
+		udpNet.send(id, datagrams)
+	}
+}
+
+// send writes the datagrams from the listening socket, so all the fragments
+// of a packet come from the same address.
+func (udpNet *Network) send(identity h.Identity, datagrams [][]byte) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", identity.Address())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, d := range datagrams {
+		if _, err := udpNet.udpSock.WriteToUDP(d, udpAddr); err != nil {
+			return
+		}
+	}
+}
+
+func (udpNet *Network) handler() {
+	enc := udpNet.enc
+	buff := make([]byte, maxDatagramSize)
+	for {
+		//udpNet.quit and udpNet.listeners have to be guarded by a read lock
+		udpNet.RLock()
+		quit := udpNet.quit
+		udpNet.RUnlock()
+
+		if quit {
+			return
+		}
+		n, _, err := udpNet.udpSock.ReadFromUDP(buff)
+		if err != nil {
+			continue
+		}
+		encoded, complete, err := udpNet.reasm.add(buff[:n], time.Now())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !complete {
+			continue
+		}
+		packet, err := enc.Decode(bytes.NewReader(encoded))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		udpNet.newPacket <- packet
+	}
+}
+
+func (udpNet *Network) loop() {
+	pendings := list.New()
+	var ready = false
+	send := func() {
+		if pendings.Len() == 0 {
+			return
+		}
+		if !ready {
+			return
+		}
+		toProcess := pendings.Remove(pendings.Front()).(*h.Packet)
+		udpNet.process <- toProcess
+		ready = false
+	}
+	for {
+		select {
+		case newPacket := <-udpNet.newPacket:
+			if len(newPacket.MultiSig) == 0 {
+				continue
+			}
+			pendings.PushBack(newPacket)
+			if ready {
+				send()
+			}
+		case <-udpNet.ready:
+			ready = true
+			send()
+		case <-udpNet.done:
+			return
+		}
+	}
+}
+
+func (udpNet *Network) getListeners() []h.Listener {
+	udpNet.RLock()
+	defer udpNet.RUnlock()
+	udpNet.rcvd++
+	return udpNet.listeners
+}
+
+func (udpNet *Network) dispatchLoop() {
+	dispatch := func(p *h.Packet) {
+		listeners := udpNet.getListeners()
+		for _, listener := range listeners {
+			listener.NewPacket(p)
+		}
+	}
+
+	udpNet.ready <- true
+	for {
+		select {
+		case <-udpNet.done:
+			return
+		case newPacket := <-udpNet.process:
+			// new packet to analyze
+			dispatch(newPacket)
+			// we say we're ready to analyze more
+			udpNet.ready <- true
+		}
+	}
+}
+
+// Values implements the monitor.CounterMeasure interface
+func (udpNet *Network) Values() map[string]float64 {
+	udpNet.RLock()
+	defer udpNet.RUnlock()
+	toSend := map[string]float64{
+		"sent": float64(udpNet.sent),
+		"rcvd": float64(udpNet.rcvd),
+	}
+	counter, ok := udpNet.enc.(*network.CounterEncoding)
+	if ok {
+		for k, v := range counter.Values() {
+			toSend[k] = v
+		}
+	}
+	return toSend
+}
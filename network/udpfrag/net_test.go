@@ -0,0 +1,88 @@
+package udpfrag
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+	"github.com/stretchr/testify/require"
+)
+
+func testRegistry(addrs ...string) handel.Registry {
+	ids := make([]handel.Identity, len(addrs))
+	for i, addr := range addrs {
+		ids[i] = handel.NewStaticIdentity(int32(i), addr, nil)
+	}
+	return handel.NewArrayRegistry(ids)
+}
+
+func TestUDPNetworkFragments(t *testing.T) {
+	reg := testRegistry("127.0.0.1:3100", "127.0.0.1:3101")
+	n1, err := NewUDPNetwork("127.0.0.1:3100", reg)
+	require.NoError(t, err)
+	defer n1.(*Network).Stop()
+	n2, err := NewUDPNetwork("127.0.0.1:3101", reg)
+	require.NoError(t, err)
+	defer n2.(*Network).Stop()
+
+	received := make(chan *handel.Packet, 1)
+	n2.RegisterListener(handel.ListenFunc(func(p *handel.Packet) {
+		received <- p
+	}))
+
+	// a 4KB signature does not fit in a single datagram
+	sig := bytes.Repeat([]byte{0x01, 0x02}, 2048)
+	id2, _ := reg.Identity(1)
+	n1.Send([]handel.Identity{id2}, &handel.Packet{Origin: 0, Level: 3, MultiSig: sig})
+
+	select {
+	case p := <-received:
+		require.Equal(t, int32(0), p.Origin)
+		require.Equal(t, byte(3), p.Level)
+		require.Equal(t, sig, p.MultiSig)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("fragmented packet not received")
+	}
+
+	_, err = NewUDPNetwork("127.0.0.1:3102", reg)
+	require.Error(t, err)
+	_, err = NewNetwork("127.0.0.1:3102", reg, network.NewGOBEncoding(), headerSize, DefaultFragmentTimeout)
+	require.Error(t, err)
+}
+
+func TestUDPNetworkPendingPerSender(t *testing.T) {
+	reg := testRegistry("127.0.0.1:3103", "127.0.0.1:3104")
+	n, err := NewNetwork("127.0.0.1:3103", reg, network.NewGOBEncoding(), DefaultMTU, time.Minute)
+	require.NoError(t, err)
+	defer n.Stop()
+
+	// the first fragment of many packets of the same sender, each sent from
+	// another source port
+	dst, err := net.ResolveUDPAddr("udp4", "127.0.0.1:3103")
+	require.NoError(t, err)
+	sent := 2 * maxPendingPerSender
+	for seq := 1; seq <= sent; seq++ {
+		sock, err := net.DialUDP("udp", nil, dst)
+		require.NoError(t, err)
+		_, err = sock.Write(forged(1, uint32(seq), 0, 2))
+		require.NoError(t, err)
+		sock.Close()
+	}
+
+	// the last datagrams are read once all of them are, so wait until the
+	// last one dropped the first
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		n.reasm.Lock()
+		_, last := n.reasm.pending[fragmentKey{1, uint32(sent)}]
+		n.reasm.Unlock()
+		if last {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, maxPendingPerSender, n.reasm.len())
+}
@@ -0,0 +1,169 @@
+package handel
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Observer reconstructs the best multi-signature out of the packets received
+// by a Handel node, without taking part in the protocol: it does not send
+// anything and does not need any key. It is meant for passive monitoring of an
+// aggregation. The Observer stores each packet as the first node of its level
+// would, with the partitioner given by Config.NewPartitioner, and the best
+// multi-signature is the best full signature of these nodes.
+// Observer is thread-safe.
+type Observer struct {
+	sync.Mutex
+	reg  Registry
+	cons Constructor
+	msg  []byte
+	c    *Config
+	log  Logger
+	// partitioner and store of each node the packets are stored for
+	nodes map[int32]*observedNode
+}
+
+// observedNode is the view of a node of the aggregation by the Observer
+type observedNode struct {
+	part  Partitioner
+	store SignatureStore
+}
+
+// NewObserver returns an Observer of the aggregation of the given message by
+// the nodes of the registry. If conf is nil, the default config is used.
+func NewObserver(reg Registry, cons Constructor, msg []byte, conf *Config) *Observer {
+	var config *Config
+	if conf != nil {
+		config = mergeWithDefault(conf, reg.Size())
+	} else {
+		config = DefaultConfig(reg.Size())
+	}
+	return &Observer{
+		reg:   reg,
+		cons:  cons,
+		msg:   msg,
+		c:     config,
+		log:   config.Logger.With("observer", true),
+		nodes: make(map[int32]*observedNode),
+	}
+}
+
+// Feed verifies the signatures contained in the packet and keeps them if they
+// improve the best multi-signature of the Observer.
+func (o *Observer) Feed(p *Packet) {
+	o.Lock()
+	defer o.Unlock()
+	if err := o.feed(p); err != nil {
+		o.log.Warn("invalid_packet", err)
+	}
+}
+
+func (o *Observer) feed(p *Packet) error {
+	if err := p.checkVersion(); err != nil {
+		return err
+	}
+	if p.Origin < 0 || p.Origin >= int32(o.reg.Size()) {
+		return fmt.Errorf("%w: origin %d", ErrOriginOutOfRange, p.Origin)
+	}
+	origin := o.node(p.Origin)
+	level := int(p.Level)
+	if level < 1 || level > origin.part.MaxLevel() {
+		return fmt.Errorf("%w: level %d", ErrLevelOutOfRange, p.Level)
+	}
+	// the multi-signature covers the levels of the origin below the level of
+	// the packet, which is the level of the packet of the nodes it is sent to
+	ids, err := origin.part.IdentitiesAt(level)
+	if err != nil || len(ids) == 0 {
+		return fmt.Errorf("%w: level %d", ErrLevelOutOfRange, p.Level)
+	}
+	dst := o.node(ids[0].ID())
+	ms := new(MultiSignature)
+	if err := ms.Unmarshal(p.MultiSig, o.cons.Signature(), o.c.NewBitSet); err != nil {
+		return fmt.Errorf("%w: multisig: %s", ErrUnmarshal, err)
+	}
+	if ms.None() {
+		return fmt.Errorf("%w: no signature in the bitset", ErrUnmarshal)
+	}
+	if err := o.keep(dst, &incomingSig{origin: p.Origin, level: p.Level, ms: ms}); err != nil {
+		return err
+	}
+
+	if p.IndividualSig == nil {
+		return nil
+	}
+	ind := o.cons.Signature()
+	if err := ind.UnmarshalBinary(p.IndividualSig); err != nil {
+		return fmt.Errorf("%w: individual signature: %s", ErrUnmarshal, err)
+	}
+	idx, err := dst.part.IndexAtLevel(p.Origin, level)
+	if err != nil {
+		return err
+	}
+	bs := o.c.NewBitSet(dst.part.Size(level))
+	bs.Set(idx, true)
+	err = o.keep(dst, &incomingSig{
+		origin:      p.Origin,
+		level:       p.Level,
+		ms:          &MultiSignature{BitSet: bs, Signature: ind},
+		isInd:       true,
+		mappedIndex: idx,
+	})
+	if err != nil {
+		return err
+	}
+	// the individual signature is the contribution of the origin itself
+	own, err := origin.part.IndexAtLevel(p.Origin, 0)
+	if err != nil {
+		return err
+	}
+	bs = o.c.NewBitSet(origin.part.Size(0))
+	bs.Set(own, true)
+	return o.keep(origin, &incomingSig{origin: p.Origin, ms: &MultiSignature{BitSet: bs, Signature: ind}})
+}
+
+// node returns the view of the node with the given ID, created at its first
+// packet.
+func (o *Observer) node(id int32) *observedNode {
+	n, exists := o.nodes[id]
+	if !exists {
+		part := o.c.NewPartitioner(id, o.reg, o.log)
+		n = &observedNode{part: part, store: newStore(part, o.c.NewBitSet, o.cons)}
+		o.nodes[id] = n
+	}
+	return n
+}
+
+// keep verifies the signature and stores it in the store of the node if it
+// improves it.
+func (o *Observer) keep(n *observedNode, sp *incomingSig) error {
+	if sp.ms.BitLength() != n.part.Size(int(sp.level)) {
+		return fmt.Errorf("%w: invalid bitset's size %d for level %d", ErrUnmarshal, sp.ms.BitLength(), sp.level)
+	}
+	if n.store.Evaluate(sp) == 0 {
+		return nil
+	}
+	if err := verifySignature(sp, o.msg, n.part, o.cons, nil); err != nil {
+		return err
+	}
+	n.store.Store(sp)
+	return nil
+}
+
+// Best returns the best full multi-signature that can be built out of the
+// packets fed so far, or nil if there is none.
+func (o *Observer) Best() *MultiSignature {
+	o.Lock()
+	defer o.Unlock()
+	var best *MultiSignature
+	for _, n := range o.nodes {
+		full := n.store.FullSignature()
+		if full == nil {
+			continue
+		}
+		if best == nil || full.Cardinality() > best.Cardinality() ||
+			(full.Cardinality() == best.Cardinality() && bitsetLess(full.BitSet, best.BitSet)) {
+			best = full
+		}
+	}
+	return best
+}
@@ -0,0 +1,72 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserver(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	o := NewObserver(reg, new(fakeCons), msg, nil)
+	require.Nil(t, o.Best())
+
+	packet := func(origin int32, level byte, ms *MultiSignature, ind Signature) *Packet {
+		buff, err := ms.MarshalBinary()
+		require.NoError(t, err)
+		p := &Packet{Origin: origin, Level: level, MultiSig: buff}
+		if ind != nil {
+			p.IndividualSig, err = ind.MarshalBinary()
+			require.NoError(t, err)
+		}
+		return p
+	}
+
+	// invalid packets are ignored
+	o.Feed(packet(int32(n), 1, fullSig(1), nil))
+	o.Feed(packet(2, 1, fullSig(2), nil))
+	o.Feed(packet(0, 1, &MultiSignature{BitSet: finalBitset(1), Signature: &fakeSig{false}}, nil))
+	require.Nil(t, o.Best())
+
+	// stream received by node 0
+	o.Feed(packet(1, 1, fullSig(1), nil))
+	require.Equal(t, 1, o.Best().Cardinality())
+	// signature from 5 covering [4,8) without its own contribution, merged
+	// with its individual signature
+	partial := fullSig(3)
+	partial.BitSet.Set(1, false)
+	o.Feed(packet(5, 3, partial, &fakeSig{true}))
+	best := o.Best()
+	require.Equal(t, 5, best.Cardinality())
+	require.True(t, best.Get(5))
+	o.Feed(packet(2, 2, fullSig(2), nil))
+	best = o.Best()
+	require.Equal(t, 7, best.Cardinality())
+	require.Equal(t, n, best.BitLength())
+	require.False(t, best.Get(0))
+
+	// the individual signature of node 0 completes its own contribution
+	o.Feed(packet(0, 1, fullSig(1), &fakeSig{true}))
+	require.Equal(t, n, o.Best().Cardinality())
+}
+
+func TestObserverPartitioner(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	var ids []int32
+	conf := &Config{NewPartitioner: func(id int32, reg Registry, logger Logger) Partitioner {
+		ids = append(ids, id)
+		return NewBinPartitioner(id, reg, logger)
+	}}
+	o := NewObserver(reg, new(fakeCons), msg, conf)
+	buff, err := fullSig(2).MarshalBinary()
+	require.NoError(t, err)
+	o.Feed(&Packet{Origin: 6, Level: 2, MultiSig: buff})
+	// the partitioner of the origin and of the first node of the level
+	require.Equal(t, []int32{6, 4}, ids)
+	best := o.Best()
+	require.Equal(t, 2, best.Cardinality())
+	require.True(t, best.Get(6))
+	require.True(t, best.Get(7))
+}
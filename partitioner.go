@@ -1,9 +1,13 @@
 package handel
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 )
 
 // Partitioner is a generic interface holding the logic used to partition the
@@ -26,7 +30,9 @@ type Partitioner interface {
 	Levels() []int
 
 	// IdentitiesAt returns the list of Identity that composes the whole level
-	// in this partition scheme.
+	// in this partition scheme. The levels must be disjoint and must not
+	// include the node itself, whose contribution is only at level 0, so a
+	// contribution is never aggregated twice in the full signature.
 	IdentitiesAt(level int) ([]Identity, error)
 
 	// IndexAtLevel returns the index inside the given level of the given global
@@ -294,3 +300,62 @@ func (c *binomialPartitioner) combineSize(sigs []*incomingSig, bs BitSet, combin
 		Signature: finalSig,
 	}
 }
+
+// candidateOrderer is implemented by the partitioners giving the order in
+// which the peers of each level are contacted, instead of the order of
+// IdentitiesAt.
+type candidateOrderer interface {
+	// Candidates returns the identities of the level in the order in which
+	// they are contacted.
+	Candidates(level int) ([]Identity, error)
+}
+
+// ShufflePartitioner is a binomial Partitioner whose peers of each level are
+// contacted in a pseudo-random order derived from a seed, e.g. a nonce shared
+// by the nodes of a session, instead of the registry order. The identities are
+// ordered by the hash of the seed and of their ID, so all the nodes using the
+// same seed agree on the order of the peers of a level. The levels and the
+// bitsets are the ones of the binomial partitioner.
+type ShufflePartitioner struct {
+	Partitioner
+	seed []byte
+}
+
+// NewShufflePartitioner returns a constructor of ShufflePartitioner with the
+// given seed, to use as Config.NewPartitioner. The order it gives takes
+// precedence over Config.ShuffleCandidates and Config.DisableShuffling.
+func NewShufflePartitioner(seed []byte) func(id int32, reg Registry, logger Logger) Partitioner {
+	return func(id int32, reg Registry, logger Logger) Partitioner {
+		return &ShufflePartitioner{
+			Partitioner: NewBinPartitioner(id, reg, logger),
+			seed:        seed,
+		}
+	}
+}
+
+// Candidates returns the identities of the level ordered by the hash of the
+// seed and of their ID.
+func (s *ShufflePartitioner) Candidates(level int) ([]Identity, error) {
+	ids, err := s.IdentitiesAt(level)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[int32][]byte, len(ids))
+	for _, id := range ids {
+		keys[id.ID()] = s.key(id.ID())
+	}
+	ordered := make([]Identity, len(ids))
+	copy(ordered, ids)
+	sort.Slice(ordered, func(i, j int) bool {
+		return bytes.Compare(keys[ordered[i].ID()], keys[ordered[j].ID()]) < 0
+	})
+	return ordered, nil
+}
+
+// key returns the sort key of the given ID.
+func (s *ShufflePartitioner) key(id int32) []byte {
+	h := sha256.New()
+	h.Write(s.seed)
+	binary.Write(h, binary.BigEndian, id)
+	return h.Sum(nil)
+}
@@ -413,3 +413,46 @@ func TestIsSet(t *testing.T) {
 		require.Equal(t, test.expected, res, "%d - failed: %v", i, test)
 	}
 }
+
+func TestShufflePartitioner(t *testing.T) {
+	n := 64
+	reg := FakeRegistry(n)
+	newPart := func(seed string, id int32) Partitioner {
+		return NewShufflePartitioner([]byte(seed))(id, reg, DefaultLogger)
+	}
+	order := func(p Partitioner, level int) []int32 {
+		ids, err := p.(*ShufflePartitioner).Candidates(level)
+		require.NoError(t, err)
+		var res []int32
+		for _, id := range ids {
+			res = append(res, id.ID())
+		}
+		return res
+	}
+	bin := NewBinPartitioner(1, reg, DefaultLogger)
+	p1, p2, p3 := newPart("nonce", 1), newPart("nonce", 1), newPart("other", 1)
+	require.Equal(t, bin.Levels(), p1.Levels())
+	for _, level := range bin.Levels() {
+		ids, _ := bin.IdentitiesAt(level)
+		sids, _ := p1.IdentitiesAt(level)
+		require.Equal(t, ids, sids)
+		require.Equal(t, order(p1, level), order(p2, level))
+		require.ElementsMatch(t, order(p1, level), order(p3, level))
+		if len(ids) > 2 {
+			require.NotEqual(t, order(p1, level), order(p3, level))
+		}
+	}
+	// the nodes 1 and 2 have the same peers at the level 6, in the same order
+	require.Equal(t, order(p1, 6), order(newPart("nonce", 2), 6))
+
+	// the levels follow the order
+	c := DefaultConfig(n)
+	lvls := createLevels(c, 1, p1)
+	for _, level := range p1.Levels() {
+		var ids []int32
+		for _, id := range lvls[level].nodes {
+			ids = append(ids, id.ID())
+		}
+		require.Equal(t, order(p1, level), ids)
+	}
+}
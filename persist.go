@@ -0,0 +1,243 @@
+package handel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// walCompactFactor is the number of records per level the write-ahead log of
+// a walStore can hold before being compacted.
+const walCompactFactor = 4
+
+// walStore is a SignatureStore persisting the signatures stored in a
+// write-ahead log, so a restarted node recovers them, see Config.StorePath.
+// Each time the best signature of a level changes, the level and the new
+// signature are appended to the log. Only the last record of each level
+// matters, so the log is compacted once it holds walCompactFactor records per
+// level. The log starts with the hash of the message, so the log of another
+// round is discarded. It survives a crash of the process, not of the machine,
+// since the writes are not synced.
+type walStore struct {
+	SignatureStore
+	sync.Mutex
+	path   string
+	header []byte
+	f      *os.File
+	w      *bufio.Writer
+	// last record of each level
+	last    map[byte][]byte
+	records int
+	log     Logger
+}
+
+// newWALStore returns a walStore persisting the signatures stored in s to the
+// log at the given path, after recovering in s the signatures of the log, if
+// any, for the same message.
+func newWALStore(s SignatureStore, path string, msg []byte, c Constructor, nbs func(int) BitSet, log Logger) (*walStore, error) {
+	header := sha256.Sum256(msg)
+	w := &walStore{
+		SignatureStore: s,
+		path:           path,
+		header:         header[:],
+		last:           make(map[byte][]byte),
+		log:            log,
+	}
+	last, err := readWAL(path, w.header)
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := unmarshalWAL(last, c, nbs)
+	if err != nil {
+		return nil, err
+	}
+	levels := make([]int, 0, len(sigs))
+	for lvl := range sigs {
+		levels = append(levels, lvl)
+	}
+	sort.Ints(levels)
+	for _, lvl := range levels {
+		s.Store(&incomingSig{level: byte(lvl), ms: sigs[lvl]})
+	}
+	w.last = last
+	if err := w.compact(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Recover returns by level the signatures persisted with Config.StorePath in
+// the write-ahead log at the given path, for the given message. A missing log,
+// or the log of another message, has no signature. Handel recovers them itself
+// when started with the same Config.StorePath; Recover allows to inspect them
+// without starting Handel, e.g. after a crash. A nil nbs means DefaultBitSet.
+func Recover(path string, msg []byte, c Constructor, nbs func(int) BitSet) (map[int]*MultiSignature, error) {
+	if nbs == nil {
+		nbs = DefaultBitSet
+	}
+	header := sha256.Sum256(msg)
+	last, err := readWAL(path, header[:])
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalWAL(last, c, nbs)
+}
+
+// unmarshalWAL returns the signatures of the records of each level.
+func unmarshalWAL(last map[byte][]byte, c Constructor, nbs func(int) BitSet) (map[int]*MultiSignature, error) {
+	sigs := make(map[int]*MultiSignature, len(last))
+	for lvl, record := range last {
+		ms := new(MultiSignature)
+		if err := ms.Unmarshal(record, c.Signature(), nbs); err != nil {
+			return nil, err
+		}
+		sigs[int(lvl)] = ms
+	}
+	return sigs, nil
+}
+
+// readWAL returns the last record of each level of the log at the given path.
+// A missing log, or the log of another message, has no record. A record
+// truncated by a crash is ignored, as a record longer than the rest of the
+// log, so a corrupt length does not make it allocate more than the log size.
+func readWAL(path string, header []byte) (map[byte][]byte, error) {
+	last := make(map[byte][]byte)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return last, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	read := make([]byte, len(header))
+	if _, err := io.ReadFull(r, read); err != nil || !bytes.Equal(read, header) {
+		return last, nil
+	}
+	remaining := info.Size() - int64(len(header))
+	for {
+		var head [5]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return last, nil
+		}
+		remaining -= int64(len(head))
+		length := int64(binary.BigEndian.Uint32(head[1:]))
+		if length > remaining {
+			return last, nil
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return last, nil
+		}
+		remaining -= length
+		last[head[0]] = record
+	}
+}
+
+// Store implements the SignatureStore interface, appending the resulting
+// signature to the log if it is the new best of its level.
+func (w *walStore) Store(sp *incomingSig) *MultiSignature {
+	w.Lock()
+	defer w.Unlock()
+	ms := w.SignatureStore.Store(sp)
+	// our own signature is stored again at startup
+	if ms == nil || w.f == nil || sp.level == 0 {
+		return ms
+	}
+	if best, ok := w.SignatureStore.Best(sp.level); !ok || best != ms {
+		return ms
+	}
+	if err := w.append(sp.level, ms); err != nil {
+		w.log.Error("wal", err)
+	}
+	return ms
+}
+
+// setPrefix implements the prefixStore interface, if the inner store does.
+func (w *walStore) setPrefix(level byte, ms *MultiSignature) {
+	if ps, ok := w.SignatureStore.(prefixStore); ok {
+		ps.setPrefix(level, ms)
+	}
+}
+
+func (w *walStore) append(level byte, ms *MultiSignature) error {
+	buff, err := ms.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	writeRecord(w.w, level, buff)
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	w.last[level] = buff
+	w.records++
+	if w.records > walCompactFactor*len(w.last) {
+		return w.compact()
+	}
+	return nil
+}
+
+func writeRecord(w io.Writer, level byte, record []byte) {
+	var head [5]byte
+	head[0] = level
+	binary.BigEndian.PutUint32(head[1:], uint32(len(record)))
+	w.Write(head[:])
+	w.Write(record)
+}
+
+// compact rewrites the log with the last record of each level only, and opens
+// it to append the next records.
+func (w *walStore) compact() error {
+	if w.f != nil {
+		w.f.Close()
+		w.f = nil
+	}
+	tmp := w.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	bw.Write(w.header)
+	for level, record := range w.last {
+		writeRecord(bw, level, record)
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return err
+	}
+	w.records = len(w.last)
+	if w.f, err = os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+	w.w = bufio.NewWriter(w.f)
+	return nil
+}
+
+// Close closes the log. The signatures stored afterwards are not persisted.
+func (w *walStore) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.f == nil {
+		return errors.New("handel: write-ahead log already closed")
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,103 @@
+package handel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALStore(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	path := filepath.Join(t.TempDir(), "store")
+	open := func(msg []byte) (*store, *walStore) {
+		st := newStore(part, NewWilffBitset, new(fakeCons))
+		w, err := newWALStore(st, path, msg, new(fakeCons), NewWilffBitset, DefaultLogger)
+		require.NoError(t, err)
+		return st, w
+	}
+
+	_, w := open(msg)
+	for _, lvl := range []int{0, 1, 3} {
+		require.NotNil(t, w.Store(fullIncomingSig(lvl)))
+	}
+	full := w.FullSignature()
+	// crash: the file is not closed, and our own signature is stored again
+	st, w2 := open(msg)
+	st.Store(fullIncomingSig(0))
+	require.Equal(t, full.BitSet, st.FullSignature().BitSet)
+	for _, lvl := range []int{1, 3} {
+		_, ok := st.Best(byte(lvl))
+		require.True(t, ok)
+	}
+	_, ok := st.Best(2)
+	require.False(t, ok)
+
+	// the log is compacted to the last record of each level
+	for i := 0; i < walCompactFactor*4; i++ {
+		w2.Store(fullIncomingSig(2))
+		w2.Store(fullIncomingSig(4))
+	}
+	require.True(t, w2.records <= walCompactFactor*len(w2.last))
+	require.NoError(t, w2.Close())
+	require.Error(t, w2.Close())
+	last, err := readWAL(path, w2.header)
+	require.NoError(t, err)
+	require.Len(t, last, 4)
+
+	// a truncated record is ignored
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-1))
+	last, err = readWAL(path, w2.header)
+	require.NoError(t, err)
+	require.Len(t, last, 3)
+
+	// a corrupt length larger than the log is a corrupt tail
+	corrupt := filepath.Join(t.TempDir(), "corrupt")
+	buff := append(append([]byte{}, w2.header...), 1, 0xff, 0xff, 0xff, 0xff, 0)
+	require.NoError(t, os.WriteFile(corrupt, buff, 0600))
+	last, err = readWAL(corrupt, w2.header)
+	require.NoError(t, err)
+	require.Empty(t, last)
+
+	// the log of another message is discarded
+	st, w3 := open([]byte("another message"))
+	defer w3.Close()
+	_, ok = st.Best(1)
+	require.False(t, ok)
+}
+
+func TestHandelStorePath(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := DefaultConfig(n)
+	conf.StorePath = filepath.Join(t.TempDir(), "store")
+	h, err := NewHandelErr(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	require.NoError(t, err)
+	h.store.Store(fullIncomingSig(1))
+	h.store.Store(fullIncomingSig(2))
+	card := h.store.FullSignature().Cardinality()
+	require.Equal(t, 4, card)
+	h.Stop()
+
+	// the signatures of the log can be recovered without Handel
+	sigs, err := Recover(conf.StorePath, msg, new(fakeCons), nil)
+	require.NoError(t, err)
+	require.Len(t, sigs, 2)
+	require.Equal(t, 1, sigs[1].Cardinality())
+	require.Equal(t, 2, sigs[2].Cardinality())
+	sigs, err = Recover(conf.StorePath, []byte("another message"), new(fakeCons), nil)
+	require.NoError(t, err)
+	require.Empty(t, sigs)
+
+	// restarted node
+	h, err = NewHandelErr(&TestNetwork{}, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	require.NoError(t, err)
+	defer h.Stop()
+	require.Equal(t, card, h.store.FullSignature().Cardinality())
+}
@@ -0,0 +1,50 @@
+package handel
+
+import "sync"
+
+// SignaturePool is an optional interface of the constructors able to reuse the
+// signatures Handel unmarshalled and then discarded, e.g. the signatures of
+// invalid packets or of signatures not worth verifying, to reduce the
+// allocations on the receiving path. Handel never uses a signature again once
+// it has been released.
+type SignaturePool interface {
+	Constructor
+	// Release gives back a signature returned by Signature which is not used
+	// anymore.
+	Release(Signature)
+}
+
+// pooledConstructor is a SignaturePool keeping the released signatures in a
+// sync.Pool.
+type pooledConstructor struct {
+	Constructor
+	pool sync.Pool
+}
+
+// NewPooledConstructor returns a SignaturePool creating its signatures with
+// the given constructor and reusing the ones released by Handel. Since a
+// signature can be reused, the UnmarshalBinary method of the signatures must
+// overwrite all their state.
+func NewPooledConstructor(c Constructor) SignaturePool {
+	p := &pooledConstructor{Constructor: c}
+	p.pool.New = func() interface{} {
+		return c.Signature()
+	}
+	return p
+}
+
+func (p *pooledConstructor) Signature() Signature {
+	return p.pool.Get().(Signature)
+}
+
+func (p *pooledConstructor) Release(s Signature) {
+	p.pool.Put(s)
+}
+
+// release gives back the signature to the constructor if it is a
+// SignaturePool.
+func release(c Constructor, s Signature) {
+	if p, ok := c.(SignaturePool); ok && s != nil {
+		p.Release(s)
+	}
+}
@@ -0,0 +1,112 @@
+package handel
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingPool is a SignaturePool recording the released signatures
+type recordingPool struct {
+	Constructor
+	sync.Mutex
+	released []Signature
+}
+
+func (r *recordingPool) Release(s Signature) {
+	r.Lock()
+	defer r.Unlock()
+	r.released = append(r.released, s)
+}
+
+func TestHandelReleaseSignatures(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	pool := &recordingPool{Constructor: new(fakeCons)}
+	// identical packets must reach the processing
+	conf := &Config{NewTimeoutStrategy: newInfiniteTimeout, DedupCacheSize: -1}
+	h := NewHandel(new(levelNetwork), reg, id, pool, msg, &fakeSig{true}, conf)
+	defer h.Stop()
+	packet := func(level byte, ms *MultiSignature, ind Signature) *Packet {
+		buff, err := ms.MarshalBinary()
+		require.NoError(t, err)
+		p := &Packet{Origin: 2, Level: level, MultiSig: buff}
+		if ind != nil {
+			p.IndividualSig, err = ind.MarshalBinary()
+			require.NoError(t, err)
+		}
+		return p
+	}
+
+	// invalid packet
+	h.NewPacket(packet(2, fullSig(1), nil))
+	require.Len(t, pool.released, 1)
+
+	// duplicate individual signature dropped by the processing
+	h.NewPacket(packet(2, fullSig(2), &fakeSig{true}))
+	require.Len(t, pool.released, 1)
+	h.NewPacket(packet(2, fullSig(2), &fakeSig{true}))
+	require.Len(t, pool.released, 2)
+
+	// packet for a completed level
+	h.store.Store(fullIncomingSig(2))
+	h.Lock()
+	h.checkCompletedLevel(fullIncomingSig(2))
+	h.Unlock()
+	h.NewPacket(packet(2, fullSig(2), &fakeSig{true}))
+	require.Len(t, pool.released, 4)
+}
+
+// blobSig is a signature whose buffer is reused when unmarshalling
+type blobSig struct {
+	b []byte
+}
+
+func (s *blobSig) MarshalBinary() ([]byte, error) { return s.b, nil }
+func (s *blobSig) UnmarshalBinary(b []byte) error {
+	s.b = append(s.b[:0], b...)
+	return nil
+}
+func (s *blobSig) Combine(Signature) Signature { return s }
+
+type blobCons struct{}
+
+func (b *blobCons) Signature() Signature { return new(blobSig) }
+func (b *blobCons) PublicKey() PublicKey { return &fakePublic{true} }
+
+// BenchmarkUnmarshalPooled measures the allocations of unmarshalling the
+// multi-signatures of the largest level of a node at n=1000, with and without
+// reusing the signatures.
+func BenchmarkUnmarshalPooled(b *testing.B) {
+	n := 1000
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	size := part.Size(part.MaxLevel())
+	ms := &MultiSignature{BitSet: finalBitset(size), Signature: &blobSig{make([]byte, 48)}}
+	buff, err := ms.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchs := []struct {
+		name string
+		cons Constructor
+	}{
+		{"unpooled", new(blobCons)},
+		{"pooled", NewPooledConstructor(new(blobCons))},
+	}
+	for _, bench := range benchs {
+		cons := bench.cons
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := new(MultiSignature)
+				s := cons.Signature()
+				if err := m.Unmarshal(buff, s, NewWilffBitset); err != nil {
+					b.Fatal(err)
+				}
+				release(cons, s)
+			}
+		})
+	}
+}
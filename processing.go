@@ -5,6 +5,8 @@ package handel
 // interface, and may be returned to main Handel logic when verified.
 
 import (
+	"container/heap"
+	"container/list"
 	"errors"
 	"fmt"
 	"sync"
@@ -22,6 +24,9 @@ type incomingSig struct {
 	// mapped index of the origin to the level's range - only useful when this
 	// signature is an individual signature.
 	mappedIndex int
+	// the packet of the signature had no individual signature: its origin
+	// completed the level containing our contribution
+	selfAck bool
 }
 
 // Individual returns true if this incoming sig is an individual signature
@@ -120,9 +125,42 @@ type evaluatorProcessing struct {
 
 	// Time spent checking the signature
 	sigCheckingTime int
+
+	// maximum number of signatures dropped by the evaluator kept around to
+	// be verified later on if Handel stalls. 0 means none are kept.
+	retrySize int
+	// signatures recently dropped by the evaluator, oldest first
+	dropped []*incomingSig
+	// signatures to verify without evaluation, filled by Retry
+	retries []*incomingSig
+
+	// spaces out the verifications
+	pacer pacer
+
+	// cache of aggregate public keys, nil if disabled
+	apks *apkCache
+
+	// last multi-signature verified at each level, to verify the supersets
+	// of subtractable signatures by their delta
+	verified map[byte]*MultiSignature
+	// number of signatures verified by their delta
+	sigDeltaCt int
+
+	// called when there are new signatures to verify, when driven by a
+	// SharedScheduler
+	wake func()
+
+	// time spent in the verifications of the signatures
+	verifyTimes verifyStats
 }
 
-func newEvaluatorProcessing(part Partitioner, c Constructor, msg []byte, sigSleepTime int, e SigEvaluator, log Logger) signatureProcessing {
+// newEvaluatorProcessing returns a processing verifying the signatures in the
+// order given by the evaluator. It takes from the config the sleep time of
+// each verification, the size of the retry buffer, the pacing of the
+// verifications and the size of the aggregate public keys cache, see
+// Config.UnsafeSleepTimeOnSigVerify, Config.RetryBufferSize,
+// Config.VerifyPacing and Config.APKCacheSize.
+func newEvaluatorProcessing(part Partitioner, c Constructor, msg []byte, conf *Config, e SigEvaluator, log Logger) signatureProcessing {
 	m := sync.Mutex{}
 
 	ev := &evaluatorProcessing{
@@ -130,7 +168,11 @@ func newEvaluatorProcessing(part Partitioner, c Constructor, msg []byte, sigSlee
 		part:         part,
 		cons:         c,
 		msg:          msg,
-		sigSleepTime: int64(sigSleepTime),
+		sigSleepTime: int64(conf.UnsafeSleepTimeOnSigVerify),
+		retrySize:    conf.RetryBufferSize,
+		pacer:        pacer{interval: conf.VerifyPacing},
+		apks:         newAPKCache(conf.APKCacheSize),
+		verified:     make(map[byte]*MultiSignature),
 
 		out:       make(chan incomingSig, 1000),
 		todos:     make([]*incomingSig, 0),
@@ -163,6 +205,24 @@ func (f *evaluatorProcessing) Add(sp *incomingSig) {
 	if f.filter.Accept(sp) {
 		f.todos = append(f.todos, sp)
 		f.cond.Signal()
+		f.signalWake()
+	} else if sp.ms != nil {
+		release(f.cons, sp.ms.Signature)
+	}
+}
+
+// setWake implements the scheduledProcessing interface.
+func (f *evaluatorProcessing) setWake(wake func()) {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	f.wake = wake
+}
+
+// signalWake calls the wake function if any. It must be called with the lock
+// held.
+func (f *evaluatorProcessing) signalWake() {
+	if f.wake != nil {
+		f.wake()
 	}
 }
 
@@ -171,9 +231,23 @@ func (f *evaluatorProcessing) Add(sp *incomingSig) {
 func (f *evaluatorProcessing) readTodos() (bool, *incomingSig) {
 	f.cond.L.Lock()
 	defer f.cond.L.Unlock()
-	for len(f.todos) == 0 {
+	for len(f.todos) == 0 && len(f.retries) == 0 {
 		f.cond.Wait()
 	}
+	return f.unsafeReadTodos()
+}
+
+// unsafeReadTodos selects the signature to process first among the pending
+// ones. It must be called with the lock held.
+func (f *evaluatorProcessing) unsafeReadTodos() (bool, *incomingSig) {
+	if len(f.retries) > 0 {
+		// signatures to retry have already been evaluated once, we verify
+		// them directly
+		retry := f.retries[0]
+		f.retries = f.retries[1:]
+		f.sigCheckedCt++
+		return false, retry
+	}
 
 	previousLen := len(f.todos)
 
@@ -202,6 +276,8 @@ func (f *evaluatorProcessing) readTodos() (bool, *incomingSig) {
 				best = pair
 				bestMark = mark
 			}
+		} else {
+			f.keepDropped(pair)
 		}
 	}
 
@@ -219,6 +295,57 @@ func (f *evaluatorProcessing) readTodos() (bool, *incomingSig) {
 	return false, best
 }
 
+// keepDropped saves the given signature in the bounded buffer of dropped
+// signatures, evicting the oldest one if the buffer is full. It must be called
+// with the lock held.
+func (f *evaluatorProcessing) keepDropped(sp *incomingSig) {
+	if f.retrySize <= 0 {
+		release(f.cons, sp.ms.Signature)
+		return
+	}
+	if len(f.dropped) >= f.retrySize {
+		release(f.cons, f.dropped[0].ms.Signature)
+		f.dropped = f.dropped[1:]
+	}
+	f.dropped = append(f.dropped, sp)
+}
+
+// Retry implements the retrier interface. It re-submits all the signatures
+// recently dropped by the evaluator for verification and returns how many
+// there were.
+func (f *evaluatorProcessing) Retry() int {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	n := len(f.dropped)
+	if n == 0 {
+		return 0
+	}
+	f.retries = append(f.retries, f.dropped...)
+	f.dropped = nil
+	f.cond.Signal()
+	f.signalWake()
+	return n
+}
+
+// pending implements the pendingProcessing interface. The death pill stays
+// queued so a stopped processing still stops.
+func (f *evaluatorProcessing) pending() []*incomingSig {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	pending := f.retries
+	f.retries = nil
+	var kept []*incomingSig
+	for _, sp := range f.todos {
+		if *sp == deathPillPair {
+			kept = append(kept, sp)
+			continue
+		}
+		pending = append(pending, sp)
+	}
+	f.todos = kept
+	return pending
+}
+
 func (f *evaluatorProcessing) hasTodos() bool {
 	f.cond.L.Lock()
 	defer f.cond.L.Unlock()
@@ -252,6 +379,7 @@ func (f *evaluatorProcessing) Values() map[string]float64 {
 		"sigQueueSize":    sigQueueSize,
 		"sigSuppressed":   float64(f.sigSuppressed),
 		"sigCheckingTime": sigCheckingTime,
+		"sigDeltaCt":      float64(f.sigDeltaCt),
 	}
 }
 
@@ -267,11 +395,35 @@ func (f *evaluatorProcessing) processStep() bool {
 	return false
 }
 
+// tryStep implements the scheduledProcessing interface. It is the processStep
+// of the processing driven by a SharedScheduler, which does not wait for
+// signatures.
+func (f *evaluatorProcessing) tryStep() bool {
+	f.cond.L.Lock()
+	if len(f.todos) == 0 && len(f.retries) == 0 {
+		f.cond.L.Unlock()
+		return false
+	}
+	done, best := f.unsafeReadTodos()
+	f.cond.L.Unlock()
+	if done {
+		close(f.out)
+		return false
+	}
+	if best == nil {
+		return false
+	}
+	f.verifyAndPublish(best)
+	return true
+}
+
 func (f *evaluatorProcessing) verifyAndPublish(sp *incomingSig) {
+	f.pacer.wait()
 	startTime := time.Now()
 	err := (error)(nil)
 	if f.sigSleepTime <= 0 {
-		err = verifySignature(sp, f.msg, f.part, f.cons)
+		err = f.verify(sp)
+		f.verifyTimes.add(time.Since(startTime))
 	} else {
 		time.Sleep(time.Duration(f.sigSleepTime * 1000000))
 	}
@@ -286,6 +438,130 @@ func (f *evaluatorProcessing) verifyAndPublish(sp *incomingSig) {
 	}
 }
 
+// VerificationStats implements the verificationTimer interface.
+func (f *evaluatorProcessing) VerificationStats() (int, time.Duration, time.Duration) {
+	return f.verifyTimes.get()
+}
+
+// verify verifies the signature. A multi-signature which is a strict superset
+// of the last one verified at its level is verified by the contributions it
+// adds only, if its signature is a SubtractableSignature.
+func (f *evaluatorProcessing) verify(sp *incomingSig) error {
+	if sp.Individual() {
+		return verifySignature(sp, f.msg, f.part, f.cons, f.apks)
+	}
+	var err error
+	if delta, ok := f.delta(sp); ok {
+		f.sigDeltaCt++
+		err = verifySignature(delta, f.msg, f.part, f.cons, nil)
+	} else {
+		err = verifySignature(sp, f.msg, f.part, f.cons, f.apks)
+	}
+	if err == nil {
+		f.verified[sp.level] = sp.ms
+	}
+	return err
+}
+
+// delta returns the contributions the signature adds to the last one verified
+// at its level, if the signature can be verified by its delta.
+func (f *evaluatorProcessing) delta(sp *incomingSig) (*incomingSig, bool) {
+	prev, ok := f.verified[sp.level]
+	if !ok || prev.BitLength() != sp.ms.BitLength() {
+		return nil, false
+	}
+	sig, ok := sp.ms.Signature.(SubtractableSignature)
+	if !ok || !sp.ms.IsSuperSet(prev.BitSet) || sp.ms.Cardinality() == prev.Cardinality() {
+		return nil, false
+	}
+	return &incomingSig{
+		origin: sp.origin,
+		level:  sp.level,
+		ms: &MultiSignature{
+			BitSet:    sp.ms.AndNot(prev.BitSet),
+			Signature: sig.Subtract(prev.Signature),
+		},
+	}, true
+}
+
+// retrier is implemented by the signatureProcessing that keep the signatures
+// they dropped without verifying them. Retry re-submits these signatures for
+// verification and returns how many have been re-submitted. Handel calls it
+// when it detects a stall.
+type retrier interface {
+	Retry() int
+}
+
+// pendingProcessing is implemented by the processings able to hand over the
+// signatures they have not verified yet, see Handel.SetProcessing.
+type pendingProcessing interface {
+	// pending removes and returns the signatures waiting for verification,
+	// once the processing is stopped.
+	pending() []*incomingSig
+}
+
+// verificationTimer is implemented by the processings measuring the time spent
+// verifying signatures. VerificationStats returns the number of signatures
+// verified, the total and the maximum time spent verifying one. It must be
+// safe to call concurrently with the processing.
+type verificationTimer interface {
+	VerificationStats() (count int, total time.Duration, max time.Duration)
+}
+
+// pacer spaces out the start of consecutive verifications, see
+// Config.VerifyPacing. It is not thread safe.
+type pacer struct {
+	// minimum interval between the start of two consecutive verifications
+	interval time.Duration
+	// start time of the last verification
+	last time.Time
+}
+
+// wait sleeps until the interval has elapsed since the last verification
+// started, and records the start of a new one.
+func (p *pacer) wait() {
+	if p.interval > 0 {
+		time.Sleep(p.interval - time.Since(p.last))
+	}
+	p.last = time.Now()
+}
+
+// verifyStats records the durations of verifications. It is safe for
+// concurrent use.
+type verifyStats struct {
+	sync.Mutex
+	count int
+	total time.Duration
+	max   time.Duration
+}
+
+func (v *verifyStats) add(d time.Duration) {
+	v.Lock()
+	defer v.Unlock()
+	v.count++
+	v.total += d
+	if d > v.max {
+		v.max = d
+	}
+}
+
+// addBatch records the verification of n signatures at once.
+func (v *verifyStats) addBatch(n int, d time.Duration) {
+	v.Lock()
+	defer v.Unlock()
+	v.count += n
+	v.total += d
+	if per := d / time.Duration(n); per > v.max {
+		v.max = per
+	}
+}
+
+func (v *verifyStats) get() (int, time.Duration, time.Duration) {
+	v.Lock()
+	defer v.Unlock()
+	return v.count, v.total, v.max
+}
+
 // Filter holds the responsibility of filtering out the signatures before they
 // go into the processing queue. It is a preprocessing filter. For example, it
 // can remove individual signatures already stored even before inserting them in
@@ -322,6 +598,28 @@ func (i *individualSigFilter) Accept(inc *incomingSig) bool {
 	return true
 }
 
+// improvementFilter is a filter dropping the multi-signatures whose
+// contributions are all in the best signature stored at their level, so they
+// are not verified for nothing. Individual signatures are always accepted, as
+// the store keeps them to build better signatures. It counts the signatures
+// it drops.
+type improvementFilter struct {
+	store   SignatureStore
+	dropped int
+}
+
+func (i *improvementFilter) Accept(inc *incomingSig) bool {
+	if inc.Individual() {
+		return true
+	}
+	best, ok := i.store.Best(inc.level)
+	if !ok || inc.ms.AndNot(best.BitSet).Any() {
+		return true
+	}
+	i.dropped++
+	return false
+}
+
 // combinedFilter can combine sequentially multiple filter into one. The first
 // filter that returns false makes the combinedFilter returns false immediately
 // as well.
@@ -338,33 +636,133 @@ func (c *combinedFilter) Accept(inc *incomingSig) bool {
 
 // verifySignature returns true if the given signature is valid. The function
 // constructs the aggregate public key from all public keys denoted in the
-// bitset.
-func verifySignature(pair *incomingSig, msg []byte, part Partitioner, cons Constructor) error {
+// bitset, or takes it from the cache if given. The signature is always
+// verified against the message given, never against one carried by the
+// packet, so contributions over another message are rejected.
+func verifySignature(pair *incomingSig, msg []byte, part Partitioner, cons Constructor, cache *apkCache) error {
+	aggregateKey, err := aggregateKeyOf(pair, part, cons, cache)
+	if err != nil {
+		return err
+	}
+	ms := pair.ms
+	if err := aggregateKey.VerifySignature(msg, ms.Signature); err != nil {
+		logf("processing err: from %d -> level %d -> %s", pair.origin, pair.level, ms.String())
+		return fmt.Errorf("handel: %s", err)
+	}
+	return nil
+}
+
+// aggregateKeyOf returns the aggregate public key of all public keys denoted
+// in the bitset of the signature, taken from the cache if given. On a cache
+// miss, a Constructor implementing AggregateKeyer computes it.
+func aggregateKeyOf(pair *incomingSig, part Partitioner, cons Constructor, cache *apkCache) (PublicKey, error) {
 	level := pair.level
 	ms := pair.ms
 	ids, err := part.IdentitiesAt(int(level))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if ms.BitSet.BitLength() != len(ids) {
-		return errors.New("handel: inconsistent bitset with given level")
+		return nil, errors.New("handel: inconsistent bitset with given level")
 	}
 
-	// compute the aggregate public key corresponding to bitset
-	aggregateKey := cons.PublicKey()
-	for i := 0; i < ms.BitSet.BitLength(); i++ {
-		if !ms.BitSet.Get(i) {
-			continue
+	// the key is only computed with a cache, marshalling the bitset is not free
+	var key string
+	var cacheable, cached bool
+	var aggregateKey PublicKey
+	if cache != nil {
+		key, cacheable = apkCacheKey(level, ms.BitSet)
+		aggregateKey, cached = cache.get(key)
+	}
+	if !cached {
+		if keyer, ok := cons.(AggregateKeyer); ok {
+			aggregateKey, err = keyer.AggregatePublicKey(ids, ms.BitSet)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// compute the aggregate public key corresponding to bitset
+			aggregateKey = cons.PublicKey()
+			for i := 0; i < ms.BitSet.BitLength(); i++ {
+				if !ms.BitSet.Get(i) {
+					continue
+				}
+				aggregateKey = aggregateKey.Combine(ids[i].PublicKey())
+			}
 		}
-		aggregateKey = aggregateKey.Combine(ids[i].PublicKey())
+		if cacheable {
+			cache.add(key, aggregateKey)
+		}
+	}
+	return aggregateKey, nil
+}
+
+// apkCache is a LRU cache of the aggregate public keys corresponding to the
+// bitsets verified at each level. A nil apkCache is a valid, always empty,
+// cache. It is not thread-safe.
+type apkCache struct {
+	size int
+	// most recently used entries first
+	ll *list.List
+	m  map[string]*list.Element
+}
+
+type apkEntry struct {
+	key string
+	apk PublicKey
+}
+
+// newAPKCache returns a cache holding up to size aggregate public keys, or nil
+// if size is not positive.
+func newAPKCache(size int) *apkCache {
+	if size <= 0 {
+		return nil
+	}
+	return &apkCache{
+		size: size,
+		ll:   list.New(),
+		m:    make(map[string]*list.Element),
+	}
+}
+
+// apkCacheKey returns the key of the given bitset at the given level. It
+// returns false if the bitset can't be encoded.
+func apkCacheKey(level byte, bs BitSet) (string, bool) {
+	buff, err := bs.MarshalBinary()
+	if err != nil {
+		return "", false
 	}
+	return string(append([]byte{level}, buff...)), true
+}
 
-	if err := aggregateKey.VerifySignature(msg, ms.Signature); err != nil {
-		logf("processing err: from %d -> level %d -> %s", pair.origin, pair.level, ms.String())
-		return fmt.Errorf("handel: %s", err)
+func (c *apkCache) get(key string) (PublicKey, bool) {
+	if c == nil {
+		return nil, false
+	}
+	e, ok := c.m[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*apkEntry).apk, true
+}
+
+func (c *apkCache) add(key string, apk PublicKey) {
+	if c == nil {
+		return
+	}
+	if e, ok := c.m[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*apkEntry).apk = apk
+		return
+	}
+	c.m[key] = c.ll.PushFront(&apkEntry{key, apk})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.m, oldest.Value.(*apkEntry).key)
 	}
-	return nil
 }
 
 func (is *incomingSig) String() string {
@@ -386,6 +784,10 @@ type fifoProcessing struct {
 	in    chan incomingSig
 	out   chan incomingSig
 	done  bool
+	// left holds the signatures received once stopped, handed over by pending
+	left []*incomingSig
+	// running is done when processIncoming returns
+	running sync.WaitGroup
 }
 
 // newFifoProcessing returns a signatureProcessing implementation using a fifo
@@ -407,6 +809,9 @@ func newFifoProcessing(store SignatureStore, part Partitioner,
 // processIncoming verifies the signature, stores it, and outputs it
 func (f *fifoProcessing) processIncoming() {
 	for pair := range f.in {
+		if f.keepLeft(pair) {
+			continue
+		}
 		score := f.store.Evaluate(&pair)
 		if score == 0 {
 			//logf("handel: fifo: skipping verification of signature %s", pair.String())
@@ -420,18 +825,28 @@ func (f *fifoProcessing) processIncoming() {
 		}
 
 		f.Lock()
-		done := f.done
-		if !done {
+		if f.done {
+			// the signature is verified again by the next processing
+			f.left = append(f.left, &pair)
+		} else {
 			//logf("handel: handling back verified signature to actors")
 			f.out <- pair
 		}
 		f.Unlock()
-		if done {
-			break
-		}
 	}
 }
 
+// keepLeft keeps the signature for pending and returns true if the processing
+// is stopped.
+func (f *fifoProcessing) keepLeft(pair incomingSig) bool {
+	f.Lock()
+	defer f.Unlock()
+	if f.done {
+		f.left = append(f.left, &pair)
+	}
+	return f.done
+}
+
 func (f *fifoProcessing) verifySignature(pair *incomingSig) error {
 	level := pair.level
 	ms := pair.ms
@@ -470,6 +885,14 @@ func (f *fifoProcessing) Verified() chan incomingSig {
 }
 
 func (f *fifoProcessing) Start() {
+	f.Lock()
+	if f.done {
+		f.Unlock()
+		return
+	}
+	f.running.Add(1)
+	f.Unlock()
+	defer f.running.Done()
 	f.processIncoming()
 }
 
@@ -484,9 +907,333 @@ func (f *fifoProcessing) Stop() {
 	close(f.out)
 }
 
+// pending implements the pendingProcessing interface. It must be called once
+// stopped: it waits for the signature being verified and drains the queue.
+func (f *fifoProcessing) pending() []*incomingSig {
+	f.running.Wait()
+	f.Lock()
+	defer f.Unlock()
+	pending := f.left
+	f.left = nil
+	for pair := range f.in {
+		pair := pair
+		pending = append(pending, &pair)
+	}
+	return pending
+}
+
 func (f *fifoProcessing) isStopped() bool {
 	f.Lock()
 	defer f.Unlock()
 	// OK since once we call stop, we'll no go back to done = false
 	return f.done
 }
+
+// priorityProcessing implements the signatureProcessing interface by always
+// verifying first, among the pending signatures, the one at the highest level
+// and, at a given level, the one with the most contributions, since it is the
+// most likely to make Handel progress. As fifoProcessing, it skips the
+// signatures the store evaluates to zero.
+type priorityProcessing struct {
+	cond  *sync.Cond
+	store SignatureStore
+	part  Partitioner
+	cons  Constructor
+	msg   []byte
+	queue sigHeap
+	out   chan incomingSig
+	done  bool
+	pacer pacer
+
+	verifyTimes verifyStats
+}
+
+// newPriorityProcessing returns a signatureProcessing verifying the pending
+// signatures by descending level and cardinality. It needs the store to
+// evaluate the signatures, the partitioner + constructor and the message to
+// verify them, and the minimum interval between two verifications, see
+// Config.VerifyPacing.
+func newPriorityProcessing(store SignatureStore, part Partitioner,
+	c Constructor, msg []byte, pacing time.Duration) signatureProcessing {
+	return &priorityProcessing{
+		cond:  sync.NewCond(new(sync.Mutex)),
+		store: store,
+		part:  part,
+		cons:  c,
+		msg:   msg,
+		out:   make(chan incomingSig, 1000),
+		pacer: pacer{interval: pacing},
+	}
+}
+
+// Start runs the processing routine until Stop is called. It is a blocking
+// call.
+func (p *priorityProcessing) Start() {
+	defer close(p.out)
+	for {
+		sp, ok := p.next()
+		if !ok {
+			return
+		}
+		if p.store.Evaluate(sp) == 0 {
+			release(p.cons, sp.ms.Signature)
+			continue
+		}
+		p.pacer.wait()
+		startTime := time.Now()
+		err := verifySignature(sp, p.msg, p.part, p.cons, nil)
+		p.verifyTimes.add(time.Since(startTime))
+		if err != nil {
+			logf("handel: priority: verifying err: %s", err)
+			continue
+		}
+		p.out <- *sp
+	}
+}
+
+// VerificationStats implements the verificationTimer interface.
+func (p *priorityProcessing) VerificationStats() (int, time.Duration, time.Duration) {
+	return p.verifyTimes.get()
+}
+
+// next waits for a pending signature and returns the one to verify first, or
+// false once the processing is stopped.
+func (p *priorityProcessing) next() (*incomingSig, bool) {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	for len(p.queue) == 0 && !p.done {
+		p.cond.Wait()
+	}
+	if p.done {
+		return nil, false
+	}
+	return heap.Pop(&p.queue).(*incomingSig), true
+}
+
+func (p *priorityProcessing) Stop() {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	p.done = true
+	p.cond.Broadcast()
+}
+
+func (p *priorityProcessing) Add(sp *incomingSig) {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	if p.done {
+		return
+	}
+	heap.Push(&p.queue, sp)
+	p.cond.Signal()
+}
+
+func (p *priorityProcessing) Verified() chan incomingSig {
+	return p.out
+}
+
+// pending implements the pendingProcessing interface.
+func (p *priorityProcessing) pending() []*incomingSig {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	pending := p.queue
+	p.queue = nil
+	return pending
+}
+
+// sigHeap is a heap of signatures, the highest level and then the highest
+// cardinality first.
+type sigHeap []*incomingSig
+
+func (s sigHeap) Len() int { return len(s) }
+
+func (s sigHeap) Less(i, j int) bool {
+	if s[i].level != s[j].level {
+		return s[i].level > s[j].level
+	}
+	return s[i].ms.Cardinality() > s[j].ms.Cardinality()
+}
+
+func (s sigHeap) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s *sigHeap) Push(x interface{}) { *s = append(*s, x.(*incomingSig)) }
+
+func (s *sigHeap) Pop() interface{} {
+	old := *s
+	last := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return last
+}
+
+// batchProcessing implements the signatureProcessing interface by verifying
+// the pending signatures of a level together, up to a batch size, with a
+// single call to the BatchVerifier of the Constructor. It waits for a small
+// window after the first pending signature so more can join the batch. When
+// a batch fails, its signatures are verified one by one so the valid ones are
+// still output. Without BatchVerifier, all signatures are verified one by
+// one. As fifoProcessing, it skips the signatures the store evaluates to zero.
+type batchProcessing struct {
+	cond   *sync.Cond
+	store  SignatureStore
+	part   Partitioner
+	cons   Constructor
+	msg    []byte
+	size   int
+	window time.Duration
+	todos  map[byte][]*incomingSig
+	out    chan incomingSig
+	done   bool
+	pacer  pacer
+
+	verifyTimes verifyStats
+}
+
+// newBatchProcessing returns a signatureProcessing verifying up to size
+// pending signatures of a level at once, waiting for the given window for
+// them to arrive and for the pacing between two verifications, see
+// Config.VerifyPacing.
+func newBatchProcessing(store SignatureStore, part Partitioner, c Constructor,
+	msg []byte, size int, window, pacing time.Duration) signatureProcessing {
+	return &batchProcessing{
+		cond:   sync.NewCond(new(sync.Mutex)),
+		store:  store,
+		part:   part,
+		cons:   c,
+		msg:    msg,
+		size:   size,
+		window: window,
+		todos:  make(map[byte][]*incomingSig),
+		out:    make(chan incomingSig, 1000),
+		pacer:  pacer{interval: pacing},
+	}
+}
+
+// Start runs the processing routine until Stop is called. It is a blocking
+// call.
+func (b *batchProcessing) Start() {
+	defer close(b.out)
+	for {
+		batch, ok := b.nextBatch()
+		if !ok {
+			return
+		}
+		for _, sp := range b.verifyBatch(batch) {
+			b.out <- *sp
+		}
+	}
+}
+
+// nextBatch waits for pending signatures, and for the batching window, and
+// returns the ones of the level with the most pending signatures, or false
+// once the processing is stopped.
+func (b *batchProcessing) nextBatch() ([]*incomingSig, bool) {
+	b.cond.L.Lock()
+	for len(b.todos) == 0 && !b.done {
+		b.cond.Wait()
+	}
+	b.cond.L.Unlock()
+	if b.window > 0 {
+		time.Sleep(b.window)
+	}
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	if b.done {
+		return nil, false
+	}
+	var level byte
+	for lvl, sigs := range b.todos {
+		if len(sigs) > len(b.todos[level]) || (len(sigs) == len(b.todos[level]) && lvl > level) {
+			level = lvl
+		}
+	}
+	batch := b.todos[level]
+	if len(batch) > b.size {
+		b.todos[level] = batch[b.size:]
+		batch = batch[:b.size]
+	} else {
+		delete(b.todos, level)
+	}
+	return batch, true
+}
+
+// verifyBatch returns the valid signatures of the batch.
+func (b *batchProcessing) verifyBatch(batch []*incomingSig) []*incomingSig {
+	var todo []*incomingSig
+	var keys []PublicKey
+	var sigs []Signature
+	for _, sp := range batch {
+		if b.store.Evaluate(sp) == 0 {
+			release(b.cons, sp.ms.Signature)
+			continue
+		}
+		key, err := aggregateKeyOf(sp, b.part, b.cons, nil)
+		if err != nil {
+			logf("handel: batch: %s", err)
+			continue
+		}
+		todo = append(todo, sp)
+		keys = append(keys, key)
+		sigs = append(sigs, sp.ms.Signature)
+	}
+	if bv, ok := b.cons.(BatchVerifier); ok && len(todo) > 1 {
+		b.pacer.wait()
+		startTime := time.Now()
+		err := bv.VerifyBatch(b.msg, keys, sigs)
+		b.verifyTimes.addBatch(len(todo), time.Since(startTime))
+		if err == nil {
+			return todo
+		}
+	}
+	var valid []*incomingSig
+	for i, sp := range todo {
+		b.pacer.wait()
+		startTime := time.Now()
+		err := keys[i].VerifySignature(b.msg, sigs[i])
+		b.verifyTimes.add(time.Since(startTime))
+		if err != nil {
+			logf("handel: batch: verifying err: %s", err)
+			continue
+		}
+		valid = append(valid, sp)
+	}
+	return valid
+}
+
+// VerificationStats implements the verificationTimer interface. A batch
+// verification counts as one verification per signature of the batch, each
+// taking an equal share of its time.
+func (b *batchProcessing) VerificationStats() (int, time.Duration, time.Duration) {
+	return b.verifyTimes.get()
+}
+
+func (b *batchProcessing) Stop() {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	b.done = true
+	b.cond.Broadcast()
+}
+
+func (b *batchProcessing) Add(sp *incomingSig) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	if b.done {
+		return
+	}
+	b.todos[sp.level] = append(b.todos[sp.level], sp)
+	b.cond.Signal()
+}
+
+func (b *batchProcessing) Verified() chan incomingSig {
+	return b.out
+}
+
+// pending implements the pendingProcessing interface.
+func (b *batchProcessing) pending() []*incomingSig {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	var pending []*incomingSig
+	for _, sigs := range b.todos {
+		pending = append(pending, sigs...)
+	}
+	b.todos = make(map[byte][]*incomingSig)
+	return pending
+}
@@ -1,6 +1,8 @@
 package handel
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"testing"
 	"time"
 
@@ -23,7 +25,7 @@ func TestSigProcessingStrategy(t *testing.T) {
 	sig1 := fullIncomingSig(1)
 	sig2 := fullIncomingSig(2)
 
-	s := newEvaluatorProcessing(partitioner, cons, nil, 0, &EvaluatorLevel{}, nil)
+	s := newEvaluatorProcessing(partitioner, cons, nil, new(Config), &EvaluatorLevel{}, nil)
 	ss := s.(*evaluatorProcessing)
 
 	require.Equal(t, 0, len(ss.todos))
@@ -119,3 +121,510 @@ func TestProcessingFifo(t *testing.T) {
 		fifo.Stop()
 	}
 }
+
+// timedPublic is a public key that reports when it verifies a signature
+type timedPublic struct {
+	*fakePublic
+	verified chan time.Time
+}
+
+func (p *timedPublic) VerifySignature(msg []byte, s Signature) error {
+	p.verified <- time.Now()
+	return p.fakePublic.VerifySignature(msg, s)
+}
+
+func (p *timedPublic) Combine(PublicKey) PublicKey { return p }
+
+type timedCons struct {
+	*fakeCons
+	pub *timedPublic
+}
+
+func (c *timedCons) PublicKey() PublicKey { return c.pub }
+
+func TestProcessingPriority(t *testing.T) {
+	n := 16
+	registry := FakeRegistry(n)
+	partitioner := NewBinPartitioner(1, registry, DefaultLogger)
+	cons := new(fakeCons)
+	store := newStore(partitioner, NewWilffBitset, cons)
+	half := NewWilffBitset(4)
+	half.Set(0, true)
+	half.Set(1, true)
+	sig3Half := &incomingSig{origin: 4, level: 3, ms: newSig(half)}
+
+	proc := newPriorityProcessing(store, partitioner, cons, msg, 0)
+	// queued before the processing starts so the order is not the arrival one
+	for _, sp := range []*incomingSig{fullIncomingSig(1), sig3Half, fullIncomingSig(2), fullIncomingSig(3)} {
+		proc.Add(sp)
+	}
+	go proc.Start()
+	defer proc.Stop()
+	var levels []byte
+	var cards []int
+	for i := 0; i < 4; i++ {
+		select {
+		case v := <-proc.Verified():
+			levels = append(levels, v.level)
+			cards = append(cards, v.ms.Cardinality())
+		case <-time.After(time.Second):
+			t.Fatal("signature not verified")
+		}
+	}
+	require.Equal(t, []byte{3, 3, 2, 1}, levels)
+	require.Equal(t, []int{4, 2, 2, 1}, cards)
+
+	// the output is closed once stopped
+	proc.Stop()
+	select {
+	case _, ok := <-proc.Verified():
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("output not closed")
+	}
+}
+
+func TestHandelPriorityProcessing(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	nets := make([]Network, n)
+	for i := range nets {
+		nets[i] = &TestNetwork{int32(i), nets, nil}
+	}
+	conf := &Config{PriorityProcessing: true}
+	handels := make([]*Handel, n)
+	for i := range handels {
+		id, _ := reg.Identity(i)
+		handels[i] = NewHandel(nets[i], reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		require.IsType(t, &priorityProcessing{}, handels[i].proc)
+	}
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.True(t, ms.Cardinality() >= h.threshold)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("instance %d did not complete", i)
+		}
+	}
+}
+
+// batchCons is a BatchVerifier counting its batches, whose signatures cost a
+// given number of hashes per pairing to verify: two for a signature and one
+// per signature plus one for a batch, as BLS.
+type batchCons struct {
+	*fakeCons
+	cost    int
+	batches int
+}
+
+// pairings simulates the cost of n pairings.
+func (b *batchCons) pairings(n int) {
+	buff := make([]byte, 32)
+	for i := 0; i < n*b.cost; i++ {
+		h := sha256.Sum256(buff)
+		buff = h[:]
+	}
+}
+
+func (b *batchCons) PublicKey() PublicKey { return &costPublic{&fakePublic{true}, b} }
+
+func (b *batchCons) VerifyBatch(msg []byte, keys []PublicKey, sigs []Signature) error {
+	b.batches++
+	b.pairings(len(sigs) + 1)
+	for i := range sigs {
+		if err := keys[i].(*costPublic).fakePublic.VerifySignature(msg, sigs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type costPublic struct {
+	*fakePublic
+	cons *batchCons
+}
+
+func (c *costPublic) VerifySignature(msg []byte, s Signature) error {
+	c.cons.pairings(2)
+	return c.fakePublic.VerifySignature(msg, s)
+}
+
+func (c *costPublic) Combine(p PublicKey) PublicKey {
+	if cp, ok := p.(*costPublic); ok {
+		p = cp.fakePublic
+	}
+	return &costPublic{c.fakePublic.Combine(p).(*fakePublic), c.cons}
+}
+
+// individualSigs returns the individual signatures of the given level of node
+// 1, all valid but the invalid indexes.
+func individualSigs(part Partitioner, level int, invalid ...int) []*incomingSig {
+	ids, _ := part.IdentitiesAt(level)
+	sigs := make([]*incomingSig, len(ids))
+	for i := range ids {
+		bs := NewWilffBitset(len(ids))
+		bs.Set(i, true)
+		sigs[i] = &incomingSig{origin: ids[i].ID(), level: byte(level), isInd: true, mappedIndex: i,
+			ms: &MultiSignature{BitSet: bs, Signature: &fakeSig{true}}}
+	}
+	for _, i := range invalid {
+		sigs[i].ms.Signature = &fakeSig{false}
+	}
+	return sigs
+}
+
+func TestProcessingBatch(t *testing.T) {
+	n := 16
+	partitioner := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	verifyAll := func(cons Constructor, sigs []*incomingSig) []int32 {
+		store := newStore(partitioner, NewWilffBitset, cons)
+		proc := newBatchProcessing(store, partitioner, cons, msg, 8, 10*time.Millisecond, 0)
+		for _, sp := range sigs {
+			proc.Add(sp)
+		}
+		go proc.Start()
+		defer proc.Stop()
+		var origins []int32
+		for {
+			select {
+			case v := <-proc.Verified():
+				origins = append(origins, v.origin)
+			case <-time.After(100 * time.Millisecond):
+				return origins
+			}
+		}
+	}
+
+	// a single batch for the whole level
+	cons := &batchCons{fakeCons: new(fakeCons)}
+	require.Equal(t, []int32{8, 9, 10, 11, 12, 13, 14, 15}, verifyAll(cons, individualSigs(partitioner, 4)))
+	require.Equal(t, 1, cons.batches)
+
+	// a failed batch falls back to verifying one by one
+	cons = &batchCons{fakeCons: new(fakeCons)}
+	require.Equal(t, []int32{4, 5, 7}, verifyAll(cons, individualSigs(partitioner, 3, 2)))
+	require.Equal(t, 1, cons.batches)
+
+	// without BatchVerifier
+	require.Len(t, verifyAll(new(fakeCons), individualSigs(partitioner, 4, 0)), 7)
+}
+
+// BenchmarkProcessingBatch compares verifying the individual signatures of
+// the last level of 1024 nodes one by one and in batches.
+func BenchmarkProcessingBatch(b *testing.B) {
+	n := 1024
+	partitioner := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	level := partitioner.MaxLevel()
+	news := map[string]func(SignatureStore, Constructor) signatureProcessing{
+		"fifo": func(s SignatureStore, c Constructor) signatureProcessing {
+			return newFifoProcessing(s, partitioner, c, msg)
+		},
+		"batch": func(s SignatureStore, c Constructor) signatureProcessing {
+			return newBatchProcessing(s, partitioner, c, msg, 64, 0, 0)
+		},
+	}
+	for _, name := range []string{"fifo", "batch"} {
+		b.Run(name, func(b *testing.B) {
+			cons := &batchCons{fakeCons: new(fakeCons), cost: 100}
+			for i := 0; i < b.N; i++ {
+				sigs := individualSigs(partitioner, level)
+				proc := news[name](newStore(partitioner, NewWilffBitset, cons), cons)
+				go proc.Start()
+				go func() {
+					for _, sp := range sigs {
+						proc.Add(sp)
+					}
+				}()
+				for range sigs {
+					<-proc.Verified()
+				}
+				proc.Stop()
+			}
+		})
+	}
+}
+
+func TestProcessingVerifyPacing(t *testing.T) {
+	n := 16
+	nbSigs := 5
+	pacing := 20 * time.Millisecond
+	registry := FakeRegistry(n)
+	partitioner := NewBinPartitioner(1, registry, DefaultLogger)
+	processings := map[string]func(c Constructor) signatureProcessing{
+		"evaluator": func(c Constructor) signatureProcessing {
+			return newEvaluatorProcessing(partitioner, c, msg, &Config{VerifyPacing: pacing}, new(Evaluator1), DefaultLogger)
+		},
+		"priority": func(c Constructor) signatureProcessing {
+			return newPriorityProcessing(newStore(partitioner, NewWilffBitset, c), partitioner, c, msg, pacing)
+		},
+		"batch": func(c Constructor) signatureProcessing {
+			return newBatchProcessing(newStore(partitioner, NewWilffBitset, c), partitioner, c, msg, nbSigs, 0, pacing)
+		},
+	}
+	for name, newProc := range processings {
+		t.Run(name, func(t *testing.T) {
+			cons := &timedCons{
+				fakeCons: new(fakeCons),
+				pub:      &timedPublic{&fakePublic{true}, make(chan time.Time, nbSigs)},
+			}
+			proc := newProc(cons)
+			// flood the processing before it starts
+			for i := 0; i < nbSigs; i++ {
+				proc.Add(fullIncomingSig(2))
+			}
+			go proc.Start()
+			defer proc.Stop()
+			go func() {
+				for range proc.Verified() {
+				}
+			}()
+
+			var last time.Time
+			for i := 0; i < nbSigs; i++ {
+				select {
+				case verified := <-cons.pub.verified:
+					if i > 0 {
+						require.True(t, verified.Sub(last) >= pacing)
+					}
+					last = verified
+				case <-time.After(time.Second):
+					t.Fatal("signature not verified")
+				}
+			}
+		})
+	}
+}
+
+// msgSig is a signature over a message, valid for any key, see msgPublic
+type msgSig struct {
+	msg string
+}
+
+func (m *msgSig) MarshalBinary() ([]byte, error) { return []byte(m.msg), nil }
+func (m *msgSig) UnmarshalBinary(b []byte) error { m.msg = string(b); return nil }
+func (m *msgSig) Combine(s Signature) Signature {
+	if s.(*msgSig).msg != m.msg {
+		return &msgSig{"mixed"}
+	}
+	return m
+}
+
+type msgPublic struct{}
+
+func (m *msgPublic) VerifySignature(msg []byte, s Signature) error {
+	if s.(*msgSig).msg != string(msg) {
+		return fmt.Errorf("signature over %q", s.(*msgSig).msg)
+	}
+	return nil
+}
+func (m *msgPublic) Combine(PublicKey) PublicKey { return m }
+func (m *msgPublic) String() string              { return "msg" }
+
+type msgCons struct{}
+
+func (m *msgCons) Signature() Signature { return new(msgSig) }
+func (m *msgCons) PublicKey() PublicKey { return new(msgPublic) }
+
+func TestProcessingOtherMessage(t *testing.T) {
+	n := 16
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", new(msgPublic))
+	}
+	partitioner := NewBinPartitioner(1, NewArrayRegistry(ids), DefaultLogger)
+	proc := newEvaluatorProcessing(partitioner, new(msgCons), msg, new(Config), new(Evaluator1), DefaultLogger)
+	ss := proc.(*evaluatorProcessing)
+	sigOver := func(level int, m string) *incomingSig {
+		return &incomingSig{level: byte(level), ms: &MultiSignature{BitSet: fullBitset(level), Signature: &msgSig{m}}}
+	}
+	ss.Add(sigOver(1, "other message"))
+	ss.Add(sigOver(2, string(msg)))
+	ss.Add(sigOver(3, "mixed"))
+	for i := 0; i < 3; i++ {
+		ss.processStep()
+	}
+	require.Len(t, ss.out, 1)
+	verified := <-ss.out
+	require.Equal(t, byte(2), verified.level)
+}
+
+// countRegistry returns a registry of identities whose public keys count
+// their contributions, see countPublic.
+func countRegistry(n int) Registry {
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", &countPublic{countSig{int32(i): 1}})
+	}
+	return NewArrayRegistry(ids)
+}
+
+// countIncomingSig returns a multi-signature at the given level of node 1 over
+// the given indexes of the level, whose level starts at the ID min.
+func countIncomingSig(level byte, size, min int, indexes ...int) *incomingSig {
+	bs := NewWilffBitset(size)
+	sig := make(countSig)
+	for _, i := range indexes {
+		bs.Set(i, true)
+		sig[int32(min+i)] = 1
+	}
+	return &incomingSig{level: level, ms: &MultiSignature{BitSet: bs, Signature: sig}}
+}
+
+func TestProcessingDeltaVerification(t *testing.T) {
+	n := 16
+	registry := countRegistry(n)
+	partitioner := NewBinPartitioner(1, registry, DefaultLogger)
+	proc := newEvaluatorProcessing(partitioner, new(countCons), msg, new(Config), new(Evaluator1), DefaultLogger)
+	ss := proc.(*evaluatorProcessing)
+	// the level 4 of node 1 is made of the nodes 8 to 15
+	sig := func(indexes ...int) *incomingSig {
+		return countIncomingSig(4, 8, 8, indexes...)
+	}
+
+	require.NoError(t, ss.verify(sig(0, 1, 2, 3)))
+	require.Equal(t, 0, ss.sigDeltaCt)
+	// a superset is verified by its delta
+	require.NoError(t, ss.verify(sig(0, 1, 2, 3, 4, 5)))
+	require.Equal(t, 1, ss.sigDeltaCt)
+	// an invalid superset fails
+	invalid := sig(0, 1, 2, 3, 4, 5, 6)
+	delete(invalid.ms.Signature.(countSig), 8)
+	require.Error(t, ss.verify(invalid))
+	require.Equal(t, 2, ss.sigDeltaCt)
+	// neither a signature which is not a superset nor the same bitset
+	require.NoError(t, ss.verify(sig(6, 7)))
+	require.NoError(t, ss.verify(sig(6, 7)))
+	require.Equal(t, 2, ss.sigDeltaCt)
+	// individual signatures are always verified entirely
+	ind := sig(6)
+	ind.isInd = true
+	require.NoError(t, ss.verify(ind))
+	require.Equal(t, 2, ss.sigDeltaCt)
+	require.Equal(t, 2.0, ss.Values()["sigDeltaCt"])
+}
+
+func BenchmarkProcessingDeltaVerification(b *testing.B) {
+	n := 1024
+	registry := countRegistry(n)
+	partitioner := NewBinPartitioner(1, registry, DefaultLogger)
+	// the last level of node 1, level 10, is made of the nodes 512 to 1023
+	size := n / 2
+	all := make([]int, size)
+	for i := range all {
+		all[i] = i
+	}
+	prev := countIncomingSig(10, size, size, all[:size-8]...)
+	sp := countIncomingSig(10, size, size, all...)
+	for _, delta := range []bool{false, true} {
+		b.Run(fmt.Sprintf("delta-%v", delta), func(b *testing.B) {
+			proc := newEvaluatorProcessing(partitioner, new(countCons), msg, new(Config), new(Evaluator1), DefaultLogger)
+			ss := proc.(*evaluatorProcessing)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				delete(ss.verified, 10)
+				if delta {
+					ss.verified[10] = prev.ms
+				}
+				require.NoError(b, ss.verify(sp))
+			}
+		})
+	}
+}
+
+func TestProcessingAPKCache(t *testing.T) {
+	c := newAPKCache(2)
+	k1, ok := apkCacheKey(1, NewWilffBitset(4))
+	require.True(t, ok)
+	bs := NewWilffBitset(4)
+	bs.Set(2, true)
+	k2, _ := apkCacheKey(1, bs)
+	k3, _ := apkCacheKey(2, bs)
+	require.NotEqual(t, k2, k3)
+
+	c.add(k1, &fakePublic{true})
+	c.add(k2, &fakePublic{true})
+	_, ok = c.get(k1)
+	require.True(t, ok)
+	// k2 is the least recently used
+	c.add(k3, &fakePublic{true})
+	_, ok = c.get(k2)
+	require.False(t, ok)
+	_, ok = c.get(k1)
+	require.True(t, ok)
+	_, ok = c.get(k3)
+	require.True(t, ok)
+
+	// nil cache
+	var nilCache *apkCache
+	nilCache.add(k1, &fakePublic{true})
+	_, ok = nilCache.get(k1)
+	require.False(t, ok)
+
+	// verification reuses the cached aggregate public key
+	n := 16
+	registry := FakeRegistry(n)
+	partitioner := NewBinPartitioner(1, registry, DefaultLogger)
+	cache := newAPKCache(4)
+	sig := fullIncomingSig(3)
+	require.NoError(t, verifySignature(sig, msg, partitioner, new(fakeCons), cache))
+	key, _ := apkCacheKey(3, sig.ms.BitSet)
+	apk, ok := cache.get(key)
+	require.True(t, ok)
+	// a wrong cached key makes the verification fail
+	cache.add(key, &fakePublic{false})
+	require.Error(t, verifySignature(sig, msg, partitioner, new(fakeCons), cache))
+	cache.add(key, apk)
+	require.NoError(t, verifySignature(sig, msg, partitioner, new(fakeCons), cache))
+
+	// without cache, the bitset is not marshalled into a key
+	counter := &marshalCounter{BitSet: sig.ms.BitSet}
+	sig.ms.BitSet = counter
+	require.NoError(t, verifySignature(sig, msg, partitioner, new(fakeCons), nil))
+	require.Zero(t, counter.calls)
+	require.NoError(t, verifySignature(sig, msg, partitioner, new(fakeCons), cache))
+	require.Equal(t, 1, counter.calls)
+}
+
+// marshalCounter is a BitSet counting the calls to MarshalBinary
+type marshalCounter struct {
+	BitSet
+	calls int
+}
+
+func (m *marshalCounter) MarshalBinary() ([]byte, error) {
+	m.calls++
+	return m.BitSet.MarshalBinary()
+}
+
+func BenchmarkProcessingAPKCache(b *testing.B) {
+	n := 1024
+	registry := FakeRegistry(n)
+	partitioner := NewBinPartitioner(1, registry, DefaultLogger)
+	lvl := partitioner.MaxLevel()
+	ids, _ := partitioner.IdentitiesAt(lvl)
+	// a pool of distinct bitsets at the last level
+	var sigs []*incomingSig
+	for i := 0; i < 32; i++ {
+		bs := NewWilffBitset(len(ids))
+		for j := 0; j < len(ids); j++ {
+			bs.Set(j, j%32 != i)
+		}
+		ms := &MultiSignature{BitSet: bs, Signature: &fakeSig{true}}
+		sigs = append(sigs, &incomingSig{origin: ids[0].ID(), level: byte(lvl), ms: ms})
+	}
+	for _, size := range []int{0, 8, 32} {
+		b.Run(fmt.Sprintf("size-%d", size), func(b *testing.B) {
+			cache := newAPKCache(size)
+			for i := 0; i < b.N; i++ {
+				sig := sigs[i%len(sigs)]
+				if err := verifySignature(sig, msg, partitioner, new(fakeCons), cache); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
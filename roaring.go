@@ -0,0 +1,472 @@
+package handel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// roaringArrayMax is the maximum cardinality of an array container, above
+// which a container is a bitmap: 4096 16-bit values take as much space as a
+// bitmap of 65536 bits.
+const roaringArrayMax = 4096
+
+// roaringWords is the number of 64-bit words of a bitmap container.
+const roaringWords = 1 << 16 / 64
+
+// roaringContainer holds the bits of a RoaringBitSet sharing the same 16 high
+// bits, its key. It stores the 16 low bits of the bits set either as a sorted
+// array when there are at most roaringArrayMax of them, or as a bitmap.
+type roaringContainer struct {
+	key    uint16
+	array  []uint16
+	bitmap []uint64
+	card   int
+}
+
+// RoaringBitSet implements a BitSet as a roaring bitmap: the bits are split
+// in chunks of 65536 bits, and only the chunks with bits set are allocated,
+// as a sorted array of the bits set or as a bitmap when the chunk is dense.
+// Sparse bitsets, e.g. the signatures of the first levels with many nodes,
+// take space proportional to their cardinality instead of their bit length.
+//
+// The binary encoding of a RoaringBitSet, all integers being big endian, is
+// the bit length on 4 bytes, the number of containers on 2 bytes, then for
+// each container by increasing key: its key on 2 bytes, its cardinality minus
+// one on 2 bytes, and its bits, either as the sorted 2-byte low bits set if
+// the cardinality is at most 4096, or as 1024 8-byte words otherwise, the
+// bit i of the chunk being the bit i%64 of the word i/64. The encoding is
+// canonical: any other form is rejected.
+type RoaringBitSet struct {
+	l  int
+	cs []*roaringContainer
+}
+
+// NewRoaringBitset returns an empty RoaringBitSet of the given bit length. It
+// can be used as Config.NewBitSet.
+func NewRoaringBitset(length int) BitSet {
+	return &RoaringBitSet{l: length}
+}
+
+// BitLength implements the BitSet interface
+func (r *RoaringBitSet) BitLength() int {
+	return r.l
+}
+
+// Cardinality implements the BitSet interface
+func (r *RoaringBitSet) Cardinality() int {
+	card := 0
+	for _, c := range r.cs {
+		card += c.card
+	}
+	return card
+}
+
+// container returns the position of the container with the given key, and
+// whether it exists.
+func (r *RoaringBitSet) container(key uint16) (int, bool) {
+	i := sort.Search(len(r.cs), func(i int) bool { return r.cs[i].key >= key })
+	return i, i < len(r.cs) && r.cs[i].key == key
+}
+
+// Set implements the BitSet interface
+func (r *RoaringBitSet) Set(idx int, status bool) {
+	if idx < 0 || idx >= r.l {
+		panic("bitset: set out of bounds")
+	}
+	key, low := uint16(idx>>16), uint16(idx)
+	i, exists := r.container(key)
+	if !exists {
+		if !status {
+			return
+		}
+		r.cs = append(r.cs, nil)
+		copy(r.cs[i+1:], r.cs[i:])
+		r.cs[i] = &roaringContainer{key: key}
+	}
+	c := r.cs[i]
+	c.set(low, status)
+	if c.card == 0 {
+		r.cs = append(r.cs[:i], r.cs[i+1:]...)
+	}
+}
+
+// Get implements the BitSet interface
+func (r *RoaringBitSet) Get(idx int) bool {
+	if idx < 0 || idx >= r.l {
+		panic("bitset: get out of bounds")
+	}
+	i, exists := r.container(uint16(idx >> 16))
+	return exists && r.cs[i].get(uint16(idx))
+}
+
+// MarshalBinary implements the go Marshaler interface, see RoaringBitSet for
+// the encoding.
+func (r *RoaringBitSet) MarshalBinary() ([]byte, error) {
+	if uint64(r.l) > 1<<32-1 || len(r.cs) > 1<<16-1 {
+		return nil, errors.New("bitset: too large to marshal")
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(r.l))
+	binary.Write(&b, binary.BigEndian, uint16(len(r.cs)))
+	for _, c := range r.cs {
+		binary.Write(&b, binary.BigEndian, c.key)
+		binary.Write(&b, binary.BigEndian, uint16(c.card-1))
+		if c.bitmap != nil {
+			binary.Write(&b, binary.BigEndian, c.bitmap)
+		} else {
+			binary.Write(&b, binary.BigEndian, c.array)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary implements the go Unmarshaler interface, see RoaringBitSet
+// for the encoding.
+func (r *RoaringBitSet) UnmarshalBinary(buff []byte) error {
+	b := bytes.NewReader(buff)
+	var length uint32
+	var count uint16
+	if err := binary.Read(b, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, &count); err != nil {
+		return err
+	}
+	cs := make([]*roaringContainer, 0, count)
+	for i := 0; i < int(count); i++ {
+		var head [2]uint16
+		if err := binary.Read(b, binary.BigEndian, &head); err != nil {
+			return err
+		}
+		c := &roaringContainer{key: head[0], card: int(head[1]) + 1}
+		if i > 0 && c.key <= cs[i-1].key {
+			return errors.New("bitset: containers not sorted")
+		}
+		if c.card > roaringArrayMax {
+			c.bitmap = make([]uint64, roaringWords)
+			if err := binary.Read(b, binary.BigEndian, c.bitmap); err != nil {
+				return err
+			}
+			if popcount(c.bitmap) != c.card {
+				return errors.New("bitset: container cardinality mismatch")
+			}
+		} else {
+			c.array = make([]uint16, c.card)
+			if err := binary.Read(b, binary.BigEndian, c.array); err != nil {
+				return err
+			}
+			for j := 1; j < len(c.array); j++ {
+				if c.array[j] <= c.array[j-1] {
+					return errors.New("bitset: container values not sorted")
+				}
+			}
+		}
+		cs = append(cs, c)
+	}
+	if b.Len() != 0 {
+		return errors.New("bitset: trailing data")
+	}
+	if len(cs) > 0 {
+		last := cs[len(cs)-1]
+		if highest := int(last.key)<<16 | int(last.highest()); highest >= int(length) {
+			return errors.New("bitset: encoded bits exceed the bit length")
+		}
+	}
+	r.l = int(length)
+	r.cs = cs
+	return nil
+}
+
+func (r *RoaringBitSet) String() string {
+	var s []string
+	for i, e := r.NextSet(0); e; i, e = r.NextSet(i + 1) {
+		s = append(s, fmt.Sprint(i))
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+// All implements the BitSet interface
+func (r *RoaringBitSet) All() bool {
+	return r.Cardinality() == r.l
+}
+
+// None implements the BitSet interface
+func (r *RoaringBitSet) None() bool {
+	return len(r.cs) == 0
+}
+
+// Any implements the BitSet interface
+func (r *RoaringBitSet) Any() bool {
+	return len(r.cs) != 0
+}
+
+// Or implements the BitSet interface
+func (r *RoaringBitSet) Or(b2 BitSet) BitSet {
+	return r.combine(b2, func(a, b uint64) uint64 { return a | b })
+}
+
+// And implements the BitSet interface
+func (r *RoaringBitSet) And(b2 BitSet) BitSet {
+	return r.combine(b2, func(a, b uint64) uint64 { return a & b })
+}
+
+// Xor implements the BitSet interface
+func (r *RoaringBitSet) Xor(b2 BitSet) BitSet {
+	return r.combine(b2, func(a, b uint64) uint64 { return a ^ b })
+}
+
+// AndNot implements the BitSet interface
+func (r *RoaringBitSet) AndNot(b2 BitSet) BitSet {
+	return r.combine(b2, func(a, b uint64) uint64 { return a &^ b })
+}
+
+// IsSuperSet implements the BitSet interface
+func (r *RoaringBitSet) IsSuperSet(b2 BitSet) bool {
+	return !r.operand(b2).AndNot(r).Any()
+}
+
+// NextSet implements the BitSet interface
+func (r *RoaringBitSet) NextSet(i int) (int, bool) {
+	if i < 0 {
+		i = 0
+	}
+	if i >= r.l {
+		return 0, false
+	}
+	start, _ := r.container(uint16(i >> 16))
+	for _, c := range r.cs[start:] {
+		low := 0
+		if int(c.key) == i>>16 {
+			low = i & 0xffff
+		}
+		if next, ok := c.next(low); ok {
+			return int(c.key)<<16 | next, true
+		}
+	}
+	return 0, false
+}
+
+// IntersectionCardinality implements the BitSet interface
+func (r *RoaringBitSet) IntersectionCardinality(b2 BitSet) int {
+	return r.And(b2).Cardinality()
+}
+
+// Clone implements the BitSet interface
+func (r *RoaringBitSet) Clone() BitSet {
+	cs := make([]*roaringContainer, len(r.cs))
+	for i, c := range r.cs {
+		cs[i] = &roaringContainer{key: c.key, card: c.card}
+		if c.bitmap != nil {
+			cs[i].bitmap = append([]uint64(nil), c.bitmap...)
+		} else {
+			cs[i].array = append([]uint16(nil), c.array...)
+		}
+	}
+	return &RoaringBitSet{l: r.l, cs: cs}
+}
+
+// operand returns the other operand of an operation, which must have the same
+// bit length. Other BitSet implementations are converted bit by bit.
+func (r *RoaringBitSet) operand(b2 BitSet) *RoaringBitSet {
+	if b2.BitLength() != r.l {
+		panic(fmt.Sprintf("bitset: operation between bit lengths %d and %d", r.l, b2.BitLength()))
+	}
+	if r2, ok := b2.(*RoaringBitSet); ok {
+		return r2
+	}
+	r2 := &RoaringBitSet{l: r.l}
+	for i, e := b2.NextSet(0); e; i, e = b2.NextSet(i + 1) {
+		r2.Set(i, true)
+	}
+	return r2
+}
+
+// combine returns a new bitset applying the given bitwise operation to the
+// containers of both bitsets with the same key. The operation must map two
+// unset bits to an unset bit.
+func (r *RoaringBitSet) combine(b2 BitSet, op func(a, b uint64) uint64) BitSet {
+	r2 := r.operand(b2)
+	res := &RoaringBitSet{l: r.l}
+	i, j := 0, 0
+	for i < len(r.cs) || j < len(r2.cs) {
+		var a, b *roaringContainer
+		switch {
+		case j == len(r2.cs) || (i < len(r.cs) && r.cs[i].key < r2.cs[j].key):
+			a = r.cs[i]
+			i++
+		case i == len(r.cs) || r2.cs[j].key < r.cs[i].key:
+			b = r2.cs[j]
+			j++
+		default:
+			a, b = r.cs[i], r2.cs[j]
+			i++
+			j++
+		}
+		if c := combineContainers(a, b, op); c.card > 0 {
+			res.cs = append(res.cs, c)
+		}
+	}
+	return res
+}
+
+// combineContainers applies the operation to the containers, one of them
+// possibly nil for an empty container.
+func combineContainers(a, b *roaringContainer, op func(a, b uint64) uint64) *roaringContainer {
+	c := &roaringContainer{}
+	if a != nil {
+		c.key = a.key
+	} else {
+		c.key = b.key
+	}
+	if (a == nil || a.bitmap == nil) && (b == nil || b.bitmap == nil) {
+		var va, vb []uint16
+		if a != nil {
+			va = a.array
+		}
+		if b != nil {
+			vb = b.array
+		}
+		for len(va) > 0 || len(vb) > 0 {
+			var v uint16
+			var inA, inB uint64
+			switch {
+			case len(vb) == 0 || (len(va) > 0 && va[0] < vb[0]):
+				v, inA = va[0], 1
+				va = va[1:]
+			case len(va) == 0 || vb[0] < va[0]:
+				v, inB = vb[0], 1
+				vb = vb[1:]
+			default:
+				v, inA, inB = va[0], 1, 1
+				va, vb = va[1:], vb[1:]
+			}
+			if op(inA, inB)&1 == 1 {
+				c.array = append(c.array, v)
+			}
+		}
+		c.card = len(c.array)
+		return c
+	}
+	wa, wb := a.words(), b.words()
+	c.bitmap = make([]uint64, roaringWords)
+	for i := range c.bitmap {
+		c.bitmap[i] = op(wa[i], wb[i])
+	}
+	c.card = popcount(c.bitmap)
+	c.compact()
+	return c
+}
+
+// words returns the bitmap of the container, which may be nil.
+func (c *roaringContainer) words() []uint64 {
+	w := make([]uint64, roaringWords)
+	if c == nil {
+		return w
+	}
+	if c.bitmap != nil {
+		return c.bitmap
+	}
+	for _, v := range c.array {
+		w[v/64] |= 1 << (v % 64)
+	}
+	return w
+}
+
+func (c *roaringContainer) get(low uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[low/64]&(1<<(low%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	return i < len(c.array) && c.array[i] == low
+}
+
+func (c *roaringContainer) set(low uint16, status bool) {
+	if c.get(low) == status {
+		return
+	}
+	if status {
+		c.card++
+	} else {
+		c.card--
+	}
+	if c.bitmap != nil {
+		c.bitmap[low/64] ^= 1 << (low % 64)
+		c.compact()
+		return
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if !status {
+		c.array = append(c.array[:i], c.array[i+1:]...)
+		return
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = low
+	if c.card > roaringArrayMax {
+		c.bitmap = c.words()
+		c.array = nil
+	}
+}
+
+// compact turns a bitmap container into an array container if its
+// cardinality allows it.
+func (c *roaringContainer) compact() {
+	if c.bitmap == nil || c.card > roaringArrayMax {
+		return
+	}
+	c.array = make([]uint16, 0, c.card)
+	for low, ok := c.next(0); ok; low, ok = c.next(low + 1) {
+		c.array = append(c.array, uint16(low))
+	}
+	c.bitmap = nil
+}
+
+// next returns the lowest bit set from the given low bits, included.
+func (c *roaringContainer) next(low int) (int, bool) {
+	if low > 0xffff {
+		return 0, false
+	}
+	if c.bitmap == nil {
+		i := sort.Search(len(c.array), func(i int) bool { return int(c.array[i]) >= low })
+		if i == len(c.array) {
+			return 0, false
+		}
+		return int(c.array[i]), true
+	}
+	w := low / 64
+	word := c.bitmap[w] >> uint(low%64)
+	if word != 0 {
+		return low + bits.TrailingZeros64(word), true
+	}
+	for w++; w < roaringWords; w++ {
+		if c.bitmap[w] != 0 {
+			return w*64 + bits.TrailingZeros64(c.bitmap[w]), true
+		}
+	}
+	return 0, false
+}
+
+// highest returns the highest low bits set of a non-empty container.
+func (c *roaringContainer) highest() uint16 {
+	if c.bitmap == nil {
+		return c.array[len(c.array)-1]
+	}
+	for w := roaringWords - 1; ; w-- {
+		if c.bitmap[w] != 0 {
+			return uint16(w*64 + 63 - bits.LeadingZeros64(c.bitmap[w]))
+		}
+	}
+}
+
+func popcount(words []uint64) int {
+	card := 0
+	for _, w := range words {
+		card += bits.OnesCount64(w)
+	}
+	return card
+}
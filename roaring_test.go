@@ -0,0 +1,207 @@
+package handel
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireSameBits checks the roaring bitset holds the same bits as the
+// reference one.
+func requireSameBits(t *testing.T, ref, r BitSet) {
+	require.Equal(t, ref.BitLength(), r.BitLength())
+	require.Equal(t, ref.Cardinality(), r.Cardinality())
+	require.Equal(t, ref.Any(), r.Any())
+	require.Equal(t, ref.None(), r.None())
+	require.Equal(t, ref.All(), r.All())
+	i, e := ref.NextSet(0)
+	j, f := r.NextSet(0)
+	for e || f {
+		require.Equal(t, e, f)
+		require.Equal(t, i, j)
+		i, e = ref.NextSet(i + 1)
+		j, f = r.NextSet(j + 1)
+	}
+}
+
+func TestRoaringBitSet(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	random := func(length, card int) (BitSet, BitSet) {
+		ref, r := nb(length), NewRoaringBitset(length)
+		for k := 0; k < card; k++ {
+			i := rnd.Intn(length)
+			ref.Set(i, true)
+			r.Set(i, true)
+		}
+		return ref, r
+	}
+	// sparse, dense, and across two containers
+	for _, tt := range []struct{ length, card int }{
+		{1, 1},
+		{10, 4},
+		{300, 280},
+		{1 << 16, 20},
+		{1 << 16, 8000},
+		{70000, 30},
+		{70000, 12000},
+	} {
+		ref, r := random(tt.length, tt.card)
+		ref2, r2 := random(tt.length, tt.card)
+		requireSameBits(t, ref, r)
+		for i := 0; i < tt.length; i += 1 + tt.length/100 {
+			require.Equal(t, ref.Get(i), r.Get(i))
+		}
+		requireSameBits(t, ref.Or(ref2), r.Or(r2))
+		requireSameBits(t, ref.And(ref2), r.And(r2))
+		requireSameBits(t, ref.Xor(ref2), r.Xor(r2))
+		requireSameBits(t, ref.AndNot(ref2), r.AndNot(r2))
+		// with another implementation
+		requireSameBits(t, ref.Or(ref2), r.Or(ref2))
+		require.Equal(t, ref.IntersectionCardinality(ref2), r.IntersectionCardinality(r2))
+		require.Equal(t, ref.IsSuperSet(ref2), r.IsSuperSet(r2))
+		require.True(t, r.Or(r2).IsSuperSet(r2))
+
+		c := r.Clone()
+		requireSameBits(t, ref, c)
+		i, _ := r.NextSet(0)
+		c.Set(i, false)
+		require.True(t, r.Get(i))
+		require.False(t, c.Get(i))
+
+		buff, err := r.MarshalBinary()
+		require.NoError(t, err)
+		r3 := NewRoaringBitset(0)
+		require.NoError(t, r3.UnmarshalBinary(buff))
+		requireSameBits(t, ref, r3)
+
+		// unsetting all the bits frees the containers
+		for i, e := ref.NextSet(0); e; i, e = ref.NextSet(i + 1) {
+			r.Set(i, false)
+		}
+		require.True(t, r.None())
+		require.Empty(t, r.(*RoaringBitSet).cs)
+	}
+
+	require.Panics(t, func() { NewRoaringBitset(4).Set(4, true) })
+	require.Panics(t, func() { NewRoaringBitset(4).Get(-1) })
+	require.Panics(t, func() { NewRoaringBitset(4).Or(NewRoaringBitset(5)) })
+}
+
+func TestRoaringBitSetMarshalling(t *testing.T) {
+	r := NewRoaringBitset(100)
+	r.Set(3, true)
+	r.Set(70, true)
+	buff, err := r.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0, 0, 0, 100, // bit length
+		0, 1, // containers
+		0, 0, 0, 1, // key, cardinality - 1
+		0, 3, 0, 70,
+	}, buff)
+
+	r2 := new(RoaringBitSet)
+	// unsorted values
+	invalid := append([]byte{}, buff...)
+	binary.BigEndian.PutUint16(invalid[10:], 71)
+	require.Error(t, r2.UnmarshalBinary(invalid))
+	// bit beyond the bit length
+	invalid = append([]byte{}, buff...)
+	binary.BigEndian.PutUint32(invalid, 70)
+	require.Error(t, r2.UnmarshalBinary(invalid))
+	// trailing and missing data
+	require.Error(t, r2.UnmarshalBinary(append(buff, 0)))
+	require.Error(t, r2.UnmarshalBinary(buff[:len(buff)-1]))
+
+	// a dense container
+	r = NewRoaringBitset(1 << 16)
+	for i := 0; i < roaringArrayMax+1; i++ {
+		r.Set(i, true)
+	}
+	buff, err = r.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, buff, 6+4+roaringWords*8)
+	require.NoError(t, r2.UnmarshalBinary(buff))
+	requireSameBits(t, r, r2)
+	// cardinality not matching the bitmap
+	binary.BigEndian.PutUint16(buff[8:], roaringArrayMax+1)
+	require.Error(t, r2.UnmarshalBinary(buff))
+}
+
+func TestHandelRoaringBitSet(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n).(*arrayRegistry)
+	nets := make([]Network, n)
+	for i := range nets {
+		nets[i] = &TestNetwork{int32(i), nets, nil}
+	}
+	conf := &Config{NewBitSet: NewRoaringBitset, Contributions: n}
+	handels := make([]*Handel, n)
+	for i := range handels {
+		handels[i] = NewHandel(nets[i], reg, reg.ids[i], new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		h.Start()
+	}
+	for _, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality())
+			require.IsType(t, &RoaringBitSet{}, ms.BitSet)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no final signature")
+		}
+	}
+}
+
+// BenchmarkRoaringBitSetSparse compares the memory allocated and the
+// marshalled size of a 65535-bit set with 16 bits set, reported as the
+// "marshalled-bytes" metric, for the dense and the roaring implementations.
+// The length is the largest one the encoding of a WilffBitSet supports.
+func BenchmarkRoaringBitSetSparse(b *testing.B) {
+	length := 1<<16 - 1
+	bits := rand.New(rand.NewSource(1)).Perm(length)[:16]
+	for _, bench := range []struct {
+		name string
+		new  func(int) BitSet
+	}{
+		{"wilff", NewWilffBitset},
+		{"roaring", NewRoaringBitset},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			// the measured encoding must round trip
+			bs := bench.new(length)
+			for _, i := range bits {
+				bs.Set(i, true)
+			}
+			buff, err := bs.MarshalBinary()
+			require.NoError(b, err)
+			decoded := bench.new(1)
+			require.NoError(b, decoded.UnmarshalBinary(buff))
+			require.Equal(b, length, decoded.BitLength())
+			require.Equal(b, len(bits), decoded.Cardinality())
+			for _, i := range bits {
+				require.True(b, decoded.Get(i))
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			var size int
+			for i := 0; i < b.N; i++ {
+				bs := bench.new(length)
+				for _, i := range bits {
+					bs.Set(i, true)
+				}
+				if i == 0 {
+					buff, _ := bs.MarshalBinary()
+					size = len(buff)
+				}
+			}
+			b.ReportMetric(float64(size), "marshalled-bytes")
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package handel
+
+import (
+	"sync"
+	"time"
+)
+
+// SharedScheduler drives the periodic updates and the verification of the
+// signatures of many Handel instances, e.g. thousands of instances simulated
+// in one process, so the number of goroutines does not grow with the number of
+// instances. A Handel instance uses it when set in Config.Scheduler, instead
+// of its own update ticker, processing routine and verified signatures
+// routine. The verifications of all instances are done one at a time, in
+// round robin. The timeout strategy of each instance is still run by the
+// instance.
+type SharedScheduler struct {
+	sync.Mutex
+	period time.Duration
+	// registered instances, in round robin order
+	instances []*scheduled
+	// signals that a signature may be waiting for verification
+	wake    chan bool
+	done    chan bool
+	ticker  *time.Ticker
+	started bool
+	stopped bool
+}
+
+// scheduled is an instance registered to the scheduler.
+type scheduled struct {
+	h    *Handel
+	proc scheduledProcessing
+}
+
+// scheduledProcessing is a signature processing which can be driven by the
+// SharedScheduler instead of its own routine.
+type scheduledProcessing interface {
+	signatureProcessing
+	// tryStep verifies the best pending signature, without waiting for one,
+	// and returns whether a signature has been verified. Once the processing
+	// is stopped, its Verified channel is closed.
+	tryStep() bool
+	// setWake registers a function called each time there are new signatures
+	// to verify.
+	setWake(func())
+}
+
+// NewSharedScheduler returns a SharedScheduler sending the periodic updates
+// of its instances with the given period. The period replaces the
+// Config.UpdatePeriod of the instances, which still disables the updates of
+// an instance when inferior or equal to zero.
+func NewSharedScheduler(period time.Duration) *SharedScheduler {
+	return &SharedScheduler{
+		period: period,
+		wake:   make(chan bool, 1),
+		done:   make(chan bool),
+	}
+}
+
+// Start starts the routines of the scheduler. Instances can register before
+// or after it is started.
+func (s *SharedScheduler) Start() {
+	s.Lock()
+	defer s.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.ticker = time.NewTicker(s.period)
+	go s.updateLoop()
+	go s.verifyLoop()
+}
+
+// Stop stops the routines of the scheduler. The registered instances are not
+// updated anymore and do not verify signatures anymore.
+func (s *SharedScheduler) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if !s.started || s.stopped {
+		return
+	}
+	s.stopped = true
+	s.ticker.Stop()
+	close(s.done)
+}
+
+// register adds the instance to the scheduler.
+func (s *SharedScheduler) register(h *Handel, proc scheduledProcessing) {
+	proc.setWake(s.signal)
+	s.Lock()
+	s.instances = append(s.instances, &scheduled{h: h, proc: proc})
+	s.Unlock()
+	s.signal()
+}
+
+// unregister removes the instance from the scheduler.
+func (s *SharedScheduler) unregister(e *scheduled) {
+	s.Lock()
+	defer s.Unlock()
+	for i, r := range s.instances {
+		if r == e {
+			s.instances = append(s.instances[:i], s.instances[i+1:]...)
+			return
+		}
+	}
+}
+
+// signal wakes up the verification routine, without waiting.
+func (s *SharedScheduler) signal() {
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+// registered returns a copy of the registered instances.
+func (s *SharedScheduler) registered() []*scheduled {
+	s.Lock()
+	defer s.Unlock()
+	return append([]*scheduled{}, s.instances...)
+}
+
+// updateLoop runs the periodic update of every instance at each tick.
+func (s *SharedScheduler) updateLoop() {
+	for {
+		select {
+		case <-s.ticker.C:
+		case <-s.done:
+			return
+		}
+		for _, e := range s.registered() {
+			e.h.periodicUpdate()
+		}
+	}
+}
+
+// verifyLoop verifies a signature of each instance having some in turn, and
+// waits for new signatures when none has.
+func (s *SharedScheduler) verifyLoop() {
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		busy := false
+		for _, e := range s.registered() {
+			if s.step(e) {
+				busy = true
+			}
+		}
+		if busy {
+			continue
+		}
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// step verifies the best pending signature of the instance, if any, and
+// passes the verified signatures to the instance. It returns whether a
+// signature has been verified. A stopped instance is unregistered.
+func (s *SharedScheduler) step(e *scheduled) bool {
+	stepped := e.proc.tryStep()
+	verified := e.proc.Verified()
+	for {
+		select {
+		case v, ok := <-verified:
+			if !ok {
+				s.unregister(e)
+				return stepped
+			}
+			e.h.handleVerified(verified, v)
+		default:
+			return stepped
+		}
+	}
+}
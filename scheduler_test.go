@@ -0,0 +1,72 @@
+package handel
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countRoutines returns the number of goroutines running the given function
+func countRoutines(fn string) int {
+	buff := make([]byte, 1<<24)
+	buff = buff[:runtime.Stack(buff, true)]
+	return strings.Count(string(buff), fn)
+}
+
+func TestSharedScheduler(t *testing.T) {
+	n := 1000
+	routines := []string{
+		"handel.(*evaluatorProcessing).processLoop",
+		"handel.(*Handel).rangeOnVerified",
+		"handel.(*Handel).periodicLoop",
+	}
+	before := make([]int, len(routines))
+	for i, fn := range routines {
+		before[i] = countRoutines(fn)
+	}
+
+	sched := NewSharedScheduler(20 * time.Millisecond)
+	sched.Start()
+	defer sched.Stop()
+	ids := make([]Identity, n)
+	nets := make([]Network, n)
+	for i := 0; i < n; i++ {
+		ids[i] = NewStaticIdentity(int32(i), "", &fakePublic{true})
+		nets[i] = &TestNetwork{id: int32(i), list: nets}
+	}
+	reg := NewArrayRegistry(ids)
+	conf := &Config{Contributions: n, NewTimeoutStrategy: newInfiniteTimeout, Scheduler: sched}
+	handels := make([]*Handel, n)
+	for i := 0; i < n; i++ {
+		handels[i] = NewHandel(nets[i], reg, ids[i], new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	for _, h := range handels {
+		h.Start()
+	}
+	require.Len(t, sched.registered(), n)
+	// the instances have no routine of their own
+	for i, fn := range routines {
+		require.True(t, countRoutines(fn) <= before[i], fn)
+	}
+
+	timeout := time.After(60 * time.Second)
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality())
+		case <-timeout:
+			t.Fatalf("instance %d did not complete", i)
+		}
+	}
+	CloseHandels(handels)
+	// the stopped instances unregister once their processing is stopped
+	for i := 0; len(sched.registered()) > 0; i++ {
+		require.True(t, i < 500, "instances still registered")
+		time.Sleep(10 * time.Millisecond)
+	}
+	// collect the instances so their memory does not slow the next tests down
+	runtime.GC()
+}
@@ -0,0 +1,107 @@
+package handel
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+)
+
+// SessionManager runs concurrent aggregations of different messages, one
+// Handel per message, over a single Network. Each Handel has its own store,
+// levels and output channel, while they share the registry, the network and
+// its listener. The packets are routed to the Handel of their message by
+// Packet.Session, which is the SHA-256 of the message. The packets of a
+// message whose session is not open are dropped, the peers resending their
+// signatures later on. SessionManager is thread-safe.
+type SessionManager struct {
+	sync.Mutex
+	net      Network
+	reg      Registry
+	id       Identity
+	cons     Constructor
+	conf     []*Config
+	sessions map[string]*Handel
+}
+
+// NewSessionManager returns a SessionManager listening to the given network,
+// whose sessions use the given registry, identity, constructor and config.
+func NewSessionManager(n Network, r Registry, id Identity, c Constructor, conf ...*Config) *SessionManager {
+	s := &SessionManager{
+		net:      n,
+		reg:      r,
+		id:       id,
+		cons:     c,
+		conf:     conf,
+		sessions: make(map[string]*Handel),
+	}
+	n.RegisterListener(s)
+	return s
+}
+
+// NewSession returns a new Handel aggregating the signatures of the given
+// message, with our own signature of it. The caller starts it as usual. It
+// returns an error if a session is already open for the message.
+func (s *SessionManager) NewSession(msg []byte, ownSig Signature) (*Handel, error) {
+	s.Lock()
+	defer s.Unlock()
+	key := sessionID(msg)
+	if _, exists := s.sessions[string(key)]; exists {
+		return nil, errors.New("handel: session already open for this message")
+	}
+	net := &sessionNetwork{Network: s.net, session: key}
+	h, err := NewHandelErr(net, s.reg, s.id, s.cons, msg, ownSig, s.conf...)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions[string(key)] = h
+	return h, nil
+}
+
+// CloseSession stops the Handel of the given message and drops the packets
+// of its session from now on.
+func (s *SessionManager) CloseSession(msg []byte) {
+	s.Lock()
+	key := string(sessionID(msg))
+	h, exists := s.sessions[key]
+	delete(s.sessions, key)
+	s.Unlock()
+	if exists {
+		h.Stop()
+	}
+}
+
+// NewPacket implements the Listener interface, dispatching the packet to the
+// Handel of its session.
+func (s *SessionManager) NewPacket(p *Packet) {
+	s.Lock()
+	h, exists := s.sessions[string(p.Session)]
+	s.Unlock()
+	if !exists {
+		return
+	}
+	h.NewPacket(p)
+}
+
+// sessionID returns the identifier of the session of the given message.
+func sessionID(msg []byte) []byte {
+	id := sha256.Sum256(msg)
+	return id[:]
+}
+
+// sessionNetwork is the Network of a session: it marks the packets sent with
+// the session. The packets received are dispatched by the SessionManager.
+type sessionNetwork struct {
+	Network
+	session []byte
+}
+
+// RegisterListener implements the Network interface. The session's Handel is
+// already known to the SessionManager.
+func (s *sessionNetwork) RegisterListener(Listener) {}
+
+// Send implements the Network interface
+func (s *sessionNetwork) Send(ids []Identity, p *Packet) {
+	sp := *p
+	sp.Session = s.session
+	s.Network.Send(ids, &sp)
+}
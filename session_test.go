@@ -0,0 +1,61 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionManager(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	nets := make([]Network, n)
+	for i := range nets {
+		nets[i] = &TestNetwork{int32(i), nets, nil}
+	}
+	msgs := [][]byte{[]byte("block a"), []byte("block b")}
+	managers := make([]*SessionManager, n)
+	sessions := make([][]*Handel, len(msgs))
+	for i := range managers {
+		id, _ := reg.Identity(i)
+		managers[i] = NewSessionManager(nets[i], reg, id, new(fakeCons))
+		for j, msg := range msgs {
+			h, err := managers[i].NewSession(msg, &fakeSig{true})
+			require.NoError(t, err)
+			sessions[j] = append(sessions[j], h)
+		}
+	}
+	_, err := managers[0].NewSession(msgs[0], &fakeSig{true})
+	require.Error(t, err)
+
+	for _, handels := range sessions {
+		for _, h := range handels {
+			go h.Start()
+		}
+	}
+	for j, handels := range sessions {
+		for i, h := range handels {
+			select {
+			case ms := <-h.FinalSignatures():
+				require.True(t, ms.Cardinality() >= h.threshold)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("session %d of instance %d did not complete", j, i)
+			}
+		}
+	}
+
+	// the packets of a closed session are dropped
+	for _, m := range managers {
+		m.CloseSession(msgs[0])
+	}
+	h := sessions[0][1]
+	h.Lock()
+	require.True(t, h.done)
+	h.Unlock()
+	managers[1].NewPacket(&Packet{Origin: 0, Level: 1, Session: sessionID(msgs[0])})
+	managers[1].NewPacket(&Packet{Origin: 0, Level: 1})
+	for _, h := range sessions[1] {
+		h.Stop()
+	}
+}
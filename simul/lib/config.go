@@ -44,6 +44,15 @@ type Config struct {
 	// which network should we use
 	// Valid value: "udp" (default)
 	Network string
+	// latency added to each packet sent, see ParseLatencyModel, e.g.
+	// "fixed:50ms" or "uniform:10ms-100ms". Empty means no latency is added.
+	Latency string
+	// fraction of the packets sent that are lost, see NewUniformLoss. Zero
+	// means no packet is lost.
+	Loss float64
+	// seed of the packet losses of the node 0, the node i using the seed
+	// LossSeed+i, so the runs are reproducible.
+	LossSeed int64
 	// which "curve system" should we use
 	// Valid value: "bn256" (default)
 	Curve string
@@ -169,6 +178,16 @@ func (c *Config) NewNetwork(id handel.Identity) handel.Network {
 	if err != nil {
 		panic(err)
 	}
+	if c.Latency != "" {
+		model, err := ParseLatencyModel(c.Latency)
+		if err != nil {
+			panic(err)
+		}
+		netw = NewLatencyNetwork(netw, model)
+	}
+	if c.Loss > 0 {
+		netw = NewLossyNetwork(netw, NewUniformLoss(c.Loss, c.LossSeed+int64(id.ID())))
+	}
 	return netw
 }
 
@@ -304,6 +323,7 @@ func (r *RunConfig) GetHandelConfig() *handel.Config {
 	ch.FastPath = r.Handel.NodeCount
 	ch.Contributions = r.GetThreshold()
 	ch.UnsafeSleepTimeOnSigVerify = r.Handel.UnsafeSleepTimeOnSigVerify
+	ch.RejectNonImproving = true
 
 	dd, err := time.ParseDuration(r.Handel.Timeout)
 	if err == nil {
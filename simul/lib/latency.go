@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// LatencyModel gives the latency of each link between two nodes
+type LatencyModel interface {
+	// Delay returns the time a packet sent by the node from takes to reach the
+	// node to.
+	Delay(from, to int32) time.Duration
+}
+
+// FixedLatency is a LatencyModel where all the links have the same latency
+type FixedLatency time.Duration
+
+// Delay implements the LatencyModel interface
+func (f FixedLatency) Delay(from, to int32) time.Duration {
+	return time.Duration(f)
+}
+
+// uniformLatency draws the latency of each packet uniformly between min and
+// max.
+type uniformLatency struct {
+	sync.Mutex
+	min, max time.Duration
+	rnd      *rand.Rand
+}
+
+// NewUniformLatency returns a LatencyModel drawing the latency of each packet
+// uniformly in [min, max], from a random source with the given seed.
+func NewUniformLatency(min, max time.Duration, seed int64) LatencyModel {
+	return &uniformLatency{min: min, max: max, rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (u *uniformLatency) Delay(from, to int32) time.Duration {
+	u.Lock()
+	defer u.Unlock()
+	return u.min + time.Duration(u.rnd.Int63n(int64(u.max-u.min)+1))
+}
+
+// LatencyMatrix is a LatencyModel giving the latency of each link: the
+// latency from the node i to the node j is at [i][j]. The links outside the
+// matrix have no latency.
+type LatencyMatrix [][]time.Duration
+
+// Delay implements the LatencyModel interface
+func (m LatencyMatrix) Delay(from, to int32) time.Duration {
+	if int(from) >= len(m) || int(to) >= len(m[from]) {
+		return 0
+	}
+	return m[from][to]
+}
+
+// LoadLatencyMatrix reads a LatencyMatrix from the given file, holding one
+// line per sending node with the latencies in milliseconds to each node
+// separated by spaces.
+func LoadLatencyMatrix(path string) (LatencyMatrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m LatencyMatrix
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var row []time.Duration
+		for _, field := range strings.Fields(line) {
+			var ms float64
+			if _, err := fmt.Sscanf(field, "%g", &ms); err != nil {
+				return nil, fmt.Errorf("latency matrix line %d: %s", len(m)+1, err)
+			}
+			row = append(row, time.Duration(ms*float64(time.Millisecond)))
+		}
+		m = append(m, row)
+	}
+	return m, scanner.Err()
+}
+
+// ParseLatencyModel returns the LatencyModel described by the given string:
+// "fixed:<duration>", "uniform:<min>-<max>" or "matrix:<path of the file>",
+// see LoadLatencyMatrix. The durations are in the time.ParseDuration format.
+func ParseLatencyModel(s string) (LatencyModel, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("latency model must be of the form <kind>:<parameters>")
+	}
+	switch parts[0] {
+	case "fixed":
+		d, err := time.ParseDuration(parts[1])
+		return FixedLatency(d), err
+	case "uniform":
+		bounds := strings.SplitN(parts[1], "-", 2)
+		if len(bounds) != 2 {
+			return nil, errors.New("uniform latency must be of the form uniform:<min>-<max>")
+		}
+		min, err := time.ParseDuration(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		max, err := time.ParseDuration(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if max < min {
+			return nil, errors.New("uniform latency with max < min")
+		}
+		return NewUniformLatency(min, max, time.Now().UnixNano()), nil
+	case "matrix":
+		return LoadLatencyMatrix(parts[1])
+	default:
+		return nil, fmt.Errorf("unknown latency model %s", parts[0])
+	}
+}
+
+// delayedPacket is a packet waiting for its delivery time
+type delayedPacket struct {
+	at time.Time
+	id handel.Identity
+	p  *handel.Packet
+}
+
+// LatencyNetwork is a handel.Network delaying the packets sent by the latency
+// of their link before sending them on the inner network. The packets to a
+// given node are sent in order: a packet is never sent before the packets
+// sent earlier to the same node, even if its own delay is shorter.
+type LatencyNetwork struct {
+	sync.Mutex
+	inner  handel.Network
+	model  LatencyModel
+	queues map[int32]chan delayedPacket
+	done   chan bool
+	closed bool
+}
+
+// NewLatencyNetwork returns a LatencyNetwork delaying the packets sent on the
+// inner network according to the given model. The sender of a packet is its
+// Origin.
+func NewLatencyNetwork(inner handel.Network, model LatencyModel) *LatencyNetwork {
+	return &LatencyNetwork{
+		inner:  inner,
+		model:  model,
+		queues: make(map[int32]chan delayedPacket),
+		done:   make(chan bool),
+	}
+}
+
+// RegisterListener implements the handel.Network interface
+func (l *LatencyNetwork) RegisterListener(li handel.Listener) {
+	l.inner.RegisterListener(li)
+}
+
+// Send implements the handel.Network interface
+func (l *LatencyNetwork) Send(ids []handel.Identity, p *handel.Packet) {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return
+	}
+	for _, id := range ids {
+		queue, exists := l.queues[id.ID()]
+		if !exists {
+			queue = make(chan delayedPacket, 1000)
+			l.queues[id.ID()] = queue
+			go l.deliver(queue)
+		}
+		at := now.Add(l.model.Delay(p.Origin, id.ID()))
+		select {
+		case queue <- delayedPacket{at: at, id: id, p: p}:
+		default:
+			// the queue is full: the packet is lost, as on a congested link
+		}
+	}
+}
+
+// deliver sends the packets of the queue in order, each at its delivery time.
+func (l *LatencyNetwork) deliver(queue chan delayedPacket) {
+	for {
+		select {
+		case d := <-queue:
+			select {
+			case <-time.After(time.Until(d.at)):
+				l.inner.Send([]handel.Identity{d.id}, d.p)
+			case <-l.done:
+				return
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Stop drops the packets not delivered yet. The packets sent afterwards are
+// dropped.
+func (l *LatencyNetwork) Stop() {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.done)
+}
+
+// Values implements the handel.Reporter interface, with the values of the
+// inner network if it is a Reporter.
+func (l *LatencyNetwork) Values() map[string]float64 {
+	if r, ok := l.inner.(handel.Reporter); ok {
+		return r.Values()
+	}
+	return map[string]float64{}
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+// timedNetwork records the time each packet is sent to each identity
+type timedNetwork struct {
+	sync.Mutex
+	sent map[int32][]*handel.Packet
+	at   map[*handel.Packet]time.Time
+	ch   chan bool
+}
+
+func (t *timedNetwork) RegisterListener(handel.Listener) {}
+
+func (t *timedNetwork) Send(ids []handel.Identity, p *handel.Packet) {
+	t.Lock()
+	for _, id := range ids {
+		t.sent[id.ID()] = append(t.sent[id.ID()], p)
+	}
+	t.at[p] = time.Now()
+	t.Unlock()
+	t.ch <- true
+}
+
+func TestLatencyNetwork(t *testing.T) {
+	inner := &timedNetwork{
+		sent: make(map[int32][]*handel.Packet),
+		at:   make(map[*handel.Packet]time.Time),
+		ch:   make(chan bool, 10),
+	}
+	model := LatencyMatrix{
+		{0, 100 * time.Millisecond},
+		{0, 0},
+	}
+	net := NewLatencyNetwork(inner, model)
+	defer net.Stop()
+	to := handel.NewStaticIdentity(1, "", nil)
+
+	// the second packet has no latency but is sent after the first one
+	p1 := &handel.Packet{Origin: 0}
+	p2 := &handel.Packet{Origin: 1}
+	start := time.Now()
+	net.Send([]handel.Identity{to}, p1)
+	net.Send([]handel.Identity{to}, p2)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-inner.ch:
+		case <-time.After(time.Second):
+			t.Fatal("packet not delivered")
+		}
+	}
+	inner.Lock()
+	defer inner.Unlock()
+	require.Equal(t, []*handel.Packet{p1, p2}, inner.sent[1])
+	require.True(t, inner.at[p1].Sub(start) >= 100*time.Millisecond)
+}
+
+func TestLatencyModels(t *testing.T) {
+	u := NewUniformLatency(10*time.Millisecond, 20*time.Millisecond, 1)
+	for i := 0; i < 100; i++ {
+		d := u.Delay(0, 1)
+		require.True(t, d >= 10*time.Millisecond && d <= 20*time.Millisecond)
+	}
+
+	path := filepath.Join(t.TempDir(), "latency")
+	require.NoError(t, ioutil.WriteFile(path, []byte("0 12.5\n30 0\n"), 0644))
+	m, err := ParseLatencyModel("matrix:" + path)
+	require.NoError(t, err)
+	require.Equal(t, 12500*time.Microsecond, m.Delay(0, 1))
+	require.Equal(t, 30*time.Millisecond, m.Delay(1, 0))
+	require.Equal(t, time.Duration(0), m.Delay(2, 0))
+
+	m, err = ParseLatencyModel("fixed:50ms")
+	require.NoError(t, err)
+	require.Equal(t, 50*time.Millisecond, m.Delay(3, 4))
+	_, err = ParseLatencyModel("uniform:20ms-10ms")
+	require.Error(t, err)
+	_, err = ParseLatencyModel("gaussian:10ms")
+	require.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/ConsenSys/handel"
+)
+
+// LossModel decides which packets are lost
+type LossModel interface {
+	// Drop returns true if the packet sent by the node from to the node to is
+	// lost.
+	Drop(from, to int32) bool
+}
+
+// uniformLoss loses each packet independently with the same probability
+type uniformLoss struct {
+	sync.Mutex
+	p   float64
+	rnd *rand.Rand
+}
+
+// NewUniformLoss returns a LossModel losing each packet independently with the
+// probability p, from a random source with the given seed.
+func NewUniformLoss(p float64, seed int64) LossModel {
+	return &uniformLoss{p: p, rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (u *uniformLoss) Drop(from, to int32) bool {
+	u.Lock()
+	defer u.Unlock()
+	return u.rnd.Float64() < u.p
+}
+
+// link is a directed link between two nodes
+type link struct {
+	from, to int32
+}
+
+// burstLoss is a Gilbert model: each link is either in a good state, where
+// no packet is lost, or in a bad state, where all the packets are lost.
+type burstLoss struct {
+	sync.Mutex
+	enter, leave float64
+	bad          map[link]bool
+	rnd          *rand.Rand
+}
+
+// NewBurstLoss returns a LossModel where the packets are lost by bursts: at
+// each packet, a link starts losing all the packets with the probability
+// enter, and a link losing the packets recovers with the probability leave.
+// The links lose enter / (enter + leave) of the packets on average, in bursts
+// of 1 / leave packets on average. The random source has the given seed.
+func NewBurstLoss(enter, leave float64, seed int64) LossModel {
+	return &burstLoss{
+		enter: enter,
+		leave: leave,
+		bad:   make(map[link]bool),
+		rnd:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (b *burstLoss) Drop(from, to int32) bool {
+	b.Lock()
+	defer b.Unlock()
+	l := link{from, to}
+	if b.bad[l] {
+		b.bad[l] = b.rnd.Float64() >= b.leave
+	} else {
+		b.bad[l] = b.rnd.Float64() < b.enter
+	}
+	return b.bad[l]
+}
+
+// LossyNetwork is a handel.Network losing the packets sent according to a
+// LossModel, before they reach the inner network. The sender of a packet is
+// its Origin.
+type LossyNetwork struct {
+	inner handel.Network
+	model LossModel
+}
+
+// NewLossyNetwork returns a LossyNetwork losing the packets sent on the inner
+// network according to the given model.
+func NewLossyNetwork(inner handel.Network, model LossModel) *LossyNetwork {
+	return &LossyNetwork{inner: inner, model: model}
+}
+
+// RegisterListener implements the handel.Network interface
+func (l *LossyNetwork) RegisterListener(li handel.Listener) {
+	l.inner.RegisterListener(li)
+}
+
+// Send implements the handel.Network interface
+func (l *LossyNetwork) Send(ids []handel.Identity, p *handel.Packet) {
+	kept := make([]handel.Identity, 0, len(ids))
+	for _, id := range ids {
+		if !l.model.Drop(p.Origin, id.ID()) {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) > 0 {
+		l.inner.Send(kept, p)
+	}
+}
+
+// Values implements the handel.Reporter interface, with the values of the
+// inner network if it is a Reporter.
+func (l *LossyNetwork) Values() map[string]float64 {
+	if r, ok := l.inner.(handel.Reporter); ok {
+		return r.Values()
+	}
+	return map[string]float64{}
+}
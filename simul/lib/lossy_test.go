@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCons is the handel.Constructor of the fake signatures of the empty
+// constructor
+type fakeCons struct{}
+
+func (f *fakeCons) Signature() handel.Signature { return new(fakeSig) }
+func (f *fakeCons) PublicKey() handel.PublicKey { return new(fakePublic) }
+
+// memNetwork dispatches the packets to the listeners of the other nodes in
+// memory
+type memNetwork struct {
+	sync.Mutex
+	nets []*memNetwork
+	lis  []handel.Listener
+}
+
+func (m *memNetwork) RegisterListener(l handel.Listener) {
+	m.Lock()
+	defer m.Unlock()
+	m.lis = append(m.lis, l)
+}
+
+func (m *memNetwork) Send(ids []handel.Identity, p *handel.Packet) {
+	for _, id := range ids {
+		go func(dst *memNetwork) {
+			dst.Lock()
+			lis := dst.lis
+			dst.Unlock()
+			for _, l := range lis {
+				l.NewPacket(p)
+			}
+		}(m.nets[id.ID()])
+	}
+}
+
+func TestLossModels(t *testing.T) {
+	count := func(m LossModel) int {
+		lost := 0
+		for i := 0; i < 10000; i++ {
+			if m.Drop(0, 1) {
+				lost++
+			}
+		}
+		return lost
+	}
+	require.InDelta(t, 2000, count(NewUniformLoss(0.2, 1)), 200)
+	require.Equal(t, count(NewUniformLoss(0.2, 1)), count(NewUniformLoss(0.2, 1)))
+	require.Zero(t, count(NewUniformLoss(0, 1)))
+	// 20% lost on average, by bursts
+	require.InDelta(t, 2000, count(NewBurstLoss(0.05, 0.2, 1)), 400)
+
+	// the losses of a link are in bursts
+	burst := NewBurstLoss(0.05, 0.1, 2)
+	var bursts, lost int
+	prev := false
+	for i := 0; i < 10000; i++ {
+		drop := burst.Drop(0, 1)
+		if drop {
+			lost++
+			if !prev {
+				bursts++
+			}
+		}
+		prev = drop
+	}
+	require.True(t, lost/bursts >= 5)
+}
+
+func TestLossyNetworkConvergence(t *testing.T) {
+	n := 32
+	period := 10 * time.Millisecond
+	maxTicks := 200
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(int32(i), "", new(fakePublic))
+	}
+	reg := handel.NewArrayRegistry(ids)
+	nets := make([]*memNetwork, n)
+	for i := range nets {
+		nets[i] = &memNetwork{nets: nets}
+	}
+	conf := handel.DefaultConfig(n)
+	conf.UpdatePeriod = period
+	conf.Logger = handel.NewKitLoggerFrom(log.NewNopLogger())
+	threshold := conf.Contributions
+	handels := make([]*handel.Handel, n)
+	for i := range handels {
+		lossy := NewLossyNetwork(nets[i], NewUniformLoss(0.2, int64(i)))
+		handels[i] = handel.NewHandel(lossy, reg, ids[i], new(fakeCons), Message, new(fakeSig), conf)
+	}
+	for _, h := range handels {
+		h.Start()
+		defer h.Stop()
+	}
+	deadline := time.After(time.Duration(maxTicks) * period)
+	for _, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.True(t, ms.Cardinality() >= threshold)
+		case <-deadline:
+			t.Fatalf("no threshold signature within %d ticks", maxTicks)
+		}
+	}
+}
@@ -3,8 +3,14 @@ package lib
 import (
 	"bufio"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 
 	"github.com/ConsenSys/handel"
@@ -154,3 +160,122 @@ func (c *csvParser) Write(uri string, records []*NodeRecord) error {
 	w.Flush()
 	return nil
 }
+
+// identityRecord is the format of an identity in the registry files, see
+// LoadRegistry.
+type identityRecord struct {
+	ID      int32  `json:"id"`
+	Address string `json:"address,omitempty"`
+	Public  string `json:"public"` // hex encoded
+}
+
+// LoadRegistry reads the identities of the file at the given path and returns
+// the registry made of them. A file with the ".json" extension holds a JSON
+// array of objects with the "id", the optional "address" and the hex encoded
+// "public" key of each identity, as written by WriteRegistry. Otherwise, the
+// file is a CSV with the ID, the address and the hex encoded public key of an
+// identity per line; the CSV written by the NodeParser, with the private key
+// before the public one, is accepted as well. The IDs must go from 0 to the
+// number of identities - 1.
+func LoadRegistry(path string, cons Constructor) (handel.Registry, error) {
+	var records []*identityRecord
+	var err error
+	if filepath.Ext(path) == ".json" {
+		records, err = readJSONIdentities(path)
+	} else {
+		records, err = readCSVIdentities(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]handel.Identity, len(records))
+	for _, rec := range records {
+		if rec.ID < 0 || int(rec.ID) >= len(ids) {
+			return nil, fmt.Errorf("registry: identity %d out of the IDs 0 to %d", rec.ID, len(ids)-1)
+		}
+		if ids[rec.ID] != nil {
+			return nil, fmt.Errorf("registry: duplicate identity %d", rec.ID)
+		}
+		buff, err := hex.DecodeString(rec.Public)
+		if err != nil {
+			return nil, fmt.Errorf("registry: identity %d: malformed public key: %s", rec.ID, err)
+		}
+		pk := cons.PublicKey()
+		if err := pk.UnmarshalBinary(buff); err != nil {
+			return nil, fmt.Errorf("registry: identity %d: malformed public key: %s", rec.ID, err)
+		}
+		ids[rec.ID] = handel.NewStaticIdentity(rec.ID, rec.Address, pk)
+	}
+	return handel.NewArrayRegistry(ids), nil
+}
+
+func readJSONIdentities(path string) ([]*identityRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []*identityRecord
+	if err := json.NewDecoder(file).Decode(&records); err != nil {
+		return nil, fmt.Errorf("registry: %s", err)
+	}
+	return records, nil
+}
+
+func readCSVIdentities(path string) ([]*identityRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	csvReader := csv.NewReader(bufio.NewReader(file))
+	csvReader.FieldsPerRecord = -1
+	var records []*identityRecord
+	for {
+		line, err := csvReader.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(line) != 3 && len(line) != 4 {
+			return nil, fmt.Errorf("registry: line %d: %d fields instead of 3 or 4", len(records)+1, len(line))
+		}
+		id, err := strconv.ParseInt(line[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("registry: line %d: %s", len(records)+1, err)
+		}
+		records = append(records, &identityRecord{
+			ID:      int32(id),
+			Address: line[1],
+			Public:  line[len(line)-1],
+		})
+	}
+}
+
+// WriteRegistry writes the identities of the registry to w as the JSON read
+// by LoadRegistry from a ".json" file, sorted by ID so the output of a given
+// registry is always the same. The address of the identities without one is
+// omitted. The public keys of the identities must implement PublicKey.
+func WriteRegistry(w io.Writer, reg handel.Registry) error {
+	ids, ok := reg.Identities(0, reg.Size())
+	if !ok {
+		return errors.New("registry: can not read the identities")
+	}
+	records := make([]*identityRecord, len(ids))
+	for i, id := range ids {
+		pk, ok := id.PublicKey().(PublicKey)
+		if !ok {
+			return fmt.Errorf("registry: identity %d: public key not marshallable", id.ID())
+		}
+		buff, err := pk.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		records[i] = &identityRecord{ID: id.ID(), Address: id.Address(), Public: hex.EncodeToString(buff)}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
@@ -1,12 +1,19 @@
 package lib
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/ConsenSys/handel"
 	"github.com/stretchr/testify/require"
 )
 
@@ -77,3 +84,142 @@ func TestCSVParser(t *testing.T) {
 	}
 
 }
+
+// keyPublic is a fake public key marshalled to its 4 bytes
+type keyPublic struct {
+	fakePublic
+	v uint32
+}
+
+func (k *keyPublic) MarshalBinary() ([]byte, error) {
+	buff := make([]byte, 4)
+	binary.BigEndian.PutUint32(buff, k.v)
+	return buff, nil
+}
+
+func (k *keyPublic) UnmarshalBinary(buff []byte) error {
+	if len(buff) != 4 {
+		return errors.New("public key of 4 bytes expected")
+	}
+	k.v = binary.BigEndian.Uint32(buff)
+	return nil
+}
+
+type keyConstructor struct {
+	emptyConstructor
+}
+
+func (k *keyConstructor) PublicKey() PublicKey {
+	return new(keyPublic)
+}
+
+func TestLoadRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cons := new(keyConstructor)
+	n := 50
+	var records []*NodeRecord
+	for i := 0; i < n; i++ {
+		records = append(records, &NodeRecord{
+			ID:      int32(i),
+			Addr:    fmt.Sprintf("127.0.0.1:%d", 3000+i),
+			Private: "aed142",
+			Public:  fmt.Sprintf("%08x", i*7),
+		})
+	}
+	check := func(path string) {
+		reg, err := LoadRegistry(path, cons)
+		require.NoError(t, err)
+		require.Equal(t, n, reg.Size())
+		for i := 0; i < n; i++ {
+			id, ok := reg.Identity(i)
+			require.True(t, ok)
+			require.Equal(t, int32(i), id.ID())
+			require.Equal(t, records[i].Addr, id.Address())
+			require.Equal(t, uint32(i*7), id.PublicKey().(*keyPublic).v)
+		}
+	}
+
+	// the CSV written by the node parser
+	path := filepath.Join(dir, "registry.csv")
+	require.NoError(t, NewCSVParser().Write(path, records))
+	check(path)
+
+	// the JSON format
+	var identities []*identityRecord
+	for _, rec := range records {
+		identities = append(identities, &identityRecord{ID: rec.ID, Address: rec.Addr, Public: rec.Public})
+	}
+	buff, err := json.Marshal(identities)
+	require.NoError(t, err)
+	path = filepath.Join(dir, "registry.json")
+	require.NoError(t, ioutil.WriteFile(path, buff, 0644))
+	check(path)
+
+	// the node files with unordered IDs are accepted
+	name := writeCSV([][]string{
+		{"1", "127.0.0.1:3001", "aed142", "00000001"},
+		{"0", "127.0.0.1:3000", "aed142", "00000000"},
+	})
+	defer os.RemoveAll(name)
+	reg2, err := LoadRegistry(name, cons)
+	require.NoError(t, err)
+	require.Equal(t, 2, reg2.Size())
+
+	invalid := func(records [][]string, msg string) {
+		name := writeCSV(records)
+		defer os.RemoveAll(name)
+		_, err := LoadRegistry(name, cons)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), msg)
+	}
+	invalid([][]string{
+		{"0", "127.0.0.1:3000", "00000000"},
+		{"0", "127.0.0.1:3001", "00000001"},
+	}, "duplicate identity 0")
+	invalid([][]string{
+		{"0", "127.0.0.1:3000", "00000000"},
+		{"2", "127.0.0.1:3002", "00000002"},
+	}, "identity 2 out of the IDs 0 to 1")
+	invalid([][]string{{"0", "127.0.0.1:3000", "zz"}}, "identity 0: malformed public key")
+	invalid([][]string{{"0", "127.0.0.1:3000", "0000"}}, "identity 0: malformed public key")
+	invalid([][]string{{"x", "127.0.0.1:3000", "00000000"}}, "line 1")
+}
+
+func TestWriteRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	n := 50
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		var addr string
+		if i%2 == 0 {
+			addr = fmt.Sprintf("127.0.0.1:%d", 3000+i)
+		}
+		// the registry is not in ID order
+		ids[n-1-i] = handel.NewStaticIdentity(int32(i), addr, &keyPublic{v: uint32(i * 7)})
+	}
+	var b bytes.Buffer
+	require.NoError(t, WriteRegistry(&b, handel.NewArrayRegistry(ids)))
+	require.Equal(t, n/2, strings.Count(b.String(), `"address"`))
+	path := filepath.Join(dir, "registry.json")
+	require.NoError(t, ioutil.WriteFile(path, b.Bytes(), 0644))
+
+	reg, err := LoadRegistry(path, new(keyConstructor))
+	require.NoError(t, err)
+	require.Equal(t, n, reg.Size())
+	for i := 0; i < n; i++ {
+		id, ok := reg.Identity(i)
+		require.True(t, ok)
+		require.Equal(t, int32(i), id.ID())
+		require.Equal(t, ids[n-1-i].Address(), id.Address())
+		require.Equal(t, uint32(i*7), id.PublicKey().(*keyPublic).v)
+	}
+
+	// the output is sorted by ID
+	var b2 bytes.Buffer
+	require.NoError(t, WriteRegistry(&b2, reg))
+	require.Equal(t, b.String(), b2.String())
+}
@@ -1,9 +1,13 @@
 package p2p
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/ConsenSys/handel"
@@ -96,6 +100,100 @@ func (*random) Connect(node Node, reg handel.Registry, max int) error {
 	return nil
 }
 
+// Topology is the adjacency list of a network: the IDs of the peers each node
+// is allowed to connect to.
+type Topology map[int32][]int32
+
+// ParseTopology reads a Topology written with a line "nodeID: peer1,peer2,..."
+// per node. A node without peers is written "nodeID:". Empty lines and lines
+// starting with # are ignored.
+func ParseTopology(r io.Reader) (Topology, error) {
+	t := make(Topology)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("topology line %d: missing ':'", line)
+		}
+		node, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("topology line %d: %s", line, err)
+		}
+		if _, exists := t[int32(node)]; exists {
+			return nil, fmt.Errorf("topology line %d: node %d listed twice", line, node)
+		}
+		peers := []int32{}
+		for _, field := range strings.Split(parts[1], ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			peer, err := strconv.ParseInt(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("topology line %d: %s", line, err)
+			}
+			peers = append(peers, int32(peer))
+		}
+		t[int32(node)] = peers
+	}
+	return t, scanner.Err()
+}
+
+// LoadTopology reads the Topology of the given file, see ParseTopology.
+func LoadTopology(path string) (Topology, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTopology(f)
+}
+
+type fileConnector struct {
+	topology Topology
+}
+
+// NewFileConnector returns a Connector that connects each node only to its
+// peers in the topology of the given file, see ParseTopology. The nodes absent
+// from the topology connect to no one.
+func NewFileConnector(path string) (Connector, error) {
+	t, err := LoadTopology(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTopologyConnector(t), nil
+}
+
+// NewTopologyConnector returns a Connector that connects each node only to
+// its peers in the given topology.
+func NewTopologyConnector(t Topology) Connector {
+	return &fileConnector{topology: t}
+}
+
+// Connect connects the node to at most max of its peers in the topology. It
+// returns an error if a peer is not in the registry.
+func (f *fileConnector) Connect(node Node, reg handel.Registry, max int) error {
+	own := node.Identity().ID()
+	for i, id := range f.topology[own] {
+		if i >= max {
+			break
+		}
+		identity, ok := reg.Identity(int(id))
+		if !ok {
+			return fmt.Errorf("topology: node %d connects to unknown node %d", own, id)
+		}
+		if err := node.Connect(identity); err != nil {
+			fmt.Println(own, "error connecting to ", id, ":", err)
+			continue
+		}
+	}
+	return nil
+}
+
 // ExtractConnector returns connector
 func ExtractConnector(opts Opts) (Connector, int) {
 	c, exists := opts.String("Connector")
@@ -114,6 +212,12 @@ func ExtractConnector(opts Opts) (Connector, int) {
 	case "random":
 		con = NewRandomConnector()
 		fmt.Println(" selecting RANDOM connector with ", count)
+	case "file":
+		path, _ := opts.String("Topology")
+		var err error
+		con, err = NewFileConnector(path)
+		requireNil(err)
+		fmt.Println(" selecting FILE connector from ", path, " with ", count)
 	}
 	return con, count
 
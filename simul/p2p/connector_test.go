@@ -0,0 +1,68 @@
+package p2p
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/stretchr/testify/require"
+)
+
+// connectNode is a Node recording the identities it connects to
+type connectNode struct {
+	id        handel.Identity
+	connected []int32
+}
+
+func (c *connectNode) Values() map[string]float64 { return nil }
+func (c *connectNode) Identity() handel.Identity  { return c.id }
+func (c *connectNode) SecretKey() lib.SecretKey   { return nil }
+func (c *connectNode) Diffuse(*handel.Packet)     {}
+func (c *connectNode) Next() chan handel.Packet   { return nil }
+func (c *connectNode) Connect(id handel.Identity) error {
+	c.connected = append(c.connected, id.ID())
+	return nil
+}
+
+func TestFileConnector(t *testing.T) {
+	n := 4
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(int32(i), "", nil)
+	}
+	reg := handel.NewArrayRegistry(ids)
+	// a 3-clique and an isolated node
+	topology, err := ParseTopology(strings.NewReader(`
+# clique
+0: 1,2
+1: 0, 2
+2: 0,1
+
+3:
+`))
+	require.NoError(t, err)
+	require.Len(t, topology, n)
+	con := NewTopologyConnector(topology)
+	expected := [][]int32{{1, 2}, {0, 2}, {0, 1}, nil}
+	for i, exp := range expected {
+		node := &connectNode{id: ids[i]}
+		require.NoError(t, con.Connect(node, reg, MaxCount))
+		require.Equal(t, exp, node.connected)
+	}
+	// at most max peers
+	node := &connectNode{id: ids[0]}
+	require.NoError(t, con.Connect(node, reg, 1))
+	require.Equal(t, []int32{1}, node.connected)
+
+	// an edge to an unknown node
+	topology, err = ParseTopology(strings.NewReader("0: 1,4"))
+	require.NoError(t, err)
+	err = NewTopologyConnector(topology).Connect(&connectNode{id: ids[0]}, reg, MaxCount)
+	require.Error(t, err)
+
+	for _, invalid := range []string{"0 1,2", "x: 1", "0: 1,y", "0: 1\n0: 2"} {
+		_, err := ParseTopology(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+}
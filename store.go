@@ -3,6 +3,7 @@ package handel
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -10,7 +11,8 @@ import (
 // multisignature, and to be able to serve the best multisignature received so
 // far at a given level. Different strategies can be implemented such as keeping
 // only the best one, merging two non-colluding multi-signatures etc.
-// NOTE: implementation MUST be thread-safe.
+// NOTE: implementation MUST be thread-safe. Implementations keeping several
+// candidates per level must respect Config.MaxCandidatesPerLevel.
 type SignatureStore interface {
 	// A Store is as well an evaluator since it best knows which signatures are
 	// important.
@@ -21,6 +23,9 @@ type SignatureStore interface {
 	Store(sp *incomingSig) *MultiSignature
 	// GetBest returns the "best" multisignature at the requested level. Best
 	// should be interpreted as "containing the most individual contributions".
+	// Among signatures with as many contributions, the best is the one whose
+	// bitset is the smallest according to bitsetLess, so the choice does not
+	// depend on the order in which the signatures are stored.
 	// Tt returns false if there is no signature associated to that level, true
 	// otherwise.
 	Best(level byte) (*MultiSignature, bool)
@@ -31,7 +36,9 @@ type SignatureStore interface {
 	Combined(level byte) *MultiSignature
 
 	// FullSignature returns the best combined multi-signatures with the bitset
-	// bitlength being the size of the registry.
+	// bitlength being the size of the registry. Since only verified signatures
+	// are stored, the result is valid by construction and does not need to be
+	// verified again.
 	FullSignature() *MultiSignature
 }
 
@@ -56,13 +63,34 @@ type store struct {
 
 	// We keep all our verified individual signatures
 	individualSigs map[byte]map[int]*MultiSignature
+
+	// combine the signatures in ascending level order, our own contribution
+	// first
+	selfFirst bool
+
+	// signature covering the levels below its level, whose bitset is the one
+	// of the full signature, see Handel.StartAt
+	prefix *incomingSig
+	// bitset of the prefix combined at each level, the full signature being
+	// at -1
+	prefixBits map[int]BitSet
+}
+
+// prefixStore is a store that can be seeded with a signature covering several
+// levels, see Handel.StartAt.
+type prefixStore interface {
+	// setPrefix stores the signature covering the levels below the given one,
+	// whose bitset has the length of the full signature. The combined
+	// signatures use it instead of the signatures of these levels, as long as
+	// they do not hold more contributions.
+	setPrefix(level byte, ms *MultiSignature)
 }
 
 // newStore is the constructor for the store.
 func newStore(part Partitioner, nbs func(int) BitSet, c Constructor) *store {
 	indivSigsVerified := make(map[byte]BitSet)
 	individualSigs := make(map[byte]map[int]*MultiSignature)
-	indivSigsVerified[0] = nbs(1)
+	indivSigsVerified[0] = nbs(part.Size(0))
 	individualSigs[0] = make(map[int]*MultiSignature)
 	for _, lvl := range part.Levels() {
 		indivSigsVerified[byte(lvl)] = nbs(part.Size(lvl))
@@ -82,7 +110,10 @@ func newStore(part Partitioner, nbs func(int) BitSet, c Constructor) *store {
 func (r *store) Store(sp *incomingSig) *MultiSignature {
 	r.Lock()
 	defer r.Unlock()
+	return r.unsafeStore(sp)
+}
 
+func (r *store) unsafeStore(sp *incomingSig) *MultiSignature {
 	if sp.Individual() {
 		if sp.ms.BitSet.Cardinality() != 1 {
 			panic("bad individual sig")
@@ -208,7 +239,7 @@ func (r *store) unsafeCheckMerge(sp *incomingSig) (*MultiSignature, bool) {
 	// There are some individual sigs that we could use.
 	// Let's check first that the final signature will be larger than the
 	// existing one
-	if iS.Cardinality()+best.Cardinality() <= ms2.Cardinality() {
+	if iS.Cardinality()+best.Cardinality() < ms2.Cardinality() {
 		return nil, false
 	}
 
@@ -225,9 +256,102 @@ func (r *store) unsafeCheckMerge(sp *incomingSig) (*MultiSignature, bool) {
 		best.Signature = sig.Combine(best.Signature)
 	}
 
+	// with as many contributions, the smallest bitset wins the tie
+	if best.Cardinality() == ms2.Cardinality() && !bitsetLess(best.BitSet, ms2.BitSet) {
+		return nil, false
+	}
 	return best, true
 }
 
+// bitsetLess returns true if the bitset a is lexicographically smaller than b,
+// i.e. if a is shorter, or if the first bit differing between a and b is
+// unset in a. It is the tie-break between signatures of equal cardinality.
+func bitsetLess(a, b BitSet) bool {
+	if a.BitLength() != b.BitLength() {
+		return a.BitLength() < b.BitLength()
+	}
+	pos, diff := a.Xor(b).NextSet(0)
+	return diff && !a.Get(pos)
+}
+
+func (r *store) setPrefix(level byte, ms *MultiSignature) {
+	r.Lock()
+	defer r.Unlock()
+	r.prefix = &incomingSig{level: level, ms: ms}
+	r.prefixBits = make(map[int]BitSet)
+}
+
+// unsafeWithPrefix returns the combination of the given signatures and, if
+// it holds more contributions than the signatures of the levels it covers,
+// of the prefix instead of them. The combination is the one of the given
+// level as for Partitioner.Combine, or the full signature if full is set.
+func (r *store) unsafeWithPrefix(sigs []*incomingSig, level int, full bool) *MultiSignature {
+	combine := func(sigs []*incomingSig) *MultiSignature {
+		if full {
+			return r.part.CombineFull(sigs, r.nbs)
+		}
+		return r.part.Combine(sigs, level, r.nbs)
+	}
+	if r.prefix == nil || (!full && level < int(r.prefix.level)) {
+		return combine(sigs)
+	}
+	var upper []*incomingSig
+	covered := 0
+	for _, s := range sigs {
+		if s.level < r.prefix.level {
+			covered += s.ms.Cardinality()
+		} else {
+			upper = append(upper, s)
+		}
+	}
+	if covered >= r.prefix.ms.Cardinality() {
+		return combine(sigs)
+	}
+	key := level
+	if full {
+		key = -1
+	}
+	bits, ok := r.prefixBits[key]
+	if !ok {
+		bits = combine(r.unsafePrefixPieces()).BitSet
+		r.prefixBits[key] = bits
+	}
+	res := &MultiSignature{BitSet: bits.Clone(), Signature: r.prefix.ms.Signature}
+	if len(upper) == 0 {
+		return res
+	}
+	ms := combine(upper)
+	res.BitSet = res.Or(ms.BitSet)
+	res.Signature = res.Signature.Combine(ms.Signature)
+	return res
+}
+
+// unsafePrefixPieces splits the bitset of the prefix by level. The pieces
+// only serve to compute the bitset of the prefix in the combinations: they
+// all hold the signature of the whole prefix.
+func (r *store) unsafePrefixPieces() []*incomingSig {
+	var pieces []*incomingSig
+	for _, lvl := range append([]int{0}, r.part.Levels()...) {
+		if lvl >= int(r.prefix.level) {
+			break
+		}
+		ids, _ := r.part.IdentitiesAt(lvl)
+		bs := r.nbs(r.part.Size(lvl))
+		for _, id := range ids {
+			if !r.prefix.ms.Get(int(id.ID())) {
+				continue
+			}
+			idx, _ := r.part.IndexAtLevel(id.ID(), lvl)
+			bs.Set(idx, true)
+		}
+		pieces = append(pieces, &incomingSig{
+			level: byte(lvl),
+			ms:    &MultiSignature{BitSet: bs, Signature: r.prefix.ms.Signature},
+		})
+	}
+	return pieces
+}
+
 func (r *store) Best(level byte) (*MultiSignature, bool) {
 	r.Lock()
 	defer r.Unlock()
@@ -235,19 +359,31 @@ func (r *store) Best(level byte) (*MultiSignature, bool) {
 	return ms, ok
 }
 
+// FullSignature returns the combination of the best signatures of all
+// levels, including our own contribution stored at level 0. With
+// Config.SelfFirst, it is the first signature combined.
 func (r *store) FullSignature() *MultiSignature {
 	r.Lock()
 	defer r.Unlock()
-	sigs := make([]*incomingSig, 0, len(r.m))
-	for k, ms := range r.m {
-		sigs = append(sigs, &incomingSig{level: k, ms: ms})
-	}
-	return r.part.CombineFull(sigs, r.nbs)
+	return r.unsafeWithPrefix(r.unsafeSigsUpTo(byte(r.part.MaxLevel())), 0, true)
 }
 
+// Combined returns the combination of the best signatures of the levels up to
+// the given one, including our own contribution stored at level 0. With
+// Config.SelfFirst, it is the first signature combined.
 func (r *store) Combined(level byte) *MultiSignature {
 	r.Lock()
 	defer r.Unlock()
+	sigs := r.unsafeSigsUpTo(level)
+	if level < byte(r.part.MaxLevel()) {
+		level++
+	}
+	return r.unsafeWithPrefix(sigs, int(level), false)
+}
+
+// unsafeSigsUpTo returns the best signatures of the levels up to the given
+// one, in ascending level order if selfFirst is set.
+func (r *store) unsafeSigsUpTo(level byte) []*incomingSig {
 	sigs := make([]*incomingSig, 0, len(r.m))
 	for k, ms := range r.m {
 		if k > level {
@@ -255,10 +391,10 @@ func (r *store) Combined(level byte) *MultiSignature {
 		}
 		sigs = append(sigs, &incomingSig{level: k, ms: ms})
 	}
-	if level < byte(r.part.MaxLevel()) {
-		level++
+	if r.selfFirst {
+		sort.Slice(sigs, func(i, j int) bool { return sigs[i].level < sigs[j].level })
 	}
-	return r.part.Combine(sigs, int(level), r.nbs)
+	return sigs
 }
 
 func (r *store) store(level byte, ms *MultiSignature) {
@@ -268,6 +404,189 @@ func (r *store) store(level byte, ms *MultiSignature) {
 	}
 }
 
+// candidates is a bounded set of candidate multi-signatures for a level, for
+// stores keeping several of them per level. When the cap is exceeded, the
+// worst candidate is evicted: the one with the lowest cardinality and, among
+// these, the largest bitset. The default store keeps only the best signature
+// per level so it does not need it, see mergeStore.
+type candidates struct {
+	max  int
+	sigs []*MultiSignature
+}
+
+// newCandidates returns a candidates set holding at most max signatures. A
+// max inferior or equal to zero means no limit.
+func newCandidates(max int) *candidates {
+	return &candidates{max: max}
+}
+
+// add inserts the signature in the set. It returns false if the signature has
+// been rejected because the set is full of better candidates.
+func (c *candidates) add(ms *MultiSignature) bool {
+	c.sigs = append(c.sigs, ms)
+	if c.max <= 0 || len(c.sigs) <= c.max {
+		return true
+	}
+	lowest := 0
+	for i, s := range c.sigs {
+		if c.better(c.sigs[lowest], s) {
+			lowest = i
+		}
+	}
+	evicted := c.sigs[lowest]
+	c.sigs = append(c.sigs[:lowest], c.sigs[lowest+1:]...)
+	return evicted != ms
+}
+
+// best returns the candidate with the highest cardinality, the smallest
+// bitset breaking the ties, or false if the set is empty.
+func (c *candidates) best() (*MultiSignature, bool) {
+	if len(c.sigs) == 0 {
+		return nil, false
+	}
+	best := c.sigs[0]
+	for _, s := range c.sigs[1:] {
+		if c.better(s, best) {
+			best = s
+		}
+	}
+	return best, true
+}
+
+// better returns true if the signature a is a better candidate than b.
+func (c *candidates) better(a, b *MultiSignature) bool {
+	if a.Cardinality() != b.Cardinality() {
+		return a.Cardinality() > b.Cardinality()
+	}
+	return bitsetLess(a.BitSet, b.BitSet)
+}
+
+// contains returns true if a candidate contains all the contributions of the
+// signature.
+func (c *candidates) contains(ms *MultiSignature) bool {
+	for _, s := range c.sigs {
+		if s.IsSuperSet(ms.BitSet) {
+			return true
+		}
+	}
+	return false
+}
+
+// put adds the signature unless a candidate contains it, removing the
+// candidates it contains, so the candidates stay incomparable. It returns
+// false if the signature is not kept.
+func (c *candidates) put(ms *MultiSignature) bool {
+	if c.contains(ms) {
+		return false
+	}
+	kept := c.sigs[:0]
+	for _, s := range c.sigs {
+		if !ms.IsSuperSet(s.BitSet) {
+			kept = append(kept, s)
+		}
+	}
+	c.sigs = kept
+	return c.add(ms)
+}
+
+// merge returns the largest union of disjoint candidates found by combining,
+// from each candidate, the following ones in decreasing cardinality order
+// that are disjoint with the union so far. It returns nil if no candidates
+// are disjoint.
+func (c *candidates) merge() *MultiSignature {
+	sorted := append([]*MultiSignature{}, c.sigs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return c.better(sorted[i], sorted[j]) })
+	var best *MultiSignature
+	for i, first := range sorted {
+		union := &MultiSignature{BitSet: first.BitSet.Clone(), Signature: first.Signature}
+		merged := false
+		for _, s := range sorted[i+1:] {
+			if union.IntersectionCardinality(s.BitSet) != 0 {
+				continue
+			}
+			union.BitSet = union.Or(s.BitSet)
+			union.Signature = union.Signature.Combine(s.Signature)
+			merged = true
+		}
+		if merged && (best == nil || c.better(union, best)) {
+			best = union
+		}
+	}
+	return best
+}
+
+// mergeStore is a store keeping, besides the best signature of each level,
+// up to k candidate signatures per level none of the others contains. The
+// best signature of a level is replaced by the largest union of disjoint
+// candidates when it is better, so two overlapping signatures replacing
+// each other in the store do not prevent merging them with a third one, see
+// Config.MergeStore.
+type mergeStore struct {
+	*store
+	k     int
+	cands map[byte]*candidates
+}
+
+// newMergeStore returns a mergeStore keeping at most k candidates per level.
+// A k inferior or equal to zero means no limit.
+func newMergeStore(part Partitioner, nbs func(int) BitSet, c Constructor, k int) *mergeStore {
+	return &mergeStore{
+		store: newStore(part, nbs, c),
+		k:     k,
+		cands: make(map[byte]*candidates),
+	}
+}
+
+// Store implements the SignatureStore interface. The individual signatures
+// are merged in the best signature by the store already, they are not
+// candidates.
+func (m *mergeStore) Store(sp *incomingSig) *MultiSignature {
+	m.Lock()
+	defer m.Unlock()
+	ms := m.unsafeStore(sp)
+	if sp.Individual() {
+		return ms
+	}
+	c, exists := m.cands[sp.level]
+	if !exists {
+		c = newCandidates(m.k)
+		m.cands[sp.level] = c
+	}
+	if !c.put(sp.ms) {
+		return ms
+	}
+	union := c.merge()
+	if union == nil {
+		return ms
+	}
+	if n, better := m.unsafeCheckMerge(&incomingSig{level: sp.level, ms: union}); better {
+		m.store.store(sp.level, n)
+		return n
+	}
+	return ms
+}
+
+// Evaluate implements the SigEvaluator interface. Unlike the store, it gives
+// a minimal score to the signatures overlapping the best one instead of
+// discarding them, as long as they can be a new candidate of an incomplete
+// level.
+func (m *mergeStore) Evaluate(sp *incomingSig) int {
+	m.Lock()
+	defer m.Unlock()
+	score := m.unsafeEvaluate(sp)
+	if score > 0 || sp.Individual() {
+		return score
+	}
+	best := m.m[sp.level]
+	if best == nil || best.Cardinality() == m.part.Size(int(sp.level)) || best.IsSuperSet(sp.ms.BitSet) {
+		return 0
+	}
+	if c, exists := m.cands[sp.level]; exists && c.contains(sp.ms) {
+		return 0
+	}
+	return 1
+}
+
 func (r *store) String() string {
 	full := r.FullSignature()
 	r.Lock()
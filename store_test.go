@@ -1,6 +1,7 @@
 package handel
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -66,6 +67,73 @@ func TestStoreFullSignature(t *testing.T) {
 	require.True(t, ms.BitSet.Get(1))
 }
 
+// orderSig records the levels of the signatures in their combination order
+type orderSig struct {
+	levels []byte
+}
+
+func (o *orderSig) MarshalBinary() ([]byte, error) { return o.levels, nil }
+func (o *orderSig) UnmarshalBinary(b []byte) error { o.levels = b; return nil }
+func (o *orderSig) Combine(s Signature) Signature {
+	levels := append([]byte{}, o.levels...)
+	return &orderSig{append(levels, s.(*orderSig).levels...)}
+}
+
+func TestStorePrefix(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	// the first four nodes without the node 3, i.e. the levels 0 to 2
+	prefix := NewWilffBitset(n)
+	for _, i := range []int{0, 1, 2} {
+		prefix.Set(i, true)
+	}
+	store.setPrefix(3, newSig(prefix))
+	store.Store(fullIncomingSig(0))
+
+	ms := store.Combined(2)
+	require.Equal(t, 3, ms.Cardinality())
+	for i := 0; i < 3; i++ {
+		require.True(t, ms.Get(i))
+	}
+	// below the level of the prefix
+	require.Equal(t, 1, store.Combined(0).Cardinality())
+
+	// with a signature above the prefix
+	store.Store(fullIncomingSig(4))
+	ms = store.FullSignature()
+	require.Equal(t, 11, ms.Cardinality())
+	require.False(t, ms.Get(3))
+
+	// the stored signatures are used once better than the prefix
+	store.Store(fullIncomingSig(1))
+	store.Store(fullIncomingSig(2))
+	require.Equal(t, 4, store.Combined(2).Cardinality())
+	require.Equal(t, 12, store.FullSignature().Cardinality())
+}
+
+func TestStoreSelfFirst(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	store.selfFirst = true
+	for _, lvl := range append([]int{0}, part.Levels()...) {
+		store.Store(&incomingSig{
+			level: byte(lvl),
+			ms:    &MultiSignature{BitSet: fullBitset(lvl), Signature: &orderSig{[]byte{byte(lvl)}}},
+		})
+	}
+	for i := 0; i < 20; i++ {
+		full := store.FullSignature()
+		require.Equal(t, n, full.Cardinality())
+		require.Equal(t, []byte{0, 1, 2, 3, 4}, full.Signature.(*orderSig).levels)
+		combined := store.Combined(2)
+		require.Equal(t, []byte{0, 1, 2}, combined.Signature.(*orderSig).levels)
+	}
+}
+
 func TestStoreUnsafeCheckMerge(t *testing.T) {
 	n := 8
 	reg := FakeRegistry(n)
@@ -195,3 +263,182 @@ func TestStoreReplace(t *testing.T) {
 		//require.Equal(t, test.highest, store.Highest())
 	}
 }
+
+func TestStoreCandidates(t *testing.T) {
+	sigOf := func(card int) *MultiSignature {
+		bs := NewWilffBitset(8)
+		for i := 0; i < card; i++ {
+			bs.Set(i, true)
+		}
+		return newSig(bs)
+	}
+
+	c := newCandidates(2)
+	s3, s5 := sigOf(3), sigOf(5)
+	require.True(t, c.add(s3))
+	require.True(t, c.add(s5))
+	// at the cap, a worse candidate is rejected
+	require.False(t, c.add(sigOf(1)))
+	require.Equal(t, []*MultiSignature{s3, s5}, c.sigs)
+	// a better one evicts the lowest cardinality
+	s4 := sigOf(4)
+	require.True(t, c.add(s4))
+	require.Equal(t, []*MultiSignature{s5, s4}, c.sigs)
+
+	// no limit
+	c = newCandidates(0)
+	for i := 0; i < 10; i++ {
+		require.True(t, c.add(sigOf(1)))
+	}
+	require.Len(t, c.sigs, 10)
+}
+
+func TestMergeStore(t *testing.T) {
+	sigOf := func(indexes ...int) *MultiSignature {
+		bs := NewWilffBitset(8)
+		for _, i := range indexes {
+			bs.Set(i, true)
+		}
+		return newSig(bs)
+	}
+	reg := FakeRegistry(16)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	// b overlaps the best signature, c replaces it and is disjoint with b
+	a, b, c := sigOf(0, 1, 2, 3), sigOf(3, 4, 5), sigOf(0, 1, 2, 6, 7)
+	st := newStore(part, NewWilffBitset, new(fakeCons))
+	ms := newMergeStore(part, NewWilffBitset, new(fakeCons), 4)
+	for _, s := range []SignatureStore{st, ms} {
+		s.Store(&incomingSig{level: 4, ms: a})
+	}
+	require.Zero(t, st.Evaluate(&incomingSig{level: 4, ms: b}))
+	require.Equal(t, 1, ms.Evaluate(&incomingSig{level: 4, ms: b}))
+	require.Zero(t, ms.Evaluate(&incomingSig{level: 4, ms: sigOf(1, 2)}))
+	for _, s := range []SignatureStore{st, ms} {
+		s.Store(&incomingSig{level: 4, ms: b})
+		s.Store(&incomingSig{level: 4, ms: c})
+	}
+	best, _ := st.Best(4)
+	require.Equal(t, 5, best.Cardinality())
+	best, _ = ms.Best(4)
+	require.Equal(t, 8, best.Cardinality())
+	require.Equal(t, 8, ms.FullSignature().Cardinality())
+	// the level is complete
+	require.Zero(t, ms.Evaluate(&incomingSig{level: 4, ms: sigOf(4)}))
+
+	// a signature contained in a candidate is not kept
+	cands := newCandidates(0)
+	require.True(t, cands.put(sigOf(0, 1)))
+	require.False(t, cands.put(sigOf(1)))
+	require.True(t, cands.put(sigOf(0, 1, 2)))
+	require.Len(t, cands.sigs, 1)
+	require.Nil(t, cands.merge())
+
+	// with a single candidate, nothing is merged
+	ms = newMergeStore(part, NewWilffBitset, new(fakeCons), 1)
+	for _, s := range []*MultiSignature{a, b, c} {
+		ms.Store(&incomingSig{level: 4, ms: s})
+	}
+	best, _ = ms.Best(4)
+	require.Equal(t, 5, best.Cardinality())
+}
+
+func TestStoreTieBreak(t *testing.T) {
+	sigOf := func(indexes ...int) *MultiSignature {
+		bs := NewWilffBitset(4)
+		for _, i := range indexes {
+			bs.Set(i, true)
+		}
+		return newSig(bs)
+	}
+	require.True(t, bitsetLess(sigOf(1, 2).BitSet, sigOf(0, 3).BitSet))
+	require.False(t, bitsetLess(sigOf(0, 3).BitSet, sigOf(1, 2).BitSet))
+	require.False(t, bitsetLess(sigOf(1, 2).BitSet, sigOf(1, 2).BitSet))
+
+	// the same signature is the best whatever the order they are stored in,
+	// the two overlapping so they can not be merged
+	reg := FakeRegistry(8)
+	part := NewBinPartitioner(0, reg, DefaultLogger)
+	for _, order := range [][]*MultiSignature{
+		{sigOf(0, 1), sigOf(1, 2)},
+		{sigOf(1, 2), sigOf(0, 1)},
+	} {
+		st := newStore(part, NewWilffBitset, new(fakeCons))
+		c := newCandidates(0)
+		for _, ms := range order {
+			st.Store(&incomingSig{origin: 4, level: 3, ms: ms})
+			c.add(ms)
+		}
+		best, ok := st.Best(3)
+		require.True(t, ok)
+		require.Equal(t, sigOf(1, 2).BitSet, best.BitSet)
+		best, ok = c.best()
+		require.True(t, ok)
+		require.Equal(t, sigOf(1, 2).BitSet, best.BitSet)
+	}
+}
+
+// BenchmarkStoreFullSignature measures the cost of building the full signature
+// out of the verified signatures of each level, without any verification.
+func BenchmarkStoreFullSignature(b *testing.B) {
+	n := 2048
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	store.Store(&incomingSig{origin: 1, level: 0, ms: newSig(finalBitset(1)), isInd: true})
+	for _, lvl := range part.Levels() {
+		store.Store(fullIncomingSig(lvl))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ms := store.FullSignature(); ms.Cardinality() != n {
+			b.Fatal("incomplete full signature")
+		}
+	}
+}
+
+// BenchmarkStoreMerge compares the cardinality of the best signature of a
+// level reached by the store and the merge store, on a lossy network where
+// each signature received holds a random subset of the sub-levels of the
+// level, reported as the "contributions" metric.
+func BenchmarkStoreMerge(b *testing.B) {
+	n := 128
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(0, reg, DefaultLogger)
+	level := part.MaxLevel()
+	size := part.Size(level)
+	blocks := 8
+	received := 6
+	lossy := func(r *rand.Rand) *MultiSignature {
+		bs := NewWilffBitset(size)
+		for blk := 0; blk < blocks; blk++ {
+			if r.Intn(2) == 0 {
+				continue
+			}
+			for i := blk * size / blocks; i < (blk+1)*size/blocks; i++ {
+				bs.Set(i, true)
+			}
+		}
+		return newSig(bs)
+	}
+	for _, bench := range []struct {
+		name     string
+		newStore func() SignatureStore
+	}{
+		{"replace", func() SignatureStore { return newStore(part, NewWilffBitset, new(fakeCons)) }},
+		{"merge", func() SignatureStore { return newMergeStore(part, NewWilffBitset, new(fakeCons), blocks) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			total := 0
+			for i := 0; i < b.N; i++ {
+				st := bench.newStore()
+				for j := 0; j < received; j++ {
+					st.Store(&incomingSig{level: byte(level), ms: lossy(r)})
+				}
+				best, _ := st.Best(byte(level))
+				total += best.Cardinality()
+			}
+			b.ReportMetric(float64(total)/float64(b.N), "contributions")
+		})
+	}
+}
@@ -2,6 +2,7 @@ package handel
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	mathRand "math/rand"
 	"time"
@@ -221,6 +222,63 @@ func (t *Test) waitFinalSig(i int) {
 	}
 }
 
+// TestSigner builds valid multi-signatures over the identities of a registry,
+// to use as inputs of tests.
+// DO NOT USE IT IN PRODUCTION.
+type TestSigner struct {
+	reg  Registry
+	keys []SecretKey
+	cons Constructor
+	msg  []byte
+}
+
+// NewTestSigner returns a TestSigner signing the message with the given secret
+// keys, the i-th key being the one of the i-th identity of the registry.
+func NewTestSigner(reg Registry, keys []SecretKey, cons Constructor, msg []byte) *TestSigner {
+	return &TestSigner{reg: reg, keys: keys, cons: cons, msg: msg}
+}
+
+// Sign returns the multi-signature of the identities of the registry at the
+// given indices. Its bitset covers the whole registry, as the multi-signatures
+// output by Handel.
+func (s *TestSigner) Sign(indices ...int) (*MultiSignature, error) {
+	return s.SignRange(0, s.reg.Size(), indices...)
+}
+
+// SignRange returns the multi-signature of the identities at the given
+// indices within the range of the registry from min inclusive to max
+// exclusive. Its bitset covers the range only and the indices are relative to
+// min, as for the multi-signatures of a level.
+func (s *TestSigner) SignRange(min, max int, indices ...int) (*MultiSignature, error) {
+	if min < 0 || max > s.reg.Size() || max > len(s.keys) || min >= max {
+		return nil, fmt.Errorf("invalid range [%d,%d)", min, max)
+	}
+	if len(indices) == 0 {
+		return nil, errors.New("no index to sign for")
+	}
+	bs := DefaultBitSet(max - min)
+	var sig Signature
+	for _, i := range indices {
+		if i < 0 || i >= max-min {
+			return nil, fmt.Errorf("index %d out of range [%d,%d)", i, min, max)
+		}
+		if bs.Get(i) {
+			return nil, fmt.Errorf("index %d given twice", i)
+		}
+		ind, err := s.keys[min+i].Sign(s.msg, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		bs.Set(i, true)
+		if sig == nil {
+			sig = ind
+		} else {
+			sig = sig.Combine(ind)
+		}
+	}
+	return &MultiSignature{BitSet: bs, Signature: sig}, nil
+}
+
 // TestNetwork is a simple Network implementation using local dispatch functions
 // in goroutine.
 type TestNetwork struct {
@@ -5,7 +5,12 @@ import (
 	"math"
 )
 
+// log2 returns the ceiling of the base 2 logarithm of size, 0 for a size
+// inferior or equal to 1.
 func log2(size int) int {
+	if size <= 1 {
+		return 0
+	}
 	r := math.Log2(float64(size))
 	return int(math.Ceil(r))
 }